@@ -10,6 +10,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/charmbracelet/bubbles/spinner"
@@ -63,6 +64,10 @@ type home struct {
 	appConfig *config.Config
 	// appState stores persistent application state like seen help screens
 	appState config.AppState
+	// events carries instance lifecycle events to the configured hooks
+	events *session.EventBus
+	// scheduler queues instances beyond max_concurrent_instances
+	scheduler *session.Scheduler
 
 	// -- State --
 
@@ -114,6 +119,13 @@ func newHome(ctx context.Context, program string, autoYes bool) *home {
 		fmt.Printf("Failed to initialize storage: %v\n", err)
 		os.Exit(1)
 	}
+	storage.SetLargePayloadThreshold(appConfig.GetStorageLargePayloadBytes())
+	storage.SetDiffStatsTolerance(appConfig.StorageDiffStatsTolerance)
+	if appConfig.StorageDebounceMs != 0 {
+		if err := storage.SetDebounceInterval(time.Duration(appConfig.StorageDebounceMs) * time.Millisecond); err != nil {
+			log.WarningLog.Printf("ignoring storage_debounce_ms: %v", err)
+		}
+	}
 
 	h := &home{
 		ctx:          ctx,
@@ -127,7 +139,10 @@ func newHome(ctx context.Context, program string, autoYes bool) *home {
 		autoYes:      autoYes,
 		state:        stateDefault,
 		appState:     appState,
+		events:       session.NewEventBus(),
+		scheduler:    session.NewScheduler(appConfig.MaxConcurrentInstances),
 	}
+	session.RunHooks(ctx, h.events, appConfig.Hooks)
 	h.list = ui.NewList(&h.spinner, autoYes)
 
 	// Load saved instances
@@ -141,8 +156,9 @@ func newHome(ctx context.Context, program string, autoYes bool) *home {
 	for _, instance := range instances {
 		// Call the finalizer immediately.
 		h.list.AddInstance(instance)()
+		instance.SetEventBus(h.events)
 		if autoYes {
-			instance.AutoYes = true
+			instance.SetAutoYes(true)
 		}
 	}
 
@@ -214,26 +230,29 @@ func (m *home) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				continue
 			}
 			updated, prompt := instance.HasUpdated()
-			if updated {
+			switch {
+			case instance.Status == session.Loading || instance.Status == session.Crashed ||
+				instance.Status == session.Detached:
+				// HasUpdated moves instances out of Loading once the program is ready, and
+				// Detached instances wait for Recover.
+			case updated:
 				instance.SetStatus(session.Running)
-			} else {
-				if prompt {
-					instance.TapEnter()
-				} else {
-					instance.SetStatus(session.Ready)
-				}
+			case prompt:
+				instance.TapEnter()
+			default:
+				instance.SetStatus(session.Ready)
 			}
 			if err := instance.UpdateDiffStats(now); err != nil {
 				log.WarningLog.Printf("could not update diff stats: %v", err)
 			}
 		}
-		return m, tickUpdateMetadataCmd
+		return m, tea.Batch(tickUpdateMetadataCmd, m.promoteQueuedCmd())
 	case tea.MouseMsg:
 		// Handle mouse wheel events for scrolling the diff/preview pane
 		if msg.Action == tea.MouseActionPress {
 			if msg.Button == tea.MouseButtonWheelDown || msg.Button == tea.MouseButtonWheelUp {
 				selected := m.list.GetSelectedInstance()
-				if selected == nil || selected.Status == session.Paused {
+				if selected == nil || selected.Paused() {
 					return m, nil
 				}
 
@@ -342,7 +361,15 @@ func (m *home) handleKeyPress(msg tea.KeyMsg) (mod tea.Model, cmd tea.Cmd) {
 				return m, m.handleError(fmt.Errorf("title cannot be empty"))
 			}
 
-			if err := instance.Start(true); err != nil {
+			// The new instance is not started yet, so it takes no slot itself.
+			var err error
+			if m.scheduler.HasSlot(m.list.GetInstances()) {
+				err = instance.Start(true)
+			} else {
+				// A queued instance keeps its prompt until it is promoted.
+				err = instance.Queue()
+			}
+			if err != nil {
 				m.list.Kill()
 				m.state = stateDefault
 				return m, m.handleError(err)
@@ -354,7 +381,7 @@ func (m *home) handleKeyPress(msg tea.KeyMsg) (mod tea.Model, cmd tea.Cmd) {
 			// Instance added successfully, call the finalizer.
 			m.newInstanceFinalizer()
 			if m.autoYes {
-				instance.AutoYes = true
+				instance.SetAutoYes(true)
 			}
 
 			m.newInstanceFinalizer()
@@ -416,7 +443,13 @@ func (m *home) handleKeyPress(msg tea.KeyMsg) (mod tea.Model, cmd tea.Cmd) {
 				return m, nil
 			}
 			if m.textInputOverlay.IsSubmitted() {
-				if err := selected.SendPrompt(m.textInputOverlay.GetValue()); err != nil {
+				if selected.PendingStart() {
+					// The scheduler sends the prompt once the instance is promoted.
+					selected.Prompt = m.textInputOverlay.GetValue()
+					if err := m.storage.SaveInstances(m.list.GetInstances()); err != nil {
+						return m, m.handleError(err)
+					}
+				} else if err := selected.SendPrompt(m.textInputOverlay.GetValue()); err != nil {
 					// TODO: we probably end up in a bad state here.
 					return m, m.handleError(err)
 				}
@@ -487,6 +520,7 @@ func (m *home) handleKeyPress(msg tea.KeyMsg) (mod tea.Model, cmd tea.Cmd) {
 			Title:   "",
 			Path:    ".",
 			Program: m.program,
+			Events:  m.events,
 		})
 		if err != nil {
 			return m, m.handleError(err)
@@ -508,6 +542,7 @@ func (m *home) handleKeyPress(msg tea.KeyMsg) (mod tea.Model, cmd tea.Cmd) {
 			Title:   "",
 			Path:    ".",
 			Program: m.program,
+			Events:  m.events,
 		})
 		if err != nil {
 			return m, m.handleError(err)
@@ -541,6 +576,7 @@ func (m *home) handleKeyPress(msg tea.KeyMsg) (mod tea.Model, cmd tea.Cmd) {
 			return m, nil
 		}
 
+		killOptions := session.KillOptions{DeleteBranch: m.appConfig.KillDeletesBranch}
 		// Create the kill action as a tea.Cmd
 		killAction := func() tea.Msg {
 			// Get worktree and check if branch is checked out
@@ -558,18 +594,25 @@ func (m *home) handleKeyPress(msg tea.KeyMsg) (mod tea.Model, cmd tea.Cmd) {
 				return fmt.Errorf("instance %s is currently checked out", selected.Title)
 			}
 
-			// Delete from storage first
+			// Delete from storage first, keeping the data around so the kill can be undone
+			data := selected.ToInstanceData()
 			if err := m.storage.DeleteInstance(selected.Title); err != nil {
 				return err
 			}
+			m.storage.RecordKilled(data)
 
 			// Then kill the instance
-			m.list.Kill()
+			m.list.KillWithOptions(killOptions)
 			return instanceChangedMsg{}
 		}
 
-		// Show confirmation modal
+		// Show confirmation modal, spelling out any work the kill would lose
 		message := fmt.Sprintf("[!] Kill session '%s'?", selected.Title)
+		if impact, err := selected.KillPlanWithOptions(killOptions); err != nil {
+			log.ForInstance(selected.Title).Warning.Printf("could not check what killing %s would lose: %v", selected.Title, err)
+		} else if impact.LosesWork() {
+			message = fmt.Sprintf("[!] Kill session '%s'? Its %s.", selected.Title, impact)
+		}
 		return m, m.confirmAction(message, killAction)
 	case keys.KeySubmit:
 		selected := m.list.GetSelectedInstance()
@@ -614,6 +657,12 @@ func (m *home) handleKeyPress(msg tea.KeyMsg) (mod tea.Model, cmd tea.Cmd) {
 		if selected == nil {
 			return m, nil
 		}
+		if !m.scheduler.HasSlot(m.list.GetInstances()) {
+			if err := selected.Queue(); err != nil {
+				return m, m.handleError(err)
+			}
+			return m, m.instanceChanged()
+		}
 		if err := selected.Resume(); err != nil {
 			return m, m.handleError(err)
 		}
@@ -704,6 +753,27 @@ var tickUpdateMetadataCmd = func() tea.Msg {
 	return tickUpdateMetadataMessage{}
 }
 
+// promoteQueuedCmd starts queued instances that fit under the concurrency limit, off the UI
+// goroutine. It returns nil if no instance is queued.
+func (m *home) promoteQueuedCmd() tea.Cmd {
+	instances := m.list.GetInstances()
+	if !slices.ContainsFunc(instances, func(instance *session.Instance) bool {
+		return instance.GetStatus() == session.Queued
+	}) || !m.scheduler.HasSlot(instances) {
+		return nil
+	}
+	return func() tea.Msg {
+		promoted, err := m.scheduler.PromoteQueued(instances)
+		if err != nil {
+			return err
+		}
+		if len(promoted) == 0 {
+			return nil
+		}
+		return instanceChangedMsg{}
+	}
+}
+
 // handleError handles all errors which get bubbled up to the app. sets the error message. We return a callback tea.Cmd that returns a hideErrMsg message
 // which clears the error message after 3 seconds.
 func (m *home) handleError(err error) tea.Cmd {
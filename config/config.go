@@ -10,11 +10,37 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 const (
 	ConfigFileName = "config.json"
 	defaultProgram = "claude"
+
+	defaultStorageLargePayloadBytes = 1 << 20
+	defaultMaxDiffBytes             = 5 << 20
+	defaultTmuxCommandRetries       = 2
+	defaultProgramReadyTimeout      = 2 * time.Minute
+	defaultSendPromptEnterDelay     = 100 * time.Millisecond
+	defaultDaemonPollInterval       = time.Second
+
+	// DefaultDiffRefreshInterval is how often an instance's diff is recomputed when no change has
+	// been detected, unless diff_refresh_interval_ms says otherwise.
+	DefaultDiffRefreshInterval = 5 * time.Second
+
+	// defaultReadyPattern matches the input prompts of Claude Code, Aider and Gemini once they
+	// have finished starting up.
+	defaultReadyPattern = `(?m)\? for shortcuts|Type your message|^[\w-]*> *$`
+)
+
+// Pause strategies decide what Pause does with uncommitted changes in an instance's worktree.
+const (
+	// PauseStrategyCommit commits the changes to the instance's branch.
+	PauseStrategyCommit = "commit"
+	// PauseStrategyStash stashes the changes; Resume applies them again.
+	PauseStrategyStash = "stash"
+	// PauseStrategyLeaveDirty refuses to pause an instance with uncommitted changes.
+	PauseStrategyLeaveDirty = "leave-dirty"
 )
 
 // GetConfigDir returns the path to the application's configuration directory
@@ -33,9 +59,246 @@ type Config struct {
 	// AutoYes is a flag to automatically accept all prompts.
 	AutoYes bool `json:"auto_yes"`
 	// DaemonPollInterval is the interval (ms) at which the daemon polls sessions for autoyes mode.
+	// Each poll also gives the session's diff a chance to refresh; see DiffRefreshIntervalMs.
 	DaemonPollInterval int `json:"daemon_poll_interval"`
+	// DiffRefreshIntervalMs is how often, in milliseconds, an instance's diff is recomputed when
+	// no file change has been detected. Diffs are only recomputed when the UI or the daemon polls,
+	// so the effective interval is rounded up to the next poll. Zero uses the default of five
+	// seconds. An instance's own refresh interval takes precedence.
+	DiffRefreshIntervalMs int `json:"diff_refresh_interval_ms,omitempty"`
+	// MaxWatchDirs caps how many directories of a worktree are watched for changes. Past the cap,
+	// the rest of the worktree is only picked up by the periodic diff refresh. Zero means no limit.
+	MaxWatchDirs int `json:"max_watch_dirs,omitempty"`
 	// BranchPrefix is the prefix used for git branches created by the application.
 	BranchPrefix string `json:"branch_prefix"`
+	// BranchTemplate, if set, names new branches instead of BranchPrefix plus the title. It can
+	// use the placeholders {title}, {date}, {user} and {prefix}, e.g. "agent/{user}/{date}/{title}".
+	BranchTemplate string `json:"branch_template,omitempty"`
+	// PauseCommitTemplate, if set, is the message of the commit Pause makes of uncommitted changes.
+	// It can use the placeholders {title}, {time} and {branch}.
+	PauseCommitTemplate string `json:"pause_commit_template,omitempty"`
+	// PauseStrategy is what Pause does with uncommitted changes: "commit" (the default), "stash"
+	// or "leave-dirty".
+	PauseStrategy string `json:"pause_strategy,omitempty"`
+	// PauseSkipUntracked makes the commit pause strategy commit changes to tracked files only.
+	// Untracked files are stashed instead and restored on resume.
+	PauseSkipUntracked bool `json:"pause_skip_untracked,omitempty"`
+	// PauseCopyBranch controls whether Pause copies the branch name to the clipboard, so it can be
+	// checked out elsewhere. Unset means true.
+	PauseCopyBranch *bool `json:"pause_copy_branch,omitempty"`
+	// PauseCopyCheckoutCommand makes Pause copy a "git checkout <branch>" command instead of the
+	// bare branch name.
+	PauseCopyCheckoutCommand bool `json:"pause_copy_checkout_command,omitempty"`
+	// KillDeletesBranch makes killing a session delete its branch as well as its worktree. By
+	// default the branch is kept so it can be revisited.
+	KillDeletesBranch bool `json:"kill_deletes_branch,omitempty"`
+	// WorktreeDir, if set, is the directory new worktrees are created in instead of the
+	// worktrees directory in the config directory, e.g. a tmpfs mount for throwaway sessions. It
+	// must be absolute (a leading ~ is expanded) and can use the placeholders {repo} and {title}.
+	WorktreeDir string `json:"worktree_dir,omitempty"`
+	// WorktreeDirTemplate, if set, names worktree directories. It can use the placeholders
+	// {title}, {branch} and {repo}, e.g. "{repo}-{title}".
+	WorktreeDirTemplate string `json:"worktree_dir_template,omitempty"`
+	// TmuxHistoryLimit is the tmux scrollback size in lines for new sessions. Zero uses the default.
+	TmuxHistoryLimit int `json:"tmux_history_limit,omitempty"`
+	// TmuxCommandRetries is how many times idempotent tmux commands are retried when the tmux
+	// server is briefly unavailable. Zero uses the default; a negative value disables retries.
+	TmuxCommandRetries int `json:"tmux_command_retries,omitempty"`
+	// TranscriptDir, if set, is the directory where each instance's pane output is logged to
+	// <title>.log.
+	TranscriptDir string `json:"transcript_dir,omitempty"`
+	// TranscriptMaxBytes is the size at which an instance transcript, and the pane output piped to
+	// an instance log, is rotated. Zero disables rotation.
+	TranscriptMaxBytes int64 `json:"transcript_max_bytes,omitempty"`
+	// StorageLargePayloadBytes is the serialized instance state size above which state writes are
+	// debounced for longer. Zero uses the default; a negative value disables the policy.
+	StorageLargePayloadBytes int `json:"storage_large_payload_bytes,omitempty"`
+	// StorageDebounceMs is the minimum time in milliseconds between two writes of the instance
+	// state. Zero uses the default of five seconds.
+	StorageDebounceMs int `json:"storage_debounce_ms,omitempty"`
+	// StorageDiffStatsTolerance is how many lines an instance's diff stat counts may change before
+	// the change alone causes a state write. Zero writes on every change.
+	StorageDiffStatsTolerance int `json:"storage_diff_stats_tolerance,omitempty"`
+	// MaxDiffBytes caps how much diff content is loaded per instance. Zero uses the default; a
+	// negative value disables the limit.
+	MaxDiffBytes int `json:"max_diff_bytes,omitempty"`
+	// ReadyPattern is a regular expression matched against the pane of a starting instance to
+	// detect that the program is ready for input. Empty uses a pattern for the built-in programs.
+	ReadyPattern string `json:"ready_pattern,omitempty"`
+	// ProgramWrapper is a command, e.g. "nice -n 10" or "firejail", that every program is launched
+	// under by prefixing it to the program's command line. Its first word must be on PATH.
+	ProgramWrapper string `json:"program_wrapper,omitempty"`
+	// ActivityPattern is a regular expression matched against the pane of a running instance. While
+	// it matches, e.g. on a "Thinking..." line, the instance counts as busy even if its pane does
+	// not change. Empty only counts pane changes.
+	ActivityPattern string `json:"activity_pattern,omitempty"`
+	// ProgramReadyTimeout is how long a new instance may take to match ReadyPattern before it is
+	// marked crashed, as a duration such as "90s". Empty uses the default; "0" waits forever.
+	ProgramReadyTimeout string `json:"program_ready_timeout,omitempty"`
+	// DiffExcludePaths are pathspec patterns, e.g. "package-lock.json" or "gen/**", left out of
+	// every instance's diff content and line counts.
+	DiffExcludePaths []string `json:"diff_exclude_paths,omitempty"`
+	// DiffIncludeUntracked controls whether untracked files show up in instance diffs. Unset means
+	// true.
+	DiffIncludeUntracked *bool `json:"diff_include_untracked,omitempty"`
+	// DiffRenameThreshold is the similarity, in percent, a deleted and an added file need for the
+	// diff to show them as a rename. Zero uses git's default of 50%; a negative value turns rename
+	// detection off.
+	DiffRenameThreshold int `json:"diff_rename_threshold,omitempty"`
+	// DiffFindCopies makes the diff also show new files that are copies of existing ones as copies.
+	DiffFindCopies bool `json:"diff_find_copies,omitempty"`
+	// Hooks maps instance events to shell commands run when they happen. Keys are lifecycle
+	// events ("created", "started", "paused", "resumed", "killed", "status_changed") or a status
+	// an instance changed to, e.g. "status:ready". Commands are run with sh -c and can use the
+	// placeholders {title}, {branch} and {status}, which are substituted shell-quoted, e.g.
+	// "notify-send {title} {status}".
+	Hooks map[string]string `json:"hooks,omitempty"`
+	// MaxConcurrentInstances is how many instances may run at once. Instances created or resumed
+	// beyond it are queued until one is paused or killed. Zero means no limit.
+	MaxConcurrentInstances int `json:"max_concurrent_instances,omitempty"`
+	// SendPromptEnterDelayMs is how long to wait between typing a prompt and pressing enter, so
+	// slow terminals don't take the enter as part of the prompt. Zero uses the default of 100ms;
+	// a negative value disables the delay.
+	SendPromptEnterDelayMs int `json:"send_prompt_enter_delay_ms,omitempty"`
+	// PastePrompts sends prompts through a tmux paste buffer instead of typing them, which keeps
+	// large and multiline prompts intact.
+	PastePrompts bool `json:"paste_prompts,omitempty"`
+	// Templates are named presets for creating instances, e.g. a "reviewer" running a particular
+	// program with auto-yes enabled.
+	Templates map[string]InstanceTemplate `json:"templates,omitempty"`
+	// LogFormat is the format of the log file: "text" (the default) or "json" for one structured
+	// object per line.
+	LogFormat string `json:"log_format,omitempty"`
+}
+
+// InstanceTemplate is a preset for creating instances. Unset fields fall back to the defaults.
+type InstanceTemplate struct {
+	// Program is the program to run. Empty uses DefaultProgram.
+	Program string `json:"program,omitempty"`
+	// AutoYes automatically accepts the program's prompts.
+	AutoYes bool `json:"auto_yes,omitempty"`
+	// Env holds extra environment variables for the program.
+	Env map[string]string `json:"env,omitempty"`
+	// Labels tag the instance, e.g. for filtering.
+	Labels []string `json:"labels,omitempty"`
+	// BranchTemplate overrides the branch_template config for the instance's branch.
+	BranchTemplate string `json:"branch_template,omitempty"`
+}
+
+// GetTemplate returns the instance template called name.
+func (c *Config) GetTemplate(name string) (InstanceTemplate, error) {
+	template, ok := c.Templates[name]
+	if !ok {
+		return InstanceTemplate{}, fmt.Errorf("no instance template named %q", name)
+	}
+	return template, nil
+}
+
+// GetDiffIncludeUntracked reports whether untracked files should show up in instance diffs.
+func (c *Config) GetDiffIncludeUntracked() bool {
+	return c.DiffIncludeUntracked == nil || *c.DiffIncludeUntracked
+}
+
+// GetPauseCopyBranch reports whether Pause copies the branch name to the clipboard.
+func (c *Config) GetPauseCopyBranch() bool {
+	return c.PauseCopyBranch == nil || *c.PauseCopyBranch
+}
+
+// GetPauseStrategy returns the pause strategy, falling back to PauseStrategyCommit for unset or
+// unknown values.
+func (c *Config) GetPauseStrategy() string {
+	switch c.PauseStrategy {
+	case PauseStrategyStash, PauseStrategyLeaveDirty:
+		return c.PauseStrategy
+	case "", PauseStrategyCommit:
+	default:
+		log.WarningLog.Printf("unknown pause_strategy %q, committing changes instead", c.PauseStrategy)
+	}
+	return PauseStrategyCommit
+}
+
+// GetReadyPattern returns the pattern that marks a starting program as ready for input.
+func (c *Config) GetReadyPattern() string {
+	if c.ReadyPattern == "" {
+		return defaultReadyPattern
+	}
+	return c.ReadyPattern
+}
+
+// GetProgramReadyTimeout returns how long a new instance may take to become ready, or zero if
+// it may take as long as it needs. Invalid values fall back to the default.
+func (c *Config) GetProgramReadyTimeout() time.Duration {
+	if c.ProgramReadyTimeout == "" {
+		return defaultProgramReadyTimeout
+	}
+	timeout, err := time.ParseDuration(c.ProgramReadyTimeout)
+	if err != nil {
+		log.WarningLog.Printf("invalid program_ready_timeout %q, using the default: %v", c.ProgramReadyTimeout, err)
+		return defaultProgramReadyTimeout
+	}
+	return max(timeout, 0)
+}
+
+// GetMaxDiffBytes returns the diff content limit, or zero if diffs should not be limited.
+func (c *Config) GetMaxDiffBytes() int {
+	if c.MaxDiffBytes == 0 {
+		return defaultMaxDiffBytes
+	}
+	if c.MaxDiffBytes < 0 {
+		return 0
+	}
+	return c.MaxDiffBytes
+}
+
+// GetTmuxCommandRetries returns how many times idempotent tmux commands are retried.
+func (c *Config) GetTmuxCommandRetries() int {
+	if c.TmuxCommandRetries == 0 {
+		return defaultTmuxCommandRetries
+	}
+	return max(c.TmuxCommandRetries, 0)
+}
+
+// GetSendPromptEnterDelay returns how long to wait between typing a prompt and pressing enter.
+func (c *Config) GetSendPromptEnterDelay() time.Duration {
+	if c.SendPromptEnterDelayMs == 0 {
+		return defaultSendPromptEnterDelay
+	}
+	return time.Duration(max(c.SendPromptEnterDelayMs, 0)) * time.Millisecond
+}
+
+// GetDaemonPollInterval returns how often the daemon polls sessions. Zero and negative values
+// fall back to the default.
+func (c *Config) GetDaemonPollInterval() time.Duration {
+	if c.DaemonPollInterval < 0 {
+		log.WarningLog.Printf("invalid daemon_poll_interval %d, using the default", c.DaemonPollInterval)
+	}
+	if c.DaemonPollInterval <= 0 {
+		return defaultDaemonPollInterval
+	}
+	return time.Duration(c.DaemonPollInterval) * time.Millisecond
+}
+
+// GetDiffRefreshInterval returns how often an instance's diff is recomputed when no change has
+// been detected. Negative values fall back to the default.
+func (c *Config) GetDiffRefreshInterval() time.Duration {
+	if c.DiffRefreshIntervalMs < 0 {
+		log.WarningLog.Printf("invalid diff_refresh_interval_ms %d, using the default", c.DiffRefreshIntervalMs)
+	}
+	if c.DiffRefreshIntervalMs <= 0 {
+		return DefaultDiffRefreshInterval
+	}
+	return time.Duration(c.DiffRefreshIntervalMs) * time.Millisecond
+}
+
+// GetStorageLargePayloadBytes returns the large payload threshold for instance storage.
+func (c *Config) GetStorageLargePayloadBytes() int {
+	if c.StorageLargePayloadBytes == 0 {
+		return defaultStorageLargePayloadBytes
+	}
+	if c.StorageLargePayloadBytes < 0 {
+		return 0
+	}
+	return c.StorageLargePayloadBytes
 }
 
 // DefaultConfig returns the default configuration
@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -237,3 +238,21 @@ func TestSaveConfig(t *testing.T) {
 		assert.Equal(t, testConfig.BranchPrefix, loadedConfig.BranchPrefix)
 	})
 }
+
+func TestPollAndDiffRefreshIntervals(t *testing.T) {
+	t.Run("uses the configured values", func(t *testing.T) {
+		cfg := &Config{DaemonPollInterval: 250, DiffRefreshIntervalMs: 2000}
+		assert.Equal(t, 250*time.Millisecond, cfg.GetDaemonPollInterval())
+		assert.Equal(t, 2*time.Second, cfg.GetDiffRefreshInterval())
+	})
+
+	t.Run("falls back to the defaults for values that are not positive", func(t *testing.T) {
+		cfg := &Config{DaemonPollInterval: -5, DiffRefreshIntervalMs: -1}
+		assert.Equal(t, defaultDaemonPollInterval, cfg.GetDaemonPollInterval())
+		assert.Equal(t, DefaultDiffRefreshInterval, cfg.GetDiffRefreshInterval())
+
+		cfg = &Config{}
+		assert.Equal(t, defaultDaemonPollInterval, cfg.GetDaemonPollInterval())
+		assert.Equal(t, DefaultDiffRefreshInterval, cfg.GetDiffRefreshInterval())
+	})
+}
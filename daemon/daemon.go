@@ -23,6 +23,20 @@ func RunDaemon(cfg *config.Config) error {
 	if err != nil {
 		return fmt.Errorf("failed to initialize storage: %w", err)
 	}
+	storage.SetLargePayloadThreshold(cfg.GetStorageLargePayloadBytes())
+	storage.SetDiffStatsTolerance(cfg.StorageDiffStatsTolerance)
+	if cfg.StorageDebounceMs != 0 {
+		if err := storage.SetDebounceInterval(time.Duration(cfg.StorageDebounceMs) * time.Millisecond); err != nil {
+			log.WarningLog.Printf("ignoring storage_debounce_ms: %v", err)
+		}
+	}
+
+	// Drop instances whose repository, branch or worktree was removed while nothing was running.
+	if removed, err := storage.Compact(session.LiveInstanceData); err != nil {
+		log.WarningLog.Printf("failed to compact instance storage: %v", err)
+	} else if removed > 0 {
+		log.InfoLog.Printf("removed %d stale instances from storage", removed)
+	}
 
 	instances, err := storage.LoadInstances()
 	if err != nil {
@@ -30,10 +44,10 @@ func RunDaemon(cfg *config.Config) error {
 	}
 	for _, instance := range instances {
 		// Assume AutoYes is true if the daemon is running.
-		instance.AutoYes = true
+		instance.SetAutoYes(true)
 	}
 
-	pollInterval := time.Duration(cfg.DaemonPollInterval) * time.Millisecond
+	pollInterval := cfg.GetDaemonPollInterval()
 	maxInterval := pollInterval * 5
 	if maxInterval < 5*time.Second {
 		maxInterval = 5 * time.Second
@@ -88,7 +102,7 @@ func RunDaemon(cfg *config.Config) error {
 
 				if err := instance.UpdateDiffStats(now); err != nil {
 					if everyN.ShouldLog() {
-						log.WarningLog.Printf("could not update diff stats for %s: %v", instance.Title, err)
+						log.ForInstance(instance.Title).Warning.Printf("could not update diff stats for %s: %v", instance.Title, err)
 					}
 				}
 
@@ -126,11 +140,8 @@ func RunDaemon(cfg *config.Config) error {
 	close(stopCh)
 	wg.Wait()
 
-	if err := storage.SaveInstances(instances); err != nil {
-		log.ErrorLog.Printf("failed to save instances when terminating daemon: %v", err)
-	}
-	if err := storage.Flush(); err != nil {
-		log.ErrorLog.Printf("failed to flush instances when terminating daemon: %v", err)
+	if err := session.Shutdown(instances, storage); err != nil {
+		log.ErrorLog.Printf("failed to shut down cleanly when terminating daemon: %v", err)
 	}
 	return nil
 }
@@ -1,10 +1,13 @@
 package log
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -14,9 +17,22 @@ var (
 	ErrorLog   *log.Logger
 )
 
+// Log formats accepted by SetFormat.
+const (
+	// FormatText writes plain lines prefixed with the level. It is the default.
+	FormatText = "text"
+	// FormatJSON writes one JSON object per line with level, time, caller, message and, for
+	// loggers from ForInstance, the instance title.
+	FormatJSON = "json"
+)
+
 var logFileName = filepath.Join(os.TempDir(), "agentsquad.log")
 
-var globalLogFile *os.File
+var (
+	globalLogFile *os.File
+	daemonMode    bool
+	format        = FormatText
+)
 
 // Initialize should be called once at the beginning of the program to set up logging.
 // defer Close() after calling this function. It sets the go log output to the file in
@@ -31,15 +47,101 @@ func Initialize(daemon bool) {
 	// Set log format to include timestamp and file/line number
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	fmtS := "%s"
-	if daemon {
-		fmtS = "[DAEMON] %s"
+	globalLogFile = f
+	daemonMode = daemon
+	InfoLog = newLogger("INFO", "")
+	WarningLog = newLogger("WARNING", "")
+	ErrorLog = newLogger("ERROR", "")
+}
+
+// SetFormat switches the loggers to FormatText or FormatJSON. An empty format means FormatText.
+// Call it after Initialize, before logging from other goroutines.
+func SetFormat(f string) error {
+	switch f {
+	case "", FormatText:
+		format = FormatText
+	case FormatJSON:
+		format = FormatJSON
+	default:
+		return fmt.Errorf("unknown log format %q, expected %q or %q", f, FormatText, FormatJSON)
 	}
-	InfoLog = log.New(f, fmt.Sprintf(fmtS, "INFO:"), log.Ldate|log.Ltime|log.Lshortfile)
-	WarningLog = log.New(f, fmt.Sprintf(fmtS, "WARNING:"), log.Ldate|log.Ltime|log.Lshortfile)
-	ErrorLog = log.New(f, fmt.Sprintf(fmtS, "ERROR:"), log.Ldate|log.Ltime|log.Lshortfile)
+	InfoLog = newLogger("INFO", "")
+	WarningLog = newLogger("WARNING", "")
+	ErrorLog = newLogger("ERROR", "")
+	return nil
+}
 
-	globalLogFile = f
+// InstanceLoggers are loggers whose lines belong to one instance.
+type InstanceLoggers struct {
+	Info    *log.Logger
+	Warning *log.Logger
+	Error   *log.Logger
+}
+
+// ForInstance returns loggers that tag their lines with the instance title in FormatJSON. Plain
+// text lines are unchanged, since messages already name the instance where it matters.
+func ForInstance(title string) InstanceLoggers {
+	if format != FormatJSON {
+		return InstanceLoggers{Info: InfoLog, Warning: WarningLog, Error: ErrorLog}
+	}
+	return InstanceLoggers{
+		Info:    newLogger("INFO", title),
+		Warning: newLogger("WARNING", title),
+		Error:   newLogger("ERROR", title),
+	}
+}
+
+func newLogger(level, instance string) *log.Logger {
+	if format == FormatJSON {
+		return log.New(&jsonWriter{out: globalLogFile, level: level, instance: instance, daemon: daemonMode}, "", log.Lshortfile)
+	}
+	prefix := level + ":"
+	if daemonMode {
+		prefix = "[DAEMON] " + prefix
+	}
+	return log.New(globalLogFile, prefix, log.Ldate|log.Ltime|log.Lshortfile)
+}
+
+// jsonEntry is a line written in FormatJSON.
+type jsonEntry struct {
+	Time     string `json:"time"`
+	Level    string `json:"level"`
+	Daemon   bool   `json:"daemon,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Caller   string `json:"caller,omitempty"`
+	Message  string `json:"message"`
+}
+
+// jsonWriter turns the "file.go:12: message" lines of a logger with only log.Lshortfile set into
+// JSON lines.
+type jsonWriter struct {
+	out      io.Writer
+	level    string
+	instance string
+	daemon   bool
+}
+
+func (w *jsonWriter) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+	caller, message, ok := strings.Cut(line, ": ")
+	if !ok {
+		caller, message = "", line
+	}
+	data, err := json.Marshal(jsonEntry{
+		Time:     time.Now().Format(time.RFC3339Nano),
+		Level:    w.level,
+		Daemon:   w.daemon,
+		Instance: w.instance,
+		Caller:   caller,
+		Message:  message,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.out.Write(append(data, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
 
 func Close() {
@@ -0,0 +1,55 @@
+package log
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONFormat(t *testing.T) {
+	logFileName = filepath.Join(t.TempDir(), "agentsquad.log")
+	Initialize(false)
+	t.Cleanup(func() {
+		_ = SetFormat(FormatText)
+		_ = globalLogFile.Close()
+	})
+
+	InfoLog.Printf("plain %d", 1)
+	if err := SetFormat("xml"); err == nil {
+		t.Fatal("expected an unknown format to be rejected")
+	}
+	if err := SetFormat(FormatJSON); err != nil {
+		t.Fatalf("SetFormat: %v", err)
+	}
+	WarningLog.Printf("structured: %d", 2)
+	ForInstance("my-session").Error.Print("went wrong")
+
+	data, err := os.ReadFile(logFileName)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %q", lines)
+	}
+	if !strings.HasPrefix(lines[0], "INFO:") || !strings.HasSuffix(lines[0], "plain 1") {
+		t.Fatalf("expected a plain text line, got %q", lines[0])
+	}
+
+	var warning, failure jsonEntry
+	if err := json.Unmarshal([]byte(lines[1]), &warning); err != nil {
+		t.Fatalf("unmarshal %q: %v", lines[1], err)
+	}
+	if warning.Level != "WARNING" || warning.Message != "structured: 2" || !strings.HasPrefix(warning.Caller, "log_test.go:") ||
+		warning.Instance != "" || warning.Time == "" {
+		t.Fatalf("unexpected entry %+v", warning)
+	}
+	if err := json.Unmarshal([]byte(lines[2]), &failure); err != nil {
+		t.Fatalf("unmarshal %q: %v", lines[2], err)
+	}
+	if failure.Level != "ERROR" || failure.Instance != "my-session" || failure.Message != "went wrong" {
+		t.Fatalf("unexpected entry %+v", failure)
+	}
+}
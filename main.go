@@ -32,6 +32,7 @@ var (
 
 			if daemonFlag {
 				cfg := config.LoadConfig()
+				setLogFormat(cfg)
 				err := daemon.RunDaemon(cfg)
 				log.ErrorLog.Printf("failed to start daemon %v", err)
 				return err
@@ -48,6 +49,7 @@ var (
 			}
 
 			cfg := config.LoadConfig()
+			setLogFormat(cfg)
 
 			// Program flag overrides config
 			program := cfg.DefaultProgram
@@ -143,6 +145,13 @@ var (
 	}
 )
 
+// setLogFormat applies the configured log format, keeping plain text if it is invalid.
+func setLogFormat(cfg *config.Config) {
+	if err := log.SetFormat(cfg.LogFormat); err != nil {
+		log.WarningLog.Printf("invalid log_format: %v", err)
+	}
+}
+
 func init() {
 	rootCmd.Flags().StringVarP(&programFlag, "program", "p", "",
 		"Program to run in new instances (e.g. 'aider --model ollama_chat/gemma3:1b')")
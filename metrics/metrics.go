@@ -0,0 +1,120 @@
+// Package metrics reports gauges about a set of instances, for scraping by Prometheus.
+package metrics
+
+import (
+	"agent-squad/session"
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// InstanceStats holds the gauges of a single instance.
+type InstanceStats struct {
+	Title   string
+	Status  string
+	Added   int
+	Removed int
+	// Idle is how long ago the instance's pane last changed or it was sent a prompt.
+	Idle time.Duration
+}
+
+// Stats is a snapshot of the gauges of a set of instances.
+type Stats struct {
+	// ByStatus counts the instances in each status, keyed by status name, e.g. "running".
+	ByStatus map[string]int
+	// Added and Removed are the lines added and removed across all instances.
+	Added   int
+	Removed int
+	// Instances holds per-instance gauges, in the order the instances were given.
+	Instances []InstanceStats
+}
+
+// Collect builds a snapshot from instances. It only reads the diff stats the instances last
+// computed, so it never runs git.
+func Collect(instances []*session.Instance, now time.Time) Stats {
+	stats := Stats{ByStatus: make(map[string]int)}
+	for _, instance := range instances {
+		entry := InstanceStats{
+			Title:  instance.Title,
+			Status: instance.Status.String(),
+			Idle:   max(now.Sub(instance.LastActivity()), 0),
+		}
+		if diff := instance.GetDiffStats(); diff != nil {
+			entry.Added = diff.Added
+			entry.Removed = diff.Removed
+		}
+		stats.ByStatus[entry.Status]++
+		stats.Added += entry.Added
+		stats.Removed += entry.Removed
+		stats.Instances = append(stats.Instances, entry)
+	}
+	return stats
+}
+
+// statusNames lists every status so that gauges for empty statuses are reported as zero rather
+// than disappearing.
+var statusNames = []string{
+	session.Running.String(),
+	session.Ready.String(),
+	session.Loading.String(),
+	session.Paused.String(),
+	session.Crashed.String(),
+	session.Detached.String(),
+	session.Queued.String(),
+}
+
+// WriteText writes the snapshot in the Prometheus text exposition format.
+func (s Stats) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	writeHeader(bw, "agentsquad_instances", "Number of instances by status.")
+	for _, status := range statusNames {
+		fmt.Fprintf(bw, "agentsquad_instances{status=%q} %d\n", status, s.ByStatus[status])
+	}
+	writeHeader(bw, "agentsquad_lines_added", "Lines added across all instances.")
+	fmt.Fprintf(bw, "agentsquad_lines_added %d\n", s.Added)
+	writeHeader(bw, "agentsquad_lines_removed", "Lines removed across all instances.")
+	fmt.Fprintf(bw, "agentsquad_lines_removed %d\n", s.Removed)
+
+	writeHeader(bw, "agentsquad_instance_lines_added", "Lines added by an instance.")
+	for _, instance := range s.Instances {
+		fmt.Fprintf(bw, "agentsquad_instance_lines_added{title=\"%s\"} %d\n", escapeLabel(instance.Title), instance.Added)
+	}
+	writeHeader(bw, "agentsquad_instance_lines_removed", "Lines removed by an instance.")
+	for _, instance := range s.Instances {
+		fmt.Fprintf(bw, "agentsquad_instance_lines_removed{title=\"%s\"} %d\n", escapeLabel(instance.Title), instance.Removed)
+	}
+	writeHeader(bw, "agentsquad_instance_idle_seconds", "Seconds since an instance last showed activity.")
+	for _, instance := range s.Instances {
+		fmt.Fprintf(bw, "agentsquad_instance_idle_seconds{title=\"%s\",status=%q} %g\n",
+			escapeLabel(instance.Title), instance.Status, instance.Idle.Seconds())
+	}
+
+	return bw.Flush()
+}
+
+func writeHeader(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+// labelEscaper escapes label values as the text exposition format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// Handler serves the gauges of the instances returned by source in the Prometheus text
+// exposition format. source is called on every scrape and must be safe to call from the
+// server's goroutines.
+func Handler(source func() []*session.Instance) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := Collect(source(), time.Now()).WriteText(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
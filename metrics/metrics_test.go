@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"agent-squad/session"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectAndWriteText(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	paused, err := session.FromInstanceData(session.InstanceData{
+		Title:     `paused "one"`,
+		Status:    session.Paused,
+		CreatedAt: now.Add(-90 * time.Second),
+		DiffStats: session.DiffStatsData{Added: 7, Removed: 2},
+	})
+	if err != nil {
+		t.Fatalf("FromInstanceData: %v", err)
+	}
+	ready := &session.Instance{Title: "ready", Status: session.Ready, CreatedAt: now.Add(-time.Second)}
+
+	stats := Collect([]*session.Instance{paused, ready}, now)
+	if stats.ByStatus["paused"] != 1 || stats.ByStatus["ready"] != 1 || stats.Added != 7 || stats.Removed != 2 {
+		t.Fatalf("unexpected totals %+v", stats)
+	}
+	if stats.Instances[0].Idle != 90*time.Second {
+		t.Fatalf("expected idle time since creation, got %v", stats.Instances[0].Idle)
+	}
+
+	var out strings.Builder
+	if err := stats.WriteText(&out); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	for _, want := range []string{
+		"# TYPE agentsquad_instances gauge\n",
+		`agentsquad_instances{status="running"} 0` + "\n",
+		`agentsquad_instances{status="paused"} 1` + "\n",
+		"agentsquad_lines_added 7\n",
+		`agentsquad_instance_lines_removed{title="paused \"one\""} 2` + "\n",
+		`agentsquad_instance_idle_seconds{title="ready",status="ready"} 1` + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	instances := []*session.Instance{{Title: "a", Status: session.Running, CreatedAt: time.Now()}}
+	recorder := httptest.NewRecorder()
+	Handler(func() []*session.Instance { return instances }).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if recorder.Code != 200 || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected response %d %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(recorder.Body.String(), `agentsquad_instances{status="running"} 1`) {
+		t.Fatalf("unexpected body:\n%s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,32 @@
+package session
+
+// PauseAll pauses every running instance, skipping ones that are not started or already paused.
+// A failure does not stop the remaining instances from being paused; the returned map holds the
+// error for each instance that failed, keyed by title, and is empty if all succeeded.
+func PauseAll(instances []*Instance) map[string]error {
+	errs := make(map[string]error)
+	for _, instance := range instances {
+		if !instance.Started() || instance.Paused() {
+			continue
+		}
+		if err := instance.Pause(); err != nil {
+			errs[instance.Title] = err
+		}
+	}
+	return errs
+}
+
+// ResumeAll resumes every paused instance, skipping ones that are not paused. Like PauseAll, it
+// keeps going after a failure and returns the errors keyed by instance title.
+func ResumeAll(instances []*Instance) map[string]error {
+	errs := make(map[string]error)
+	for _, instance := range instances {
+		if !instance.Started() || !instance.Paused() {
+			continue
+		}
+		if err := instance.Resume(); err != nil {
+			errs[instance.Title] = err
+		}
+	}
+	return errs
+}
@@ -0,0 +1,45 @@
+package session
+
+import (
+	"strings"
+	"testing"
+
+	"agent-squad/session/git"
+)
+
+func TestPauseAllAndResumeAllCollectErrors(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	head := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD"))
+
+	// main is checked out in the repository itself, so resuming it into a worktree fails.
+	newInstance := func(title string, status Status) *Instance {
+		return &Instance{
+			Title:       title,
+			Status:      status,
+			started:     true,
+			gitWorktree: git.NewGitWorktreeFromStorage(repo, repo, title, "main", head),
+		}
+	}
+	notStarted := &Instance{Title: "not-started", Status: Ready}
+	paused := newInstance("paused", Paused)
+
+	errs := PauseAll([]*Instance{notStarted, paused})
+	if len(errs) != 0 {
+		t.Fatalf("expected skipped instances not to fail, got %v", errs)
+	}
+	if paused.Status != Paused || notStarted.Status != Ready {
+		t.Fatal("expected skipped instances to keep their status")
+	}
+
+	running := newInstance("running", Running)
+	errs = ResumeAll([]*Instance{notStarted, running, paused})
+	if len(errs) != 1 || errs["paused"] == nil {
+		t.Fatalf("expected only the paused instance to be resumed and fail, got %v", errs)
+	}
+	if !strings.Contains(errs["paused"].Error(), "branch is checked out") {
+		t.Fatalf("unexpected resume error %v", errs["paused"])
+	}
+	if running.Status != Running {
+		t.Fatal("expected running instance to be skipped")
+	}
+}
@@ -0,0 +1,97 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies what happened to an instance.
+type EventType string
+
+const (
+	EventCreated       EventType = "created"
+	EventStarted       EventType = "started"
+	EventPaused        EventType = "paused"
+	EventResumed       EventType = "resumed"
+	EventKilled        EventType = "killed"
+	EventStatusChanged EventType = "status_changed"
+)
+
+// eventBufferSize is how many events a subscriber can fall behind before events are dropped.
+const eventBufferSize = 64
+
+// Event is a lifecycle change of an instance.
+type Event struct {
+	// Title is the title of the instance.
+	Title string
+	// Branch is the instance's branch, if it has one yet.
+	Branch string
+	// Type is what happened.
+	Type EventType
+	// Status is the instance's status after the event.
+	Status Status
+	// Time is when the event happened.
+	Time time.Time
+}
+
+// EventBus fans instance lifecycle events out to subscribers. Instances publish to the bus set
+// with InstanceOptions.Events or SetEventBus. Publishing never blocks: a subscriber that falls
+// more than eventBufferSize events behind misses events until it catches up.
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[int]chan Event
+	nextID      int
+}
+
+// NewEventBus returns an event bus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[int]chan Event)}
+}
+
+// Subscribe returns a channel that receives every event published from now on, and a function
+// that unsubscribes and closes the channel.
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	ch := make(chan Event, eventBufferSize)
+	b.subscribers[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers, id)
+			close(ch)
+		})
+	}
+}
+
+// Publish sends event to every subscriber.
+func (b *EventBus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// SetEventBus sets the bus the instance publishes its lifecycle events to. Pass nil to stop
+// publishing.
+func (i *Instance) SetEventBus(bus *EventBus) {
+	i.events = bus
+}
+
+// emit publishes an event of the given type for the instance, if it has an event bus.
+func (i *Instance) emit(eventType EventType) {
+	if i.events == nil {
+		return
+	}
+	i.events.Publish(Event{Title: i.Title, Branch: i.Branch, Type: eventType, Status: i.Status, Time: time.Now()})
+}
@@ -0,0 +1,71 @@
+package session
+
+import (
+	"testing"
+
+	"agent-squad/session/tmux"
+	"agent-squad/session/tmux/tmuxtest"
+)
+
+func TestInstanceLifecycleEvents(t *testing.T) {
+	bus := NewEventBus()
+	events, unsubscribe := bus.Subscribe()
+
+	inst, err := NewInstance(InstanceOptions{Title: "events", Path: t.TempDir(), Program: "bash", Events: bus})
+	if err != nil {
+		t.Fatalf("NewInstance: %v", err)
+	}
+	inst.SetStatus(Running)
+	inst.SetStatus(Running)
+	inst.SetStatus(Ready)
+
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddSession(tmux.TmuxPrefix+"events", t.TempDir(), "bash")
+	inst.tmuxSession = tmuxtest.NewSession(server, "events", "bash")
+	inst.started = true
+	if err := inst.Kill(); err != nil {
+		t.Fatalf("Kill: %v", err)
+	}
+
+	unsubscribe()
+	unsubscribe()
+	var got []Event
+	for event := range events {
+		if event.Title != "events" || event.Time.IsZero() {
+			t.Fatalf("unexpected event %+v", event)
+		}
+		got = append(got, event)
+	}
+
+	want := []struct {
+		eventType EventType
+		status    Status
+	}{
+		{EventCreated, Ready},
+		{EventStatusChanged, Running},
+		{EventStatusChanged, Ready},
+		{EventKilled, Ready},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), got)
+	}
+	for n, w := range want {
+		if got[n].Type != w.eventType || got[n].Status != w.status {
+			t.Fatalf("event %d: expected %s/%v, got %+v", n, w.eventType, w.status, got[n])
+		}
+	}
+}
+
+func TestEventBusPublishDoesNotBlock(t *testing.T) {
+	bus := NewEventBus()
+	events, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+
+	for n := 0; n < eventBufferSize+10; n++ {
+		bus.Publish(Event{Title: "flood", Type: EventStatusChanged})
+	}
+	if len(events) != eventBufferSize {
+		t.Fatalf("expected a full buffer of %d events, got %d", eventBufferSize, len(events))
+	}
+}
@@ -1,6 +1,14 @@
 package git
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -13,19 +21,160 @@ type DiffStats struct {
 	Added int
 	// Removed is the number of removed lines
 	Removed int
+	// FilesChanged is the number of files with changes
+	FilesChanged int
+	// BinaryFilesChanged is how many of those files are binary. Their changes count no lines,
+	// and Content only has git's one-line "Binary files ... differ" summary for them.
+	BinaryFilesChanged int
+	// Context is the number of unchanged lines shown around the changes, and Hunks the number of
+	// hunks. Both are zero if the content was truncated.
+	Context int
+	Hunks   int
+	// Files breaks the changes down per file, in diff order.
+	Files []FileStat
+	// Truncated is true if Content was cut off at the worktree's diff size limit. Added and
+	// Removed still reflect the whole diff.
+	Truncated bool
 	// Error holds any error that occurred during diff computation
 	// This allows propagating setup errors (like missing base commit) without breaking the flow
 	Error error
 }
 
+// FileChangeKind is how a file changed.
+type FileChangeKind int
+
+const (
+	FileModified FileChangeKind = iota
+	FileAdded
+	FileDeleted
+	FileRenamed
+	FileCopied
+)
+
+// FileStat describes the changes to one file.
+type FileStat struct {
+	// OldPath is the path before the change, empty for added files. NewPath is the path after
+	// the change, empty for deleted files. They differ for renames and copies.
+	OldPath string
+	NewPath string
+	Change  FileChangeKind
+	Added   int
+	Removed int
+	// Binary is true for binary files, whose Added and Removed counts are always zero.
+	Binary bool
+	// Submodule is true if the path is a submodule. Its Added and Removed counts are zero, and
+	// OldCommit and NewCommit are the commits the submodule pointed at, empty if it was added or
+	// removed. Diffs truncated at the size limit count submodules as line changes instead.
+	Submodule bool
+	OldCommit string
+	NewCommit string
+}
+
+// Path returns the file's path after the change, or before it for deleted files.
+func (f FileStat) Path() string {
+	if f.NewPath != "" {
+		return f.NewPath
+	}
+	return f.OldPath
+}
+
 func (d *DiffStats) IsEmpty() bool {
 	return d.Added == 0 && d.Removed == 0 && d.Content == ""
 }
 
+// Summary returns a compact description of the diff for list views, e.g. "+42 -7 (5 files)" or
+// "+0 -0 (2 files, 2 binary)", "clean" for an empty diff or "diff error" if it could not be
+// computed. It is cheap enough to call on every render.
+func (d *DiffStats) Summary() string {
+	switch {
+	case d == nil:
+		return ""
+	case d.Error != nil:
+		return "diff error"
+	case d.IsEmpty():
+		return "clean"
+	}
+	buf := make([]byte, 0, 32)
+	buf = append(buf, '+')
+	buf = strconv.AppendInt(buf, int64(d.Added), 10)
+	buf = append(buf, " -"...)
+	buf = strconv.AppendInt(buf, int64(d.Removed), 10)
+	switch {
+	case d.FilesChanged == 1:
+		buf = append(buf, " (1 file"...)
+	case d.FilesChanged > 1:
+		buf = append(buf, " ("...)
+		buf = strconv.AppendInt(buf, int64(d.FilesChanged), 10)
+		buf = append(buf, " files"...)
+	}
+	if d.FilesChanged > 0 {
+		if d.BinaryFilesChanged > 0 {
+			buf = append(buf, ", "...)
+			buf = strconv.AppendInt(buf, int64(d.BinaryFilesChanged), 10)
+			buf = append(buf, " binary"...)
+		}
+		buf = append(buf, ')')
+	}
+	return string(buf)
+}
+
+// Equal reports whether d and other describe the same diff: the same counts, content and error.
+// Two nil stats are equal; a nil and a non-nil one are not.
+func (d *DiffStats) Equal(other *DiffStats) bool {
+	if d == nil || other == nil {
+		return d == other
+	}
+	if d.Added != other.Added || d.Removed != other.Removed || d.FilesChanged != other.FilesChanged ||
+		d.BinaryFilesChanged != other.BinaryFilesChanged || d.Context != other.Context || d.Hunks != other.Hunks || d.Truncated != other.Truncated ||
+		d.Content != other.Content {
+		return false
+	}
+	if d.Error == nil || other.Error == nil {
+		return d.Error == other.Error
+	}
+	return d.Error.Error() == other.Error.Error()
+}
+
+// ChangedSince reports whether d differs from the previously computed stats prev, e.g. to decide
+// whether a rendered diff needs to be redrawn.
+func (d *DiffStats) ChangedSince(prev *DiffStats) bool {
+	return !d.Equal(prev)
+}
+
 // Diff returns the git diff between the worktree and the base branch along with statistics.
 // If force is true, cached results are bypassed even when the status signature matches.
 func (g *GitWorktree) Diff(force bool) *DiffStats {
+	return g.DiffPaths(nil, force)
+}
+
+// DiffPaths is like Diff, but only covers changes under the given paths, which are git pathspecs
+// relative to the worktree root such as "services/api". No paths means the whole worktree.
+func (g *GitWorktree) DiffPaths(paths []string, force bool) *DiffStats {
+	return g.DiffWithOptions(DiffOptions{Paths: paths}, force)
+}
+
+// DiffIgnoreWhitespace is like Diff, but leaves out whitespace-only changes, e.g. when an agent
+// reindented whole files. Lines whose only change is whitespace are neither counted nor shown.
+func (g *GitWorktree) DiffIgnoreWhitespace(force bool) *DiffStats {
+	return g.DiffWithOptions(DiffOptions{IgnoreWhitespace: true}, force)
+}
+
+// DiffOptions configures DiffWithOptions.
+type DiffOptions struct {
+	// Paths limits the diff to changes under these pathspecs, as for DiffPaths.
+	Paths []string
+	// IgnoreWhitespace leaves out whitespace-only changes, as for DiffIgnoreWhitespace.
+	IgnoreWhitespace bool
+}
+
+// DiffWithOptions returns the diff configured by opts. Diffs with different options are cached
+// separately from each other.
+func (g *GitWorktree) DiffWithOptions(opts DiffOptions, force bool) *DiffStats {
 	stats := &DiffStats{}
+	if err := g.checkGitVersion(); err != nil {
+		stats.Error = err
+		return stats
+	}
 
 	g.diffMu.Lock()
 	defer g.diffMu.Unlock()
@@ -40,72 +189,541 @@ func (g *GitWorktree) Diff(force bool) *DiffStats {
 	g.lastDiffCheckedAt = now
 
 	statusSignature := statusOutput
+	// The pathspec covers both the requested paths and the excludes, so changing either one
+	// misses the cache.
+	pathspec := g.diffPathspec(opts.Paths)
+	diffKey := strings.Join(pathspec, "\x00")
+	var extraArgs []string
+	if opts.IgnoreWhitespace {
+		extraArgs = append(extraArgs, "--ignore-all-space")
+		diffKey += "\x00--ignore-all-space"
+	}
+	extraArgs = append(extraArgs, g.renameArgs()...)
 
-	if !force && g.lastDiff != nil && statusSignature == g.lastStatusSnapshot {
+	if !force && g.lastDiff != nil && statusSignature == g.lastStatusSnapshot && diffKey == g.lastDiffKey {
 		return cloneDiffStats(g.lastDiff)
 	}
 
-	if strings.Contains(statusOutput, "?? ") {
-		if _, err := g.runGitCommand(g.worktreePath, "add", "-N", "."); err != nil {
+	// Untracked files only show up in the diff once they are in the index. Mark them with add -N
+	// in a copy of the index so reading the diff does not change what the agent has staged.
+	var env []string
+	if strings.Contains(statusOutput, "?? ") && !g.excludeUntracked {
+		indexEnv, cleanup, err := g.temporaryIndex()
+		if err != nil {
 			stats.Error = err
 			return stats
 		}
+		defer cleanup()
+		env = indexEnv
 
-		statusOutput, err = g.runGitCommand(g.worktreePath, "status", "--porcelain")
-		if err != nil {
+		if _, err := g.runGitCommandEnv(g.worktreePath, env, "add", "-N", "."); err != nil {
 			stats.Error = err
 			return stats
 		}
-		statusSignature = statusOutput
 	}
 
-	content, err := g.runGitCommand(g.worktreePath, "--no-pager", "diff", g.GetBaseCommitSHA())
+	// Pin the submodule format, which diff.submodule could otherwise change, to the one
+	// countDiffStats understands.
+	diffArgs := append([]string{"--no-pager", "diff", "--submodule=short"}, extraArgs...)
+	diffArgs = append(append(diffArgs, g.GetBaseCommitSHA()), pathspec...)
+	content, truncated, err := g.runGitCommandLimited(g.worktreePath, env, g.maxDiffBytes, diffArgs...)
 	if err != nil {
 		stats.Error = err
 		return stats
 	}
 
-	added, removed := countDiffStats(content)
-	stats.Added = added
-	stats.Removed = removed
-	stats.Content = content
+	if truncated {
+		// The content is incomplete, so take the line counts from numstat instead.
+		numstatArgs := append([]string{"--no-pager", "diff", "--numstat"}, extraArgs...)
+		numstatArgs = append(append(numstatArgs, g.GetBaseCommitSHA()), pathspec...)
+		numstat, err := g.runGitCommandEnv(g.worktreePath, env, numstatArgs...)
+		if err != nil {
+			stats.Error = err
+			return stats
+		}
+		counts := countNumstat(numstat)
+		stats.Added, stats.Removed, stats.FilesChanged = counts.added, counts.removed, counts.files
+		stats.BinaryFilesChanged, stats.Files = counts.binary, counts.fileStats
+		stats.Content = truncateDiffContent(content, g.maxDiffBytes)
+		stats.Truncated = true
+	} else {
+		counts := countDiffStats(content)
+		stats.Added, stats.Removed, stats.FilesChanged = counts.added, counts.removed, counts.files
+		stats.Context, stats.Hunks = counts.context, counts.hunks
+		stats.BinaryFilesChanged, stats.Files = counts.binary, counts.fileStats
+		stats.Content = content
+	}
 
 	g.lastStatusSnapshot = statusSignature
+	g.lastDiffKey = diffKey
 	g.lastDiff = cloneDiffStats(stats)
 
 	return stats
 }
 
+// PatchOptions configures ExportPatch.
+type PatchOptions struct {
+	// IncludeUntracked adds untracked files to the patch as new files.
+	IncludeUntracked bool
+}
+
+// ExportPatch writes the worktree's changes against the base commit as a binary-safe patch that
+// applies with `git apply` on a checkout of the base commit.
+func (g *GitWorktree) ExportPatch(w io.Writer, opts PatchOptions) error {
+	base := g.GetBaseCommitSHA()
+	if base == "" {
+		return fmt.Errorf("base commit SHA not set")
+	}
+
+	g.diffMu.Lock()
+	defer g.diffMu.Unlock()
+
+	args := []string{"--no-pager", "diff", "--binary", base}
+	var env []string
+	if opts.IncludeUntracked {
+		indexEnv, cleanup, err := g.temporaryIndex()
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		env = indexEnv
+		if _, err := g.runGitCommandEnv(g.worktreePath, env, "add", "-N", "."); err != nil {
+			return fmt.Errorf("failed to mark untracked files: %w", err)
+		}
+	} else {
+		// Files marked with add -N show up as new files even though nothing about them is staged.
+		// Leave those out; against the index, a new file can only be an intent-to-add entry.
+		output, err := g.runGitCommand(g.worktreePath, "diff", "--name-only", "--diff-filter=A")
+		if err != nil {
+			return fmt.Errorf("failed to list untracked files: %w", err)
+		}
+		var excludes []string
+		for _, line := range strings.Split(output, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				excludes = append(excludes, ":(exclude,literal)"+line)
+			}
+		}
+		if len(excludes) > 0 {
+			args = append(append(args, "--", "."), excludes...)
+		}
+	}
+
+	// Stream stdout straight to w; warnings on stderr must not end up in the patch.
+	cmd := exec.Command("git", append([]string{"-C", g.worktreePath}, args...)...)
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := g.executor().Run(cmd); err != nil {
+		return fmt.Errorf("failed to generate patch: %s (%w)", stderr.String(), err)
+	}
+	return nil
+}
+
+// WorkInProgressPatch returns the worktree's uncommitted changes, including untracked files, as a
+// binary-safe patch against HEAD. It is empty if the worktree is clean.
+func (g *GitWorktree) WorkInProgressPatch() (string, error) {
+	g.diffMu.Lock()
+	defer g.diffMu.Unlock()
+
+	env, cleanup, err := g.temporaryIndex()
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	if _, err := g.runGitCommandEnv(g.worktreePath, env, "add", "-N", "."); err != nil {
+		return "", fmt.Errorf("failed to mark untracked files: %w", err)
+	}
+	cmd := exec.Command("git", "-C", g.worktreePath, "--no-pager", "diff", "--binary", "HEAD")
+	cmd.Env = append(os.Environ(), env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := g.executor().Run(cmd); err != nil {
+		return "", fmt.Errorf("failed to generate patch: %s (%w)", stderr.String(), err)
+	}
+	return stdout.String(), nil
+}
+
+// ApplyPatch applies a patch such as one from WorkInProgressPatch to the worktree without
+// committing it.
+func (g *GitWorktree) ApplyPatch(patch string) error {
+	cmd := exec.Command("git", "-C", g.worktreePath, "apply", "--binary", "-")
+	cmd.Stdin = strings.NewReader(patch)
+	if output, err := g.combinedOutput(cmd); err != nil {
+		return fmt.Errorf("failed to apply patch: %s (%w)", output, err)
+	}
+	g.InvalidateDiffCache()
+	return nil
+}
+
+// diffPathspec returns the pathspec arguments that limit a diff to paths, or the whole worktree
+// if there are none, minus the configured exclude patterns. Callers must hold diffMu.
+func (g *GitWorktree) diffPathspec(paths []string) []string {
+	excludes := append(slices.Clone(g.configDiffExcludes), g.diffExcludes...)
+	if len(paths) == 0 && len(excludes) == 0 {
+		return nil
+	}
+	args := []string{"--"}
+	if len(paths) == 0 {
+		args = append(args, ".")
+	}
+	args = append(args, paths...)
+	for _, pattern := range excludes {
+		args = append(args, ":(exclude)"+pattern)
+	}
+	return args
+}
+
+// temporaryIndex copies the worktree's index to a temporary file. It returns the environment that
+// points git at the copy, and a function that removes it.
+func (g *GitWorktree) temporaryIndex() ([]string, func(), error) {
+	output, err := g.runGitCommand(g.worktreePath, "rev-parse", "--git-path", "index")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to locate index: %w", err)
+	}
+	indexPath := strings.TrimSpace(output)
+	if !filepath.IsAbs(indexPath) {
+		indexPath = filepath.Join(g.worktreePath, indexPath)
+	}
+
+	dir, err := os.MkdirTemp("", "agent-squad-index")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temporary index: %w", err)
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+	tempIndex := filepath.Join(dir, "index")
+
+	// A repository without an index yet is fine; git creates the copy from scratch.
+	data, err := os.ReadFile(indexPath)
+	if err == nil {
+		err = os.WriteFile(tempIndex, data, 0o644)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to copy index: %w", err)
+	}
+	return []string{"GIT_INDEX_FILE=" + tempIndex}, cleanup, nil
+}
+
+// runGitCommandLimited runs a git command and reads at most limit bytes of its output, stopping
+// the command early if it produces more. A limit of zero or less reads the whole output.
+func (g *GitWorktree) runGitCommandLimited(path string, env []string, limit int, args ...string) (string, bool, error) {
+	if limit <= 0 {
+		output, err := g.runGitCommandEnv(path, env, args...)
+		return output, false, err
+	}
+	if g.cmdExec != nil {
+		// Injected executors can't stream, so read everything and cut it down afterwards.
+		output, err := g.runGitCommandEnv(path, env, args...)
+		if err != nil || len(output) <= limit {
+			return output, false, err
+		}
+		return output[:limit], true, nil
+	}
+
+	cmd := exec.Command("git", append([]string{"-C", path}, args...)...)
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", false, fmt.Errorf("git command failed: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return "", false, fmt.Errorf("git command failed: %w", err)
+	}
+
+	// Read one byte past the limit to tell whether the output was cut off.
+	output, readErr := io.ReadAll(io.LimitReader(stdout, int64(limit)+1))
+	if len(output) > limit {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return string(output[:limit]), true, nil
+	}
+	if err := cmd.Wait(); err != nil {
+		return "", false, fmt.Errorf("git command failed: %s (%w)", stderr.String(), err)
+	}
+	if readErr != nil {
+		return "", false, fmt.Errorf("git command failed: %w", readErr)
+	}
+	return string(output), false, nil
+}
+
+// truncateDiffContent trims content back to the last complete line and appends a marker noting
+// that the diff exceeded limit bytes.
+func truncateDiffContent(content string, limit int) string {
+	if idx := strings.LastIndexByte(content, '\n'); idx >= 0 {
+		content = content[:idx+1]
+	}
+	return content + fmt.Sprintf("\n... diff truncated: exceeds %d bytes ...\n", limit)
+}
+
+// renameArgs returns the git diff options for the worktree's rename and copy detection.
+func (g *GitWorktree) renameArgs() []string {
+	if g.renameThreshold < 0 {
+		return []string{"--no-renames"}
+	}
+	threshold := ""
+	if g.renameThreshold > 0 {
+		threshold = "=" + strconv.Itoa(g.renameThreshold) + "%"
+	}
+	args := []string{"--find-renames" + threshold}
+	if g.findCopies {
+		args = append(args, "--find-copies"+threshold)
+	}
+	return args
+}
+
+// countNumstat sums the added and removed line counts from `git diff --numstat` output and
+// counts the files. Binary files, whose line counts are reported as "-", count no lines.
+// Numstat does not tell added or deleted files apart from modified ones, and reports copies as
+// renames.
+func countNumstat(output string) diffCounts {
+	var counts diffCounts
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		counts.files++
+		file := FileStat{}
+		if fields[0] == "-" && fields[1] == "-" {
+			counts.binary++
+			file.Binary = true
+		}
+		if n, err := strconv.Atoi(fields[0]); err == nil {
+			counts.added += n
+			file.Added = n
+		}
+		if n, err := strconv.Atoi(fields[1]); err == nil {
+			counts.removed += n
+			file.Removed = n
+		}
+		file.OldPath, file.NewPath = parseNumstatPath(fields[2])
+		if file.OldPath != file.NewPath {
+			file.Change = FileRenamed
+		}
+		counts.fileStats = append(counts.fileStats, file)
+	}
+	return counts
+}
+
+// parseNumstatPath splits a numstat path into the old and new path. Renames are written as
+// "old => new", or with the common parts factored out as "dir/{old => new}/file".
+func parseNumstatPath(path string) (oldPath, newPath string) {
+	if !strings.Contains(path, " => ") {
+		path = unquotePath(path)
+		return path, path
+	}
+	prefix, rest, braced := strings.Cut(path, "{")
+	if !braced {
+		oldPath, newPath, _ = strings.Cut(path, " => ")
+		return oldPath, newPath
+	}
+	inner, suffix, _ := strings.Cut(rest, "}")
+	oldPart, newPart, _ := strings.Cut(inner, " => ")
+	join := func(part string) string {
+		// An empty part leaves a doubled or leading slash, e.g. "{ => dir}/file".
+		joined := strings.ReplaceAll(prefix+part+suffix, "//", "/")
+		return strings.TrimPrefix(joined, "/")
+	}
+	return join(oldPart), join(newPart)
+}
+
+// unquotePath undoes the C-style quoting git applies to paths with unusual characters.
+func unquotePath(path string) string {
+	if strings.HasPrefix(path, `"`) {
+		if unquoted, err := strconv.Unquote(path); err == nil {
+			return unquoted
+		}
+	}
+	return path
+}
+
+// Clone returns a copy of d that shares nothing with it. Cloning nil returns nil.
+func (d *DiffStats) Clone() *DiffStats {
+	return cloneDiffStats(d)
+}
+
 func cloneDiffStats(src *DiffStats) *DiffStats {
 	if src == nil {
 		return nil
 	}
 	copy := *src
+	copy.Files = slices.Clone(src.Files)
 	return &copy
 }
 
-func countDiffStats(content string) (int, int) {
-	var added, removed int
-	if content == "" {
-		return 0, 0
-	}
+// noNewlineMarkerPrefix starts the "\ No newline at end of file" line git writes after the last
+// line of a file without a final newline. It is not a line of either side of the diff.
+const noNewlineMarkerPrefix = "\\ "
+
+// submoduleMode is the file mode git gives submodule entries, and subprojectPrefix starts the
+// lines that stand for a submodule's commit in a hunk.
+const (
+	submoduleMode    = "160000"
+	subprojectPrefix = "Subproject commit "
+)
+
+// diffCounts are the line, hunk and file counts of a diff.
+type diffCounts struct {
+	added, removed, context int
+	hunks, files, binary    int
+	fileStats               []FileStat
+}
+
+// countDiffStats counts the lines, hunks and files in unified diff content. Lines are only
+// counted inside hunks, using the line counts of each hunk header to tell where the hunk ends, so
+// metadata such as "--- a/file" is never mistaken for a removed line and content lines that
+// themselves start with "---" or "+++" are not skipped. The "Subproject commit" lines of
+// submodules are recorded on the file instead of counted.
+func countDiffStats(content string) diffCounts {
+	var counts diffCounts
+	// file is the entry of the file whose header or hunks are being read.
+	var file *FileStat
+	// oldLeft and newLeft are the lines of the current hunk still to come on each side.
+	var oldLeft, newLeft int
+	for _, line := range strings.Split(content, "\n") {
+		if oldLeft > 0 || newLeft > 0 {
+			switch {
+			case file.Submodule && strings.HasPrefix(line, "+"+subprojectPrefix):
+				file.NewCommit = strings.TrimSuffix(strings.TrimPrefix(line, "+"+subprojectPrefix), "-dirty")
+				newLeft--
+			case file.Submodule && strings.HasPrefix(line, "-"+subprojectPrefix):
+				file.OldCommit = strings.TrimSuffix(strings.TrimPrefix(line, "-"+subprojectPrefix), "-dirty")
+				oldLeft--
+			case strings.HasPrefix(line, "+"):
+				counts.added++
+				file.Added++
+				newLeft--
+			case strings.HasPrefix(line, "-"):
+				counts.removed++
+				file.Removed++
+				oldLeft--
+			case strings.HasPrefix(line, noNewlineMarkerPrefix):
+				// The marker belongs to the line before it, which was already counted: it only
+				// says that line has no final newline.
+			default:
+				// A context line. Some tools strip the leading space of blank context lines.
+				counts.context++
+				oldLeft--
+				newLeft--
+			}
+			continue
+		}
 
-	lines := strings.Split(content, "\n")
-	for _, line := range lines {
-		if len(line) == 0 {
+		if strings.HasPrefix(line, "diff --git ") {
+			counts.files++
+			oldPath, newPath := parseDiffGitPaths(strings.TrimPrefix(line, "diff --git "))
+			counts.fileStats = append(counts.fileStats, FileStat{OldPath: oldPath, NewPath: newPath})
+			file = &counts.fileStats[len(counts.fileStats)-1]
+			continue
+		}
+		if file == nil {
 			continue
 		}
-		if line[0] == '+' {
-			if strings.HasPrefix(line, "+++") {
-				continue
+		// Anything else outside a hunk is metadata describing the current file.
+		switch {
+		case strings.HasPrefix(line, "@@ "):
+			oldLines, newLines, ok := parseHunkHeader(line)
+			if ok {
+				counts.hunks++
+				oldLeft, newLeft = oldLines, newLines
 			}
-			added++
-		} else if line[0] == '-' {
-			if strings.HasPrefix(line, "---") {
-				continue
+		case strings.HasPrefix(line, "new file mode "):
+			file.Change, file.OldPath = FileAdded, ""
+			file.Submodule = strings.HasSuffix(line, " "+submoduleMode)
+		case strings.HasPrefix(line, "deleted file mode "):
+			file.Change, file.NewPath = FileDeleted, ""
+			file.Submodule = strings.HasSuffix(line, " "+submoduleMode)
+		case strings.HasPrefix(line, "index ") && strings.HasSuffix(line, " "+submoduleMode):
+			file.Submodule = true
+		case strings.HasPrefix(line, "rename from "):
+			file.Change, file.OldPath = FileRenamed, unquotePath(strings.TrimPrefix(line, "rename from "))
+		case strings.HasPrefix(line, "rename to "):
+			file.NewPath = unquotePath(strings.TrimPrefix(line, "rename to "))
+		case strings.HasPrefix(line, "copy from "):
+			file.Change, file.OldPath = FileCopied, unquotePath(strings.TrimPrefix(line, "copy from "))
+		case strings.HasPrefix(line, "copy to "):
+			file.NewPath = unquotePath(strings.TrimPrefix(line, "copy to "))
+		case strings.HasPrefix(line, "Binary files ") && strings.HasSuffix(line, " differ"),
+			line == "GIT binary patch":
+			if !file.Binary {
+				counts.binary++
+				file.Binary = true
 			}
-			removed++
 		}
 	}
-	return added, removed
+	return counts
+}
+
+// parseDiffGitPaths returns the paths of a "diff --git a/old b/new" header line, without the
+// line's prefix. The header is ambiguous when paths contain " b/", so it is only a fallback: the
+// new file, deleted file, rename and copy lines that follow override it.
+func parseDiffGitPaths(header string) (oldPath, newPath string) {
+	if strings.HasPrefix(header, `"`) {
+		// Quoted paths: "a/old" "b/new", either of which may be unquoted.
+		if end := quotedEnd(header); end > 0 {
+			oldPath = unquotePath(header[:end])
+			newPath = unquotePath(strings.TrimPrefix(header[end:], " "))
+			return strings.TrimPrefix(oldPath, "a/"), strings.TrimPrefix(newPath, "b/")
+		}
+	}
+	// Unless the file was renamed both paths are the same, so split the header in half.
+	if half := len(header) / 2; len(header)%2 == 1 && header[half] == ' ' &&
+		strings.TrimPrefix(header[:half], "a/") == strings.TrimPrefix(header[half+1:], "b/") {
+		path := strings.TrimPrefix(header[:half], "a/")
+		return path, path
+	}
+	oldPath, newPath, _ = strings.Cut(header, " b/")
+	return strings.TrimPrefix(oldPath, "a/"), unquotePath(newPath)
+}
+
+// quotedEnd returns the index just past the closing quote of the quoted string s starts with,
+// or -1 if it is not terminated.
+func quotedEnd(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// parseHunkHeader returns the number of old and new lines covered by a hunk header such as
+// "@@ -12,5 +12,7 @@ func main() {". A range without a count covers one line.
+func parseHunkHeader(line string) (oldLines, newLines int, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[0] != "@@" || fields[3] != "@@" ||
+		!strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return 0, 0, false
+	}
+	oldLines, ok = hunkRangeLines(fields[1][1:])
+	if !ok {
+		return 0, 0, false
+	}
+	newLines, ok = hunkRangeLines(fields[2][1:])
+	return oldLines, newLines, ok
+}
+
+func hunkRangeLines(r string) (int, bool) {
+	start, count, found := strings.Cut(r, ",")
+	if _, err := strconv.Atoi(start); err != nil {
+		return 0, false
+	}
+	if !found {
+		return 1, true
+	}
+	n, err := strconv.Atoi(count)
+	return n, err == nil && n >= 0
 }
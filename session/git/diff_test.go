@@ -1,9 +1,12 @@
 package git
 
 import (
+	"agent-squad/config"
+	"errors"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -60,6 +63,175 @@ func TestGitWorktreeDiffCachesStatusSnapshots(t *testing.T) {
 	}
 }
 
+func TestGitWorktreeDiffTruncatesLargeContent(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+	wt.maxDiffBytes = 512
+
+	var content strings.Builder
+	for i := 0; i < 200; i++ {
+		content.WriteString("a fairly long line of generated content\n")
+	}
+	if err := os.WriteFile(filepath.Join(repo, "big.txt"), []byte(content.String()), 0o644); err != nil {
+		t.Fatalf("write big file: %v", err)
+	}
+
+	stats := wt.Diff(true)
+	if stats.Error != nil {
+		t.Fatalf("Diff: %v", stats.Error)
+	}
+	if !stats.Truncated {
+		t.Fatal("expected diff to be truncated")
+	}
+	if !strings.Contains(stats.Content, "diff truncated") {
+		t.Fatalf("expected truncation marker in content, got %q", stats.Content)
+	}
+	if len(stats.Content) > 600 {
+		t.Fatalf("expected content to be bounded, got %d bytes", len(stats.Content))
+	}
+	if stats.Added != 200 || stats.Removed != 0 || stats.FilesChanged != 1 {
+		t.Fatalf("expected numstat counts 200/0 in 1 file, got %d/%d in %d", stats.Added, stats.Removed, stats.FilesChanged)
+	}
+}
+
+func TestGitWorktreeDiffExcludes(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+
+	if err := os.WriteFile(filepath.Join(repo, "file.txt"), []byte("changed\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "deps.lock"), []byte("generated\n"), 0o644); err != nil {
+		t.Fatalf("write lockfile: %v", err)
+	}
+
+	stats := wt.Diff(true)
+	if stats.Error != nil {
+		t.Fatalf("Diff: %v", stats.Error)
+	}
+	if !strings.Contains(stats.Content, "deps.lock") {
+		t.Fatal("expected lockfile in unfiltered diff")
+	}
+
+	wt.SetDiffExcludes([]string{"*.lock"})
+	stats = wt.Diff(false)
+	if stats.Error != nil {
+		t.Fatalf("Diff with excludes: %v", stats.Error)
+	}
+	if strings.Contains(stats.Content, "deps.lock") {
+		t.Fatal("expected lockfile to be excluded from the diff")
+	}
+	if !strings.Contains(stats.Content, "file.txt") || stats.Added != 1 || stats.Removed != 1 {
+		t.Fatalf("expected only file.txt changes, got +%d -%d:\n%s", stats.Added, stats.Removed, stats.Content)
+	}
+}
+
+func TestGitWorktreeDiffExcludePathsConfig(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+
+	if err := os.WriteFile(filepath.Join(repo, "file.txt"), []byte("changed\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "package-lock.json"), []byte("{}\n"), 0o644); err != nil {
+		t.Fatalf("write lockfile: %v", err)
+	}
+	if stats := wt.Diff(false); !strings.Contains(stats.Content, "package-lock.json") {
+		t.Fatalf("expected lockfile in unfiltered diff:\n%s", stats.Content)
+	}
+
+	// Applying the config changes the pathspec, so the cached diff must not be reused.
+	wt.ApplyConfig(&config.Config{DiffExcludePaths: []string{"package-lock.json"}})
+	stats := wt.Diff(false)
+	if stats.Error != nil {
+		t.Fatalf("Diff: %v", stats.Error)
+	}
+	if strings.Contains(stats.Content, "package-lock.json") {
+		t.Fatalf("expected configured path to be excluded:\n%s", stats.Content)
+	}
+	if stats.Added != 1 || stats.Removed != 1 {
+		t.Fatalf("expected counts of file.txt only, got +%d -%d", stats.Added, stats.Removed)
+	}
+}
+
+func TestGitWorktreeDiffPaths(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+
+	if err := os.MkdirAll(filepath.Join(repo, "services", "api"), 0o755); err != nil {
+		t.Fatalf("create dirs: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "services", "api", "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatalf("write api file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "file.txt"), []byte("changed\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	scoped := wt.DiffPaths([]string{"services/api"}, false)
+	if scoped.Error != nil {
+		t.Fatalf("DiffPaths: %v", scoped.Error)
+	}
+	if !strings.Contains(scoped.Content, "services/api/main.go") || strings.Contains(scoped.Content, "file.txt") {
+		t.Fatalf("expected only the api change in the scoped diff:\n%s", scoped.Content)
+	}
+	if scoped.Added != 1 || scoped.Removed != 0 {
+		t.Fatalf("expected +1 -0 in the scoped diff, got +%d -%d", scoped.Added, scoped.Removed)
+	}
+
+	// The unscoped diff must not be served from the scoped diff's cache entry, or vice versa.
+	full := wt.Diff(false)
+	if !strings.Contains(full.Content, "file.txt") || !strings.Contains(full.Content, "services/api/main.go") {
+		t.Fatalf("expected both changes in the full diff:\n%s", full.Content)
+	}
+	if again := wt.DiffPaths([]string{"services/api"}, false); strings.Contains(again.Content, "file.txt") {
+		t.Fatalf("expected the scoped diff again, got:\n%s", again.Content)
+	}
+
+	wt.SetDiffExcludes([]string{"*.go"})
+	if excluded := wt.DiffPaths([]string{"services"}, false); !excluded.IsEmpty() {
+		t.Fatalf("expected excludes to apply to scoped diffs, got:\n%s", excluded.Content)
+	}
+}
+
+func TestGitWorktreeDiffIgnoreWhitespace(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+	writeAndCommit(t, repo, "code.txt", "if x {\nrun()\n}\n", "add code")
+	wt.baseCommitSHA = strings.TrimSpace(runGit(t, repo, "rev-parse", "HEAD"))
+
+	reindented := "if x {\n\trun()\n}\n"
+	if err := os.WriteFile(filepath.Join(repo, "code.txt"), []byte(reindented), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "file.txt"), []byte("changed\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	compact := wt.DiffIgnoreWhitespace(false)
+	if compact.Error != nil {
+		t.Fatalf("DiffIgnoreWhitespace: %v", compact.Error)
+	}
+	if compact.Added != 1 || compact.Removed != 1 || compact.FilesChanged != 1 || strings.Contains(compact.Content, "code.txt") {
+		t.Fatalf("expected only the file.txt change, got %+v", compact)
+	}
+
+	// The real diff is cached separately and still has the reindented line.
+	full := wt.Diff(false)
+	if full.Added != 2 || full.Removed != 2 || !strings.Contains(full.Content, "code.txt") {
+		t.Fatalf("expected the whitespace change in the full diff, got %+v", full)
+	}
+	if again := wt.DiffIgnoreWhitespace(false); strings.Contains(again.Content, "code.txt") {
+		t.Fatalf("expected the compact diff again, got:\n%s", again.Content)
+	}
+
+	// The numstat fallback for large diffs ignores whitespace too.
+	wt.maxDiffBytes = 1
+	if truncated := wt.DiffIgnoreWhitespace(true); !truncated.Truncated || truncated.Added != 1 || truncated.Removed != 1 {
+		t.Fatalf("expected whitespace to be ignored in numstat counts, got %+v", truncated)
+	}
+}
+
 func setupTempRepo(t testing.TB) string {
 	t.Helper()
 	dir := t.TempDir()
@@ -88,3 +260,401 @@ func runGit(t testing.TB, dir string, args ...string) string {
 	}
 	return string(out)
 }
+
+func TestExportPatchAppliesToBase(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+
+	writeAndCommit(t, repo, "committed.txt", "committed\n", "session commit")
+	if err := os.WriteFile(filepath.Join(repo, "file.txt"), []byte("hello world\nedited\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "untracked.txt"), []byte("new\n"), 0o644); err != nil {
+		t.Fatalf("write untracked: %v", err)
+	}
+	// An intent-to-add entry has nothing staged, so it still counts as untracked.
+	runGit(t, repo, "add", "-N", "untracked.txt")
+
+	var without strings.Builder
+	if err := wt.ExportPatch(&without, PatchOptions{}); err != nil {
+		t.Fatalf("ExportPatch: %v", err)
+	}
+	if strings.Contains(without.String(), "untracked.txt") {
+		t.Fatal("expected untracked file to be left out of the patch")
+	}
+
+	var with strings.Builder
+	if err := wt.ExportPatch(&with, PatchOptions{IncludeUntracked: true}); err != nil {
+		t.Fatalf("ExportPatch: %v", err)
+	}
+
+	checkout := t.TempDir()
+	runGit(t, checkout, "clone", "--quiet", repo, ".")
+	runGit(t, checkout, "checkout", "--quiet", wt.GetBaseCommitSHA())
+	patchPath := filepath.Join(t.TempDir(), "session.patch")
+	if err := os.WriteFile(patchPath, []byte(with.String()), 0o644); err != nil {
+		t.Fatalf("write patch: %v", err)
+	}
+	runGit(t, checkout, "apply", patchPath)
+
+	for name, want := range map[string]string{
+		"file.txt":      "hello world\nedited\n",
+		"committed.txt": "committed\n",
+		"untracked.txt": "new\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(checkout, name))
+		if err != nil || string(got) != want {
+			t.Fatalf("expected %s to be %q after apply, got %q (%v)", name, want, got, err)
+		}
+	}
+}
+
+func TestDiffUntrackedFilesLeaveIndexAlone(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+
+	if err := os.WriteFile(filepath.Join(repo, "untracked.txt"), []byte("new\n"), 0o644); err != nil {
+		t.Fatalf("write untracked: %v", err)
+	}
+	statusBefore := runGit(t, repo, "status", "--porcelain")
+
+	stats := wt.Diff(true)
+	if stats.Error != nil {
+		t.Fatalf("Diff: %v", stats.Error)
+	}
+	if !strings.Contains(stats.Content, "+new") || stats.Added != 1 {
+		t.Fatalf("expected untracked file in diff, got %+v", stats)
+	}
+	if statusAfter := runGit(t, repo, "status", "--porcelain"); statusAfter != statusBefore {
+		t.Fatalf("expected Diff to leave the index alone, status went from %q to %q", statusBefore, statusAfter)
+	}
+
+	include := false
+	wt.ApplyConfig(&config.Config{DiffIncludeUntracked: &include})
+	stats = wt.Diff(true)
+	if stats.Error != nil {
+		t.Fatalf("Diff: %v", stats.Error)
+	}
+	if !stats.IsEmpty() {
+		t.Fatalf("expected untracked file to be left out, got %+v", stats)
+	}
+}
+
+func TestDiffStatsEqual(t *testing.T) {
+	base := &DiffStats{Added: 1, Removed: 2, Content: "+a\n-b\n-c\n"}
+	same := &DiffStats{Added: 1, Removed: 2, Content: "+a\n-b\n-c\n"}
+	tests := []struct {
+		name  string
+		a, b  *DiffStats
+		equal bool
+	}{
+		{"both nil", nil, nil, true},
+		{"nil and computed", nil, base, false},
+		{"computed and nil", base, nil, false},
+		{"same values", base, same, true},
+		{"different counts", base, &DiffStats{Added: 2, Removed: 2, Content: base.Content}, false},
+		{"different content", base, &DiffStats{Added: 1, Removed: 2, Content: "+x\n-b\n-c\n"}, false},
+		{"truncated", base, &DiffStats{Added: 1, Removed: 2, Content: base.Content, Truncated: true}, false},
+		{"same error", &DiffStats{Error: errors.New("boom")}, &DiffStats{Error: errors.New("boom")}, true},
+		{"error and no error", &DiffStats{Error: errors.New("boom")}, &DiffStats{}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Equal(tt.b); got != tt.equal {
+			t.Errorf("%s: expected Equal to be %v", tt.name, tt.equal)
+		}
+		if got := tt.a.ChangedSince(tt.b); got == tt.equal {
+			t.Errorf("%s: expected ChangedSince to be %v", tt.name, !tt.equal)
+		}
+	}
+}
+
+func TestDiffStatsSummary(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+	if got := wt.Diff(true).Summary(); got != "clean" {
+		t.Fatalf("expected a clean summary, got %q", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(repo, "file.txt"), []byte("changed\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "new.txt"), []byte("one\ntwo\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	stats := wt.Diff(true)
+	if stats.FilesChanged != 2 {
+		t.Fatalf("expected 2 files changed, got %d", stats.FilesChanged)
+	}
+	if got := stats.Summary(); got != "+3 -1 (2 files)" {
+		t.Fatalf("unexpected summary %q", got)
+	}
+
+	for _, tt := range []struct {
+		stats *DiffStats
+		want  string
+	}{
+		{nil, ""},
+		{&DiffStats{Error: errors.New("boom")}, "diff error"},
+		{&DiffStats{Added: 1, Content: "+a", FilesChanged: 1}, "+1 -0 (1 file)"},
+		{&DiffStats{Added: 1, Content: "+a"}, "+1 -0"},
+		{&DiffStats{Content: "Binary files differ", FilesChanged: 2, BinaryFilesChanged: 2}, "+0 -0 (2 files, 2 binary)"},
+	} {
+		if got := tt.stats.Summary(); got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestCountDiffStats(t *testing.T) {
+	content := strings.Join([]string{
+		"diff --git a/list.md b/list.md",
+		"index 1111111..2222222 100644",
+		"--- a/list.md",
+		"+++ b/list.md",
+		"@@ -1,4 +1,3 @@ heading",
+		" # items",
+		"--- old separator",
+		"+++ new separator",
+		" - item",
+		"-- nested",
+		"@@ -10 +10,2 @@",
+		"-+ plus item",
+		"++ plus item",
+		"+",
+		"diff --git a/new.txt b/new.txt",
+		"new file mode 100644",
+		"index 0000000..3333333",
+		"--- /dev/null",
+		"+++ b/new.txt",
+		"@@ -0,0 +1 @@",
+		"+hello",
+		"",
+	}, "\n")
+
+	got := countDiffStats(content)
+	want := diffCounts{
+		added: 4, removed: 3, context: 2, hunks: 3, files: 2,
+		fileStats: []FileStat{
+			{OldPath: "list.md", NewPath: "list.md", Change: FileModified, Added: 3, Removed: 3},
+			{NewPath: "new.txt", Change: FileAdded, Added: 1},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestDiffDetectsRenames(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+
+	content := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\n"
+	if err := os.Mkdir(filepath.Join(repo, "docs"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	writeAndCommit(t, repo, "docs/old.txt", content, "add old")
+	wt.baseCommitSHA = strings.TrimSpace(runGit(t, repo, "rev-parse", "HEAD"))
+	runGit(t, repo, "mv", "docs/old.txt", "docs/new.txt")
+	if err := os.WriteFile(filepath.Join(repo, "docs", "new.txt"), []byte(content+"nine\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	want := []FileStat{{OldPath: "docs/old.txt", NewPath: "docs/new.txt", Change: FileRenamed, Added: 1}}
+	stats := wt.Diff(true)
+	if stats.Error != nil {
+		t.Fatalf("Diff: %v", stats.Error)
+	}
+	if !reflect.DeepEqual(stats.Files, want) || stats.Added != 1 || stats.Removed != 0 {
+		t.Fatalf("expected a rename, got %+v", stats)
+	}
+
+	// The numstat fallback for large diffs reports the same rename.
+	wt.maxDiffBytes = 1
+	wt.lastDiff = nil
+	stats = wt.Diff(true)
+	if !stats.Truncated || !reflect.DeepEqual(stats.Files, want) {
+		t.Fatalf("expected a rename from numstat, got %+v", stats.Files)
+	}
+
+	// A negative threshold disables rename detection.
+	wt.ApplyConfig(&config.Config{DiffRenameThreshold: -1})
+	stats = wt.Diff(true)
+	if len(stats.Files) != 2 || stats.Files[0].Change != FileAdded || stats.Files[1].Change != FileDeleted {
+		t.Fatalf("expected an add and a delete, got %+v", stats.Files)
+	}
+}
+
+func TestDiffCountsBinaryFiles(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+
+	if err := os.WriteFile(filepath.Join(repo, "image.png"), []byte("\x89PNG\x00\x01\x02"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "file.txt"), []byte("changed\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	check := func(stats *DiffStats) {
+		t.Helper()
+		if stats.Error != nil {
+			t.Fatalf("Diff: %v", stats.Error)
+		}
+		if stats.FilesChanged != 2 || stats.BinaryFilesChanged != 1 || stats.Added != 1 || stats.Removed != 1 {
+			t.Fatalf("unexpected stats %+v", stats)
+		}
+		binary := 0
+		for _, file := range stats.Files {
+			if file.Binary {
+				binary++
+				if file.Path() != "image.png" || file.Added != 0 || file.Removed != 0 {
+					t.Fatalf("unexpected binary file %+v", file)
+				}
+			}
+		}
+		if binary != 1 {
+			t.Fatalf("expected one binary file, got %+v", stats.Files)
+		}
+		if strings.Contains(stats.Content, "PNG") {
+			t.Fatalf("expected binary content to be left out:\n%s", stats.Content)
+		}
+	}
+	check(wt.Diff(true))
+
+	// The numstat fallback for large diffs flags binary files too.
+	wt.maxDiffBytes = 1
+	stats := wt.Diff(true)
+	if !stats.Truncated {
+		t.Fatalf("expected a truncated diff")
+	}
+	check(stats)
+}
+
+func TestDiffReportsSubmoduleCommits(t *testing.T) {
+	sub := setupTempRepo(t)
+	oldCommit := strings.TrimSpace(runGit(t, sub, "rev-parse", "HEAD"))
+	writeAndCommit(t, sub, "file.txt", "newer\n", "update")
+	newCommit := strings.TrimSpace(runGit(t, sub, "rev-parse", "HEAD"))
+	runGit(t, sub, "checkout", "--quiet", oldCommit)
+
+	repo := setupTempRepo(t)
+	runGit(t, repo, "-c", "protocol.file.allow=always", "submodule", "add", "--quiet", sub, "deps/sub")
+	runGit(t, repo, "commit", "-m", "add submodule")
+	wt := newTestWorktree(t, repo)
+	runGit(t, filepath.Join(repo, "deps", "sub"), "checkout", "--quiet", newCommit)
+	// A diff.submodule setting must not change how the diff is read.
+	runGit(t, repo, "config", "diff.submodule", "log")
+
+	stats := wt.Diff(true)
+	if stats.Error != nil {
+		t.Fatalf("Diff: %v", stats.Error)
+	}
+	want := []FileStat{{OldPath: "deps/sub", NewPath: "deps/sub", Submodule: true, OldCommit: oldCommit, NewCommit: newCommit}}
+	if !reflect.DeepEqual(stats.Files, want) {
+		t.Fatalf("expected %+v, got %+v", want, stats.Files)
+	}
+	if stats.Added != 0 || stats.Removed != 0 || stats.FilesChanged != 1 {
+		t.Fatalf("expected the submodule not to count as line changes, got %+v", stats)
+	}
+}
+
+func TestCountDiffStatsNewSubmodule(t *testing.T) {
+	content := strings.Join([]string{
+		"diff --git a/sub b/sub",
+		"new file mode 160000",
+		"index 0000000..74aa737",
+		"--- /dev/null",
+		"+++ b/sub",
+		"@@ -0,0 +1 @@",
+		"+Subproject commit 74aa7372a43730d09ab03f48cf0306f2cfccba7a-dirty",
+		"",
+	}, "\n")
+
+	got := countDiffStats(content)
+	want := []FileStat{{NewPath: "sub", Change: FileAdded, Submodule: true, NewCommit: "74aa7372a43730d09ab03f48cf0306f2cfccba7a"}}
+	if got.added != 0 || !reflect.DeepEqual(got.fileStats, want) {
+		t.Fatalf("expected %+v and no added lines, got %+v", want, got)
+	}
+}
+
+func TestParseNumstatPath(t *testing.T) {
+	for _, tt := range []struct {
+		path, oldPath, newPath string
+	}{
+		{"file.txt", "file.txt", "file.txt"},
+		{"old.txt => new.txt", "old.txt", "new.txt"},
+		{"docs/{old.txt => new.txt}", "docs/old.txt", "docs/new.txt"},
+		{"{a => b}/file.txt", "a/file.txt", "b/file.txt"},
+		{"src/{ => lib}/main.go", "src/main.go", "src/lib/main.go"},
+		{`"sp\303\244ce.txt"`, "sp\u00e4ce.txt", "sp\u00e4ce.txt"},
+	} {
+		oldPath, newPath := parseNumstatPath(tt.path)
+		if oldPath != tt.oldPath || newPath != tt.newPath {
+			t.Errorf("%q: expected %q -> %q, got %q -> %q", tt.path, tt.oldPath, tt.newPath, oldPath, newPath)
+		}
+	}
+}
+
+func TestDiffCountsLinesStartingWithDiffMarkers(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+
+	original := "keep\n--- removed rule\n+++ kept rule\n"
+	writeAndCommit(t, repo, "rules.txt", original, "add rules")
+	wt.baseCommitSHA = strings.TrimSpace(runGit(t, repo, "rev-parse", "HEAD"))
+
+	updated := "keep\n+++ kept rule\n-- added dash\n++ added plus\n"
+	if err := os.WriteFile(filepath.Join(repo, "rules.txt"), []byte(updated), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	stats := wt.Diff(true)
+	if stats.Error != nil {
+		t.Fatalf("Diff: %v", stats.Error)
+	}
+	if stats.Added != 2 || stats.Removed != 1 || stats.Hunks != 1 || stats.Context != 2 || stats.FilesChanged != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDiffCountsFilesWithoutFinalNewline(t *testing.T) {
+	tests := []struct {
+		name           string
+		before, after  string
+		added, removed int
+	}{
+		{"append to unterminated file", "a\nb", "a\nb\nc", 2, 1},
+		{"terminate last line", "a\nb", "a\nb\n", 1, 1},
+		{"unterminate last line", "a\nb\n", "a\nb", 1, 1},
+		{"change unterminated last line", "a\nb", "a\nx", 1, 1},
+		{"new unterminated file", "", "x\ny", 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := setupTempRepo(t)
+			wt := newTestWorktree(t, repo)
+			if tt.before != "" {
+				writeAndCommit(t, repo, "data.txt", tt.before, "before")
+				wt.baseCommitSHA = strings.TrimSpace(runGit(t, repo, "rev-parse", "HEAD"))
+			}
+			if err := os.WriteFile(filepath.Join(repo, "data.txt"), []byte(tt.after), 0o644); err != nil {
+				t.Fatalf("write file: %v", err)
+			}
+
+			stats := wt.Diff(true)
+			if stats.Error != nil {
+				t.Fatalf("Diff: %v", stats.Error)
+			}
+			if !strings.Contains(stats.Content, "\\ No newline at end of file") {
+				t.Fatalf("expected the diff to contain the marker:\n%s", stats.Content)
+			}
+			if stats.Added != tt.added || stats.Removed != tt.removed {
+				t.Fatalf("expected +%d -%d, got +%d -%d:\n%s", tt.added, tt.removed, stats.Added, stats.Removed, stats.Content)
+			}
+			runGit(t, repo, "add", "-N", ".")
+			num := countNumstat(runGit(t, repo, "diff", "--numstat", wt.baseCommitSHA))
+			if num.added != stats.Added || num.removed != stats.Removed {
+				t.Fatalf("expected counts to match numstat +%d -%d, got +%d -%d", num.added, num.removed, stats.Added, stats.Removed)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package git
+
+import (
+	"agent-squad/config"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// OrphanInfo describes a worktree or branch that was created for a session but is no longer
+// tracked by any instance, e.g. because the instance was killed uncleanly.
+type OrphanInfo struct {
+	// WorktreePath is the orphaned worktree, or empty for a branch that has no worktree.
+	WorktreePath string
+	// BranchName is the branch checked out in the worktree, or the orphaned branch itself. It is
+	// empty for a worktree with a detached HEAD.
+	BranchName string
+	// Dirty is true if the worktree has uncommitted changes.
+	Dirty bool
+	// Unpushed is true if the branch has commits that are on no other branch or remote.
+	Unpushed bool
+}
+
+// CleanupOrphansOptions configures CleanupOrphans.
+type CleanupOrphansOptions struct {
+	// DeleteBranches also deletes the branches of orphaned worktrees and orphaned branches.
+	DeleteBranches bool
+	// Force removes orphans even if that loses uncommitted or unpushed work.
+	Force bool
+}
+
+// ListOrphans returns the session worktrees registered with the repository at repoPath whose
+// branch is not in trackedBranches, and the branches carrying the configured branch prefix that
+// are neither tracked nor checked out anywhere. Only worktrees under the application's worktree
+// directory or the configured worktree_dir are considered, so worktrees the user created
+// elsewhere are never reported.
+func ListOrphans(repoPath string, trackedBranches []string) ([]OrphanInfo, error) {
+	repoPath, err := findGitRepoRoot(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := config.LoadConfig()
+	prefixes, err := worktreePathPrefixes(cfg, repoPath)
+	if err != nil {
+		return nil, err
+	}
+	// git reports worktree paths with symlinks resolved.
+	for n, prefix := range prefixes {
+		dir, rest := filepath.Split(prefix)
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			prefixes[n] = filepath.Join(resolved, rest)
+			if rest == "" {
+				prefixes[n] += string(os.PathSeparator)
+			}
+		}
+	}
+
+	tracked := make(map[string]bool, len(trackedBranches))
+	for _, branch := range trackedBranches {
+		tracked[branch] = true
+	}
+
+	g := &GitWorktree{repoPath: repoPath}
+	output, err := g.runGitCommand(repoPath, "worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list worktrees: %w", err)
+	}
+
+	var orphans []OrphanInfo
+	checkedOut := make(map[string]bool)
+	for _, block := range strings.Split(strings.TrimSpace(output), "\n\n") {
+		var path, branch string
+		for _, line := range strings.Split(block, "\n") {
+			if strings.HasPrefix(line, "worktree ") {
+				path = strings.TrimPrefix(line, "worktree ")
+			} else if strings.HasPrefix(line, "branch ") {
+				branch = strings.TrimPrefix(line, "branch refs/heads/")
+			}
+		}
+		if branch != "" {
+			checkedOut[branch] = true
+		}
+		if path == "" || tracked[branch] || !slices.ContainsFunc(prefixes, func(prefix string) bool {
+			return strings.HasPrefix(path, prefix)
+		}) {
+			continue
+		}
+
+		orphan := OrphanInfo{WorktreePath: path, BranchName: branch}
+		// A worktree whose directory is gone has nothing uncommitted left to lose.
+		if _, err := os.Stat(path); err == nil {
+			wt := &GitWorktree{repoPath: repoPath, worktreePath: path, branchName: branch}
+			if orphan.Dirty, err = wt.IsDirty(); err != nil {
+				return nil, err
+			}
+		}
+		if branch != "" {
+			if orphan.Unpushed, err = g.hasUniqueCommits(branch); err != nil {
+				return nil, err
+			}
+		}
+		orphans = append(orphans, orphan)
+	}
+
+	prefix := cfg.BranchPrefix
+	if prefix == "" {
+		return orphans, nil
+	}
+	output, err = g.runGitCommand(repoPath, "for-each-ref", "--format=%(refname)", "refs/heads/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+	for _, ref := range strings.Split(output, "\n") {
+		branch := strings.TrimPrefix(strings.TrimSpace(ref), "refs/heads/")
+		if branch == "" || !strings.HasPrefix(branch, prefix) || tracked[branch] || checkedOut[branch] {
+			continue
+		}
+		unpushed, err := g.hasUniqueCommits(branch)
+		if err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, OrphanInfo{BranchName: branch, Unpushed: unpushed})
+	}
+	return orphans, nil
+}
+
+// CleanupOrphans removes the orphans ListOrphans finds. Orphaned branches without a worktree are
+// only deleted with opts.DeleteBranches. Unless opts.Force is set, a worktree with uncommitted
+// changes is left alone, and so is a branch with commits found nowhere else. It returns the
+// orphans that were removed and the ones skipped for safety.
+func CleanupOrphans(repoPath string, trackedBranches []string, opts CleanupOrphansOptions) (removed, skipped []OrphanInfo, err error) {
+	orphans, err := ListOrphans(repoPath, trackedBranches)
+	if err != nil {
+		return nil, nil, err
+	}
+	repoPath, err = findGitRepoRoot(repoPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	g := &GitWorktree{repoPath: repoPath}
+	var errs []error
+	for _, orphan := range orphans {
+		if orphan.WorktreePath == "" && !opts.DeleteBranches {
+			continue
+		}
+		if (orphan.Dirty || (opts.DeleteBranches && orphan.Unpushed)) && !opts.Force {
+			skipped = append(skipped, orphan)
+			continue
+		}
+
+		if orphan.WorktreePath != "" {
+			if _, err := os.Stat(orphan.WorktreePath); err == nil {
+				if _, err := g.runGitCommand(repoPath, "worktree", "remove", "-f", orphan.WorktreePath); err != nil {
+					errs = append(errs, fmt.Errorf("failed to remove worktree %s: %w", orphan.WorktreePath, err))
+					continue
+				}
+			}
+		}
+		if orphan.BranchName != "" && opts.DeleteBranches {
+			if _, err := g.runGitCommand(repoPath, "branch", "-D", orphan.BranchName); err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete branch %s: %w", orphan.BranchName, err))
+				continue
+			}
+		}
+		removed = append(removed, orphan)
+	}
+
+	// Drop the administrative files of worktrees whose directories were already deleted.
+	if err := g.Prune(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return removed, skipped, g.combineErrors(errs)
+	}
+	return removed, skipped, nil
+}
+
+// hasUniqueCommits reports whether branch has commits that are on no other branch or remote.
+func (g *GitWorktree) hasUniqueCommits(branch string) (bool, error) {
+	count, err := g.uniqueCommitCount(branch)
+	return count > 0, err
+}
+
+// uniqueCommitCount returns how many commits of branch are on no other branch or remote, i.e.
+// would be lost if the branch were deleted.
+func (g *GitWorktree) uniqueCommitCount(branch string) (int, error) {
+	output, err := g.runGitCommand(g.repoPath, "rev-list", "--count", "refs/heads/"+branch,
+		"--not", "--exclude="+branch, "--branches", "--remotes")
+	if err != nil {
+		return 0, fmt.Errorf("failed to check branch %s for unpushed commits: %w", branch, err)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(output))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected commit count %q for branch %s", output, branch)
+	}
+	return count, nil
+}
+
+// UnpushedCommits returns how many commits of the worktree's branch exist nowhere else: on no
+// remote and no other local branch. A branch that was not created yet has none.
+func (g *GitWorktree) UnpushedCommits() (int, error) {
+	if _, err := g.runGitCommand(g.repoPath, "rev-parse", "--verify", "--quiet", "refs/heads/"+g.branchName); err != nil {
+		return 0, nil
+	}
+	return g.uniqueCommitCount(g.branchName)
+}
@@ -0,0 +1,92 @@
+package git
+
+import (
+	"agent-squad/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListAndCleanupOrphans(t *testing.T) {
+	setupTestHomeConfig(t, "tester/")
+	repo := setupTempRepo(t)
+
+	worktrees := make(map[string]*GitWorktree)
+	for _, name := range []string{"kept", "gone", "dirty"} {
+		worktree, _, err := NewGitWorktree(repo, name)
+		require.NoError(t, err)
+		require.NoError(t, worktree.Setup())
+		worktrees[name] = worktree
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(worktrees["dirty"].GetWorktreePath(), "wip.txt"), []byte("wip\n"), 0o644))
+
+	runGit(t, repo, "branch", "tester/stale")
+	runGit(t, repo, "branch", "feature")
+	stale := filepath.Join(t.TempDir(), "stale")
+	runGit(t, repo, "worktree", "add", stale, "tester/stale")
+	writeAndCommit(t, stale, "stale.txt", "stale\n", "stale work")
+	runGit(t, repo, "worktree", "remove", stale)
+
+	tracked := []string{"tester/kept"}
+	orphans, err := ListOrphans(repo, tracked)
+	require.NoError(t, err)
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].BranchName < orphans[j].BranchName })
+	require.Len(t, orphans, 3)
+	assert.Equal(t, OrphanInfo{WorktreePath: resolvedPath(t, worktrees["dirty"]), BranchName: "tester/dirty", Dirty: true}, orphans[0])
+	assert.Equal(t, OrphanInfo{WorktreePath: resolvedPath(t, worktrees["gone"]), BranchName: "tester/gone"}, orphans[1])
+	assert.Equal(t, OrphanInfo{BranchName: "tester/stale", Unpushed: true}, orphans[2])
+
+	removed, skipped, err := CleanupOrphans(repo, tracked, CleanupOrphansOptions{DeleteBranches: true})
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	assert.Equal(t, "tester/gone", removed[0].BranchName)
+	assert.Len(t, skipped, 2)
+	assert.NoDirExists(t, worktrees["gone"].GetWorktreePath())
+	assert.DirExists(t, worktrees["dirty"].GetWorktreePath())
+	assert.DirExists(t, worktrees["kept"].GetWorktreePath())
+	runGit(t, repo, "rev-parse", "--verify", "refs/heads/tester/stale")
+	runGit(t, repo, "rev-parse", "--verify", "refs/heads/feature")
+
+	removed, skipped, err = CleanupOrphans(repo, tracked, CleanupOrphansOptions{DeleteBranches: true, Force: true})
+	require.NoError(t, err)
+	assert.Len(t, removed, 2)
+	assert.Empty(t, skipped)
+	orphans, err = ListOrphans(repo, tracked)
+	require.NoError(t, err)
+	assert.Empty(t, orphans)
+	runGit(t, repo, "rev-parse", "--verify", "refs/heads/tester/kept")
+}
+
+// resolvedPath returns the worktree path as git reports it, with symlinks resolved.
+func resolvedPath(t *testing.T, worktree *GitWorktree) string {
+	t.Helper()
+	path, err := filepath.EvalSymlinks(worktree.GetWorktreePath())
+	require.NoError(t, err)
+	return path
+}
+
+func TestListOrphansInWorktreeDir(t *testing.T) {
+	tempHome := setupTestHomeConfig(t, "tester/")
+	base := t.TempDir()
+	configPath := filepath.Join(tempHome, ".agent-squad", config.ConfigFileName)
+	require.NoError(t, os.WriteFile(configPath, []byte(`{"branch_prefix": "tester/", "worktree_dir": "`+
+		filepath.Join(base, "{repo}")+`"}`), 0o644))
+	repo := setupTempRepo(t)
+
+	wt, _, err := NewGitWorktree(repo, "elsewhere")
+	require.NoError(t, err)
+	require.NoError(t, wt.Setup())
+	assert.True(t, strings.HasPrefix(wt.GetWorktreePath(), filepath.Join(base, filepath.Base(repo))))
+
+	orphans, err := ListOrphans(repo, nil)
+	require.NoError(t, err)
+	require.Len(t, orphans, 1)
+	resolved, err := filepath.EvalSymlinks(wt.GetWorktreePath())
+	require.NoError(t, err)
+	assert.Equal(t, resolved, orphans[0].WorktreePath)
+}
@@ -1,6 +1,7 @@
 package git
 
 import (
+	"agent-squad/cmd"
 	"fmt"
 	"os/exec"
 	"path/filepath"
@@ -10,10 +11,10 @@ import (
 	"github.com/go-git/go-git/v5"
 )
 
-// sanitizeBranchName transforms an arbitrary string into a Git branch name friendly string.
+// SanitizeBranchName transforms an arbitrary string into a Git branch name friendly string.
 // Note: Git branch names have several rules, so this function uses a simple approach
 // by allowing only a safe subset of characters.
-func sanitizeBranchName(s string) string {
+func SanitizeBranchName(s string) string {
 	// Convert to lower-case
 	s = strings.ToLower(s)
 
@@ -84,3 +85,69 @@ func findGitRepoRoot(path string) (string, error) {
 		currentPath = parent
 	}
 }
+
+// DefaultBranch returns the default branch of the repository at repoPath. It prefers the branch
+// origin/HEAD points at, then the HEAD of a bare repository, then a local branch named by the
+// init.defaultBranch setting, then main, master or trunk, and finally the only local branch. A
+// default branch only known on origin is returned as origin/<name>.
+func DefaultBranch(repoPath string) (string, error) {
+	repoPath, err := findGitRepoRoot(repoPath)
+	if err != nil {
+		return "", err
+	}
+	return findDefaultBranch(repoPath)
+}
+
+// DefaultBranch returns the default branch of the worktree's repository.
+func (g *GitWorktree) DefaultBranch() (string, error) {
+	return findDefaultBranchWith(g.executor(), g.repoPath)
+}
+
+// findDefaultBranch implements DefaultBranch for the repository root repoPath.
+func findDefaultBranch(repoPath string) (string, error) {
+	return findDefaultBranchWith(cmd.MakeExecutor(), repoPath)
+}
+
+// findDefaultBranchWith is findDefaultBranch running git through e.
+func findDefaultBranchWith(e cmd.Executor, repoPath string) (string, error) {
+	run := func(args ...string) (string, error) {
+		output, err := e.Output(exec.Command("git", append([]string{"-C", repoPath}, args...)...))
+		return strings.TrimSpace(string(output)), err
+	}
+	localBranchExists := func(name string) bool {
+		_, err := run("rev-parse", "--verify", "--quiet", "refs/heads/"+name)
+		return name != "" && err == nil
+	}
+
+	if ref, err := run("symbolic-ref", "--short", "refs/remotes/origin/HEAD"); err == nil && ref != "" {
+		if name := strings.TrimPrefix(ref, "origin/"); localBranchExists(name) {
+			return name, nil
+		}
+		return ref, nil
+	}
+
+	// A bare repository has nothing checked out, so its HEAD names the default branch.
+	if bare, err := run("rev-parse", "--is-bare-repository"); err == nil && bare == "true" {
+		if name, err := run("symbolic-ref", "--short", "HEAD"); err == nil && localBranchExists(name) {
+			return name, nil
+		}
+	}
+
+	candidates := []string{"main", "master", "trunk"}
+	if name, err := run("config", "--get", "init.defaultBranch"); err == nil && name != "" {
+		candidates = append([]string{name}, candidates...)
+	}
+	for _, name := range candidates {
+		if localBranchExists(name) {
+			return name, nil
+		}
+	}
+
+	if output, err := run("for-each-ref", "--format=%(refname:short)", "refs/heads/"); err == nil {
+		if branches := strings.Fields(output); len(branches) == 1 {
+			return branches[0], nil
+		}
+	}
+
+	return "", fmt.Errorf("could not determine the default branch of %s", repoPath)
+}
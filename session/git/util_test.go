@@ -1,6 +1,7 @@
 package git
 
 import (
+	"path/filepath"
 	"testing"
 )
 
@@ -64,10 +65,69 @@ func TestSanitizeBranchName(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := sanitizeBranchName(tt.input)
+			got := SanitizeBranchName(tt.input)
 			if got != tt.expected {
-				t.Errorf("sanitizeBranchName(%q) = %q, want %q", tt.input, got, tt.expected)
+				t.Errorf("SanitizeBranchName(%q) = %q, want %q", tt.input, got, tt.expected)
 			}
 		})
 	}
 }
+
+func TestDefaultBranch(t *testing.T) {
+	newRepo := func(t *testing.T, branch string) string {
+		t.Helper()
+		dir := t.TempDir()
+		runGit(t, dir, "init", "--initial-branch="+branch)
+		runGit(t, dir, "config", "user.email", "test@example.com")
+		runGit(t, dir, "config", "user.name", "Test User")
+		runGit(t, dir, "commit", "--allow-empty", "-m", "initial commit")
+		return dir
+	}
+	assertDefault := func(t *testing.T, repo, want string) {
+		t.Helper()
+		got, err := DefaultBranch(repo)
+		if err != nil {
+			t.Fatalf("DefaultBranch: %v", err)
+		}
+		if got != want {
+			t.Fatalf("expected default branch %q, got %q", want, got)
+		}
+	}
+
+	t.Run("conventional names", func(t *testing.T) {
+		repo := newRepo(t, "trunk")
+		runGit(t, repo, "branch", "feature")
+		assertDefault(t, repo, "trunk")
+	})
+
+	t.Run("init.defaultBranch", func(t *testing.T) {
+		repo := newRepo(t, "develop")
+		runGit(t, repo, "branch", "feature")
+		runGit(t, repo, "config", "init.defaultBranch", "develop")
+		assertDefault(t, repo, "develop")
+	})
+
+	t.Run("only branch", func(t *testing.T) {
+		assertDefault(t, newRepo(t, "stable"), "stable")
+	})
+
+	t.Run("origin HEAD", func(t *testing.T) {
+		origin := newRepo(t, "trunk")
+		clone := filepath.Join(t.TempDir(), "clone")
+		runGit(t, origin, "clone", "-q", origin, clone)
+		runGit(t, clone, "branch", "main")
+		assertDefault(t, clone, "trunk")
+
+		// Only known on the remote.
+		runGit(t, clone, "checkout", "-q", "main")
+		runGit(t, clone, "branch", "-D", "trunk")
+		assertDefault(t, clone, "origin/trunk")
+	})
+
+	t.Run("bare repository", func(t *testing.T) {
+		bare := filepath.Join(t.TempDir(), "bare.git")
+		runGit(t, newRepo(t, "trunk"), "clone", "-q", "--bare", ".", bare)
+		runGit(t, bare, "branch", "main")
+		assertDefault(t, bare, "trunk")
+	})
+}
@@ -0,0 +1,116 @@
+package git
+
+import (
+	"agent-squad/cmd"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// Version is a git release version.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast reports whether v is the same as or newer than other.
+func (v Version) AtLeast(other Version) bool {
+	if v.Major != other.Major {
+		return v.Major > other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor > other.Minor
+	}
+	return v.Patch >= other.Patch
+}
+
+// MinimumGitVersion is the oldest git release whose worktree and diff behavior the package relies
+// on. Older releases differ in how intent-to-add files (add -N) show up in diffs, which silently
+// skews diff stats. Nothing else is gated on the version: everything else the package uses (status
+// and worktree list --porcelain v1, diff --submodule=short, add -N into a temporary index) behaves
+// the same in every release from this one on, so enforcing the floor is enough.
+var MinimumGitVersion = Version{Major: 2, Minor: 25}
+
+// ErrUnsupportedGitVersion is returned when the installed git is older than MinimumGitVersion.
+var ErrUnsupportedGitVersion = errors.New("unsupported git version")
+
+var (
+	detectVersionOnce sync.Once
+	detectedVersion   Version
+	detectVersionErr  error
+)
+
+// DetectVersion returns the version of the git on PATH. The probe runs once per process.
+func DetectVersion() (Version, error) {
+	detectVersionOnce.Do(func() {
+		detectedVersion, detectVersionErr = detectVersion(cmd.MakeExecutor())
+	})
+	return detectedVersion, detectVersionErr
+}
+
+// detectVersion runs git version through e.
+func detectVersion(e cmd.Executor) (Version, error) {
+	output, err := e.Output(exec.Command("git", "version"))
+	if err != nil {
+		return Version{}, fmt.Errorf("failed to run git version: %w", err)
+	}
+	return parseGitVersion(string(output))
+}
+
+var gitVersionRegex = regexp.MustCompile(`git version (\d+)\.(\d+)(?:\.(\d+))?`)
+
+// parseGitVersion parses `git version` output such as "git version 2.39.2",
+// "git version 2.37.1 (Apple Git-137.1)" or "git version 2.41.0.windows.1".
+func parseGitVersion(output string) (Version, error) {
+	matches := gitVersionRegex.FindStringSubmatch(output)
+	if matches == nil {
+		return Version{}, fmt.Errorf("unrecognized git version output %q", output)
+	}
+	var v Version
+	v.Major, _ = strconv.Atoi(matches[1])
+	v.Minor, _ = strconv.Atoi(matches[2])
+	if matches[3] != "" {
+		v.Patch, _ = strconv.Atoi(matches[3])
+	}
+	return v, nil
+}
+
+// checkGitVersion returns an error wrapping ErrUnsupportedGitVersion if the git the worktree runs
+// is too old. With an injected executor, the version is asked of that executor once and cached
+// until the executor is replaced.
+func (g *GitWorktree) checkGitVersion() error {
+	v, err := g.gitVersion()
+	if err != nil {
+		return err
+	}
+	if !v.AtLeast(MinimumGitVersion) {
+		return fmt.Errorf("git %s found, %s or newer is required: %w", v, MinimumGitVersion, ErrUnsupportedGitVersion)
+	}
+	return nil
+}
+
+// gitVersion returns the version of the git the worktree runs. A failed probe is retried on the
+// next call.
+func (g *GitWorktree) gitVersion() (Version, error) {
+	g.cmdExecVersionMu.Lock()
+	defer g.cmdExecVersionMu.Unlock()
+	if g.cmdExec == nil {
+		return DetectVersion()
+	}
+	if g.cmdExecVersion == nil {
+		v, err := detectVersion(g.cmdExec)
+		if err != nil {
+			return Version{}, err
+		}
+		g.cmdExecVersion = &v
+	}
+	return *g.cmdExecVersion, nil
+}
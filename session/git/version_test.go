@@ -0,0 +1,38 @@
+package git
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGitVersion(t *testing.T) {
+	tests := map[string]Version{
+		"git version 2.39.2\n":                   {2, 39, 2},
+		"git version 2.37.1 (Apple Git-137.1)\n": {2, 37, 1},
+		"git version 2.41.0.windows.1\n":         {2, 41, 0},
+		"git version 3.0\n":                      {3, 0, 0},
+	}
+	for output, want := range tests {
+		got, err := parseGitVersion(output)
+		require.NoError(t, err, output)
+		assert.Equal(t, want, got, output)
+	}
+
+	_, err := parseGitVersion("not git")
+	assert.Error(t, err)
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	assert.True(t, Version{2, 25, 0}.AtLeast(MinimumGitVersion))
+	assert.True(t, Version{3, 0, 0}.AtLeast(Version{2, 40, 1}))
+	assert.False(t, Version{2, 24, 9}.AtLeast(MinimumGitVersion))
+	assert.False(t, Version{2, 25, 0}.AtLeast(Version{2, 25, 1}))
+}
+
+func TestDetectVersion(t *testing.T) {
+	v, err := DetectVersion()
+	require.NoError(t, err)
+	assert.True(t, v.Major >= 2, "unexpected git version %s", v)
+}
@@ -1,10 +1,15 @@
 package git
 
 import (
+	"agent-squad/cmd"
 	"agent-squad/config"
 	"agent-squad/log"
 	"fmt"
+	"os"
+	"os/user"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -31,12 +36,53 @@ type GitWorktree struct {
 	branchName string
 	// Base commit hash for the worktree
 	baseCommitSHA string
+	// baseBranch is the branch the session forked from, or empty if unknown
+	baseBranch string
+	// baseBranchResolved is set once GetBaseBranch has tried to work out a missing baseBranch
+	baseBranchResolved bool
+	// pendingRebaseBase is the new base commit while a rebase is stopped on conflicts
+	pendingRebaseBase string
+	// pendingRebaseBranch is the ref being rebased onto while a rebase is stopped on conflicts
+	pendingRebaseBranch string
+	// externalBranch is true if the branch existed before the session, in which case
+	// Cleanup leaves it in place
+	externalBranch bool
+	// startCommit, if set, is the commit a new branch starts from instead of the repository's HEAD
+	startCommit string
+	// maxDiffBytes bounds how much diff content Diff reads. Zero means no limit.
+	maxDiffBytes int
+	// diffExcludes are pathspec patterns left out of Diff
+	diffExcludes []string
+	// configDiffExcludes are the diff_exclude_paths patterns from the config, left out of Diff
+	// in addition to diffExcludes
+	configDiffExcludes []string
+	// excludeUntracked leaves untracked files out of Diff
+	excludeUntracked bool
+	// renameThreshold is the similarity percentage Diff needs to report a rename. Zero uses git's
+	// default; a negative value turns rename detection off.
+	renameThreshold int
+	// findCopies makes Diff report copies as well as renames
+	findCopies bool
+	// cmdExec runs the worktree's git commands. Nil means os/exec.
+	cmdExec cmd.Executor
+	// cmdExecVersion caches the git version cmdExec reported, so it is only probed once.
+	cmdExecVersionMu sync.Mutex
+	cmdExecVersion   *Version
 
 	// Cached diff bookkeeping to avoid redundant git subprocesses
 	diffMu             sync.Mutex
 	lastStatusSnapshot string
-	lastDiff           *DiffStats
-	lastDiffCheckedAt  time.Time
+	// lastDiffKey identifies the pathspec and options lastDiff was computed with
+	lastDiffKey       string
+	lastDiff          *DiffStats
+	lastDiffCheckedAt time.Time
+	// aheadBehind caches AheadBehind counts per ref
+	aheadBehind map[string]aheadBehindCounts
+	// commitCount caches CommitCount while commitCountValid is set
+	commitCount      int
+	commitCountValid bool
+	// lastCommit caches LastCommit
+	lastCommit *CommitInfo
 }
 
 func NewGitWorktreeFromStorage(repoPath string, worktreePath string, sessionName string, branchName string, baseCommitSHA string) *GitWorktree {
@@ -51,18 +97,18 @@ func NewGitWorktreeFromStorage(repoPath string, worktreePath string, sessionName
 
 // NewGitWorktree creates a new GitWorktree instance
 func NewGitWorktree(repoPath string, sessionName string) (tree *GitWorktree, branchname string, err error) {
-	sanitizedName := sanitizeBranchName(sessionName)
+	return NewGitWorktreeWithBranchTemplate(repoPath, sessionName, "")
+}
 
+// NewGitWorktreeWithBranchTemplate is like NewGitWorktree but names the branch with
+// branchTemplate instead of the branch_template config, unless branchTemplate is empty.
+func NewGitWorktreeWithBranchTemplate(repoPath string, sessionName string, branchTemplate string) (tree *GitWorktree, branchname string, err error) {
 	cfg := config.LoadConfig()
-
-	// Start with prefixed naming as the baseline to preserve backwards compatibility.
-	baseBranchName := fmt.Sprintf("%s%s", cfg.BranchPrefix, sanitizedName)
-	if strings.Contains(sessionName, "/") && sanitizedName != "" {
-		// When the sanitized name survives, allow bypassing the prefix for nested paths.
-		baseBranchName = sanitizedName
+	if branchTemplate != "" {
+		cfg.BranchTemplate = branchTemplate
 	}
 
-	branchName := sanitizeBranchName(baseBranchName)
+	branchName := newBranchName(cfg, sessionName, time.Now())
 	if branchName == "" {
 		return nil, "", fmt.Errorf("session name %q cannot be transformed into a valid branch name", sessionName)
 	}
@@ -80,20 +126,254 @@ func NewGitWorktree(repoPath string, sessionName string) (tree *GitWorktree, bra
 		return nil, "", err
 	}
 
-	worktreeDir, err := getWorktreeDirectory()
+	worktreePath, err := newWorktreePath(cfg, repoPath, sessionName, branchName)
 	if err != nil {
 		return nil, "", err
 	}
 
-	worktreePath := filepath.Join(worktreeDir, sanitizedName)
-	worktreePath = worktreePath + "_" + fmt.Sprintf("%x", time.Now().UnixNano())
-
-	return &GitWorktree{
+	g := &GitWorktree{
 		repoPath:     repoPath,
 		sessionName:  sessionName,
 		branchName:   branchName,
 		worktreePath: worktreePath,
-	}, branchName, nil
+	}
+	// The new branch starts at HEAD, so it forks from whatever is checked out. A detached HEAD
+	// has no branch to record.
+	if output, err := g.runGitCommand(repoPath, "branch", "--show-current"); err == nil {
+		g.baseBranch = strings.TrimSpace(output)
+	}
+	return g, branchName, nil
+}
+
+// newBranchName derives the branch name for a new session from the branch_template config, or
+// from the branch prefix and session name if no template is set.
+func newBranchName(cfg *config.Config, sessionName string, now time.Time) string {
+	if cfg.BranchTemplate != "" {
+		rendered := strings.NewReplacer(
+			"{title}", sessionName,
+			"{date}", now.Format("2006-01-02"),
+			"{user}", currentUsername(),
+			"{prefix}", cfg.BranchPrefix,
+		).Replace(cfg.BranchTemplate)
+		// Placeholders that render empty would otherwise leave "//" behind, which git rejects.
+		return multipleSlashRegex.ReplaceAllString(SanitizeBranchName(rendered), "/")
+	}
+
+	sanitizedName := SanitizeBranchName(sessionName)
+
+	// Start with prefixed naming as the baseline to preserve backwards compatibility.
+	baseBranchName := fmt.Sprintf("%s%s", cfg.BranchPrefix, sanitizedName)
+	if strings.Contains(sessionName, "/") && sanitizedName != "" {
+		// When the sanitized name survives, allow bypassing the prefix for nested paths.
+		baseBranchName = sanitizedName
+	}
+
+	return SanitizeBranchName(baseBranchName)
+}
+
+var multipleSlashRegex = regexp.MustCompile(`/{2,}`)
+
+// currentUsername returns the login name of the current user, or an empty string if it is unknown.
+func currentUsername() string {
+	u, err := user.Current()
+	if err != nil || u == nil {
+		return ""
+	}
+	return u.Username
+}
+
+// NewGitWorktreeFromCommit is like NewGitWorktree, but the new branch starts at commit instead of
+// the repository's HEAD. The branch derived from sessionName must not exist yet.
+func NewGitWorktreeFromCommit(repoPath string, sessionName string, commit string) (tree *GitWorktree, branchname string, err error) {
+	g, branchName, err := NewGitWorktree(repoPath, sessionName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	output, err := g.runGitCommand(g.repoPath, "rev-parse", "--verify", commit+"^{commit}")
+	if err != nil {
+		return nil, "", fmt.Errorf("commit %s does not exist", commit)
+	}
+	if _, err := g.runGitCommand(g.repoPath, "rev-parse", "--verify", "refs/heads/"+branchName); err == nil {
+		return nil, "", fmt.Errorf("branch %s already exists", branchName)
+	}
+	g.startCommit = strings.TrimSpace(output)
+	// The commit need not be on the checked out branch; GetBaseBranch works it out.
+	g.baseBranch = ""
+
+	return g, branchName, nil
+}
+
+// NewGitWorktreeFromBranch creates a GitWorktree that checks out an existing branch instead of
+// creating a new one. The branch must exist and must not be checked out anywhere else. The base
+// commit is the branch's merge-base with the repository's default branch.
+func NewGitWorktreeFromBranch(repoPath string, sessionName string, branchName string) (*GitWorktree, error) {
+	absPath, err := filepath.Abs(repoPath)
+	if err != nil {
+		log.ErrorLog.Printf("git worktree path abs error, falling back to repoPath %s: %s", repoPath, err)
+		absPath = repoPath
+	}
+
+	repoPath, err = findGitRepoRoot(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &GitWorktree{
+		repoPath:       repoPath,
+		sessionName:    sessionName,
+		branchName:     branchName,
+		externalBranch: true,
+	}
+
+	if _, err := g.runGitCommand(repoPath, "rev-parse", "--verify", "refs/heads/"+branchName); err != nil {
+		return nil, fmt.Errorf("branch %s does not exist", branchName)
+	}
+
+	if path, err := g.branchWorktreePath(); err != nil {
+		return nil, err
+	} else if path != "" {
+		return nil, fmt.Errorf("branch %s is already checked out in %s", branchName, path)
+	}
+
+	defaultBranch, err := findDefaultBranch(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	output, err := g.runGitCommand(repoPath, "merge-base", defaultBranch, branchName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find merge-base of %s and %s: %w", branchName, defaultBranch, err)
+	}
+	g.baseCommitSHA = strings.TrimSpace(output)
+	g.baseBranch = defaultBranch
+
+	g.worktreePath, err = newWorktreePath(config.LoadConfig(), repoPath, sessionName, branchName)
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// newWorktreePath returns a unique worktree path for the session. Without a worktree_dir_template
+// the directory is the sanitized session name plus a timestamp. With one, the rendered name is
+// used as is, and a numeric suffix is added if that directory already exists.
+func newWorktreePath(cfg *config.Config, repoPath, sessionName, branchName string) (string, error) {
+	worktreeDir, err := worktreeBaseDir(cfg, repoPath, sessionName)
+	if err != nil {
+		return "", err
+	}
+
+	if cfg.WorktreeDirTemplate == "" {
+		worktreePath := filepath.Join(worktreeDir, SanitizeBranchName(sessionName))
+		return worktreePath + "_" + fmt.Sprintf("%x", time.Now().UnixNano()), nil
+	}
+
+	rendered := strings.NewReplacer(
+		"{title}", sessionName,
+		"{branch}", branchName,
+		"{repo}", filepath.Base(repoPath),
+	).Replace(cfg.WorktreeDirTemplate)
+	name := pathComponent(rendered)
+	if name == "" {
+		return "", fmt.Errorf("worktree_dir_template %q rendered an empty directory name for %q", cfg.WorktreeDirTemplate, sessionName)
+	}
+
+	worktreePath := filepath.Join(worktreeDir, name)
+	for n := 2; ; n++ {
+		if _, err := os.Stat(worktreePath); os.IsNotExist(err) {
+			return worktreePath, nil
+		} else if err != nil {
+			return "", fmt.Errorf("failed to check worktree path %s: %w", worktreePath, err)
+		}
+		worktreePath = filepath.Join(worktreeDir, fmt.Sprintf("%s-%d", name, n))
+	}
+}
+
+// pathComponent turns s into a single, safe path component. It returns an empty string if
+// nothing of s survives.
+func pathComponent(s string) string {
+	return strings.TrimLeft(strings.ReplaceAll(SanitizeBranchName(s), "/", "-"), ".")
+}
+
+// worktreeBaseDir returns the directory a new worktree for the session is created in: the
+// worktree_dir config with its placeholders filled in, or the default worktree directory. A
+// configured directory is created if needed and must be writable.
+func worktreeBaseDir(cfg *config.Config, repoPath, sessionName string) (string, error) {
+	if cfg.WorktreeDir == "" {
+		return getWorktreeDirectory()
+	}
+
+	dir, err := expandWorktreeDir(cfg.WorktreeDir)
+	if err != nil {
+		return "", err
+	}
+	title := pathComponent(sessionName)
+	if title == "" && strings.Contains(dir, "{title}") {
+		return "", fmt.Errorf("session name %q cannot be used in worktree_dir", sessionName)
+	}
+	dir = strings.NewReplacer("{repo}", pathComponent(filepath.Base(repoPath)), "{title}", title).Replace(dir)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create worktree_dir %s: %w", dir, err)
+	}
+	probe, err := os.CreateTemp(dir, ".agent-squad-write-check-")
+	if err != nil {
+		return "", fmt.Errorf("worktree_dir %s is not writable: %w", dir, err)
+	}
+	_ = probe.Close()
+	_ = os.Remove(probe.Name())
+	return dir, nil
+}
+
+// expandWorktreeDir expands a leading ~ in the worktree_dir config and checks that it is absolute.
+func expandWorktreeDir(dir string) (string, error) {
+	if dir == "~" || strings.HasPrefix(dir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to expand worktree_dir %s: %w", dir, err)
+		}
+		dir = filepath.Join(home, dir[1:])
+	}
+	if !filepath.IsAbs(dir) {
+		return "", fmt.Errorf("worktree_dir %s must be an absolute path", dir)
+	}
+	return dir, nil
+}
+
+// worktreePathPrefixes returns the path prefixes of the session worktrees of the repository at
+// repoPath: the default worktree directory and, if worktree_dir is set, the part of it before the
+// {title} placeholder.
+func worktreePathPrefixes(cfg *config.Config, repoPath string) ([]string, error) {
+	defaultDir, err := getWorktreeDirectory()
+	if err != nil {
+		return nil, err
+	}
+	prefixes := []string{defaultDir + string(os.PathSeparator)}
+	if cfg.WorktreeDir == "" {
+		return prefixes, nil
+	}
+	dir, err := expandWorktreeDir(cfg.WorktreeDir)
+	if err != nil {
+		return nil, err
+	}
+	dir = strings.ReplaceAll(dir, "{repo}", pathComponent(filepath.Base(repoPath)))
+	if static, _, found := strings.Cut(dir, "{title}"); found {
+		prefixes = append(prefixes, static)
+	} else {
+		prefixes = append(prefixes, filepath.Clean(dir)+string(os.PathSeparator))
+	}
+	return prefixes, nil
+}
+
+// SetExternalBranch marks the worktree's branch as pre-existing so Cleanup keeps it.
+func (g *GitWorktree) SetExternalBranch(external bool) {
+	g.externalBranch = external
+}
+
+// IsExternalBranch returns true if the branch existed before the session was created.
+func (g *GitWorktree) IsExternalBranch() bool {
+	return g.externalBranch
 }
 
 // GetWorktreePath returns the path to the worktree
@@ -121,11 +401,85 @@ func (g *GitWorktree) GetBaseCommitSHA() string {
 	return g.baseCommitSHA
 }
 
+// SetBaseBranch records the branch the session forked from, e.g. when loading it from storage.
+func (g *GitWorktree) SetBaseBranch(branch string) {
+	g.baseBranch = branch
+	g.baseBranchResolved = false
+}
+
+// GetBaseBranch returns the branch the session forked from, such as "main". If none was
+// recorded, e.g. for sessions created by older versions, it is the local branch containing the
+// base commit, preferring the repository's default branch. It is empty if no branch contains it.
+func (g *GitWorktree) GetBaseBranch() string {
+	if g.baseBranch == "" && !g.baseBranchResolved && g.baseCommitSHA != "" {
+		g.baseBranchResolved = true
+		g.baseBranch = g.branchContaining(g.baseCommitSHA)
+	}
+	return g.baseBranch
+}
+
+// branchContaining returns a local branch other than the worktree's own that contains commit,
+// preferring the default branch, or an empty string if there is none.
+func (g *GitWorktree) branchContaining(commit string) string {
+	output, err := g.runGitCommand(g.repoPath, "for-each-ref", "--contains", commit, "--format=%(refname:short)", "refs/heads/")
+	if err != nil {
+		return ""
+	}
+	var branches []string
+	for _, line := range strings.Split(output, "\n") {
+		if branch := strings.TrimSpace(line); branch != "" && branch != g.branchName {
+			branches = append(branches, branch)
+		}
+	}
+	if len(branches) == 0 {
+		return ""
+	}
+	if defaultBranch, err := findDefaultBranchWith(g.executor(), g.repoPath); err == nil {
+		defaultBranch = strings.TrimPrefix(defaultBranch, "origin/")
+		if slices.Contains(branches, defaultBranch) {
+			return defaultBranch
+		}
+	}
+	return branches[0]
+}
+
+// ApplyConfig applies the settings from cfg that affect how the worktree is inspected.
+func (g *GitWorktree) ApplyConfig(cfg *config.Config) {
+	g.diffMu.Lock()
+	defer g.diffMu.Unlock()
+	g.maxDiffBytes = cfg.GetMaxDiffBytes()
+	g.excludeUntracked = !cfg.GetDiffIncludeUntracked()
+	g.configDiffExcludes = slices.Clone(cfg.DiffExcludePaths)
+	g.renameThreshold = min(cfg.DiffRenameThreshold, 100)
+	g.findCopies = cfg.DiffFindCopies
+	// The options change what the diff looks like, so a cached one may be stale.
+	g.lastDiff = nil
+}
+
+// SetDiffExcludes sets the pathspec patterns (e.g. "*.lock" or "vendor/**") that Diff leaves out.
+func (g *GitWorktree) SetDiffExcludes(patterns []string) {
+	g.diffMu.Lock()
+	g.diffExcludes = slices.Clone(patterns)
+	g.diffMu.Unlock()
+	g.InvalidateDiffCache()
+}
+
+// GetDiffExcludes returns the pathspec patterns that Diff leaves out.
+func (g *GitWorktree) GetDiffExcludes() []string {
+	g.diffMu.Lock()
+	defer g.diffMu.Unlock()
+	return slices.Clone(g.diffExcludes)
+}
+
 // InvalidateDiffCache clears cached diff information.
 func (g *GitWorktree) InvalidateDiffCache() {
 	g.diffMu.Lock()
 	g.lastStatusSnapshot = ""
+	g.lastDiffKey = ""
 	g.lastDiff = nil
 	g.lastDiffCheckedAt = time.Time{}
+	g.aheadBehind = nil
+	g.commitCountValid = false
+	g.lastCommit = nil
 	g.diffMu.Unlock()
 }
@@ -3,6 +3,7 @@ package git
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -58,3 +59,86 @@ func (g *GitWorktree) combineErrors(errs []error) error {
 	}
 	return errors.New(errMsg)
 }
+
+// KilledBranchRefPrefix namespaces the refs that keep the branch of the last killed session alive
+// after DeleteBranch deletes it, so the session can be restored with RestoreKilledBranch.
+const KilledBranchRefPrefix = "refs/agentsquad/killed/"
+
+// ErrNoKilledBranch is returned by RestoreKilledBranch when no backup of the branch exists.
+var ErrNoKilledBranch = errors.New("no backup of killed branch")
+
+// backupKilledBranch points a backup ref at the branch before DeleteBranch deletes it. Only the last
+// killed branch of a repository can be restored, so older backups are dropped and do not keep
+// their commits from being garbage collected.
+func backupKilledBranch(repo *git.Repository, branch *plumbing.Reference) error {
+	refs, err := repo.References()
+	if err != nil {
+		return fmt.Errorf("failed to list references: %w", err)
+	}
+	var stale []plumbing.ReferenceName
+	_ = refs.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(ref.Name().String(), KilledBranchRefPrefix) {
+			stale = append(stale, ref.Name())
+		}
+		return nil
+	})
+	for _, name := range stale {
+		if err := repo.Storer.RemoveReference(name); err != nil {
+			return fmt.Errorf("failed to remove old backup %s: %w", name, err)
+		}
+	}
+
+	backup := plumbing.ReferenceName(KilledBranchRefPrefix + branch.Name().Short())
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(backup, branch.Hash())); err != nil {
+		return fmt.Errorf("failed to back up branch %s: %w", branch.Name().Short(), err)
+	}
+	return nil
+}
+
+// RestoreKilledBranch recreates a branch deleted when its session was killed, from the backup
+// DeleteBranch kept. The backup is consumed. A branch that still exists, e.g. because the kill
+// kept it, is left as it is.
+func RestoreKilledBranch(repoPath, branchName string) error {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	branchRef := plumbing.NewBranchReferenceName(branchName)
+	if _, err := repo.Reference(branchRef, false); err == nil {
+		return nil
+	} else if err != plumbing.ErrReferenceNotFound {
+		return fmt.Errorf("error checking branch %s existence: %w", branchName, err)
+	}
+
+	backupName := plumbing.ReferenceName(KilledBranchRefPrefix + branchName)
+	backup, err := repo.Reference(backupName, false)
+	if err == plumbing.ErrReferenceNotFound {
+		return fmt.Errorf("%w: %s", ErrNoKilledBranch, branchName)
+	} else if err != nil {
+		return fmt.Errorf("failed to read backup of branch %s: %w", branchName, err)
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(branchRef, backup.Hash())); err != nil {
+		return fmt.Errorf("failed to restore branch %s: %w", branchName, err)
+	}
+	if err := repo.Storer.RemoveReference(backupName); err != nil {
+		return fmt.Errorf("failed to remove backup of branch %s: %w", branchName, err)
+	}
+	return nil
+}
+
+// BranchExists reports whether the repository at repoPath has a branch called branchName.
+func BranchExists(repoPath, branchName string) (bool, error) {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to open repository: %w", err)
+	}
+	_, err = repo.Reference(plumbing.NewBranchReferenceName(branchName), false)
+	if err == plumbing.ErrReferenceNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("error checking branch %s existence: %w", branchName, err)
+	}
+	return true, nil
+}
@@ -1,25 +1,75 @@
 package git
 
 import (
+	"agent-squad/cmd"
 	"agent-squad/log"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // runGitCommand executes a git command and returns any error
 func (g *GitWorktree) runGitCommand(path string, args ...string) (string, error) {
+	return g.runGitCommandEnv(path, nil, args...)
+}
+
+// runGitCommandEnv is like runGitCommand but adds env to the command's environment.
+func (g *GitWorktree) runGitCommandEnv(path string, env []string, args ...string) (string, error) {
+	return g.runGitCommandContext(context.Background(), path, env, args...)
+}
+
+// runGitCommandContext is like runGitCommandEnv but kills the command once ctx is done.
+func (g *GitWorktree) runGitCommandContext(ctx context.Context, path string, env []string, args ...string) (string, error) {
 	baseArgs := []string{"-C", path}
-	cmd := exec.Command("git", append(baseArgs, args...)...)
+	gitCmd := exec.CommandContext(ctx, "git", append(baseArgs, args...)...)
+	if env != nil {
+		gitCmd.Env = append(os.Environ(), env...)
+	}
 
-	output, err := cmd.CombinedOutput()
+	output, err := g.combinedOutput(gitCmd)
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", fmt.Errorf("git %s interrupted: %w", args[0], ctxErr)
+		}
 		return "", fmt.Errorf("git command failed: %s (%w)", output, err)
 	}
 
 	return string(output), nil
 }
 
+// SetCmdExecutor makes the worktree run its git commands, including the git version check,
+// through e instead of os/exec, so tests can fake them. A nil e restores the default.
+func (g *GitWorktree) SetCmdExecutor(e cmd.Executor) {
+	g.cmdExecVersionMu.Lock()
+	g.cmdExec = e
+	g.cmdExecVersion = nil
+	g.cmdExecVersionMu.Unlock()
+}
+
+// executor returns the executor git commands run through.
+func (g *GitWorktree) executor() cmd.Executor {
+	if g.cmdExec == nil {
+		return cmd.MakeExecutor()
+	}
+	return g.cmdExec
+}
+
+// combinedOutput runs gitCmd through the worktree's executor and returns its stdout and stderr
+// interleaved, like exec.Cmd.CombinedOutput.
+func (g *GitWorktree) combinedOutput(gitCmd *exec.Cmd) ([]byte, error) {
+	var output bytes.Buffer
+	gitCmd.Stdout = &output
+	gitCmd.Stderr = &output
+	err := g.executor().Run(gitCmd)
+	return output.Bytes(), err
+}
+
 // PushChanges commits and pushes changes in the worktree to the remote branch
 func (g *GitWorktree) PushChanges(commitMessage string, open bool) error {
 	if err := checkGHCLI(); err != nil {
@@ -53,7 +103,7 @@ func (g *GitWorktree) PushChanges(commitMessage string, open bool) error {
 		// If sync fails, try creating the branch on remote first
 		gitPushCmd := exec.Command("git", "push", "-u", "origin", g.branchName)
 		gitPushCmd.Dir = g.worktreePath
-		if pushOutput, pushErr := gitPushCmd.CombinedOutput(); pushErr != nil {
+		if pushOutput, pushErr := g.combinedOutput(gitPushCmd); pushErr != nil {
 			log.ErrorLog.Print(pushErr)
 			return fmt.Errorf("failed to push branch: %s (%w)", pushOutput, pushErr)
 		}
@@ -79,8 +129,51 @@ func (g *GitWorktree) PushChanges(commitMessage string, open bool) error {
 	return nil
 }
 
-// CommitChanges commits changes locally without pushing to remote
+// ErrNothingToCommit is returned when a commit is requested but nothing is staged.
+var ErrNothingToCommit = errors.New("nothing to commit")
+
+// CommitOptions configures a local commit made with CommitWithOptions.
+type CommitOptions struct {
+	// Message is the commit message.
+	Message string
+	// Author overrides the commit author, in "Name <email>" form.
+	Author string
+	// AllowEmpty records a commit even if there are no changes.
+	AllowEmpty bool
+	// Sign GPG-signs the commit (git commit -S).
+	Sign bool
+	// TrackedOnly stages changes to tracked files only, leaving untracked files out.
+	TrackedOnly bool
+}
+
+// CommitChanges commits changes to tracked files locally without pushing to remote. Untracked
+// files are left as they are. It is a no-op if there is nothing to commit.
 func (g *GitWorktree) CommitChanges(commitMessage string) error {
+	err := g.CommitWithOptions(CommitOptions{Message: commitMessage, TrackedOnly: true})
+	if errors.Is(err, ErrNothingToCommit) {
+		return nil
+	}
+	return err
+}
+
+// CommitChangesIncludingUntracked is like CommitChanges but also commits untracked files.
+// Files matched by .gitignore are never committed.
+func (g *GitWorktree) CommitChangesIncludingUntracked(commitMessage string) error {
+	err := g.CommitWithOptions(CommitOptions{Message: commitMessage})
+	if errors.Is(err, ErrNothingToCommit) {
+		return nil
+	}
+	return err
+}
+
+// CommitWithOptions stages all changes in the worktree, including untracked files unless
+// opts.TrackedOnly is set, and commits them locally. Ignored files are never staged. It returns
+// ErrNothingToCommit if nothing ends up staged and opts.AllowEmpty is false.
+func (g *GitWorktree) CommitWithOptions(opts CommitOptions) error {
+	if opts.Message == "" {
+		return fmt.Errorf("commit message cannot be empty")
+	}
+
 	// Check if there are any changes to commit
 	isDirty, err := g.IsDirty()
 	if err != nil {
@@ -88,30 +181,455 @@ func (g *GitWorktree) CommitChanges(commitMessage string) error {
 	}
 
 	if isDirty {
-		// Stage all changes
-		if _, err := g.runGitCommand(g.worktreePath, "add", "."); err != nil {
+		// Stage the changes. Without -f, git add skips ignored files.
+		stage := "--all"
+		if opts.TrackedOnly {
+			stage = "--update"
+		}
+		if _, err := g.runGitCommand(g.worktreePath, "add", stage); err != nil {
 			log.ErrorLog.Print(err)
 			return fmt.Errorf("failed to stage changes: %w", err)
 		}
+	}
 
-		// Create commit (local only)
-		if _, err := g.runGitCommand(g.worktreePath, "commit", "-m", commitMessage, "--no-verify"); err != nil {
-			log.ErrorLog.Print(err)
-			return fmt.Errorf("failed to commit changes: %w", err)
+	staged, err := g.runGitCommand(g.worktreePath, "diff", "--cached", "--name-only")
+	if err != nil {
+		return fmt.Errorf("failed to check staged changes: %w", err)
+	}
+	if strings.TrimSpace(staged) == "" && !opts.AllowEmpty {
+		return ErrNothingToCommit
+	}
+
+	args := []string{"commit", "-m", opts.Message, "--no-verify"}
+	if opts.Author != "" {
+		args = append(args, "--author", opts.Author)
+	}
+	if opts.AllowEmpty {
+		args = append(args, "--allow-empty")
+	}
+	if opts.Sign {
+		args = append(args, "-S")
+	}
+
+	// Create commit (local only)
+	if _, err := g.runGitCommand(g.worktreePath, args...); err != nil {
+		log.ErrorLog.Print(err)
+		return fmt.Errorf("failed to commit changes: %w", err)
+	}
+	g.InvalidateDiffCache()
+
+	return nil
+}
+
+// ErrBaseNotAncestor is returned when the recorded base commit is no longer an ancestor of the
+// branch, for example because the branch was rebased onto a newer base.
+var ErrBaseNotAncestor = errors.New("base commit is not an ancestor of HEAD")
+
+// SquashToBase collapses every commit made on top of the base commit into a single commit with
+// the given message. It refuses to run on a dirty worktree so no uncommitted work is folded in
+// by accident.
+func (g *GitWorktree) SquashToBase(message string) error {
+	if message == "" {
+		return fmt.Errorf("commit message cannot be empty")
+	}
+	base := g.GetBaseCommitSHA()
+	if base == "" {
+		return fmt.Errorf("base commit SHA not set")
+	}
+
+	dirty, err := g.IsDirty()
+	if err != nil {
+		return fmt.Errorf("failed to check for changes: %w", err)
+	}
+	if dirty {
+		return fmt.Errorf("cannot squash: worktree has uncommitted changes, commit or discard them first")
+	}
+
+	isAncestor := exec.Command("git", "-C", g.worktreePath, "merge-base", "--is-ancestor", base, "HEAD")
+	if err := g.executor().Run(isAncestor); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return fmt.Errorf("cannot squash onto %s: %w", base, ErrBaseNotAncestor)
 		}
-		g.InvalidateDiffCache()
+		return fmt.Errorf("failed to check base ancestry: %w", err)
+	}
+
+	output, err := g.runGitCommand(g.worktreePath, "rev-parse", "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to get HEAD commit hash: %w", err)
+	}
+	head := strings.TrimSpace(output)
+	if head == base {
+		return ErrNothingToCommit
+	}
+
+	if _, err := g.runGitCommand(g.worktreePath, "reset", "--soft", base); err != nil {
+		return fmt.Errorf("failed to reset to base commit: %w", err)
+	}
+
+	if err := g.CommitWithOptions(CommitOptions{Message: message}); err != nil {
+		// Put the branch back where it was so the original commits are not orphaned.
+		if _, restoreErr := g.runGitCommand(g.worktreePath, "reset", "--soft", head); restoreErr != nil {
+			err = fmt.Errorf("%v (restore error: %v)", err, restoreErr)
+		}
+		return fmt.Errorf("failed to create squashed commit: %w", err)
 	}
 
 	return nil
 }
 
+// ErrNoMergeBase is returned by AheadBehind when the branch and the ref share no history.
+var ErrNoMergeBase = errors.New("no common ancestor")
+
+type aheadBehindCounts struct {
+	ahead, behind int
+}
+
+// AheadBehind returns how many commits HEAD has that ref does not (ahead) and how many ref has
+// that HEAD does not (behind). Results are cached until InvalidateDiffCache is called.
+func (g *GitWorktree) AheadBehind(ref string) (ahead, behind int, err error) {
+	g.diffMu.Lock()
+	defer g.diffMu.Unlock()
+
+	if counts, ok := g.aheadBehind[ref]; ok {
+		return counts.ahead, counts.behind, nil
+	}
+
+	// rev-list happily counts across unrelated histories, so check for a merge base first.
+	mergeBase := exec.Command("git", "-C", g.worktreePath, "merge-base", "HEAD", ref)
+	if err := g.executor().Run(mergeBase); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return 0, 0, fmt.Errorf("cannot compare with %s: %w", ref, ErrNoMergeBase)
+		}
+		return 0, 0, fmt.Errorf("failed to find merge base with %s: %w", ref, err)
+	}
+
+	output, err := g.runGitCommand(g.worktreePath, "rev-list", "--left-right", "--count", "HEAD..."+ref)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count commits against %s: %w", ref, err)
+	}
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected rev-list output %q", output)
+	}
+	if ahead, err = strconv.Atoi(fields[0]); err != nil {
+		return 0, 0, fmt.Errorf("failed to parse ahead count: %w", err)
+	}
+	if behind, err = strconv.Atoi(fields[1]); err != nil {
+		return 0, 0, fmt.Errorf("failed to parse behind count: %w", err)
+	}
+
+	if g.aheadBehind == nil {
+		g.aheadBehind = make(map[string]aheadBehindCounts)
+	}
+	g.aheadBehind[ref] = aheadBehindCounts{ahead: ahead, behind: behind}
+	return ahead, behind, nil
+}
+
+// FetchTimeout bounds how long Fetch waits for the remote.
+const FetchTimeout = time.Minute
+
+var (
+	// ErrRemoteNotFound is returned by Fetch when the repository has no remote of that name.
+	ErrRemoteNotFound = errors.New("remote not found")
+	// ErrRemoteUnreachable is returned by Fetch when the remote could not be fetched from, e.g.
+	// because of a network or authentication failure or a timeout.
+	ErrRemoteUnreachable = errors.New("remote unreachable")
+)
+
+// Fetch fetches remote so that AheadBehind and diffs against its branches are current. It gives
+// up after FetchTimeout and never prompts for credentials. It returns an error wrapping
+// ErrRemoteNotFound if there is no such remote and ErrRemoteUnreachable if fetching failed.
+func (g *GitWorktree) Fetch(remote string) error {
+	if _, err := g.runGitCommand(g.worktreePath, "remote", "get-url", remote); err != nil {
+		return fmt.Errorf("cannot fetch %s: %w", remote, ErrRemoteNotFound)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), FetchTimeout)
+	defer cancel()
+	fetchCmd := exec.CommandContext(ctx, "git", "-C", g.worktreePath, "fetch", "--quiet", remote)
+	// Fail instead of waiting on a credential prompt nobody will answer.
+	fetchCmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if output, err := g.combinedOutput(fetchCmd); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("fetching %s timed out after %s: %w", remote, FetchTimeout, ErrRemoteUnreachable)
+		}
+		return fmt.Errorf("failed to fetch %s: %s: %w", remote, strings.TrimSpace(string(output)), ErrRemoteUnreachable)
+	}
+
+	g.InvalidateDiffCache()
+	return nil
+}
+
+// CommitCount returns how many commits HEAD has on top of the base commit. The result is cached
+// until InvalidateDiffCache is called.
+func (g *GitWorktree) CommitCount() (int, error) {
+	g.diffMu.Lock()
+	defer g.diffMu.Unlock()
+
+	if g.commitCountValid {
+		return g.commitCount, nil
+	}
+	if g.baseCommitSHA == "" {
+		return 0, fmt.Errorf("base commit SHA not set")
+	}
+	output, err := g.runGitCommand(g.worktreePath, "rev-list", "--count", g.baseCommitSHA+"..HEAD")
+	if err != nil {
+		return 0, fmt.Errorf("failed to count commits since base: %w", err)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(output))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse commit count: %w", err)
+	}
+
+	g.commitCount = count
+	g.commitCountValid = true
+	return count, nil
+}
+
+// CommitInfo describes a commit.
+type CommitInfo struct {
+	// SHA is the full commit hash.
+	SHA string
+	// Subject is the first line of the commit message.
+	Subject string
+	// Author is the name of the commit's author.
+	Author string
+	// When is the commit's author date.
+	When time.Time
+}
+
+// ShortSHA returns the abbreviated commit hash.
+func (c CommitInfo) ShortSHA() string {
+	if len(c.SHA) > 7 {
+		return c.SHA[:7]
+	}
+	return c.SHA
+}
+
+// LastCommit returns the latest commit on the worktree's branch. Like HeadCommit it works whether
+// or not the worktree is checked out. The result is cached until InvalidateDiffCache is called.
+func (g *GitWorktree) LastCommit() (CommitInfo, error) {
+	g.diffMu.Lock()
+	defer g.diffMu.Unlock()
+
+	if g.lastCommit != nil {
+		return *g.lastCommit, nil
+	}
+	output, err := g.runGitCommand(g.repoPath, "log", "-1", "--format=%H%x00%an%x00%at%x00%s", "refs/heads/"+g.branchName)
+	if err != nil {
+		return CommitInfo{}, fmt.Errorf("failed to read last commit: %w", err)
+	}
+	commit, err := parseCommitInfo(output)
+	if err != nil {
+		return CommitInfo{}, err
+	}
+	g.lastCommit = &commit
+	return commit, nil
+}
+
+// parseCommitInfo parses the output of git log --format=%H%x00%an%x00%at%x00%s.
+func parseCommitInfo(output string) (CommitInfo, error) {
+	fields := strings.SplitN(strings.TrimRight(output, "\n"), "\x00", 4)
+	if len(fields) != 4 {
+		return CommitInfo{}, fmt.Errorf("unexpected git log output %q", output)
+	}
+	seconds, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return CommitInfo{}, fmt.Errorf("failed to parse commit time: %w", err)
+	}
+	return CommitInfo{
+		SHA:     fields[0],
+		Author:  fields[1],
+		When:    time.Unix(seconds, 0),
+		Subject: fields[3],
+	}, nil
+}
+
+// HeadCommit returns the commit the worktree's branch points to. It works whether or not the
+// worktree is checked out, e.g. for a paused session.
+func (g *GitWorktree) HeadCommit() (string, error) {
+	output, err := g.runGitCommand(g.repoPath, "rev-parse", "--verify", "refs/heads/"+g.branchName)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve branch %s: %w", g.branchName, err)
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// resolveCommit resolves ref to a full commit SHA.
+func (g *GitWorktree) resolveCommit(ref string) (string, error) {
+	output, err := g.runGitCommand(g.repoPath, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// isAncestor reports whether commit is an ancestor of (or the same as) descendant.
+func (g *GitWorktree) isAncestor(commit, descendant string) (bool, error) {
+	ancestorCmd := exec.Command("git", "-C", g.repoPath, "merge-base", "--is-ancestor", commit, descendant)
+	if err := g.executor().Run(ancestorCmd); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check ancestry of %s: %w", commit, err)
+	}
+	return true, nil
+}
+
+// UpdateBaseCommitSHA moves the recorded base commit to the commit ref points to, so the diff
+// only shows work done since then. The new base must be an ancestor of the branch, otherwise the
+// diff would show the base's own changes reverted; ErrBaseNotAncestor is returned in that case,
+// e.g. when the branch has not been rebased onto an updated ref yet. The base branch becomes ref
+// only if ref is a branch; a tag or commit leaves it unchanged.
+func (g *GitWorktree) UpdateBaseCommitSHA(ref string) error {
+	commit, err := g.resolveCommit(ref)
+	if err != nil {
+		return err
+	}
+	head, err := g.HeadCommit()
+	if err != nil {
+		return err
+	}
+	ok, err := g.isAncestor(commit, head)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("cannot use %s as the base: %w", ref, ErrBaseNotAncestor)
+	}
+
+	// DiffWithOptions reads the base under diffMu.
+	g.diffMu.Lock()
+	g.baseCommitSHA = commit
+	g.diffMu.Unlock()
+	// A tag or commit is not something the session can be said to have forked from.
+	if g.isBranch(ref) {
+		g.baseBranch = ref
+	}
+	g.InvalidateDiffCache()
+	return nil
+}
+
+// isBranch reports whether ref names a local or remote-tracking branch.
+func (g *GitWorktree) isBranch(ref string) bool {
+	output, err := g.runGitCommand(g.repoPath, "rev-parse", "--symbolic-full-name", ref)
+	if err != nil {
+		return false
+	}
+	name := strings.TrimSpace(output)
+	return strings.HasPrefix(name, "refs/heads/") || strings.HasPrefix(name, "refs/remotes/")
+}
+
+// BaseIsStale reports whether ref has moved on from the recorded base commit, meaning the diff
+// may include changes that came from ref rather than from the session.
+func (g *GitWorktree) BaseIsStale(ref string) (bool, error) {
+	base := g.GetBaseCommitSHA()
+	if base == "" {
+		return false, fmt.Errorf("base commit SHA not set")
+	}
+	commit, err := g.resolveCommit(ref)
+	if err != nil {
+		return false, err
+	}
+	if commit == base {
+		return false, nil
+	}
+	// A ref that is behind the base has not advanced past it.
+	behind, err := g.isAncestor(commit, base)
+	if err != nil {
+		return false, err
+	}
+	return !behind, nil
+}
+
 // IsDirty checks if the worktree has uncommitted changes
 func (g *GitWorktree) IsDirty() (bool, error) {
+	state, err := g.DirtyDetails()
+	if err != nil {
+		return false, err
+	}
+	return state.Dirty(), nil
+}
+
+// DirtyState describes the kinds of uncommitted changes in a worktree.
+type DirtyState struct {
+	// StagedChanges is true if the index differs from HEAD.
+	StagedChanges bool
+	// UnstagedChanges is true if tracked files differ from the index.
+	UnstagedChanges bool
+	// UntrackedFiles is true if there are files git does not track and does not ignore.
+	UntrackedFiles bool
+}
+
+// Dirty reports whether there are uncommitted changes of any kind.
+func (s DirtyState) Dirty() bool {
+	return s.StagedChanges || s.UnstagedChanges || s.UntrackedFiles
+}
+
+// DirtyDetails reports which kinds of uncommitted changes the worktree has.
+func (g *GitWorktree) DirtyDetails() (DirtyState, error) {
 	output, err := g.runGitCommand(g.worktreePath, "status", "--porcelain")
 	if err != nil {
-		return false, fmt.Errorf("failed to check worktree status: %w", err)
+		return DirtyState{}, fmt.Errorf("failed to check worktree status: %w", err)
 	}
-	return len(output) > 0, nil
+	return parseDirtyState(output), nil
+}
+
+// parseDirtyState classifies the entries of git status --porcelain output. Each entry starts
+// with two status letters, for the index and the worktree, or "??" for an untracked file.
+func parseDirtyState(output string) DirtyState {
+	var state DirtyState
+	for _, line := range strings.Split(output, "\n") {
+		if len(line) < 2 {
+			continue
+		}
+		index, worktree := line[0], line[1]
+		if index == '?' && worktree == '?' {
+			state.UntrackedFiles = true
+			continue
+		}
+		if index != ' ' && index != '!' {
+			state.StagedChanges = true
+		}
+		if worktree != ' ' && worktree != '!' {
+			state.UnstagedChanges = true
+		}
+	}
+	return state
+}
+
+// Stash saves the worktree's uncommitted changes, including untracked files, as a stash entry
+// with the given message and cleans the worktree.
+func (g *GitWorktree) Stash(message string) error {
+	if _, err := g.runGitCommand(g.worktreePath, "stash", "push", "--include-untracked", "-m", message); err != nil {
+		return fmt.Errorf("failed to stash changes: %w", err)
+	}
+	return nil
+}
+
+// PopStash applies and drops the most recent stash entry made by Stash with the given message.
+// It reports whether such an entry was found. Stash entries are shared by all worktrees of the
+// repository, so the message should identify the worktree.
+func (g *GitWorktree) PopStash(message string) (bool, error) {
+	output, err := g.runGitCommand(g.worktreePath, "stash", "list", "--format=%gd %gs")
+	if err != nil {
+		return false, fmt.Errorf("failed to list stashes: %w", err)
+	}
+	for _, line := range strings.Split(output, "\n") {
+		ref, subject, ok := strings.Cut(strings.TrimSpace(line), " ")
+		// Stash push records the subject as "On <branch>: <message>".
+		if !ok || !strings.HasSuffix(subject, ": "+message) {
+			continue
+		}
+		if _, err := g.runGitCommand(g.worktreePath, "stash", "pop", ref); err != nil {
+			return true, fmt.Errorf("failed to pop stash %s: %w", ref, err)
+		}
+		return true, nil
+	}
+	return false, nil
 }
 
 // IsBranchCheckedOut checks if the instance branch is currently checked out
@@ -123,6 +641,114 @@ func (g *GitWorktree) IsBranchCheckedOut() (bool, error) {
 	return strings.TrimSpace(string(output)) == g.branchName, nil
 }
 
+// branchWorktreePath returns the path of the worktree (including the main checkout) that has the
+// branch checked out, or an empty string if it is not checked out anywhere.
+func (g *GitWorktree) branchWorktreePath() (string, error) {
+	return g.worktreePathForBranch(g.branchName)
+}
+
+// worktreePathForBranch is like branchWorktreePath for any branch of the repository.
+func (g *GitWorktree) worktreePathForBranch(branch string) (string, error) {
+	output, err := g.runGitCommand(g.repoPath, "worktree", "list", "--porcelain")
+	if err != nil {
+		return "", fmt.Errorf("failed to list worktrees: %w", err)
+	}
+
+	current := ""
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "worktree ") {
+			current = strings.TrimPrefix(line, "worktree ")
+		} else if line == "branch refs/heads/"+branch {
+			return current, nil
+		}
+	}
+	return "", nil
+}
+
+// BranchCheckedOutError is returned when a branch cannot be checked out because another worktree,
+// or the main checkout, already has it checked out.
+type BranchCheckedOutError struct {
+	Branch string
+	// Path is the worktree that has the branch checked out.
+	Path string
+}
+
+func (e *BranchCheckedOutError) Error() string {
+	return fmt.Sprintf("branch %s is already checked out at %s", e.Branch, e.Path)
+}
+
+// CheckoutOptions configures CheckoutBranchWithOptions.
+type CheckoutOptions struct {
+	// Create creates the branch at the worktree's HEAD instead of checking out an existing one.
+	Create bool
+	// Force checks the branch out even if the worktree has uncommitted changes. The changes are
+	// carried over to the branch; git still refuses if that would overwrite them.
+	Force bool
+}
+
+// CheckoutBranch switches the worktree to the branch name, creating it at the current HEAD if
+// create is true. It refuses if the worktree has uncommitted changes; see
+// CheckoutBranchWithOptions to force it.
+func (g *GitWorktree) CheckoutBranch(name string, create bool) error {
+	return g.CheckoutBranchWithOptions(name, CheckoutOptions{Create: create})
+}
+
+// CheckoutBranchWithOptions switches the worktree to the branch name. A *BranchCheckedOutError is
+// returned if another worktree has the branch checked out. The previous branch is kept. A branch
+// that already existed is treated like one the session was started on, so cleanup never deletes
+// it.
+func (g *GitWorktree) CheckoutBranchWithOptions(name string, opts CheckoutOptions) error {
+	if name == "" {
+		return fmt.Errorf("branch name cannot be empty")
+	}
+	if name == g.branchName {
+		return nil
+	}
+	if !opts.Force {
+		dirty, err := g.IsDirty()
+		if err != nil {
+			return fmt.Errorf("failed to check for changes: %w", err)
+		}
+		if dirty {
+			return fmt.Errorf("cannot check out %s: worktree has uncommitted changes, commit or stash them first", name)
+		}
+	}
+	if !opts.Create {
+		path, err := g.worktreePathForBranch(name)
+		if err != nil {
+			return err
+		}
+		if path != "" {
+			return &BranchCheckedOutError{Branch: name, Path: path}
+		}
+	}
+
+	args := []string{"checkout", name}
+	if opts.Create {
+		args = []string{"checkout", "-b", name}
+	}
+	if _, err := g.runGitCommand(g.worktreePath, args...); err != nil {
+		g.InvalidateDiffCache()
+		return fmt.Errorf("failed to check out branch %s: %w", name, err)
+	}
+
+	base := g.GetBaseCommitSHA()
+	if !opts.Create {
+		// Diff against where the branches forked, so the diff only shows the new branch's work.
+		if output, err := g.runGitCommand(g.worktreePath, "merge-base", base, "HEAD"); err == nil {
+			base = strings.TrimSpace(output)
+		}
+	}
+	// DiffWithOptions reads these under diffMu.
+	g.diffMu.Lock()
+	g.baseCommitSHA = base
+	g.branchName = name
+	g.externalBranch = !opts.Create
+	g.diffMu.Unlock()
+	g.InvalidateDiffCache()
+	return nil
+}
+
 // OpenBranchURL opens the branch URL in the default browser
 func (g *GitWorktree) OpenBranchURL() error {
 	// Check if GitHub CLI is available
@@ -137,3 +763,39 @@ func (g *GitWorktree) OpenBranchURL() error {
 	}
 	return nil
 }
+
+// CreatePullRequest pushes the branch to origin and opens a pull request for it with the GitHub
+// CLI, returning the URL of the pull request. An empty base uses the repository's default branch.
+func (g *GitWorktree) CreatePullRequest(title, body, base string) (string, error) {
+	if err := checkGHCLI(); err != nil {
+		return "", err
+	}
+	if title == "" {
+		return "", fmt.Errorf("pull request title cannot be empty")
+	}
+
+	if _, err := g.runGitCommand(g.worktreePath, "push", "-u", "origin", g.branchName); err != nil {
+		log.ErrorLog.Print(err)
+		return "", fmt.Errorf("failed to push branch: %w", err)
+	}
+
+	args := []string{"pr", "create", "--head", g.branchName, "--title", title, "--body", body}
+	if base != "" {
+		args = append(args, "--base", base)
+	}
+	cmd := exec.Command("gh", args...)
+	cmd.Dir = g.worktreePath
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		log.ErrorLog.Print(err)
+		return "", fmt.Errorf("failed to create pull request: %s (%w)", strings.TrimSpace(string(output)), err)
+	}
+
+	// gh prints progress first and the URL of the new pull request last.
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	url := strings.TrimSpace(lines[len(lines)-1])
+	if !strings.HasPrefix(url, "http") {
+		return "", fmt.Errorf("unexpected output from gh pr create: %s", output)
+	}
+	return url, nil
+}
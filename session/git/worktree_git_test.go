@@ -0,0 +1,750 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestWorktree(t *testing.T, repo string) *GitWorktree {
+	t.Helper()
+	head := strings.TrimSpace(runGit(t, repo, "rev-parse", "HEAD"))
+	return &GitWorktree{
+		repoPath:      repo,
+		worktreePath:  repo,
+		branchName:    "main",
+		baseCommitSHA: head,
+	}
+}
+
+func TestCommitWithOptions(t *testing.T) {
+	t.Run("commits with custom author", func(t *testing.T) {
+		repo := setupTempRepo(t)
+		wt := newTestWorktree(t, repo)
+
+		if err := os.WriteFile(filepath.Join(repo, "new.txt"), []byte("new\n"), 0o644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+
+		err := wt.CommitWithOptions(CommitOptions{
+			Message: "bot commit",
+			Author:  "Agent Bot <bot@example.com>",
+		})
+		if err != nil {
+			t.Fatalf("CommitWithOptions: %v", err)
+		}
+
+		author := strings.TrimSpace(runGit(t, repo, "log", "-1", "--format=%an <%ae>"))
+		if author != "Agent Bot <bot@example.com>" {
+			t.Fatalf("unexpected author %q", author)
+		}
+		if dirty, err := wt.IsDirty(); err != nil || dirty {
+			t.Fatalf("expected clean worktree after commit, dirty=%v err=%v", dirty, err)
+		}
+	})
+
+	t.Run("reports nothing to commit on clean worktree", func(t *testing.T) {
+		repo := setupTempRepo(t)
+		wt := newTestWorktree(t, repo)
+
+		err := wt.CommitWithOptions(CommitOptions{Message: "empty"})
+		if !errors.Is(err, ErrNothingToCommit) {
+			t.Fatalf("expected ErrNothingToCommit, got %v", err)
+		}
+
+		if err := wt.CommitChanges("empty"); err != nil {
+			t.Fatalf("CommitChanges on clean worktree should be a no-op, got %v", err)
+		}
+	})
+
+	t.Run("allows empty commits when requested", func(t *testing.T) {
+		repo := setupTempRepo(t)
+		wt := newTestWorktree(t, repo)
+
+		if err := wt.CommitWithOptions(CommitOptions{Message: "checkpoint", AllowEmpty: true}); err != nil {
+			t.Fatalf("CommitWithOptions: %v", err)
+		}
+		subject := strings.TrimSpace(runGit(t, repo, "log", "-1", "--format=%s"))
+		if subject != "checkpoint" {
+			t.Fatalf("expected empty commit to be recorded, got subject %q", subject)
+		}
+	})
+}
+
+func TestSquashToBase(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+
+	for i, content := range []string{"one\n", "one\ntwo\n"} {
+		if err := os.WriteFile(filepath.Join(repo, "work.txt"), []byte(content), 0o644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+		if err := wt.CommitChangesIncludingUntracked("wip " + string(rune('a'+i))); err != nil {
+			t.Fatalf("CommitChangesIncludingUntracked: %v", err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(repo, "work.txt"), []byte("dirty\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := wt.SquashToBase("squashed"); err == nil {
+		t.Fatal("expected squash to refuse a dirty worktree")
+	}
+	runGit(t, repo, "checkout", "--", "work.txt")
+
+	if err := wt.SquashToBase("squashed"); err != nil {
+		t.Fatalf("SquashToBase: %v", err)
+	}
+
+	count := strings.TrimSpace(runGit(t, repo, "rev-list", "--count", wt.GetBaseCommitSHA()+"..HEAD"))
+	if count != "1" {
+		t.Fatalf("expected a single commit on top of base, got %s", count)
+	}
+	subject := strings.TrimSpace(runGit(t, repo, "log", "-1", "--format=%s"))
+	if subject != "squashed" {
+		t.Fatalf("unexpected subject %q", subject)
+	}
+	content, err := os.ReadFile(filepath.Join(repo, "work.txt"))
+	if err != nil || string(content) != "one\ntwo\n" {
+		t.Fatalf("expected accumulated changes to be preserved, got %q (%v)", content, err)
+	}
+}
+
+func TestSquashToBaseRejectsMovedBase(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+
+	// Advance main past the session's HEAD and record it as the base.
+	runGit(t, repo, "checkout", "-b", "session")
+	runGit(t, repo, "checkout", "main")
+	runGit(t, repo, "commit", "--allow-empty", "-m", "new base")
+	wt.baseCommitSHA = strings.TrimSpace(runGit(t, repo, "rev-parse", "main"))
+	runGit(t, repo, "checkout", "session")
+
+	err := wt.SquashToBase("squashed")
+	if !errors.Is(err, ErrBaseNotAncestor) {
+		t.Fatalf("expected ErrBaseNotAncestor, got %v", err)
+	}
+}
+
+func TestAheadBehind(t *testing.T) {
+	wt := setupDivergedRepo(t, "hello world\nsession\n", "")
+	runGit(t, wt.repoPath, "commit", "--allow-empty", "-m", "second session commit")
+
+	ahead, behind, err := wt.AheadBehind("main")
+	if err != nil {
+		t.Fatalf("AheadBehind: %v", err)
+	}
+	if ahead != 2 || behind != 1 {
+		t.Fatalf("expected 2 ahead, 1 behind, got %d ahead, %d behind", ahead, behind)
+	}
+
+	// Cached until the diff cache is invalidated.
+	runGit(t, wt.repoPath, "commit", "--allow-empty", "-m", "third session commit")
+	if ahead, _, _ = wt.AheadBehind("main"); ahead != 2 {
+		t.Fatalf("expected cached ahead count 2, got %d", ahead)
+	}
+	wt.InvalidateDiffCache()
+	if ahead, _, _ = wt.AheadBehind("main"); ahead != 3 {
+		t.Fatalf("expected refreshed ahead count 3, got %d", ahead)
+	}
+}
+
+func TestAheadBehindUnrelatedHistories(t *testing.T) {
+	wt := setupDivergedRepo(t, "hello world\nsession\n", "")
+	runGit(t, wt.repoPath, "checkout", "--orphan", "unrelated")
+	runGit(t, wt.repoPath, "commit", "--allow-empty", "-m", "root")
+	runGit(t, wt.repoPath, "checkout", "session")
+
+	_, _, err := wt.AheadBehind("unrelated")
+	if !errors.Is(err, ErrNoMergeBase) {
+		t.Fatalf("expected ErrNoMergeBase, got %v", err)
+	}
+}
+
+func TestBaseIsStaleAndUpdateBaseCommitSHA(t *testing.T) {
+	wt := setupDivergedRepo(t, "hello world\nsession\n", "")
+	repo := wt.repoPath
+	base := wt.GetBaseCommitSHA()
+
+	stale, err := wt.BaseIsStale(base)
+	if err != nil || stale {
+		t.Fatalf("expected base to be current against itself, stale=%v err=%v", stale, err)
+	}
+	stale, err = wt.BaseIsStale("main")
+	if err != nil || !stale {
+		t.Fatalf("expected base to be stale after main advanced, stale=%v err=%v", stale, err)
+	}
+
+	if err := wt.UpdateBaseCommitSHA("main"); !errors.Is(err, ErrBaseNotAncestor) {
+		t.Fatalf("expected ErrBaseNotAncestor before rebasing, got %v", err)
+	}
+	if wt.GetBaseCommitSHA() != base {
+		t.Fatal("expected base to be unchanged after a rejected update")
+	}
+
+	runGit(t, repo, "merge", "--no-edit", "main")
+	if err := wt.UpdateBaseCommitSHA("main"); err != nil {
+		t.Fatalf("UpdateBaseCommitSHA: %v", err)
+	}
+	if want := strings.TrimSpace(runGit(t, repo, "rev-parse", "main")); wt.GetBaseCommitSHA() != want {
+		t.Fatalf("expected base %s, got %s", want, wt.GetBaseCommitSHA())
+	}
+	if stale, err := wt.BaseIsStale("main"); err != nil || stale {
+		t.Fatalf("expected base to be current after update, stale=%v err=%v", stale, err)
+	}
+	if diff := wt.Diff(true); strings.Contains(diff.Content, "other.txt") || !strings.Contains(diff.Content, "+session") {
+		t.Fatalf("expected diff to only show session work, got %q", diff.Content)
+	}
+	if wt.GetBaseBranch() != "main" {
+		t.Fatalf("expected base branch main, got %q", wt.GetBaseBranch())
+	}
+
+	// A commit or tag moves the base commit but keeps the base branch.
+	runGit(t, repo, "tag", "v1", "main")
+	for _, ref := range []string{strings.TrimSpace(runGit(t, repo, "rev-parse", "main")), "v1"} {
+		if err := wt.UpdateBaseCommitSHA(ref); err != nil {
+			t.Fatalf("UpdateBaseCommitSHA(%s): %v", ref, err)
+		}
+		if wt.GetBaseBranch() != "main" {
+			t.Fatalf("expected base branch to stay main after updating to %s, got %q", ref, wt.GetBaseBranch())
+		}
+	}
+}
+
+func TestDirtyDetails(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+
+	check := func(want DirtyState) {
+		t.Helper()
+		got, err := wt.DirtyDetails()
+		if err != nil {
+			t.Fatalf("DirtyDetails: %v", err)
+		}
+		if got != want {
+			t.Fatalf("expected %+v, got %+v", want, got)
+		}
+		if dirty, err := wt.IsDirty(); err != nil || dirty != want.Dirty() {
+			t.Fatalf("expected IsDirty to be %v, got %v (err %v)", want.Dirty(), dirty, err)
+		}
+	}
+
+	check(DirtyState{})
+
+	if err := os.WriteFile(filepath.Join(repo, "build.log"), []byte("junk\n"), 0o644); err != nil {
+		t.Fatalf("write untracked file: %v", err)
+	}
+	check(DirtyState{UntrackedFiles: true})
+
+	runGit(t, repo, "add", "build.log")
+	check(DirtyState{StagedChanges: true})
+
+	// A leading space in the status entry marks a change that is not staged.
+	if err := os.WriteFile(filepath.Join(repo, "file.txt"), []byte("changed\n"), 0o644); err != nil {
+		t.Fatalf("write tracked file: %v", err)
+	}
+	runGit(t, repo, "reset", "-q", "build.log")
+	check(DirtyState{UnstagedChanges: true, UntrackedFiles: true})
+}
+
+func TestStashAndPopStash(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+
+	if err := os.WriteFile(filepath.Join(repo, "wip.txt"), []byte("wip\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := wt.Stash("paused 'mine'"); err != nil {
+		t.Fatalf("Stash: %v", err)
+	}
+	if dirty, err := wt.IsDirty(); err != nil || dirty {
+		t.Fatalf("expected clean worktree after Stash, got dirty=%v err=%v", dirty, err)
+	}
+
+	if found, err := wt.PopStash("paused 'other'"); err != nil || found {
+		t.Fatalf("expected no stash for another message, got found=%v err=%v", found, err)
+	}
+	found, err := wt.PopStash("paused 'mine'")
+	if err != nil || !found {
+		t.Fatalf("PopStash: found=%v err=%v", found, err)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "wip.txt")); err != nil {
+		t.Fatalf("expected stashed file to be restored: %v", err)
+	}
+	if list := strings.TrimSpace(runGit(t, repo, "stash", "list")); list != "" {
+		t.Fatalf("expected stash entry to be dropped, got %q", list)
+	}
+}
+
+func TestCreatePullRequest(t *testing.T) {
+	repo := setupTempRepo(t)
+	remote := t.TempDir()
+	runGit(t, remote, "init", "--bare")
+	runGit(t, repo, "remote", "add", "origin", remote)
+	runGit(t, repo, "checkout", "-b", "feature")
+	wt := newTestWorktree(t, repo)
+	wt.branchName = "feature"
+
+	// A fake gh records its arguments and prints a URL like gh pr create does.
+	bin := t.TempDir()
+	argsFile := filepath.Join(bin, "args")
+	script := "#!/bin/sh\n" +
+		"[ \"$1\" = auth ] && exit 0\n" +
+		"echo \"$@\" > " + argsFile + "\n" +
+		"echo 'Creating pull request for feature into main'\n" +
+		"echo https://github.com/owner/repo/pull/7\n"
+	if err := os.WriteFile(filepath.Join(bin, "gh"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake gh: %v", err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	url, err := wt.CreatePullRequest("Add feature", "Body", "main")
+	if err != nil {
+		t.Fatalf("CreatePullRequest: %v", err)
+	}
+	if url != "https://github.com/owner/repo/pull/7" {
+		t.Fatalf("unexpected URL %q", url)
+	}
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("read gh args: %v", err)
+	}
+	if got := strings.TrimSpace(string(args)); got != "pr create --head feature --title Add feature --body Body --base main" {
+		t.Fatalf("unexpected gh arguments %q", got)
+	}
+	runGit(t, remote, "rev-parse", "--verify", "refs/heads/feature")
+}
+
+func TestCreatePullRequestWithoutGH(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	wt := &GitWorktree{repoPath: t.TempDir(), worktreePath: t.TempDir(), branchName: "feature"}
+	if _, err := wt.CreatePullRequest("title", "", ""); err == nil || !strings.Contains(err.Error(), "install") {
+		t.Fatalf("expected an error suggesting to install gh, got %v", err)
+	}
+}
+
+func TestCommitCount(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+
+	count, err := wt.CommitCount()
+	if err != nil || count != 0 {
+		t.Fatalf("expected 0 commits at the base, got %d, %v", count, err)
+	}
+
+	writeAndCommit(t, repo, "one.txt", "one\n", "one")
+	writeAndCommit(t, repo, "two.txt", "two\n", "two")
+	if count, _ := wt.CommitCount(); count != 0 {
+		t.Fatalf("expected cached count of 0 before invalidation, got %d", count)
+	}
+	wt.InvalidateDiffCache()
+	if count, err := wt.CommitCount(); err != nil || count != 2 {
+		t.Fatalf("expected 2 commits, got %d, %v", count, err)
+	}
+
+	wt.baseCommitSHA = ""
+	wt.InvalidateDiffCache()
+	if _, err := wt.CommitCount(); err == nil {
+		t.Fatal("expected an error without a base commit")
+	}
+}
+
+func TestLastCommit(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+
+	writeAndCommit(t, repo, "one.txt", "one\n", "first line\n\nbody")
+	head := strings.TrimSpace(runGit(t, repo, "rev-parse", "HEAD"))
+	commit, err := wt.LastCommit()
+	if err != nil {
+		t.Fatalf("LastCommit: %v", err)
+	}
+	if commit.SHA != head || commit.ShortSHA() != head[:7] {
+		t.Fatalf("expected commit %s, got %s", head, commit.SHA)
+	}
+	if commit.Subject != "first line" {
+		t.Fatalf("expected subject %q, got %q", "first line", commit.Subject)
+	}
+	if commit.Author == "" || commit.When.IsZero() || time.Since(commit.When) > time.Hour {
+		t.Fatalf("expected author and a recent time, got %+v", commit)
+	}
+
+	// Cached until the diff cache is invalidated.
+	writeAndCommit(t, repo, "two.txt", "two\n", "second")
+	if commit, _ = wt.LastCommit(); commit.Subject != "first line" {
+		t.Fatalf("expected cached subject, got %q", commit.Subject)
+	}
+	wt.InvalidateDiffCache()
+	if commit, _ = wt.LastCommit(); commit.Subject != "second" {
+		t.Fatalf("expected refreshed subject %q, got %q", "second", commit.Subject)
+	}
+}
+
+func TestCommitChangesUntracked(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+	writeAndCommit(t, repo, ".gitignore", "build/\n", "ignore build output")
+
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(repo, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	committed := func() string {
+		t.Helper()
+		return runGit(t, repo, "show", "--name-only", "--format=", "HEAD")
+	}
+
+	write("file.txt", "changed\n")
+	write("new.txt", "new\n")
+	write("build/out.bin", "artifact\n")
+	if err := wt.CommitChanges("tracked"); err != nil {
+		t.Fatalf("CommitChanges: %v", err)
+	}
+	if files := committed(); !strings.Contains(files, "file.txt") || strings.Contains(files, "new.txt") {
+		t.Fatalf("expected only the tracked file to be committed, got %q", files)
+	}
+
+	if err := wt.CommitChangesIncludingUntracked("untracked"); err != nil {
+		t.Fatalf("CommitChangesIncludingUntracked: %v", err)
+	}
+	if files := committed(); !strings.Contains(files, "new.txt") || strings.Contains(files, "build/") {
+		t.Fatalf("expected the untracked but not the ignored file to be committed, got %q", files)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	origin := setupTempRepo(t)
+	repo := filepath.Join(t.TempDir(), "clone")
+	runGit(t, origin, "clone", "-q", origin, repo)
+	runGit(t, repo, "config", "user.email", "test@example.com")
+	runGit(t, repo, "config", "user.name", "Test User")
+	wt := newTestWorktree(t, repo)
+
+	writeAndCommit(t, origin, "upstream.txt", "upstream\n", "upstream work")
+	if _, behind, err := wt.AheadBehind("origin/main"); err != nil || behind != 0 {
+		t.Fatalf("expected to be level with the stale origin/main, got behind=%d err=%v", behind, err)
+	}
+	if err := wt.Fetch("origin"); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if _, behind, err := wt.AheadBehind("origin/main"); err != nil || behind != 1 {
+		t.Fatalf("expected to be 1 behind after fetching, got behind=%d err=%v", behind, err)
+	}
+
+	if err := wt.Fetch("upstream"); !errors.Is(err, ErrRemoteNotFound) {
+		t.Fatalf("expected ErrRemoteNotFound, got %v", err)
+	}
+	runGit(t, repo, "remote", "add", "gone", filepath.Join(t.TempDir(), "missing"))
+	if err := wt.Fetch("gone"); !errors.Is(err, ErrRemoteUnreachable) {
+		t.Fatalf("expected ErrRemoteUnreachable, got %v", err)
+	}
+}
+
+func TestRestoreKilledBranch(t *testing.T) {
+	repo := setupTempRepo(t)
+	kill := func(branch string) string {
+		t.Helper()
+		runGit(t, repo, "branch", branch)
+		tip := strings.TrimSpace(runGit(t, repo, "rev-parse", branch))
+		wt := &GitWorktree{repoPath: repo, worktreePath: filepath.Join(t.TempDir(), branch), branchName: branch}
+		if err := wt.Cleanup(); err != nil {
+			t.Fatalf("Cleanup: %v", err)
+		}
+		if out := runGit(t, repo, "branch", "--list", branch); strings.TrimSpace(out) != "" {
+			t.Fatalf("expected Cleanup to delete %s", branch)
+		}
+		return tip
+	}
+
+	kill("first")
+	writeAndCommit(t, repo, "b.txt", "b\n", "second commit")
+	tip := kill("second")
+
+	if err := RestoreKilledBranch(repo, "first"); !errors.Is(err, ErrNoKilledBranch) {
+		t.Fatalf("expected only the last killed branch to be restorable, got %v", err)
+	}
+	if err := RestoreKilledBranch(repo, "second"); err != nil {
+		t.Fatalf("RestoreKilledBranch: %v", err)
+	}
+	if got := strings.TrimSpace(runGit(t, repo, "rev-parse", "second")); got != tip {
+		t.Fatalf("expected restored branch at %s, got %s", tip, got)
+	}
+	if out := runGit(t, repo, "for-each-ref", KilledBranchRefPrefix); strings.TrimSpace(out) != "" {
+		t.Fatalf("expected the backup to be consumed, got %q", out)
+	}
+	// A branch that still exists needs no restoring.
+	if err := RestoreKilledBranch(repo, "second"); err != nil {
+		t.Fatalf("expected restoring an existing branch to be a no-op, got %v", err)
+	}
+}
+
+func TestDiscardKeepsKilledBranchBackup(t *testing.T) {
+	repo := setupTempRepo(t)
+	runGit(t, repo, "branch", "killed")
+	killed := &GitWorktree{repoPath: repo, worktreePath: filepath.Join(t.TempDir(), "killed"), branchName: "killed"}
+	if err := killed.Cleanup(); err != nil {
+		t.Fatalf("Cleanup: %v", err)
+	}
+
+	// A session that failed to start is discarded without replacing the backup.
+	runGit(t, repo, "branch", "failed")
+	failed := &GitWorktree{repoPath: repo, worktreePath: filepath.Join(t.TempDir(), "failed"), branchName: "failed"}
+	if err := failed.Discard(); err != nil {
+		t.Fatalf("Discard: %v", err)
+	}
+	if out := runGit(t, repo, "branch", "--list", "failed"); strings.TrimSpace(out) != "" {
+		t.Fatalf("expected Discard to delete the branch, got %q", out)
+	}
+	if err := RestoreKilledBranch(repo, "failed"); !errors.Is(err, ErrNoKilledBranch) {
+		t.Fatalf("expected no backup of the discarded branch, got %v", err)
+	}
+	if err := RestoreKilledBranch(repo, "killed"); err != nil {
+		t.Fatalf("expected the killed branch to stay restorable, got %v", err)
+	}
+}
+
+func TestDeleteBranchRefusesCheckedOutBranch(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+	if err := wt.DeleteBranch(); err == nil || !strings.Contains(err.Error(), "checked out") {
+		t.Fatalf("expected deleting the checked-out branch to fail, got %v", err)
+	}
+	if strings.TrimSpace(runGit(t, repo, "rev-parse", "--verify", "main")) == "" {
+		t.Fatal("expected main to survive")
+	}
+
+	runGit(t, repo, "branch", "feature")
+	wt.branchName = "feature"
+	if err := wt.DeleteBranch(); err != nil {
+		t.Fatalf("DeleteBranch: %v", err)
+	}
+	if out := runGit(t, repo, "branch", "--list", "feature"); strings.TrimSpace(out) != "" {
+		t.Fatalf("expected feature to be deleted, got %q", out)
+	}
+	if err := wt.DeleteBranch(); err != nil {
+		t.Fatalf("expected deleting a missing branch to succeed, got %v", err)
+	}
+}
+
+func TestCheckoutBranch(t *testing.T) {
+	repo := setupTempRepo(t)
+	wt := newTestWorktree(t, repo)
+
+	if err := os.WriteFile(filepath.Join(repo, "file.txt"), []byte("dirty\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := wt.CheckoutBranch("feature", true); err == nil || !strings.Contains(err.Error(), "uncommitted") {
+		t.Fatalf("expected a dirty worktree to be refused, got %v", err)
+	}
+	if err := wt.CheckoutBranchWithOptions("feature", CheckoutOptions{Create: true, Force: true}); err != nil {
+		t.Fatalf("forced CheckoutBranchWithOptions: %v", err)
+	}
+	if got := strings.TrimSpace(runGit(t, repo, "branch", "--show-current")); got != "feature" || wt.GetBranchName() != "feature" {
+		t.Fatalf("expected feature to be checked out, got %q and %q", got, wt.GetBranchName())
+	}
+	if wt.IsExternalBranch() {
+		t.Fatal("expected a created branch not to be external")
+	}
+	writeAndCommit(t, repo, "file.txt", "feature\n", "feature work")
+
+	if err := wt.CheckoutBranch("main", false); err != nil {
+		t.Fatalf("CheckoutBranch: %v", err)
+	}
+	if wt.GetBranchName() != "main" || !wt.IsExternalBranch() {
+		t.Fatalf("expected main to be checked out as an external branch, got %q", wt.GetBranchName())
+	}
+
+	other := filepath.Join(t.TempDir(), "other")
+	runGit(t, repo, "worktree", "add", other, "feature")
+	var checkedOut *BranchCheckedOutError
+	if err := wt.CheckoutBranch("feature", false); !errors.As(err, &checkedOut) || checkedOut.Path != other {
+		t.Fatalf("expected a BranchCheckedOutError for %s, got %v", other, err)
+	}
+	if wt.GetBranchName() != "main" {
+		t.Fatalf("expected a refused checkout to keep the branch, got %q", wt.GetBranchName())
+	}
+}
+
+// fakeGitResponse is what fakeGitExecutor answers a command with.
+type fakeGitResponse struct {
+	output string
+	err    error
+}
+
+// fakeGitExecutor answers git commands from canned responses without running git. Responses are
+// keyed by a prefix of the command line without "git -C <path>"; the longest matching prefix wins.
+type fakeGitExecutor struct {
+	responses map[string]fakeGitResponse
+	calls     []string
+}
+
+func (f *fakeGitExecutor) Run(c *exec.Cmd) error {
+	args := c.Args[1:]
+	if len(args) >= 2 && args[0] == "-C" {
+		args = args[2:]
+	}
+	line := strings.Join(args, " ")
+	f.calls = append(f.calls, line)
+
+	match := ""
+	found := false
+	for prefix := range f.responses {
+		if strings.HasPrefix(line, prefix) && len(prefix) >= len(match) {
+			match, found = prefix, true
+		}
+	}
+	if !found {
+		return fmt.Errorf("unexpected git %s", line)
+	}
+	response := f.responses[match]
+	if c.Stdout != nil {
+		if _, err := io.WriteString(c.Stdout, response.output); err != nil {
+			return err
+		}
+	}
+	return response.err
+}
+
+func (f *fakeGitExecutor) Output(c *exec.Cmd) ([]byte, error) {
+	var out bytes.Buffer
+	c.Stdout = &out
+	err := f.Run(c)
+	return out.Bytes(), err
+}
+
+// callsWithPrefix returns the commands the executor ran that start with prefix.
+func (f *fakeGitExecutor) callsWithPrefix(prefix string) []string {
+	var calls []string
+	for _, call := range f.calls {
+		if strings.HasPrefix(call, prefix) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func TestGitWorktreeUsesInjectedExecutor(t *testing.T) {
+	fake := &fakeGitExecutor{responses: map[string]fakeGitResponse{
+		"rev-list": {output: "3\n"},
+		"status":   {output: " M main.go\n?? notes.txt\n"},
+	}}
+	g := NewGitWorktreeFromStorage("/nonexistent/repo", "/nonexistent/worktree", "session", "main", "abc123")
+	g.SetCmdExecutor(fake)
+
+	count, err := g.CommitCount()
+	if err != nil {
+		t.Fatalf("CommitCount failed: %v", err)
+	}
+	if count != 3 {
+		t.Fatalf("expected 3 commits, got %d", count)
+	}
+
+	state, err := g.DirtyDetails()
+	if err != nil {
+		t.Fatalf("DirtyDetails failed: %v", err)
+	}
+	if !state.UnstagedChanges || !state.UntrackedFiles || state.StagedChanges {
+		t.Fatalf("unexpected dirty state %+v", state)
+	}
+
+	if _, err := g.LastCommit(); err == nil || !strings.Contains(err.Error(), "unexpected git log") {
+		t.Fatalf("expected the fake's error for git log, got %v", err)
+	}
+	if got := fake.calls[0]; got != "rev-list --count abc123..HEAD" {
+		t.Fatalf("unexpected first command %q", got)
+	}
+}
+
+func TestGitWorktreeDiffWithInjectedExecutor(t *testing.T) {
+	patch := "diff --git a/main.go b/main.go\n" +
+		"index 1111111..2222222 100644\n" +
+		"--- a/main.go\n" +
+		"+++ b/main.go\n" +
+		"@@ -1,2 +1,2 @@\n" +
+		" package main\n" +
+		"-var x = 1\n" +
+		"+var x = 2\n"
+	fake := &fakeGitExecutor{responses: map[string]fakeGitResponse{
+		"version":                           {output: "git version 2.43.0\n"},
+		"status --porcelain":                {output: " M main.go\n"},
+		"--no-pager diff --submodule=short": {output: patch},
+	}}
+	g := NewGitWorktreeFromStorage("/nonexistent/repo", "/nonexistent/worktree", "session", "main", "abc123")
+	g.SetCmdExecutor(fake)
+
+	stats := g.Diff(false)
+	if stats.Error != nil {
+		t.Fatalf("Diff: %v", stats.Error)
+	}
+	if stats.Added != 1 || stats.Removed != 1 || stats.FilesChanged != 1 || stats.Content != patch {
+		t.Fatalf("unexpected diff stats %+v", stats)
+	}
+	if diffs := fake.callsWithPrefix("--no-pager diff"); len(diffs) != 1 || !strings.Contains(diffs[0], "abc123") {
+		t.Fatalf("expected one diff against the base commit, got %v", diffs)
+	}
+
+	// An unchanged status is served from the cache.
+	if stats := g.Diff(false); stats.Added != 1 {
+		t.Fatalf("unexpected cached diff stats %+v", stats)
+	}
+	if diffs := fake.callsWithPrefix("--no-pager diff"); len(diffs) != 1 {
+		t.Fatalf("expected the cached diff to be reused, got %v", diffs)
+	}
+
+	if versions := fake.callsWithPrefix("version"); len(versions) != 1 {
+		t.Fatalf("expected the git version to be probed once, got %v", versions)
+	}
+
+	// Replacing the executor probes its version again.
+	fake.responses["version"] = fakeGitResponse{output: "git version 2.20.1\n"}
+	g.SetCmdExecutor(fake)
+	if stats := g.Diff(true); !errors.Is(stats.Error, ErrUnsupportedGitVersion) {
+		t.Fatalf("expected the injected executor's git version to be checked, got %v", stats.Error)
+	}
+}
+
+func TestGitWorktreeRebaseWithInjectedExecutor(t *testing.T) {
+	fake := &fakeGitExecutor{responses: map[string]fakeGitResponse{
+		"status --porcelain":               {},
+		"rev-parse --verify main^{commit}": {output: "def456\n"},
+		"rebase def456":                    {output: "CONFLICT (content): Merge conflict in file.txt\n", err: errors.New("exit status 1")},
+		"diff --name-only --diff-filter=U": {output: "file.txt\n"},
+	}}
+	g := NewGitWorktreeFromStorage("/nonexistent/repo", "/nonexistent/worktree", "session", "session", "abc123")
+	g.SetCmdExecutor(fake)
+
+	err := g.RebaseOnto("main")
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected ConflictError, got %v", err)
+	}
+	if conflict.Operation != "rebase" || len(conflict.Files) != 1 || conflict.Files[0] != "file.txt" {
+		t.Fatalf("unexpected conflict details %+v", conflict)
+	}
+	if g.GetBaseCommitSHA() != "abc123" {
+		t.Fatal("base should not move while the rebase is in progress")
+	}
+
+	fake.responses["-c core.editor=true rebase --continue"] = fakeGitResponse{}
+	fake.responses["diff --name-only --diff-filter=U"] = fakeGitResponse{}
+	if err := g.RebaseContinue(); err != nil {
+		t.Fatalf("RebaseContinue: %v", err)
+	}
+	if g.GetBaseCommitSHA() != "def456" {
+		t.Fatalf("expected the base to move to def456, got %s", g.GetBaseCommitSHA())
+	}
+}
@@ -2,7 +2,9 @@ package git
 
 import (
 	"agent-squad/log"
+	"context"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,12 +16,22 @@ import (
 
 // Setup creates a new worktree for the session
 func (g *GitWorktree) Setup() error {
-	// Ensure worktrees directory exists early (can be done in parallel with branch check)
-	worktreesDir, err := getWorktreeDirectory()
-	if err != nil {
-		return fmt.Errorf("failed to get worktree directory: %w", err)
+	return g.SetupContext(context.Background())
+}
+
+// SetupContext is like Setup but stops once ctx is done, killing a running git command such as a
+// slow worktree add. A cancelled setup can leave a partial worktree behind; Cleanup removes it.
+func (g *GitWorktree) SetupContext(ctx context.Context) error {
+	if err := g.checkGitVersion(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
+	// Ensure worktrees directory exists early (can be done in parallel with branch check)
+	worktreesDir := filepath.Dir(g.worktreePath)
+
 	// Create directory and check branch existence in parallel
 	errChan := make(chan error, 2)
 	var branchExists bool
@@ -52,13 +64,13 @@ func (g *GitWorktree) Setup() error {
 	}
 
 	if branchExists {
-		if err := g.setupFromExistingBranch(); err != nil {
+		if err := g.setupFromExistingBranch(ctx); err != nil {
 			return err
 		}
 		g.InvalidateDiffCache()
 		return nil
 	}
-	if err := g.setupNewWorktree(); err != nil {
+	if err := g.setupNewWorktree(ctx); err != nil {
 		return err
 	}
 	g.InvalidateDiffCache()
@@ -66,22 +78,22 @@ func (g *GitWorktree) Setup() error {
 }
 
 // setupFromExistingBranch creates a worktree from an existing branch
-func (g *GitWorktree) setupFromExistingBranch() error {
+func (g *GitWorktree) setupFromExistingBranch(ctx context.Context) error {
 	// Directory already created in Setup(), skip duplicate creation
 
 	// Clean up any existing worktree first
 	_, _ = g.runGitCommand(g.repoPath, "worktree", "remove", "-f", g.worktreePath) // Ignore error if worktree doesn't exist
 
 	// Create a new worktree from the existing branch
-	if _, err := g.runGitCommand(g.repoPath, "worktree", "add", g.worktreePath, g.branchName); err != nil {
+	if _, err := g.runGitCommandContext(ctx, g.repoPath, nil, "worktree", "add", g.worktreePath, g.branchName); err != nil {
 		return fmt.Errorf("failed to create worktree from branch %s: %w", g.branchName, err)
 	}
 
 	return nil
 }
 
-// setupNewWorktree creates a new worktree from HEAD
-func (g *GitWorktree) setupNewWorktree() error {
+// setupNewWorktree creates a new worktree from HEAD, or from startCommit if set
+func (g *GitWorktree) setupNewWorktree(ctx context.Context) error {
 	// Ensure worktrees directory exists
 	worktreesDir := filepath.Join(g.repoPath, "worktrees")
 	if err := os.MkdirAll(worktreesDir, 0755); err != nil {
@@ -102,7 +114,11 @@ func (g *GitWorktree) setupNewWorktree() error {
 		return fmt.Errorf("failed to cleanup existing branch: %w", err)
 	}
 
-	output, err := g.runGitCommand(g.repoPath, "rev-parse", "HEAD")
+	startPoint := "HEAD"
+	if g.startCommit != "" {
+		startPoint = g.startCommit
+	}
+	output, err := g.runGitCommand(g.repoPath, "rev-parse", startPoint)
 	if err != nil {
 		if strings.Contains(err.Error(), "fatal: ambiguous argument 'HEAD'") ||
 			strings.Contains(err.Error(), "fatal: not a valid object name") ||
@@ -118,15 +134,42 @@ func (g *GitWorktree) setupNewWorktree() error {
 	// Otherwise, we'll inherit uncommitted changes from the previous worktree.
 	// This way, we can start the worktree with a clean slate.
 	// TODO: we might want to give an option to use main/master instead of the current branch.
-	if _, err := g.runGitCommand(g.repoPath, "worktree", "add", "-b", g.branchName, g.worktreePath, headCommit); err != nil {
+	if _, err := g.runGitCommandContext(ctx, g.repoPath, nil, "worktree", "add", "-b", g.branchName, g.worktreePath, headCommit); err != nil {
 		return fmt.Errorf("failed to create worktree from commit %s: %w", headCommit, err)
 	}
 
 	return nil
 }
 
-// Cleanup removes the worktree and associated branch
+// Cleanup removes the worktree and associated branch. Branches that existed before the session
+// belong to the user and are kept.
 func (g *GitWorktree) Cleanup() error {
+	return g.cleanup(true)
+}
+
+// Discard is Cleanup for a session that failed to start. Its branch holds no work, so it is
+// deleted without a kill backup, which would otherwise replace the backup of the last session
+// that was really killed.
+func (g *GitWorktree) Discard() error {
+	return g.cleanup(false)
+}
+
+func (g *GitWorktree) cleanup(backup bool) error {
+	var errs []error
+	if err := g.CleanupWorktree(); err != nil {
+		errs = append(errs, err)
+	}
+	if !g.externalBranch {
+		if err := g.deleteBranch(backup); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return g.combineErrors(errs)
+}
+
+// CleanupWorktree removes the worktree, if it still exists, and prunes its administrative files,
+// keeping the branch.
+func (g *GitWorktree) CleanupWorktree() error {
 	var errs []error
 
 	// Check if worktree path exists before attempting removal
@@ -140,31 +183,59 @@ func (g *GitWorktree) Cleanup() error {
 		errs = append(errs, fmt.Errorf("failed to check worktree path: %w", err))
 	}
 
-	// Open the repository for branch cleanup
-	repo, err := git.PlainOpen(g.repoPath)
-	if err != nil {
-		errs = append(errs, fmt.Errorf("failed to open repository for cleanup: %w", err))
+	// Prune the worktree to clean up any remaining references
+	if err := g.Prune(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
 		return g.combineErrors(errs)
 	}
 
-	branchRef := plumbing.NewBranchReferenceName(g.branchName)
+	g.InvalidateDiffCache()
+	return nil
+}
 
-	// Check if branch exists before attempting removal
-	if _, err := repo.Reference(branchRef, false); err == nil {
-		if err := repo.Storer.RemoveReference(branchRef); err != nil {
-			errs = append(errs, fmt.Errorf("failed to remove branch %s: %w", g.branchName, err))
-		}
-	} else if err != plumbing.ErrReferenceNotFound {
-		errs = append(errs, fmt.Errorf("error checking branch %s existence: %w", g.branchName, err))
+// DeleteBranch deletes the session's branch. It refuses while the branch is checked out in the
+// repository or any worktree, so remove the session's worktree first. The branch tip is kept
+// under a backup ref so RestoreKilledBranch can bring it back. A missing branch is not an error.
+func (g *GitWorktree) DeleteBranch() error {
+	return g.deleteBranch(true)
+}
+
+// deleteBranch deletes the session's branch, first backing it up for RestoreKilledBranch if
+// backup is set.
+func (g *GitWorktree) deleteBranch(backup bool) error {
+	checkedOutAt, err := g.branchWorktreePath()
+	if err != nil {
+		return err
+	}
+	if checkedOutAt != "" {
+		return fmt.Errorf("cannot delete branch %s: it is checked out at %s", g.branchName, checkedOutAt)
 	}
 
-	// Prune the worktree to clean up any remaining references
-	if err := g.Prune(); err != nil {
-		errs = append(errs, err)
+	repo, err := git.PlainOpen(g.repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository for cleanup: %w", err)
 	}
 
-	if len(errs) > 0 {
-		return g.combineErrors(errs)
+	branchRef := plumbing.NewBranchReferenceName(g.branchName)
+	ref, err := repo.Reference(branchRef, false)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error checking branch %s existence: %w", g.branchName, err)
+	}
+
+	// Keep the commits reachable so a kill can be undone. A failed backup should not prevent
+	// the deletion.
+	if backup {
+		if err := backupKilledBranch(repo, ref); err != nil {
+			log.WarningLog.Printf("could not back up branch %s: %v", g.branchName, err)
+		}
+	}
+	if err := repo.Storer.RemoveReference(branchRef); err != nil {
+		return fmt.Errorf("failed to remove branch %s: %w", g.branchName, err)
 	}
 
 	g.InvalidateDiffCache()
@@ -182,6 +253,37 @@ func (g *GitWorktree) Remove() error {
 	return nil
 }
 
+// DiskUsage returns the total size in bytes of the files in the worktree. The .git entry is
+// skipped: in a linked worktree it only points at the repository's object store, which is shared
+// by every worktree and would otherwise be counted once per session.
+func (g *GitWorktree) DiskUsage() (int64, error) {
+	var total int64
+	err := filepath.WalkDir(g.worktreePath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Name() == ".git" && path != g.worktreePath {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure worktree %s: %w", g.worktreePath, err)
+	}
+	return total, nil
+}
+
 // Prune removes all working tree administrative files and directories
 func (g *GitWorktree) Prune() error {
 	if _, err := g.runGitCommand(g.repoPath, "worktree", "prune"); err != nil {
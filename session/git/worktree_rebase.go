@@ -0,0 +1,195 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConflictError is returned when a rebase or merge stops on conflicts. The operation is left in
+// progress so the conflicts can be resolved from the attached session.
+type ConflictError struct {
+	// Operation is the git operation that stopped, e.g. "rebase".
+	Operation string
+	// Files are the paths with unresolved conflicts.
+	Files []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s conflicts in %s - attach to resolve", e.Operation, strings.Join(e.Files, ", "))
+}
+
+// ConflictedFiles returns the paths with unresolved conflicts in the worktree.
+func (g *GitWorktree) ConflictedFiles() ([]string, error) {
+	output, err := g.runGitCommand(g.worktreePath, "diff", "--name-only", "--diff-filter=U")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conflicted files: %w", err)
+	}
+	var files []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// conflictErrorFor returns a *ConflictError if the worktree has unresolved conflicts after op
+// failed, or nil if the failure was something else.
+func (g *GitWorktree) conflictErrorFor(op string) error {
+	files, err := g.ConflictedFiles()
+	if err != nil || len(files) == 0 {
+		return nil
+	}
+	return &ConflictError{Operation: op, Files: files}
+}
+
+// RebaseOnto rebases the worktree's branch onto ref. If the rebase stops on conflicts a
+// *ConflictError is returned and the rebase is left in progress; use RebaseContinue or
+// RebaseAbort once resolved. On success the base commit is moved to ref so the diff only shows
+// the session's own work.
+func (g *GitWorktree) RebaseOnto(ref string) error {
+	dirty, err := g.IsDirty()
+	if err != nil {
+		return fmt.Errorf("failed to check for changes: %w", err)
+	}
+	if dirty {
+		return fmt.Errorf("cannot rebase: worktree has uncommitted changes, commit or stash them first")
+	}
+
+	output, err := g.runGitCommand(g.worktreePath, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	target := strings.TrimSpace(output)
+
+	_, err = g.runGitCommand(g.worktreePath, "rebase", target)
+	g.InvalidateDiffCache()
+	if err != nil {
+		if conflict := g.conflictErrorFor("rebase"); conflict != nil {
+			g.pendingRebaseBase = target
+			g.pendingRebaseBranch = ref
+			return conflict
+		}
+		return fmt.Errorf("failed to rebase onto %s: %w", ref, err)
+	}
+
+	g.moveBase(target, ref)
+	return nil
+}
+
+// RebaseContinue continues an in-progress rebase after conflicts were resolved.
+func (g *GitWorktree) RebaseContinue() error {
+	// Avoid opening an editor for the commit message of the resolved commit.
+	_, err := g.runGitCommand(g.worktreePath, "-c", "core.editor=true", "rebase", "--continue")
+	g.InvalidateDiffCache()
+	if err != nil {
+		if conflict := g.conflictErrorFor("rebase"); conflict != nil {
+			return conflict
+		}
+		return fmt.Errorf("failed to continue rebase: %w", err)
+	}
+
+	if g.pendingRebaseBase != "" {
+		g.moveBase(g.pendingRebaseBase, g.pendingRebaseBranch)
+		g.pendingRebaseBase = ""
+		g.pendingRebaseBranch = ""
+	}
+	return nil
+}
+
+// moveBase records that the branch now sits on commit from branch after a rebase. The base is
+// written under diffMu since DiffWithOptions reads it there, and the cached diff is dropped.
+func (g *GitWorktree) moveBase(commit, branch string) {
+	g.diffMu.Lock()
+	g.baseCommitSHA = commit
+	g.baseBranch = branch
+	g.diffMu.Unlock()
+	g.InvalidateDiffCache()
+}
+
+// RebaseAbort aborts an in-progress rebase, restoring the branch to its state before RebaseOnto.
+func (g *GitWorktree) RebaseAbort() error {
+	_, err := g.runGitCommand(g.worktreePath, "rebase", "--abort")
+	g.InvalidateDiffCache()
+	g.pendingRebaseBase = ""
+	g.pendingRebaseBranch = ""
+	if err != nil {
+		return fmt.Errorf("failed to abort rebase: %w", err)
+	}
+	return nil
+}
+
+// MergeOptions control how Merge combines another branch into the worktree.
+type MergeOptions struct {
+	// NoFastForward always creates a merge commit, even if the branch could be fast-forwarded.
+	NoFastForward bool
+}
+
+// Merge merges branch into the worktree's branch, using message for the merge commit. See
+// MergeWithOptions.
+func (g *GitWorktree) Merge(branch string, message string) error {
+	return g.MergeWithOptions(branch, message, MergeOptions{})
+}
+
+// MergeWithOptions merges branch into the worktree's branch. A clean merge, fast-forward or not,
+// returns nil. If the merge stops on conflicts a *ConflictError is returned and the merge is left
+// in progress; use MergeContinue or MergeAbort once resolved. An empty message keeps git's
+// default merge commit message.
+func (g *GitWorktree) MergeWithOptions(branch string, message string, opts MergeOptions) error {
+	dirty, err := g.IsDirty()
+	if err != nil {
+		return fmt.Errorf("failed to check for changes: %w", err)
+	}
+	if dirty {
+		return fmt.Errorf("cannot merge: worktree has uncommitted changes, commit or stash them first")
+	}
+
+	if _, err := g.runGitCommand(g.worktreePath, "rev-parse", "--verify", branch+"^{commit}"); err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", branch, err)
+	}
+
+	args := []string{"merge"}
+	if opts.NoFastForward {
+		args = append(args, "--no-ff")
+	}
+	if message != "" {
+		args = append(args, "-m", message)
+	} else {
+		args = append(args, "--no-edit")
+	}
+	args = append(args, branch)
+
+	_, err = g.runGitCommand(g.worktreePath, args...)
+	g.InvalidateDiffCache()
+	if err != nil {
+		if conflict := g.conflictErrorFor("merge"); conflict != nil {
+			return conflict
+		}
+		return fmt.Errorf("failed to merge %s: %w", branch, err)
+	}
+	return nil
+}
+
+// MergeContinue concludes an in-progress merge after conflicts were resolved and staged.
+func (g *GitWorktree) MergeContinue() error {
+	// Avoid opening an editor for the merge commit message.
+	_, err := g.runGitCommand(g.worktreePath, "-c", "core.editor=true", "merge", "--continue")
+	g.InvalidateDiffCache()
+	if err != nil {
+		if conflict := g.conflictErrorFor("merge"); conflict != nil {
+			return conflict
+		}
+		return fmt.Errorf("failed to continue merge: %w", err)
+	}
+	return nil
+}
+
+// MergeAbort aborts an in-progress merge, restoring the branch to its state before the merge.
+func (g *GitWorktree) MergeAbort() error {
+	_, err := g.runGitCommand(g.worktreePath, "merge", "--abort")
+	g.InvalidateDiffCache()
+	if err != nil {
+		return fmt.Errorf("failed to abort merge: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupDivergedRepo creates a repo where main and a "session" branch both changed file.txt,
+// and returns a worktree for the session branch whose base is the original commit.
+func setupDivergedRepo(t *testing.T, sessionContent, mainContent string) *GitWorktree {
+	t.Helper()
+	repo := setupTempRepo(t)
+	base := strings.TrimSpace(runGit(t, repo, "rev-parse", "HEAD"))
+
+	runGit(t, repo, "checkout", "-b", "session")
+	writeAndCommit(t, repo, "file.txt", sessionContent, "session change")
+
+	runGit(t, repo, "checkout", "main")
+	writeAndCommit(t, repo, "other.txt", "upstream\n", "upstream change")
+	if mainContent != "" {
+		writeAndCommit(t, repo, "file.txt", mainContent, "conflicting upstream change")
+	}
+	runGit(t, repo, "checkout", "session")
+
+	return &GitWorktree{
+		repoPath:      repo,
+		worktreePath:  repo,
+		branchName:    "session",
+		baseCommitSHA: base,
+	}
+}
+
+func writeAndCommit(t *testing.T, repo, name, content, message string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	runGit(t, repo, "add", name)
+	runGit(t, repo, "commit", "-m", message)
+}
+
+func TestRebaseOntoCleanMovesBase(t *testing.T) {
+	wt := setupDivergedRepo(t, "hello world\nsession\n", "")
+	mainHead := strings.TrimSpace(runGit(t, wt.repoPath, "rev-parse", "main"))
+
+	if err := wt.RebaseOnto("main"); err != nil {
+		t.Fatalf("RebaseOnto: %v", err)
+	}
+	if wt.GetBaseCommitSHA() != mainHead {
+		t.Fatalf("expected base to move to %s, got %s", mainHead, wt.GetBaseCommitSHA())
+	}
+
+	stats := wt.Diff(true)
+	if stats.Error != nil {
+		t.Fatalf("Diff: %v", stats.Error)
+	}
+	if strings.Contains(stats.Content, "other.txt") {
+		t.Fatal("expected upstream changes to be excluded from the diff after rebase")
+	}
+}
+
+func TestRebaseOntoConflict(t *testing.T) {
+	wt := setupDivergedRepo(t, "session version\n", "main version\n")
+	originalBase := wt.GetBaseCommitSHA()
+
+	err := wt.RebaseOnto("main")
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected ConflictError, got %v", err)
+	}
+	if conflict.Operation != "rebase" || len(conflict.Files) != 1 || conflict.Files[0] != "file.txt" {
+		t.Fatalf("unexpected conflict details %+v", conflict)
+	}
+	if wt.GetBaseCommitSHA() != originalBase {
+		t.Fatal("base should not move while the rebase is in progress")
+	}
+
+	if err := wt.RebaseAbort(); err != nil {
+		t.Fatalf("RebaseAbort: %v", err)
+	}
+	files, err := wt.ConflictedFiles()
+	if err != nil || len(files) != 0 {
+		t.Fatalf("expected no conflicts after abort, got %v (%v)", files, err)
+	}
+
+	if err := wt.RebaseOnto("main"); !errors.As(err, &conflict) {
+		t.Fatalf("expected ConflictError on retry, got %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(wt.repoPath, "file.txt"), []byte("resolved\n"), 0o644); err != nil {
+		t.Fatalf("resolve conflict: %v", err)
+	}
+	runGit(t, wt.repoPath, "add", "file.txt")
+
+	if err := wt.RebaseContinue(); err != nil {
+		t.Fatalf("RebaseContinue: %v", err)
+	}
+	mainHead := strings.TrimSpace(runGit(t, wt.repoPath, "rev-parse", "main"))
+	if wt.GetBaseCommitSHA() != mainHead {
+		t.Fatalf("expected base to move to %s after continue, got %s", mainHead, wt.GetBaseCommitSHA())
+	}
+}
+
+func TestMergeFastForward(t *testing.T) {
+	wt := setupDivergedRepo(t, "hello world\nsession\n", "")
+	runGit(t, wt.repoPath, "checkout", "-b", "ahead")
+	writeAndCommit(t, wt.repoPath, "ahead.txt", "ahead\n", "ahead change")
+	aheadHead := strings.TrimSpace(runGit(t, wt.repoPath, "rev-parse", "HEAD"))
+	runGit(t, wt.repoPath, "checkout", "session")
+
+	if err := wt.Merge("ahead", ""); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if head := strings.TrimSpace(runGit(t, wt.repoPath, "rev-parse", "HEAD")); head != aheadHead {
+		t.Fatalf("expected a fast-forward to %s, got %s", aheadHead, head)
+	}
+}
+
+func TestMergeNoFastForward(t *testing.T) {
+	wt := setupDivergedRepo(t, "hello world\nsession\n", "")
+	runGit(t, wt.repoPath, "checkout", "-b", "ahead")
+	writeAndCommit(t, wt.repoPath, "ahead.txt", "ahead\n", "ahead change")
+	runGit(t, wt.repoPath, "checkout", "session")
+
+	if err := wt.MergeWithOptions("ahead", "combine ahead", MergeOptions{NoFastForward: true}); err != nil {
+		t.Fatalf("MergeWithOptions: %v", err)
+	}
+	parents := strings.Fields(runGit(t, wt.repoPath, "log", "-1", "--format=%P"))
+	if len(parents) != 2 {
+		t.Fatalf("expected a merge commit, got parents %v", parents)
+	}
+	if subject := strings.TrimSpace(runGit(t, wt.repoPath, "log", "-1", "--format=%s")); subject != "combine ahead" {
+		t.Fatalf("unexpected merge commit message %q", subject)
+	}
+}
+
+func TestMergeConflict(t *testing.T) {
+	wt := setupDivergedRepo(t, "session version\n", "main version\n")
+
+	err := wt.Merge("main", "")
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected ConflictError, got %v", err)
+	}
+	if conflict.Operation != "merge" || len(conflict.Files) != 1 || conflict.Files[0] != "file.txt" {
+		t.Fatalf("unexpected conflict details %+v", conflict)
+	}
+
+	if err := wt.MergeAbort(); err != nil {
+		t.Fatalf("MergeAbort: %v", err)
+	}
+	if files, err := wt.ConflictedFiles(); err != nil || len(files) != 0 {
+		t.Fatalf("expected no conflicts after abort, got %v (%v)", files, err)
+	}
+
+	if err := wt.Merge("main", "resolve main"); !errors.As(err, &conflict) {
+		t.Fatalf("expected ConflictError on retry, got %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(wt.repoPath, "file.txt"), []byte("resolved\n"), 0o644); err != nil {
+		t.Fatalf("resolve conflict: %v", err)
+	}
+	runGit(t, wt.repoPath, "add", "file.txt")
+	if err := wt.MergeContinue(); err != nil {
+		t.Fatalf("MergeContinue: %v", err)
+	}
+	if parents := strings.Fields(runGit(t, wt.repoPath, "log", "-1", "--format=%P")); len(parents) != 2 {
+		t.Fatalf("expected a merge commit after continue, got parents %v", parents)
+	}
+}
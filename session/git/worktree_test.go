@@ -4,7 +4,9 @@ import (
 	"agent-squad/config"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	gogit "github.com/go-git/go-git/v5"
 	"github.com/stretchr/testify/assert"
@@ -69,3 +71,220 @@ func TestNewGitWorktreeBranchNameHandling(t *testing.T) {
 		assert.Equal(t, branchName, worktree.GetBranchName())
 	})
 }
+
+func TestNewBranchNameTemplate(t *testing.T) {
+	now := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+	user := SanitizeBranchName(currentUsername())
+
+	cfg := &config.Config{BranchPrefix: "tester/", BranchTemplate: "agent/{user}/{date}/{title}"}
+	assert.Equal(t, "agent/"+user+"/2024-03-09/fix-login-bug", newBranchName(cfg, "Fix Login Bug", now))
+
+	cfg.BranchTemplate = "{prefix}{title}"
+	assert.Equal(t, "tester/fix-login", newBranchName(cfg, "Fix Login", now))
+	// The existing sanitization and empty fallback still apply to the rendered name.
+	assert.Equal(t, "tester", newBranchName(cfg, "/🔥", now))
+
+	cfg.BranchTemplate = "agent/{title}/{date}"
+	assert.Equal(t, "agent/2024-03-09", newBranchName(cfg, "🔥", now))
+
+	cfg.BranchTemplate = "{title}"
+	assert.Equal(t, "", newBranchName(cfg, "🔥", now))
+
+	cfg.BranchTemplate = ""
+	assert.Equal(t, "tester/fix-login", newBranchName(cfg, "Fix Login", now))
+}
+
+func TestNewGitWorktreeFromBranch(t *testing.T) {
+	tempHome := setupTestHomeConfig(t, "tester/")
+	repo := setupTempRepo(t)
+	base := strings.TrimSpace(runGit(t, repo, "rev-parse", "HEAD"))
+
+	runGit(t, repo, "branch", "feature")
+	runGit(t, repo, "worktree", "add", filepath.Join(tempHome, "scratch"), "feature")
+	writeAndCommit(t, filepath.Join(tempHome, "scratch"), "feature.txt", "feature\n", "feature work")
+	runGit(t, repo, "worktree", "remove", filepath.Join(tempHome, "scratch"))
+
+	t.Run("rejects missing branch", func(t *testing.T) {
+		_, err := NewGitWorktreeFromBranch(repo, "session", "does-not-exist")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "does not exist")
+	})
+
+	t.Run("rejects branch checked out elsewhere", func(t *testing.T) {
+		_, err := NewGitWorktreeFromBranch(repo, "session", "main")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "already checked out")
+	})
+
+	t.Run("checks out existing branch with merge-base as base", func(t *testing.T) {
+		worktree, err := NewGitWorktreeFromBranch(repo, "session", "feature")
+		require.NoError(t, err)
+		assert.Equal(t, "feature", worktree.GetBranchName())
+		assert.Equal(t, base, worktree.GetBaseCommitSHA())
+		assert.Equal(t, "main", worktree.GetBaseBranch())
+		assert.True(t, worktree.IsExternalBranch())
+
+		require.NoError(t, worktree.Setup())
+		_, err = os.Stat(filepath.Join(worktree.GetWorktreePath(), "feature.txt"))
+		require.NoError(t, err)
+
+		require.NoError(t, worktree.Cleanup())
+		runGit(t, repo, "rev-parse", "--verify", "refs/heads/feature")
+	})
+}
+
+func TestNewGitWorktreeFromBranchNonDefaultMain(t *testing.T) {
+	setupTestHomeConfig(t, "tester/")
+	repo := t.TempDir()
+	runGit(t, repo, "init", "--initial-branch=trunk")
+	runGit(t, repo, "config", "user.email", "test@example.com")
+	runGit(t, repo, "config", "user.name", "Test User")
+	writeAndCommit(t, repo, "file.txt", "hello\n", "initial commit")
+	base := strings.TrimSpace(runGit(t, repo, "rev-parse", "HEAD"))
+
+	runGit(t, repo, "checkout", "-q", "-b", "feature")
+	writeAndCommit(t, repo, "feature.txt", "feature\n", "feature work")
+	runGit(t, repo, "checkout", "-q", "trunk")
+	writeAndCommit(t, repo, "trunk.txt", "trunk\n", "trunk work")
+
+	defaultBranch, err := DefaultBranch(repo)
+	require.NoError(t, err)
+	assert.Equal(t, "trunk", defaultBranch)
+
+	worktree, err := NewGitWorktreeFromBranch(repo, "session", "feature")
+	require.NoError(t, err)
+	assert.Equal(t, base, worktree.GetBaseCommitSHA())
+	assert.Equal(t, "trunk", worktree.GetBaseBranch())
+}
+
+func TestGitWorktreeBaseBranch(t *testing.T) {
+	setupTestHomeConfig(t, "tester/")
+	repo := setupTempRepo(t)
+	runGit(t, repo, "checkout", "-q", "-b", "develop")
+	writeAndCommit(t, repo, "develop.txt", "develop\n", "develop work")
+
+	worktree, _, err := NewGitWorktree(repo, "from-develop")
+	require.NoError(t, err)
+	assert.Equal(t, "develop", worktree.GetBaseBranch())
+	require.NoError(t, worktree.Setup())
+	t.Cleanup(func() { _ = worktree.Cleanup() })
+
+	t.Run("resolves a missing base branch from the base commit", func(t *testing.T) {
+		// Sessions saved before the base branch was recorded only know the base commit.
+		loaded := NewGitWorktreeFromStorage(repo, worktree.GetWorktreePath(), "from-develop",
+			worktree.GetBranchName(), worktree.GetBaseCommitSHA())
+		assert.Equal(t, "develop", loaded.GetBaseBranch())
+
+		// The initial commit is on both branches, so the default branch wins.
+		initial := strings.TrimSpace(runGit(t, repo, "rev-list", "--max-parents=0", "HEAD"))
+		loaded = NewGitWorktreeFromStorage(repo, worktree.GetWorktreePath(), "from-develop",
+			worktree.GetBranchName(), initial)
+		assert.Equal(t, "main", loaded.GetBaseBranch())
+	})
+
+	t.Run("keeps a recorded base branch", func(t *testing.T) {
+		loaded := NewGitWorktreeFromStorage(repo, worktree.GetWorktreePath(), "from-develop",
+			worktree.GetBranchName(), worktree.GetBaseCommitSHA())
+		loaded.SetBaseBranch("release")
+		assert.Equal(t, "release", loaded.GetBaseBranch())
+	})
+}
+
+func TestNewGitWorktreeFromCommitForksWork(t *testing.T) {
+	setupTestHomeConfig(t, "tester/")
+	repo := setupTempRepo(t)
+
+	source, _, err := NewGitWorktree(repo, "source")
+	require.NoError(t, err)
+	require.NoError(t, source.Setup())
+	t.Cleanup(func() { _ = source.Cleanup() })
+	writeAndCommit(t, source.GetWorktreePath(), "committed.txt", "committed\n", "source work")
+	require.NoError(t, os.WriteFile(filepath.Join(source.GetWorktreePath(), "file.txt"), []byte("edited\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(source.GetWorktreePath(), "untracked.txt"), []byte("new\n"), 0o644))
+
+	head, err := source.HeadCommit()
+	require.NoError(t, err)
+	patch, err := source.WorkInProgressPatch()
+	require.NoError(t, err)
+
+	fork, branchName, err := NewGitWorktreeFromCommit(repo, "fork", head)
+	require.NoError(t, err)
+	assert.Equal(t, "tester/fork", branchName)
+	require.NoError(t, fork.Setup())
+	t.Cleanup(func() { _ = fork.Cleanup() })
+	require.NoError(t, fork.ApplyPatch(patch))
+
+	assert.Equal(t, head, fork.GetBaseCommitSHA())
+	for name, want := range map[string]string{"committed.txt": "committed\n", "file.txt": "edited\n", "untracked.txt": "new\n"} {
+		got, err := os.ReadFile(filepath.Join(fork.GetWorktreePath(), name))
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got), name)
+	}
+
+	_, _, err = NewGitWorktreeFromCommit(repo, "fork", head)
+	assert.ErrorContains(t, err, "already exists")
+}
+
+func TestDiskUsageSkipsSharedGitDir(t *testing.T) {
+	setupTestHomeConfig(t, "tester/")
+	repo := setupTempRepo(t)
+
+	worktree, _, err := NewGitWorktree(repo, "usage")
+	require.NoError(t, err)
+	require.NoError(t, worktree.Setup())
+	t.Cleanup(func() { _ = worktree.Cleanup() })
+
+	require.NoError(t, os.MkdirAll(filepath.Join(worktree.GetWorktreePath(), "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(worktree.GetWorktreePath(), "sub", "data.bin"), make([]byte, 1000), 0o644))
+
+	usage, err := worktree.DiskUsage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("hello world\n")+1000), usage)
+}
+
+func TestNewWorktreePathTemplate(t *testing.T) {
+	tempHome := setupTestHomeConfig(t, "tester/")
+	cfg := &config.Config{WorktreeDirTemplate: "{repo}-{branch}"}
+
+	path, err := newWorktreePath(cfg, "/src/my-app", "Fix Login", "tester/fix-login")
+	require.NoError(t, err)
+	worktreesDir := filepath.Join(tempHome, ".agent-squad", "worktrees")
+	assert.Equal(t, filepath.Join(worktreesDir, "my-app-tester-fix-login"), path)
+
+	require.NoError(t, os.MkdirAll(path, 0o755))
+	path, err = newWorktreePath(cfg, "/src/my-app", "Fix Login", "tester/fix-login")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(worktreesDir, "my-app-tester-fix-login-2"), path)
+
+	_, err = newWorktreePath(&config.Config{WorktreeDirTemplate: "{title}"}, "/src/my-app", "...", "x")
+	assert.Error(t, err)
+
+	path, err = newWorktreePath(&config.Config{}, "/src/my-app", "Fix Login", "tester/fix-login")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(filepath.Base(path), "fix-login_"), "default naming should be unchanged, got %s", path)
+}
+
+func TestNewWorktreePathWorktreeDir(t *testing.T) {
+	tempHome := setupTestHomeConfig(t, "tester/")
+	base := t.TempDir()
+
+	cfg := &config.Config{WorktreeDir: filepath.Join(base, "{repo}", "{title}")}
+	path, err := newWorktreePath(cfg, "/src/my-app", "Fix Login", "tester/fix-login")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(base, "my-app", "fix-login"), filepath.Dir(path))
+	assert.DirExists(t, filepath.Dir(path))
+
+	path, err = newWorktreePath(&config.Config{WorktreeDir: "~/wt"}, "/src/my-app", "Fix Login", "tester/fix-login")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(tempHome, "wt"), filepath.Dir(path))
+
+	_, err = newWorktreePath(&config.Config{WorktreeDir: "relative/dir"}, "/src/my-app", "x", "x")
+	assert.ErrorContains(t, err, "absolute")
+
+	readOnly := filepath.Join(base, "read-only")
+	require.NoError(t, os.MkdirAll(readOnly, 0o555))
+	if os.Getuid() != 0 {
+		_, err = newWorktreePath(&config.Config{WorktreeDir: readOnly}, "/src/my-app", "x", "x")
+		assert.ErrorContains(t, err, "not writable")
+	}
+}
@@ -0,0 +1,36 @@
+package session
+
+import (
+	"fmt"
+	"io"
+)
+
+// DumpOptions configures DumpHistoryWithOptions.
+type DumpOptions struct {
+	// StripANSI removes terminal escape sequences such as colors from the output.
+	StripANSI bool
+}
+
+// DumpHistory writes the instance's full pane history, including scrollback, to w with terminal
+// escape sequences kept. It is meant for non-interactive uses such as CI logs.
+func (i *Instance) DumpHistory(w io.Writer) error {
+	return i.DumpHistoryWithOptions(w, DumpOptions{})
+}
+
+// DumpHistoryWithOptions is like DumpHistory but configurable.
+func (i *Instance) DumpHistoryWithOptions(w io.Writer, opts DumpOptions) error {
+	if !i.started || i.Paused() {
+		return fmt.Errorf("cannot dump history of instance that has not been started or is paused")
+	}
+	history, err := i.PreviewFullHistory()
+	if err != nil {
+		return err
+	}
+	if opts.StripANSI {
+		history = ansiEscapeRegex.ReplaceAllString(history, "")
+	}
+	if _, err := io.WriteString(w, history); err != nil {
+		return fmt.Errorf("failed to write history of %s: %w", i.Title, err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package session
+
+import (
+	"agent-squad/session/tmux"
+	"agent-squad/session/tmux/tmuxtest"
+	"strings"
+	"testing"
+)
+
+func TestDumpHistory(t *testing.T) {
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	name := tmux.TmuxPrefix + "dump"
+	server.AddSession(name, t.TempDir(), "bash")
+	server.SetHistory(name, "\x1b[32mearlier\x1b[0m\n")
+	server.SetPaneContent(name, "now\n")
+	tmuxSession := tmuxtest.NewSession(server, "dump", "bash")
+	if err := tmuxSession.Restore(); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	inst := &Instance{Title: "dump", started: true, Status: Running, tmuxSession: tmuxSession}
+
+	var out strings.Builder
+	if err := inst.DumpHistory(&out); err != nil {
+		t.Fatalf("DumpHistory: %v", err)
+	}
+	if out.String() != "\x1b[32mearlier\x1b[0m\nnow\n" {
+		t.Fatalf("unexpected history %q", out.String())
+	}
+
+	out.Reset()
+	if err := inst.DumpHistoryWithOptions(&out, DumpOptions{StripANSI: true}); err != nil {
+		t.Fatalf("DumpHistoryWithOptions: %v", err)
+	}
+	if out.String() != "earlier\nnow\n" {
+		t.Fatalf("unexpected stripped history %q", out.String())
+	}
+
+	inst.Status = Paused
+	if err := inst.DumpHistory(&out); err == nil || !strings.Contains(err.Error(), "paused") {
+		t.Fatalf("expected an error for a paused instance, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package session
+
+import (
+	"agent-squad/log"
+	"context"
+	"os/exec"
+	"strings"
+)
+
+// statusHookPrefix prefixes hook keys that match the status an instance changed to.
+const statusHookPrefix = "status:"
+
+// RunHooks runs the configured hook commands for every event published on bus until ctx is
+// done. hooks maps event names to command templates, as described for config.Config.Hooks.
+// Commands run asynchronously; failures are logged and never block the publisher.
+func RunHooks(ctx context.Context, bus *EventBus, hooks map[string]string) {
+	if len(hooks) == 0 {
+		return
+	}
+	events, unsubscribe := bus.Subscribe()
+	go func() {
+		defer unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-events:
+				for _, command := range hookCommands(hooks, event) {
+					go runHook(command, event)
+				}
+			}
+		}
+	}()
+}
+
+// hookCommands returns the commands to run for event, with placeholders substituted.
+func hookCommands(hooks map[string]string, event Event) []string {
+	keys := []string{string(event.Type)}
+	if event.Type == EventStatusChanged {
+		keys = append(keys, statusHookPrefix+event.Status.String())
+	}
+
+	replacer := strings.NewReplacer(
+		"{title}", shellQuote(event.Title),
+		"{branch}", shellQuote(event.Branch),
+		"{status}", shellQuote(event.Status.String()),
+	)
+	var commands []string
+	for _, key := range keys {
+		if template, ok := hooks[key]; ok && template != "" {
+			commands = append(commands, replacer.Replace(template))
+		}
+	}
+	return commands
+}
+
+func runHook(command string, event Event) {
+	output, err := exec.Command("sh", "-c", command).CombinedOutput()
+	if err != nil {
+		log.ForInstance(event.Title).Warning.Printf("hook for %s event of instance %s failed: %v: %s",
+			event.Type, event.Title, err, strings.TrimSpace(string(output)))
+	}
+}
+
+// shellQuote quotes s as a single shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
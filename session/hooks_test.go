@@ -0,0 +1,56 @@
+package session
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHookCommands(t *testing.T) {
+	hooks := map[string]string{
+		"status_changed": "echo {title} {status}",
+		"status:ready":   "notify {title} on {branch}",
+		"killed":         "echo gone",
+	}
+	event := Event{Title: "it's", Branch: "tester/its", Type: EventStatusChanged, Status: Ready}
+
+	got := hookCommands(hooks, event)
+	want := []string{`echo 'it'\''s' 'ready'`, `notify 'it'\''s' on 'tester/its'`}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	event.Status = Running
+	if got := hookCommands(hooks, event); len(got) != 1 {
+		t.Fatalf("expected only the status_changed hook for running, got %q", got)
+	}
+}
+
+func TestRunHooksExecutesCommands(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "hook.out")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := NewEventBus()
+	RunHooks(ctx, bus, map[string]string{
+		"status:ready": "echo {title} {status} > " + shellQuote(out),
+		"killed":       "exit 1",
+	})
+	bus.Publish(Event{Title: "hooked", Type: EventKilled})
+	bus.Publish(Event{Title: "hooked", Type: EventStatusChanged, Status: Ready})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := os.ReadFile(out)
+		if err == nil && strings.TrimSpace(string(data)) == "hooked ready" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("hook did not run: %q, %v", data, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
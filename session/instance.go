@@ -1,18 +1,26 @@
 package session
 
 import (
+	"agent-squad/config"
 	"agent-squad/log"
 	"agent-squad/session/git"
 	"agent-squad/session/tmux"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
 
 	"github.com/atotto/clipboard"
 	"github.com/fsnotify/fsnotify"
@@ -29,12 +37,59 @@ const (
 	Loading
 	// Paused is if the instance is paused (worktree removed but branch preserved).
 	Paused
+	// Crashed is if the program exited or never became ready while the instance was loading.
+	Crashed
+	// Detached is if the instance's worktree was deleted from under it while it was running.
+	// Recover recreates the worktree.
+	Detached
+	// Queued is if the instance is waiting for a free slot under max_concurrent_instances. Like a
+	// paused instance it has no worktree or program; Resume or a Scheduler starts it.
+	Queued
 )
 
+// String returns the lowercase name of the status, e.g. "ready".
+func (s Status) String() string {
+	switch s {
+	case Running:
+		return "running"
+	case Ready:
+		return "ready"
+	case Loading:
+		return "loading"
+	case Paused:
+		return "paused"
+	case Crashed:
+		return "crashed"
+	case Detached:
+		return "detached"
+	case Queued:
+		return "queued"
+	default:
+		return fmt.Sprintf("status(%d)", int(s))
+	}
+}
+
 const (
-	diffRefreshInterval = 5 * time.Second
+	// diffErrorBackoff is how long UpdateDiffStats waits before retrying after a failed diff. It
+	// doubles with each consecutive failure up to diffErrorBackoffMax.
+	diffErrorBackoff    = time.Second
+	diffErrorBackoffMax = 2 * time.Minute
+	// outputStreamInterval is how often StreamOutput polls the pane for new lines.
+	outputStreamInterval = 250 * time.Millisecond
+	// readyPollInterval is how often WaitUntilReady checks the pane.
+	readyPollInterval = 500 * time.Millisecond
 )
 
+// ErrInstanceCrashed is returned by WaitUntilReady when the instance's program crashed.
+var ErrInstanceCrashed = errors.New("instance crashed")
+
+// ErrReadOnly is returned when input is sent to a read-only instance.
+var ErrReadOnly = errors.New("instance is read-only")
+
+// PromptInterceptor is consulted before a prompt is sent to an instance. It may return a
+// rewritten prompt, or an error to block the prompt from being sent.
+type PromptInterceptor func(prompt string) (string, error)
+
 // Instance is a running instance of claude code.
 type Instance struct {
 	// Title is the title of the instance.
@@ -57,22 +112,56 @@ type Instance struct {
 	UpdatedAt time.Time
 	// AutoYes is true if the instance should automatically press enter when prompted.
 	AutoYes bool
-	// Prompt is the initial prompt to pass to the instance on startup
+	// ReadOnly is true if no input may be sent to the instance. Attaching shows the session
+	// without forwarding keys.
+	ReadOnly bool
+	// Labels tag the instance, e.g. for filtering.
+	Labels []string
+	// Priority orders the instance under SortByPriority; higher comes first.
+	Priority int
+	// WorkDir is the directory, relative to the worktree root, the program runs in. Diffs still
+	// cover the whole worktree; see DiffWorkDir.
+	WorkDir string
+	// Prompt is the initial prompt to pass to the instance on startup. It is only used for queued
+	// instances, which get it once they are promoted.
 	Prompt string
+	// pendingStart is set by Queue on an instance that was never started, so that promoting it
+	// runs the first-time Start rather than Resume.
+	pendingStart bool
 
 	// DiffStats stores the current git diff statistics
 	diffStats *git.DiffStats
 
+	// stateMu guards Status, Branch and diffStats, and the worktree's branch while CheckoutBranch
+	// changes it, so that Snapshot reads them from the same moment.
+	stateMu sync.RWMutex
+
 	diffDirty     atomic.Bool
 	diffMu        sync.Mutex
 	previewDirty  atomic.Bool
 	lastDiffCheck atomic.Int64
+	// lastActivity is the time, in unix nanoseconds, the pane last changed or a prompt was sent.
+	lastActivity atomic.Int64
+	// touched is set by Touch until the next HasUpdated reports it as an update.
+	touched atomic.Bool
+	// diffFailures counts consecutive failed diffs; no diff is attempted before diffRetryAt.
+	// Both are guarded by diffMu.
+	diffFailures int
+	diffRetryAt  time.Time
 
 	diffWatcher         *fsnotify.Watcher
 	diffWatcherDisabled bool
 	diffWatchCtx        context.Context
 	diffWatchCancel     context.CancelFunc
 	diffWatchWg         sync.WaitGroup
+	// diffWatchDirs counts the directories added to diffWatcher
+	diffWatchDirs int
+	// diffWatchPartial is set once diffWatcher stopped adding directories, either because
+	// maxWatchDirs was reached or the system ran out of watches. The periodic refresh covers
+	// the unwatched part of the worktree.
+	diffWatchPartial bool
+	// maxWatchDirs is the max_watch_dirs config. Zero means no limit.
+	maxWatchDirs int
 
 	// The below fields are initialized upon calling Start().
 
@@ -81,10 +170,53 @@ type Instance struct {
 	tmuxSession *tmux.TmuxSession
 	// gitWorktree is the git worktree for the instance.
 	gitWorktree *git.GitWorktree
+	// transcriptPath is the active transcript file for the instance, if transcript logging is enabled.
+	transcriptPath string
+	// transcript appends pane output to transcriptPath while the instance is running.
+	transcript *tmux.TranscriptWriter
+	// promptInterceptor, if set, can rewrite or block prompts in SendPrompt.
+	promptInterceptor PromptInterceptor
+	// existingBranch is the pre-existing branch to check out on first start instead of creating one.
+	existingBranch string
+	// branchTemplate, if set, names the branch created on first start.
+	branchTemplate string
+	// env holds extra environment variables for the program, as KEY=value.
+	env []string
+	// forkCommit is the commit a cloned instance's branch starts from on first start.
+	forkCommit string
+	// forkPatch is uncommitted work copied from the clone source, applied on first start.
+	forkPatch string
+	// diffExcludes are pathspec patterns left out of this instance's diff.
+	diffExcludes []string
+	// refreshInterval is how often diff stats are refreshed without a change notification. Zero
+	// means configRefreshInterval.
+	refreshInterval time.Duration
+	// configRefreshInterval is the diff_refresh_interval_ms config. Zero means the default.
+	configRefreshInterval time.Duration
+	// logPath, if set, is the file the pane output is piped to with tmux pipe-pane.
+	logPath string
+	// logMaxBytes is the size at which the piped log is rotated. Zero disables rotation.
+	logMaxBytes int64
+	// commitCount is the last known number of commits the session made on top of its base.
+	commitCount int
+	// readyPattern matches the pane once the program has finished starting up.
+	readyPattern *regexp.Regexp
+	// readyTimeout is how long the instance may stay Loading before it is marked Crashed. Zero
+	// waits forever.
+	readyTimeout time.Duration
+	// pastePrompts makes SendPrompt paste prompts instead of typing them.
+	pastePrompts bool
+	// loadingSince is when the instance entered the Loading status.
+	loadingSince time.Time
+	// events, if set, receives the instance's lifecycle events.
+	events *EventBus
 }
 
 // ToInstanceData converts an Instance to its serializable form
 func (i *Instance) ToInstanceData() InstanceData {
+	// Hold stateMu for the whole read so the status, branch and diff stats match each other.
+	i.stateMu.RLock()
+	defer i.stateMu.RUnlock()
 	data := InstanceData{
 		Title:     i.Title,
 		Path:      i.Path,
@@ -96,25 +228,39 @@ func (i *Instance) ToInstanceData() InstanceData {
 		UpdatedAt: time.Now(),
 		Program:   i.Program,
 		AutoYes:   i.AutoYes,
+		ReadOnly:  i.ReadOnly,
+
+		DiffExcludePatterns: slices.Clone(i.diffExcludes),
+		RefreshIntervalMs:   i.refreshInterval.Milliseconds(),
+		CommitCount:         i.commitCount,
+		Env:                 slices.Clone(i.env),
+		Labels:              slices.Clone(i.Labels),
+		Priority:            i.Priority,
+		WorkDir:             i.WorkDir,
+		PendingStart:        i.pendingStart,
+		Prompt:              i.Prompt,
 	}
 
 	// Only include worktree data if gitWorktree is initialized
 	if i.gitWorktree != nil {
 		data.Worktree = GitWorktreeData{
-			RepoPath:      i.gitWorktree.GetRepoPath(),
-			WorktreePath:  i.gitWorktree.GetWorktreePath(),
-			SessionName:   i.Title,
-			BranchName:    i.gitWorktree.GetBranchName(),
-			BaseCommitSHA: i.gitWorktree.GetBaseCommitSHA(),
+			RepoPath:       i.gitWorktree.GetRepoPath(),
+			WorktreePath:   i.gitWorktree.GetWorktreePath(),
+			SessionName:    i.Title,
+			BranchName:     i.gitWorktree.GetBranchName(),
+			BaseCommitSHA:  i.gitWorktree.GetBaseCommitSHA(),
+			ExternalBranch: i.gitWorktree.IsExternalBranch(),
+			BaseBranch:     i.gitWorktree.GetBaseBranch(),
 		}
 	}
 
 	// Only include diff stats if they exist
 	if i.diffStats != nil {
 		data.DiffStats = DiffStatsData{
-			Added:   i.diffStats.Added,
-			Removed: i.diffStats.Removed,
-			Content: i.diffStats.Content,
+			Added:        i.diffStats.Added,
+			Removed:      i.diffStats.Removed,
+			Content:      i.diffStats.Content,
+			FilesChanged: i.diffStats.FilesChanged,
 		}
 	}
 
@@ -133,6 +279,11 @@ func FromInstanceData(data InstanceData) (*Instance, error) {
 		CreatedAt: data.CreatedAt,
 		UpdatedAt: data.UpdatedAt,
 		Program:   data.Program,
+		ReadOnly:  data.ReadOnly,
+		AutoYes:   data.AutoYes,
+		Priority:  data.Priority,
+		WorkDir:   data.WorkDir,
+		Prompt:    data.Prompt,
 		gitWorktree: git.NewGitWorktreeFromStorage(
 			data.Worktree.RepoPath,
 			data.Worktree.WorktreePath,
@@ -140,19 +291,33 @@ func FromInstanceData(data InstanceData) (*Instance, error) {
 			data.Worktree.BranchName,
 			data.Worktree.BaseCommitSHA,
 		),
-		diffStats: &git.DiffStats{
-			Added:   data.DiffStats.Added,
-			Removed: data.DiffStats.Removed,
-			Content: data.DiffStats.Content,
-		},
+		diffExcludes:    slices.Clone(data.DiffExcludePatterns),
+		refreshInterval: time.Duration(data.RefreshIntervalMs) * time.Millisecond,
+		commitCount:     data.CommitCount,
+		env:             slices.Clone(data.Env),
+		Labels:          slices.Clone(data.Labels),
+		pendingStart:    data.PendingStart,
+	}
+	// Like ToInstanceData, leave the stats out if none were stored, so a reloaded clean session
+	// looks the same as a new one.
+	if data.DiffStats != (DiffStatsData{}) {
+		instance.diffStats = &git.DiffStats{
+			Added:        data.DiffStats.Added,
+			Removed:      data.DiffStats.Removed,
+			Content:      data.DiffStats.Content,
+			FilesChanged: data.DiffStats.FilesChanged,
+		}
 	}
+	instance.gitWorktree.SetExternalBranch(data.Worktree.ExternalBranch)
+	instance.gitWorktree.SetBaseBranch(data.Worktree.BaseBranch)
+	instance.gitWorktree.SetDiffExcludes(instance.diffExcludes)
 	instance.previewDirty.Store(true)
 	instance.diffDirty.Store(true)
 	instance.lastDiffCheck.Store(0)
 
 	if instance.Paused() {
 		instance.started = true
-		instance.tmuxSession = tmux.NewTmuxSession(instance.Title, instance.Program)
+		instance.tmuxSession = tmux.NewTmuxSession(instance.Title, instance.programCommand())
 		// Sync branch from gitWorktree for paused instances
 		instance.GetBranch()
 	} else {
@@ -172,11 +337,30 @@ type InstanceOptions struct {
 	Path string
 	// Program is the program to run in the instance (e.g. "claude", "aider --model ollama_chat/gemma3:1b")
 	Program string
-	// If AutoYes is true, then
+	// If AutoYes is true, then the instance automatically accepts the program's prompts.
 	AutoYes bool
+	// ExistingBranch, if set, starts the instance on this existing branch instead of creating a new one.
+	ExistingBranch string
+	// Events, if set, receives the instance's lifecycle events.
+	Events *EventBus
+	// Env holds extra environment variables for the program, as KEY=value.
+	Env []string
+	// Labels tag the instance, e.g. for filtering.
+	Labels []string
+	// BranchTemplate, if set, names the instance's new branch instead of the branch_template config.
+	BranchTemplate string
+	// WorkDir, if set, is the directory relative to the worktree root to run the program in, e.g.
+	// one package of a monorepo. It must exist in the worktree when the instance starts.
+	WorkDir string
 }
 
 func NewInstance(opts InstanceOptions) (*Instance, error) {
+	if err := validateTitle(opts.Title); err != nil {
+		return nil, err
+	}
+	if err := validateWorkDir(opts.WorkDir); err != nil {
+		return nil, err
+	}
 	t := time.Now()
 
 	// Convert path to absolute
@@ -194,14 +378,105 @@ func NewInstance(opts InstanceOptions) (*Instance, error) {
 		Width:     0,
 		CreatedAt: t,
 		UpdatedAt: t,
-		AutoYes:   false,
+		AutoYes:   opts.AutoYes,
+		Labels:    slices.Clone(opts.Labels),
+		WorkDir:   opts.WorkDir,
+
+		existingBranch: opts.ExistingBranch,
+		events:         opts.Events,
+		env:            slices.Clone(opts.Env),
+		branchTemplate: opts.BranchTemplate,
 	}
 	inst.previewDirty.Store(true)
 	inst.diffDirty.Store(true)
 	inst.lastDiffCheck.Store(0)
+	inst.emit(EventCreated)
 	return inst, nil
 }
 
+// NewInstanceFromTemplate creates an instance titled title in the current directory from the
+// instance template called name in the config.
+func NewInstanceFromTemplate(name, title string) (*Instance, error) {
+	cfg := config.LoadConfig()
+	template, err := cfg.GetTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewInstance(templateOptions(cfg, template, title))
+}
+
+// templateOptions returns the options for an instance titled title created from template.
+func templateOptions(cfg *config.Config, template config.InstanceTemplate, title string) InstanceOptions {
+	program := template.Program
+	if program == "" {
+		program = cfg.DefaultProgram
+	}
+	env := make([]string, 0, len(template.Env))
+	for key, value := range template.Env {
+		env = append(env, key+"="+value)
+	}
+	slices.Sort(env)
+	return InstanceOptions{
+		Title:          title,
+		Path:           ".",
+		Program:        program,
+		AutoYes:        template.AutoYes,
+		Env:            env,
+		Labels:         slices.Clone(template.Labels),
+		BranchTemplate: template.BranchTemplate,
+	}
+}
+
+// programCommand returns the command line that runs the instance's program with its extra
+// environment variables.
+func (i *Instance) programCommand() string {
+	if len(i.env) == 0 {
+		return i.Program
+	}
+	words := make([]string, 0, len(i.env)+2)
+	words = append(words, "env")
+	for _, kv := range i.env {
+		words = append(words, shellQuote(kv))
+	}
+	return strings.Join(append(words, i.Program), " ")
+}
+
+// validateWorkDir checks that a WorkDir option stays inside the worktree.
+func validateWorkDir(workDir string) error {
+	if workDir != "" && !filepath.IsLocal(workDir) {
+		return fmt.Errorf("work dir %q must be a relative path inside the worktree", workDir)
+	}
+	return nil
+}
+
+// agentDir returns the directory the program runs in: WorkDir in the worktree, or the worktree
+// root. WorkDir must be an existing directory that, with symlinks resolved, is in the worktree.
+func (i *Instance) agentDir() (string, error) {
+	root := i.gitWorktree.GetWorktreePath()
+	if i.WorkDir == "" || i.WorkDir == "." {
+		return root, nil
+	}
+	if err := validateWorkDir(i.WorkDir); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(root, i.WorkDir)
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve worktree path: %w", err)
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("work dir %s unavailable: %w", i.WorkDir, err)
+	}
+	if rel, err := filepath.Rel(resolvedRoot, resolved); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("work dir %s is outside the worktree", i.WorkDir)
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("work dir %s is not a directory", i.WorkDir)
+	}
+	return dir, nil
+}
+
 func (i *Instance) RepoName() (string, error) {
 	if !i.started {
 		return "", fmt.Errorf("cannot get repo name for instance that has not been started")
@@ -209,12 +484,65 @@ func (i *Instance) RepoName() (string, error) {
 	return i.gitWorktree.GetRepoName(), nil
 }
 
+// GetStatus returns the instance's status.
+func (i *Instance) GetStatus() Status {
+	i.stateMu.RLock()
+	defer i.stateMu.RUnlock()
+	return i.Status
+}
+
 func (i *Instance) SetStatus(status Status) {
+	i.stateMu.Lock()
+	if i.Status == status {
+		i.stateMu.Unlock()
+		return
+	}
 	i.Status = status
+	i.stateMu.Unlock()
+	i.emit(EventStatusChanged)
+}
+
+// InstanceSnapshot is a point-in-time copy of an instance's state, safe to hand to another
+// goroutine.
+type InstanceSnapshot struct {
+	Title  string
+	Status Status
+	Branch string
+	// DiffStats is a copy of the instance's diff stats, or nil if there are none yet.
+	DiffStats *git.DiffStats
+	// Idle is how long ago the pane last changed or a prompt was sent.
+	Idle   time.Duration
+	Paused bool
+}
+
+// Snapshot returns the instance's title, status, branch and diff stats as they were at one
+// moment, unlike reading them one after another while the instance is being updated.
+func (i *Instance) Snapshot() InstanceSnapshot {
+	i.stateMu.RLock()
+	defer i.stateMu.RUnlock()
+	branch := i.Branch
+	if i.gitWorktree != nil {
+		branch = i.gitWorktree.GetBranchName()
+	}
+	return InstanceSnapshot{
+		Title:     i.Title,
+		Status:    i.Status,
+		Branch:    branch,
+		DiffStats: i.diffStats.Clone(),
+		Idle:      time.Since(i.LastActivity()),
+		Paused:    i.Status == Paused || i.Status == Queued,
+	}
 }
 
 // firstTimeSetup is true if this is a new instance. Otherwise, it's one loaded from storage.
 func (i *Instance) Start(firstTimeSetup bool) error {
+	return i.StartContext(context.Background(), firstTimeSetup)
+}
+
+// StartContext is like Start but stops setting up the instance once ctx is done. If a new
+// instance fails to start, including because ctx was cancelled, the worktree, branch and tmux
+// session created so far are removed.
+func (i *Instance) StartContext(ctx context.Context, firstTimeSetup bool) (setupErr error) {
 	if i.Title == "" {
 		return fmt.Errorf("instance title cannot be empty")
 	}
@@ -225,25 +553,40 @@ func (i *Instance) Start(firstTimeSetup bool) error {
 		tmuxSession = i.tmuxSession
 	} else {
 		// Create new tmux session
-		tmuxSession = tmux.NewTmuxSession(i.Title, i.Program)
+		tmuxSession = tmux.NewTmuxSession(i.Title, i.programCommand())
 	}
 	i.tmuxSession = tmuxSession
 
-	if firstTimeSetup {
-		gitWorktree, branchName, err := git.NewGitWorktree(i.Path, i.Title)
-		if err != nil {
-			return fmt.Errorf("failed to create git worktree: %w", err)
+	if firstTimeSetup && !i.PendingStart() {
+		if err := i.newGitWorktree(); err != nil {
+			return err
 		}
-		i.gitWorktree = gitWorktree
-		i.Branch = branchName
 	}
+	cfg := config.LoadConfig()
+	i.gitWorktree.ApplyConfig(cfg)
+	i.gitWorktree.SetDiffExcludes(i.diffExcludes)
+	i.tmuxSession.SetHistoryLimit(cfg.TmuxHistoryLimit)
+	i.tmuxSession.SetCommandRetries(cfg.GetTmuxCommandRetries())
+	i.tmuxSession.SetEnterDelay(cfg.GetSendPromptEnterDelay())
+	i.readyPattern = compileReadyPattern(cfg)
+	i.tmuxSession.SetActivityPattern(compileActivityPattern(cfg))
+	i.tmuxSession.SetProgramWrapper(cfg.ProgramWrapper)
+	i.readyTimeout = cfg.GetProgramReadyTimeout()
+	i.pastePrompts = cfg.PastePrompts
+	i.configRefreshInterval = cfg.GetDiffRefreshInterval()
+	i.maxWatchDirs = cfg.MaxWatchDirs
+	i.logMaxBytes = cfg.TranscriptMaxBytes
 
 	// Setup error handler to cleanup resources on any error
-	var setupErr error
+	tmuxStarted := false
 	defer func() {
 		if setupErr != nil {
-			if cleanupErr := i.Kill(); cleanupErr != nil {
-				setupErr = fmt.Errorf("%v (cleanup error: %v)", setupErr, cleanupErr)
+			cleanup := i.Kill
+			if firstTimeSetup {
+				cleanup = func() error { return i.cleanupFailedStart(tmuxStarted) }
+			}
+			if cleanupErr := cleanup(); cleanupErr != nil {
+				setupErr = fmt.Errorf("%w (cleanup error: %v)", setupErr, cleanupErr)
 			}
 		} else {
 			i.started = true
@@ -260,20 +603,30 @@ func (i *Instance) Start(firstTimeSetup bool) error {
 		i.GetBranch()
 	} else {
 		// Setup git worktree first
-		if err := i.gitWorktree.Setup(); err != nil {
+		if err := i.gitWorktree.SetupContext(ctx); err != nil {
 			setupErr = fmt.Errorf("failed to setup git worktree: %w", err)
 			return setupErr
 		}
 
-		// Create new session
-		if err := i.tmuxSession.Start(i.gitWorktree.GetWorktreePath()); err != nil {
-			// Cleanup git worktree if tmux session creation fails
-			if cleanupErr := i.gitWorktree.Cleanup(); cleanupErr != nil {
-				err = fmt.Errorf("%v (cleanup error: %v)", err, cleanupErr)
+		if i.forkPatch != "" {
+			if err := i.gitWorktree.ApplyPatch(i.forkPatch); err != nil {
+				setupErr = fmt.Errorf("failed to copy uncommitted changes: %w", err)
+				return setupErr
 			}
+			i.forkPatch = ""
+		}
+
+		// Create new session
+		workDir, err := i.agentDir()
+		if err != nil {
+			setupErr = err
+			return setupErr
+		}
+		if err := i.tmuxSession.StartContext(ctx, workDir); err != nil {
 			setupErr = fmt.Errorf("failed to start new session: %w", err)
 			return setupErr
 		}
+		tmuxStarted = true
 	}
 
 	if err := i.startDiffWatcher(); err != nil {
@@ -281,16 +634,170 @@ func (i *Instance) Start(firstTimeSetup bool) error {
 		return setupErr
 	}
 
+	i.startTranscript(cfg)
+	i.startLogging()
 	i.MarkPreviewDirty()
 	i.MarkDiffDirty()
 	i.lastDiffCheck.Store(0)
-	i.SetStatus(Running)
+	if firstTimeSetup {
+		// HasUpdated moves the instance on once the program is ready for input.
+		i.loadingSince = time.Now()
+		i.SetStatus(Loading)
+	} else {
+		i.SetStatus(Running)
+	}
+	i.emit(EventStarted)
+
+	return nil
+}
+
+// newGitWorktree sets up the git worktree of a new instance, without creating it on disk yet.
+func (i *Instance) newGitWorktree() error {
+	if i.existingBranch != "" {
+		gitWorktree, err := git.NewGitWorktreeFromBranch(i.Path, i.Title, i.existingBranch)
+		if err != nil {
+			return fmt.Errorf("failed to create git worktree: %w", err)
+		}
+		i.gitWorktree = gitWorktree
+		i.Branch = i.existingBranch
+	} else if i.forkCommit != "" {
+		gitWorktree, branchName, err := git.NewGitWorktreeFromCommit(i.Path, i.Title, i.forkCommit)
+		if err != nil {
+			return fmt.Errorf("failed to create git worktree: %w", err)
+		}
+		i.gitWorktree = gitWorktree
+		i.Branch = branchName
+	} else {
+		gitWorktree, branchName, err := git.NewGitWorktreeWithBranchTemplate(i.Path, i.Title, i.branchTemplate)
+		if err != nil {
+			return fmt.Errorf("failed to create git worktree: %w", err)
+		}
+		i.gitWorktree = gitWorktree
+		i.Branch = branchName
+	}
+	return nil
+}
+
+// Queue puts the instance in the Queued status to wait for a free slot, instead of starting it.
+// A new instance is set up without creating its worktree or starting its program; a paused one
+// just changes status. Resume starts a queued instance.
+func (i *Instance) Queue() error {
+	switch status := i.GetStatus(); {
+	case status == Queued:
+		return nil
+	case i.started && status != Paused:
+		return fmt.Errorf("cannot queue running instance %s, pause it first", i.Title)
+	case i.started:
+		i.SetStatus(Queued)
+		return nil
+	}
+
+	if i.Title == "" {
+		return fmt.Errorf("instance title cannot be empty")
+	}
+	if i.forkPatch != "" {
+		return fmt.Errorf("cannot queue %s: uncommitted changes copied from its source would be lost", i.Title)
+	}
+	if err := i.newGitWorktree(); err != nil {
+		return err
+	}
+	if i.tmuxSession == nil {
+		i.tmuxSession = tmux.NewTmuxSession(i.Title, i.programCommand())
+	}
+	i.started = true
+	i.stateMu.Lock()
+	i.pendingStart = true
+	i.stateMu.Unlock()
+	i.SetStatus(Queued)
+	return nil
+}
 
+// PendingStart reports whether the instance was queued before it was ever started.
+func (i *Instance) PendingStart() bool {
+	i.stateMu.RLock()
+	defer i.stateMu.RUnlock()
+	return i.pendingStart
+}
+
+// startQueued runs the first-time Start of an instance queued by Queue, then sends it the prompt
+// it was queued with. On failure the worktree and branch Start created are removed again and the
+// instance stays queued.
+func (i *Instance) startQueued(ctx context.Context) error {
+	if err := i.StartContext(ctx, true); err != nil {
+		return err
+	}
+	// ToInstanceData reads these under stateMu while this runs off the UI goroutine.
+	i.stateMu.Lock()
+	i.pendingStart = false
+	prompt := i.Prompt
+	i.Prompt = ""
+	i.stateMu.Unlock()
+	if prompt != "" {
+		if err := i.SendPrompt(prompt); err != nil {
+			return fmt.Errorf("failed to send queued prompt to %s: %w", i.Title, err)
+		}
+	}
 	return nil
 }
 
-// Kill terminates the instance and cleans up all resources
+// compileReadyPattern compiles the configured ready pattern, falling back to the default if it
+// is invalid.
+func compileReadyPattern(cfg *config.Config) *regexp.Regexp {
+	pattern, err := regexp.Compile(cfg.GetReadyPattern())
+	if err != nil {
+		log.WarningLog.Printf("invalid ready_pattern %q, using the default: %v", cfg.ReadyPattern, err)
+		pattern = regexp.MustCompile((&config.Config{}).GetReadyPattern())
+	}
+	return pattern
+}
+
+// compileActivityPattern returns the activity_pattern config, or nil if it is unset or invalid.
+func compileActivityPattern(cfg *config.Config) *regexp.Regexp {
+	if cfg.ActivityPattern == "" {
+		return nil
+	}
+	pattern, err := regexp.Compile(cfg.ActivityPattern)
+	if err != nil {
+		log.WarningLog.Printf("invalid activity_pattern %q, ignoring it: %v", cfg.ActivityPattern, err)
+		return nil
+	}
+	return pattern
+}
+
+// checkStartup moves a Loading instance to Ready once its pane matches the ready pattern, or to
+// Crashed if the program exits or does not become ready within the program_ready_timeout.
+func (i *Instance) checkStartup(now time.Time) {
+	if !i.tmuxSession.DoesSessionExist() {
+		log.ForInstance(i.Title).Warning.Printf("program for instance %s exited while starting up", i.Title)
+		i.SetStatus(Crashed)
+		return
+	}
+	content, err := i.tmuxSession.CapturePaneContent()
+	if err == nil && i.readyPattern != nil && i.readyPattern.MatchString(content) {
+		i.SetStatus(Ready)
+		return
+	}
+	if i.readyTimeout > 0 && now.Sub(i.loadingSince) > i.readyTimeout {
+		log.ForInstance(i.Title).Warning.Printf("instance %s did not become ready within %s", i.Title, i.readyTimeout)
+		i.SetStatus(Crashed)
+	}
+}
+
+// KillOptions configures KillWithOptions.
+type KillOptions struct {
+	// DeleteBranch deletes the session's branch along with its worktree. The branch tip is kept
+	// under a backup ref so the last kill of a repository can still be undone with
+	// Storage.RestoreLastKilled. Branches that existed before the session are never deleted.
+	DeleteBranch bool
+}
+
+// Kill terminates the instance and removes its worktree, keeping its branch.
 func (i *Instance) Kill() error {
+	return i.KillWithOptions(KillOptions{})
+}
+
+// KillWithOptions terminates the instance and cleans up its resources as configured by opts.
+func (i *Instance) KillWithOptions(opts KillOptions) error {
 	if !i.started {
 		// If instance was never started, just return success
 		return nil
@@ -301,6 +808,9 @@ func (i *Instance) Kill() error {
 	if err := i.stopDiffWatcher(); err != nil {
 		errs = append(errs, fmt.Errorf("failed to stop diff watcher: %w", err))
 	}
+	if err := i.stopTranscript(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close transcript: %w", err))
+	}
 
 	// Always try to cleanup both resources, even if one fails
 	// Clean up tmux session first since it's using the git worktree
@@ -312,16 +822,21 @@ func (i *Instance) Kill() error {
 
 	// Then clean up git worktree
 	if i.gitWorktree != nil {
-		if err := i.gitWorktree.Cleanup(); err != nil {
+		cleanup := i.gitWorktree.CleanupWorktree
+		if opts.DeleteBranch {
+			cleanup = i.gitWorktree.Cleanup
+		}
+		if err := cleanup(); err != nil {
 			errs = append(errs, fmt.Errorf("failed to cleanup git worktree: %w", err))
 		}
 	}
 
-	return i.combineErrors(errs)
+	i.emit(EventKilled)
+	return combineErrors(errs)
 }
 
 // combineErrors combines multiple errors into a single error
-func (i *Instance) combineErrors(errs []error) error {
+func combineErrors(errs []error) error {
 	if len(errs) == 0 {
 		return nil
 	}
@@ -337,7 +852,7 @@ func (i *Instance) combineErrors(errs []error) error {
 }
 
 func (i *Instance) Preview() (string, error) {
-	if !i.started || i.Status == Paused {
+	if !i.started || i.Paused() {
 		return "", nil
 	}
 	content, err := i.tmuxSession.CapturePaneContent()
@@ -352,14 +867,82 @@ func (i *Instance) HasUpdated() (updated bool, hasPrompt bool) {
 	if !i.started {
 		return false, false
 	}
+	if i.GetStatus() == Loading {
+		i.checkStartup(time.Now())
+	}
 	updated, hasPrompt = i.tmuxSession.HasUpdated()
+	if i.touched.Swap(false) {
+		updated = true
+	}
+	if updated {
+		i.lastActivity.Store(time.Now().UnixNano())
+	}
 	if updated || hasPrompt {
 		i.MarkPreviewDirty()
 		i.MarkDiffDirty()
 	}
+	if updated && i.transcript != nil {
+		i.appendTranscript()
+	}
 	return updated, hasPrompt
 }
 
+// Touch records activity as if the pane had changed, for integrations that know the agent is
+// busy while it prints nothing, e.g. during a long tool call. It resets the idle time and the next
+// HasUpdated reports an update, so the instance is not marked Ready in between.
+func (i *Instance) Touch() {
+	now := time.Now()
+	i.lastActivity.Store(now.UnixNano())
+	i.touched.Store(true)
+	i.stateMu.Lock()
+	i.UpdatedAt = now
+	i.stateMu.Unlock()
+}
+
+// LastActivity returns when the instance's pane last changed or it was last sent a prompt, as
+// observed by HasUpdated and SendPrompt. Before any activity was seen it is the time the
+// instance was created.
+func (i *Instance) LastActivity() time.Time {
+	if nanos := i.lastActivity.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return i.CreatedAt
+}
+
+// WaitUntilReady polls the instance until it is Ready, that is until its pane stops changing
+// without showing a permission prompt. It returns ErrInstanceCrashed if the program crashes, or
+// the context's error if ctx is done first. The status is updated the same way the UI does.
+func (i *Instance) WaitUntilReady(ctx context.Context) error {
+	if !i.started || i.Paused() {
+		return fmt.Errorf("cannot wait for instance that has not been started or is paused")
+	}
+
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		updated, hasPrompt := i.HasUpdated()
+		switch status := i.GetStatus(); {
+		case status == Crashed:
+			return fmt.Errorf("waiting for %s: %w", i.Title, ErrInstanceCrashed)
+		case status == Loading:
+			// Still starting up; HasUpdated moves it on.
+		case updated:
+			i.SetStatus(Running)
+		case hasPrompt:
+			i.TapEnter()
+		default:
+			i.SetStatus(Ready)
+			return nil
+		}
+	}
+}
+
 func (i *Instance) MarkPreviewDirty() {
 	i.previewDirty.Store(true)
 }
@@ -376,20 +959,36 @@ func (i *Instance) MarkDiffDirty() {
 }
 
 // TapEnter sends an enter key press to the tmux session if AutoYes is enabled.
-func (i *Instance) TapEnter() {
+func (i *Instance) TapEnter() error {
 	if !i.started || !i.AutoYes {
-		return
+		return nil
+	}
+	if i.ReadOnly {
+		return fmt.Errorf("cannot tap enter in %s: %w", i.Title, ErrReadOnly)
 	}
 	if err := i.tmuxSession.TapEnter(); err != nil {
-		log.ErrorLog.Printf("error tapping enter: %v", err)
+		log.ForInstance(i.Title).Error.Printf("error tapping enter: %v", err)
+		return err
 	}
+	return nil
+}
+
+// SetAutoYes sets whether the instance automatically accepts the program's prompts. The setting
+// is persisted with the instance.
+func (i *Instance) SetAutoYes(autoYes bool) {
+	i.AutoYes = autoYes
+}
+
+// SetReadOnly sets whether the instance refuses input. It takes effect on the next Attach.
+func (i *Instance) SetReadOnly(readOnly bool) {
+	i.ReadOnly = readOnly
 }
 
 func (i *Instance) ensureTmuxSession() error {
 	if !i.started {
 		return fmt.Errorf("cannot ensure tmux session for instance that has not been started")
 	}
-	if i.Status == Paused {
+	if i.Paused() {
 		return fmt.Errorf("cannot ensure tmux session for paused instance")
 	}
 	if i.tmuxSession == nil {
@@ -411,12 +1010,17 @@ func (i *Instance) ensureTmuxSession() error {
 		return fmt.Errorf("worktree path %s unavailable: %w", worktreePath, err)
 	}
 
+	workDir, err := i.agentDir()
+	if err != nil {
+		return err
+	}
 	if log.InfoLog != nil {
-		log.InfoLog.Printf("tmux session missing for %s; starting a fresh session in %s", i.Title, worktreePath)
+		log.ForInstance(i.Title).Info.Printf("tmux session missing for %s; starting a fresh session in %s", i.Title, workDir)
 	}
-	if err := i.tmuxSession.Start(worktreePath); err != nil {
+	if err := i.tmuxSession.Start(workDir); err != nil {
 		return fmt.Errorf("failed to start new tmux session: %w", err)
 	}
+	i.startLogging()
 
 	i.MarkPreviewDirty()
 	i.MarkDiffDirty()
@@ -427,34 +1031,58 @@ func (i *Instance) ensureTmuxSession() error {
 	return nil
 }
 
+// Attach attaches the current terminal to the instance's tmux session, sized to the terminal.
 func (i *Instance) Attach() (chan struct{}, error) {
+	return i.AttachWithSize(0, 0)
+}
+
+// AttachWithSize is like Attach, but sizes the session to width x height. Zero dimensions use the
+// terminal's size.
+func (i *Instance) AttachWithSize(width, height int) (chan struct{}, error) {
 	if !i.started {
 		return nil, fmt.Errorf("cannot attach instance that has not been started")
 	}
 	if err := i.ensureTmuxSession(); err != nil {
 		return nil, err
 	}
+	if err := i.tmuxSession.SetReadOnly(i.ReadOnly); err != nil {
+		return nil, err
+	}
 
-	ch, err := i.tmuxSession.Attach()
+	ch, err := i.tmuxSession.AttachWithSize(width, height)
 	if err == nil {
 		return ch, nil
 	}
 
 	// Attempt one restore in case the PTY was stale
 	if log.WarningLog != nil {
-		log.WarningLog.Printf("failed to attach to tmux session %s: %v; attempting restore", i.Title, err)
+		log.ForInstance(i.Title).Warning.Printf("failed to attach to tmux session %s: %v; attempting restore", i.Title, err)
 	}
 	if restoreErr := i.tmuxSession.Restore(); restoreErr != nil {
 		return nil, fmt.Errorf("failed to attach and restore tmux session: %w", err)
 	}
-	return i.tmuxSession.Attach()
+	return i.tmuxSession.AttachWithSize(width, height)
 }
 
 func (i *Instance) SetPreviewSize(width, height int) error {
-	if !i.started || i.Status == Paused {
+	return i.SetPreviewSizeWithOptions(width, height, PreviewSizeOptions{})
+}
+
+// PreviewSizeOptions configures SetPreviewSizeWithOptions.
+type PreviewSizeOptions struct {
+	// Reflow redraws the attached clients at the new size right away. See tmux.TmuxSession.Resize.
+	Reflow bool
+}
+
+// SetPreviewSizeWithOptions is like SetPreviewSize, with options.
+func (i *Instance) SetPreviewSizeWithOptions(width, height int, opts PreviewSizeOptions) error {
+	if !i.started || i.Paused() {
 		return fmt.Errorf("cannot set preview size for instance that has not been started or " +
 			"is paused")
 	}
+	if opts.Reflow {
+		return i.tmuxSession.Resize(width, height)
+	}
 	return i.tmuxSession.SetDetachedSize(width, height)
 }
 
@@ -466,148 +1094,625 @@ func (i *Instance) GetGitWorktree() (*git.GitWorktree, error) {
 	return i.gitWorktree, nil
 }
 
-// GetBranch returns the current branch name, syncing from gitWorktree if available
-func (i *Instance) GetBranch() string {
-	if i.gitWorktree != nil {
-		i.Branch = i.gitWorktree.GetBranchName()
+// checkWorktreeAvailable returns an error if the instance's worktree can't be used for action.
+func (i *Instance) checkWorktreeAvailable(action string) error {
+	if !i.started {
+		return fmt.Errorf("cannot %s instance that has not been started", action)
 	}
-	return i.Branch
+	if i.Paused() {
+		return fmt.Errorf("cannot %s paused instance", action)
+	}
+	if i.GetStatus() == Detached {
+		return fmt.Errorf("cannot %s instance whose worktree is missing", action)
+	}
+	if i.gitWorktree == nil {
+		return fmt.Errorf("git worktree not initialized")
+	}
+	return nil
 }
 
-func (i *Instance) Started() bool {
-	return i.started
+// RebaseOnto rebases the instance's branch onto ref. See git.GitWorktree.RebaseOnto.
+func (i *Instance) RebaseOnto(ref string) error {
+	if err := i.checkWorktreeAvailable("rebase"); err != nil {
+		return err
+	}
+	defer i.MarkDiffDirty()
+	return i.gitWorktree.RebaseOnto(ref)
 }
 
-// SetTitle sets the title of the instance. Returns an error if the instance has started.
-// We cant change the title once it's been used for a tmux session etc.
-func (i *Instance) SetTitle(title string) error {
-	if i.started {
-		return fmt.Errorf("cannot change title of a started instance")
+// RebaseContinue continues a rebase that stopped on conflicts.
+func (i *Instance) RebaseContinue() error {
+	if err := i.checkWorktreeAvailable("continue rebase for"); err != nil {
+		return err
 	}
-	i.Title = title
-	return nil
+	defer i.MarkDiffDirty()
+	return i.gitWorktree.RebaseContinue()
 }
 
-func (i *Instance) Paused() bool {
-	return i.Status == Paused
+// RebaseAbort aborts a rebase that stopped on conflicts.
+func (i *Instance) RebaseAbort() error {
+	if err := i.checkWorktreeAvailable("abort rebase for"); err != nil {
+		return err
+	}
+	defer i.MarkDiffDirty()
+	return i.gitWorktree.RebaseAbort()
 }
 
-// TmuxAlive returns true if the tmux session is alive. This is a sanity check before attaching.
-func (i *Instance) TmuxAlive() bool {
-	return i.tmuxSession.DoesSessionExist()
+// MergeFrom merges other's branch into this instance's branch. See git.GitWorktree.Merge.
+func (i *Instance) MergeFrom(other *Instance) error {
+	return i.MergeFromWithOptions(other, git.MergeOptions{})
 }
 
-// Pause stops the tmux session and removes the worktree, preserving the branch
-func (i *Instance) Pause() error {
-	if !i.started {
-		return fmt.Errorf("cannot pause instance that has not been started")
+// MergeFromWithOptions is like MergeFrom but with opts. Only other's committed work is merged.
+func (i *Instance) MergeFromWithOptions(other *Instance, opts git.MergeOptions) error {
+	if err := i.checkWorktreeAvailable("merge into"); err != nil {
+		return err
 	}
-	if i.Status == Paused {
-		return fmt.Errorf("instance is already paused")
+	if other == nil || other == i {
+		return fmt.Errorf("cannot merge an instance into itself")
 	}
-
-	var errs []error
-
-	if err := i.stopDiffWatcher(); err != nil {
-		errs = append(errs, fmt.Errorf("failed to stop diff watcher: %w", err))
+	branch := other.GetBranch()
+	if branch == "" {
+		return fmt.Errorf("instance %s has no branch to merge", other.Title)
 	}
+	defer i.MarkDiffDirty()
+	return i.gitWorktree.MergeWithOptions(branch, "", opts)
+}
 
-	// Check if there are any changes to commit
-	if dirty, err := i.gitWorktree.IsDirty(); err != nil {
-		errs = append(errs, fmt.Errorf("failed to check if worktree is dirty: %w", err))
-		log.ErrorLog.Print(err)
-	} else if dirty {
-		// Commit changes locally (without pushing to GitHub)
-		commitMsg := fmt.Sprintf("[agentsquad] update from '%s' on %s (paused)", i.Title, time.Now().Format(time.RFC822))
-		if err := i.gitWorktree.CommitChanges(commitMsg); err != nil {
-			errs = append(errs, fmt.Errorf("failed to commit changes: %w", err))
-			log.ErrorLog.Print(err)
-			// Return early if we can't commit changes to avoid corrupted state
-			return i.combineErrors(errs)
-		}
+// MergeContinue concludes a merge that stopped on conflicts.
+func (i *Instance) MergeContinue() error {
+	if err := i.checkWorktreeAvailable("continue merge for"); err != nil {
+		return err
 	}
+	defer i.MarkDiffDirty()
+	return i.gitWorktree.MergeContinue()
+}
 
-	// Detach from tmux session instead of closing to preserve session output
-	if err := i.tmuxSession.DetachSafely(); err != nil {
-		errs = append(errs, fmt.Errorf("failed to detach tmux session: %w", err))
-		log.ErrorLog.Print(err)
-		// Continue with pause process even if detach fails
+// MergeAbort aborts a merge that stopped on conflicts.
+func (i *Instance) MergeAbort() error {
+	if err := i.checkWorktreeAvailable("abort merge for"); err != nil {
+		return err
 	}
+	defer i.MarkDiffDirty()
+	return i.gitWorktree.MergeAbort()
+}
 
-	// Check if worktree exists before trying to remove it
-	if _, err := os.Stat(i.gitWorktree.GetWorktreePath()); err == nil {
-		// Remove worktree but keep branch
-		if err := i.gitWorktree.Remove(); err != nil {
-			errs = append(errs, fmt.Errorf("failed to remove git worktree: %w", err))
-			log.ErrorLog.Print(err)
-			return i.combineErrors(errs)
-		}
-
-		// Only prune if remove was successful
-		if err := i.gitWorktree.Prune(); err != nil {
-			errs = append(errs, fmt.Errorf("failed to prune git worktrees: %w", err))
-			log.ErrorLog.Print(err)
-			return i.combineErrors(errs)
-		}
+// CheckoutBranch switches the instance's worktree to another branch. See
+// git.GitWorktree.CheckoutBranchWithOptions.
+func (i *Instance) CheckoutBranch(name string, opts git.CheckoutOptions) error {
+	if err := i.checkWorktreeAvailable("check out a branch for"); err != nil {
+		return err
 	}
-
-	if err := i.combineErrors(errs); err != nil {
-		log.ErrorLog.Print(err)
+	defer i.MarkDiffDirty()
+	i.stateMu.Lock()
+	defer i.stateMu.Unlock()
+	if err := i.gitWorktree.CheckoutBranchWithOptions(name, opts); err != nil {
+		return err
+	}
+	i.Branch = i.gitWorktree.GetBranchName()
+	return nil
+}
+
+// RunInWorktree runs a command in the instance's worktree and returns its combined output. The
+// command is killed if ctx is cancelled.
+func (i *Instance) RunInWorktree(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if err := i.checkWorktreeAvailable("run command in"); err != nil {
+		return nil, err
+	}
+	output, err := i.worktreeCommand(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return output, fmt.Errorf("command %s cancelled: %w", name, ctxErr)
+		}
+		return output, fmt.Errorf("command %s failed: %w", name, err)
+	}
+	return output, nil
+}
+
+// RunCommand runs args, e.g. the project's test command, in the instance's worktree with the
+// instance's environment and returns its exit code and combined output. A command that runs and
+// exits non-zero is not an error; err is only set if the command could not be run or ctx ended
+// first. The tmux session is not involved.
+func (i *Instance) RunCommand(ctx context.Context, args []string) (exitCode int, output string, err error) {
+	if len(args) == 0 {
+		return 0, "", fmt.Errorf("no command given")
+	}
+	if err := i.checkWorktreeAvailable("run command in"); err != nil {
+		return 0, "", err
+	}
+	out, err := i.worktreeCommand(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return -1, string(out), fmt.Errorf("command %s cancelled: %w", args[0], ctxErr)
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode(), string(out), nil
+		}
+		return -1, string(out), fmt.Errorf("command %s failed: %w", args[0], err)
+	}
+	return 0, string(out), nil
+}
+
+// worktreeCommand returns a command that runs in the instance's worktree with the instance's
+// extra environment variables and is killed when ctx ends.
+func (i *Instance) worktreeCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = i.gitWorktree.GetWorktreePath()
+	if len(i.env) > 0 {
+		cmd.Env = append(os.Environ(), i.env...)
+	}
+	return cmd
+}
+
+// ExportPatch writes the instance's changes against its base commit to w as a patch.
+func (i *Instance) ExportPatch(w io.Writer, opts git.PatchOptions) error {
+	if err := i.checkWorktreeAvailable("export patch for"); err != nil {
+		return err
+	}
+	return i.gitWorktree.ExportPatch(w, opts)
+}
+
+// OpenPR pushes the instance's branch and opens a pull request for it. See
+// git.GitWorktree.CreatePullRequest.
+func (i *Instance) OpenPR(title, body, base string) (string, error) {
+	if err := i.checkWorktreeAvailable("open pull request for"); err != nil {
+		return "", err
+	}
+	return i.gitWorktree.CreatePullRequest(title, body, base)
+}
+
+// AheadBehind returns how many commits the instance's branch is ahead of and behind ref.
+// It returns an error wrapping git.ErrNoMergeBase if the two share no history.
+func (i *Instance) AheadBehind(ref string) (ahead, behind int, err error) {
+	if err := i.checkWorktreeAvailable("compare"); err != nil {
+		return 0, 0, err
+	}
+	return i.gitWorktree.AheadBehind(ref)
+}
+
+// Fetch fetches remote into the instance's repository. See git.GitWorktree.Fetch.
+func (i *Instance) Fetch(remote string) error {
+	if err := i.checkWorktreeAvailable("fetch for"); err != nil {
+		return err
+	}
+	if err := i.gitWorktree.Fetch(remote); err != nil {
+		return err
+	}
+	i.MarkDiffDirty()
+	return nil
+}
+
+// FetchAndCompare fetches remote and returns how many commits the instance's branch is ahead of
+// and behind branch on that remote, e.g. to check whether the session is behind origin/main.
+func (i *Instance) FetchAndCompare(remote, branch string) (ahead, behind int, err error) {
+	if err := i.Fetch(remote); err != nil {
+		return 0, 0, err
+	}
+	return i.gitWorktree.AheadBehind(remote + "/" + branch)
+}
+
+// Commit commits the instance's uncommitted changes as a checkpoint while it keeps running. It
+// returns an error wrapping git.ErrNothingToCommit if the worktree is clean.
+func (i *Instance) Commit(message string) error {
+	if err := i.checkWorktreeAvailable("commit"); err != nil {
+		return err
+	}
+	if err := i.gitWorktree.CommitWithOptions(git.CommitOptions{Message: message}); err != nil {
+		if errors.Is(err, git.ErrNothingToCommit) {
+			return fmt.Errorf("cannot commit %s: %w", i.Title, err)
+		}
+		return err
+	}
+	i.MarkDiffDirty()
+	return nil
+}
+
+// DirtyDetails reports which kinds of uncommitted changes the instance's worktree has.
+func (i *Instance) DirtyDetails() (git.DirtyState, error) {
+	if err := i.checkWorktreeAvailable("inspect"); err != nil {
+		return git.DirtyState{}, err
+	}
+	return i.gitWorktree.DirtyDetails()
+}
+
+// Clone starts a new instance titled newTitle whose branch starts at this instance's current
+// commit, running the same program. Uncommitted changes are copied over, so the clone picks up
+// exactly where this instance is. Cloning a paused instance branches off its preserved branch.
+func (i *Instance) Clone(newTitle string) (*Instance, error) {
+	if !i.started {
+		return nil, fmt.Errorf("cannot clone instance that has not been started")
+	}
+	if i.gitWorktree == nil {
+		return nil, fmt.Errorf("git worktree not initialized")
+	}
+
+	commit, err := i.gitWorktree.HeadCommit()
+	if err != nil {
+		return nil, err
+	}
+	// A paused instance committed its work when it was paused.
+	var patch string
+	if !i.Paused() {
+		if patch, err = i.gitWorktree.WorkInProgressPatch(); err != nil {
+			return nil, err
+		}
+	}
+
+	clone, err := NewInstance(InstanceOptions{
+		Title:   newTitle,
+		Path:    i.Path,
+		Program: i.Program,
+		Events:  i.events,
+		Env:     i.env,
+		Labels:  i.Labels,
+	})
+	if err != nil {
+		return nil, err
+	}
+	clone.AutoYes = i.AutoYes
+	clone.Priority = i.Priority
+	clone.WorkDir = i.WorkDir
+	clone.forkCommit = commit
+	clone.forkPatch = patch
+	clone.diffExcludes = slices.Clone(i.diffExcludes)
+	clone.refreshInterval = i.refreshInterval
+	if err := clone.Start(true); err != nil {
+		return nil, fmt.Errorf("failed to start clone: %w", err)
+	}
+	return clone, nil
+}
+
+// DiskUsage returns the size in bytes of the instance's worktree. A paused instance has no
+// worktree on disk and uses nothing.
+func (i *Instance) DiskUsage() (int64, error) {
+	if !i.started {
+		return 0, fmt.Errorf("cannot measure instance that has not been started")
+	}
+	if i.Paused() {
+		return 0, nil
+	}
+	if i.gitWorktree == nil {
+		return 0, fmt.Errorf("git worktree not initialized")
+	}
+	return i.gitWorktree.DiskUsage()
+}
+
+// GetBranch returns the current branch name, syncing from gitWorktree if available
+func (i *Instance) GetBranch() string {
+	if i.gitWorktree == nil {
+		return i.Branch
+	}
+	i.stateMu.Lock()
+	defer i.stateMu.Unlock()
+	i.Branch = i.gitWorktree.GetBranchName()
+	return i.Branch
+}
+
+// GetBaseBranch returns the branch the instance's branch forked from, or an empty string if it
+// is unknown.
+func (i *Instance) GetBaseBranch() string {
+	if i.gitWorktree == nil {
+		return ""
+	}
+	return i.gitWorktree.GetBaseBranch()
+}
+
+func (i *Instance) Started() bool {
+	return i.started
+}
+
+// SetTitle sets the title of the instance. Returns an error if the instance has started.
+// We cant change the title once it's been used for a tmux session etc.
+func (i *Instance) SetTitle(title string) error {
+	if i.started {
+		return fmt.Errorf("cannot change title of a started instance")
+	}
+	if err := validateTitle(title); err != nil {
+		return err
+	}
+	i.Title = title
+	return nil
+}
+
+// validateTitle rejects titles that tmux cannot address as a session name or that leave nothing
+// to derive a branch name from. tmux treats '.' and ':' as separators in targets, so a session
+// named with either cannot be found again. An empty title is allowed while it is being entered.
+func validateTitle(title string) error {
+	if title == "" {
+		return nil
+	}
+	for _, r := range title {
+		if r == '.' || r == ':' || unicode.IsControl(r) {
+			return fmt.Errorf("title %q cannot contain %q", title, r)
+		}
+	}
+	if git.SanitizeBranchName(title) == "" {
+		return fmt.Errorf("title %q has no characters usable in a branch name", title)
+	}
+	return nil
+}
+
+// Paused returns true if the instance has no worktree or program because it is paused or
+// queued.
+func (i *Instance) Paused() bool {
+	status := i.GetStatus()
+	return status == Paused || status == Queued
+}
+
+// TmuxAlive returns true if the tmux session is alive. This is a sanity check before attaching.
+func (i *Instance) TmuxAlive() bool {
+	return i.tmuxSession.DoesSessionExist()
+}
+
+// Pause stops the tmux session and removes the worktree, preserving the branch
+func (i *Instance) Pause() error {
+	if !i.started {
+		return fmt.Errorf("cannot pause instance that has not been started")
+	}
+	if i.Paused() {
+		return fmt.Errorf("instance is already paused")
+	}
+
+	var errs []error
+	cfg := config.LoadConfig()
+	strategy := cfg.GetPauseStrategy()
+
+	// Check if there are any changes to save. A missing worktree has none left.
+	dirty := false
+	var err error
+	if i.GetStatus() != Detached {
+		dirty, err = i.gitWorktree.IsDirty()
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to check if worktree is dirty: %w", err))
+		log.ForInstance(i.Title).Error.Print(err)
+	} else if dirty && strategy == config.PauseStrategyLeaveDirty {
+		return fmt.Errorf("cannot pause %s: worktree has uncommitted changes, commit or stash them first", i.Title)
+	}
+
+	if err := i.stopDiffWatcher(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to stop diff watcher: %w", err))
+	}
+	if err := i.stopTranscript(); err != nil {
+		log.ForInstance(i.Title).Warning.Printf("failed to close transcript for %s: %v", i.Title, err)
+	}
+	if i.logPath != "" {
+		if err := i.tmuxSession.StopLogging(); err != nil {
+			log.ForInstance(i.Title).Warning.Printf("failed to stop logging for %s: %v", i.Title, err)
+		}
+	}
+
+	if dirty && strategy == config.PauseStrategyStash {
+		// Stash the changes for Resume to apply again
+		if err := i.gitWorktree.Stash(pauseStashMessage(i.Title)); err != nil {
+			errs = append(errs, err)
+			log.ForInstance(i.Title).Error.Print(err)
+			// Return early if we can't stash changes to avoid losing them with the worktree
+			return combineErrors(errs)
+		}
+	} else if dirty {
+		// Commit changes locally (without pushing to GitHub)
+		commitMsg := pauseCommitMessage(cfg, i.Title, i.gitWorktree.GetBranchName(), time.Now())
+		commit := i.gitWorktree.CommitChangesIncludingUntracked
+		if cfg.PauseSkipUntracked {
+			commit = i.gitWorktree.CommitChanges
+		}
+		if err := commit(commitMsg); err != nil {
+			errs = append(errs, fmt.Errorf("failed to commit changes: %w", err))
+			log.ForInstance(i.Title).Error.Print(err)
+			// Return early if we can't commit changes to avoid corrupted state
+			return combineErrors(errs)
+		}
+		// Untracked files left out of the commit would be lost with the worktree, so stash them
+		// for Resume to restore.
+		if cfg.PauseSkipUntracked {
+			if err := i.stashLeftovers(); err != nil {
+				errs = append(errs, err)
+				log.ForInstance(i.Title).Error.Print(err)
+				return combineErrors(errs)
+			}
+		}
+	}
+
+	// Remember the commit count, including any commit just made, while the worktree exists.
+	if count, err := i.gitWorktree.CommitCount(); err == nil {
+		i.commitCount = count
+	}
+
+	// Detach from tmux session instead of closing to preserve session output
+	if err := i.tmuxSession.DetachSafely(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to detach tmux session: %w", err))
+		log.ForInstance(i.Title).Error.Print(err)
+		// Continue with pause process even if detach fails
+	}
+
+	// Check if worktree exists before trying to remove it
+	if _, err := os.Stat(i.gitWorktree.GetWorktreePath()); err == nil {
+		// Remove worktree but keep branch
+		if err := i.gitWorktree.Remove(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove git worktree: %w", err))
+			log.ForInstance(i.Title).Error.Print(err)
+			return combineErrors(errs)
+		}
+
+		// Only prune if remove was successful
+		if err := i.gitWorktree.Prune(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to prune git worktrees: %w", err))
+			log.ForInstance(i.Title).Error.Print(err)
+			return combineErrors(errs)
+		}
+	}
+
+	if err := combineErrors(errs); err != nil {
+		log.ForInstance(i.Title).Error.Print(err)
 		return err
 	}
 
 	i.SetStatus(Paused)
-	_ = clipboard.WriteAll(i.gitWorktree.GetBranchName())
+	i.emit(EventPaused)
+	if text, ok := pauseClipboardText(cfg, i.gitWorktree.GetBranchName()); ok {
+		// There is often no clipboard, e.g. over SSH, which is no reason to fail the pause.
+		if err := clipboard.WriteAll(text); err != nil {
+			log.ForInstance(i.Title).Info.Printf("could not copy the branch of %s to the clipboard: %v", i.Title, err)
+		}
+	}
 	return nil
 }
 
+// pauseClipboardText returns what Pause copies to the clipboard for branch, and false if the
+// config turns copying off.
+func pauseClipboardText(cfg *config.Config, branch string) (string, bool) {
+	if !cfg.GetPauseCopyBranch() {
+		return "", false
+	}
+	if cfg.PauseCopyCheckoutCommand {
+		return "git checkout " + branch, true
+	}
+	return branch, true
+}
+
+// pauseCommitMessage returns the message for the commit Pause makes, rendered from the
+// configured template. A template that renders to an empty message falls back to the default.
+func pauseCommitMessage(cfg *config.Config, title, branch string, now time.Time) string {
+	if cfg.PauseCommitTemplate != "" {
+		msg := strings.NewReplacer(
+			"{title}", title,
+			"{time}", now.Format(time.RFC822),
+			"{branch}", branch,
+		).Replace(cfg.PauseCommitTemplate)
+		if strings.TrimSpace(msg) != "" {
+			return msg
+		}
+		log.WarningLog.Printf("pause_commit_template %q renders to an empty message, using the default", cfg.PauseCommitTemplate)
+	}
+	return fmt.Sprintf("[agentsquad] update from '%s' on %s (paused)", title, now.Format(time.RFC822))
+}
+
+// stashLeftovers stashes whatever Pause did not commit, for Resume to restore.
+func (i *Instance) stashLeftovers() error {
+	dirty, err := i.gitWorktree.IsDirty()
+	if err != nil {
+		return fmt.Errorf("failed to check for uncommitted changes: %w", err)
+	}
+	if !dirty {
+		return nil
+	}
+	return i.gitWorktree.Stash(pauseStashMessage(i.Title))
+}
+
+// abandonResume removes the worktree of a failed resume, stashing its uncommitted changes first
+// so the next resume brings them back. The branch is kept.
+func (i *Instance) abandonResume() error {
+	if err := i.stashLeftovers(); err != nil {
+		return fmt.Errorf("failed to stash changes before removing worktree: %w", err)
+	}
+	return i.gitWorktree.CleanupWorktree()
+}
+
+// pauseStashMessage returns the message of the stash entry Pause makes for the instance.
+func pauseStashMessage(title string) string {
+	return fmt.Sprintf("[agentsquad] paused '%s'", title)
+}
+
+// cleanupFailedStart removes what a failed first-time Start created: the tmux session, if it was
+// started, and the worktree with its branch.
+func (i *Instance) cleanupFailedStart(tmuxStarted bool) error {
+	var errs []error
+	if tmuxStarted {
+		if err := i.tmuxSession.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close tmux session: %w", err))
+		}
+	}
+	if i.gitWorktree != nil {
+		if err := i.gitWorktree.Discard(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to cleanup git worktree: %w", err))
+		}
+	}
+	return combineErrors(errs)
+}
+
 // Resume recreates the worktree and restarts the tmux session
 func (i *Instance) Resume() error {
+	return i.ResumeContext(context.Background())
+}
+
+// ResumeContext is like Resume but stops once ctx is done. If resuming fails, including because
+// ctx was cancelled, the recreated worktree is removed again; the branch and any stashed changes
+// are kept so the instance stays paused and can be resumed later.
+func (i *Instance) ResumeContext(ctx context.Context) error {
 	if !i.started {
 		return fmt.Errorf("cannot resume instance that has not been started")
 	}
-	if i.Status != Paused {
-		return fmt.Errorf("can only resume paused instances")
+	if !i.Paused() {
+		return fmt.Errorf("can only resume paused or queued instances")
+	}
+	if i.PendingStart() {
+		return i.startQueued(ctx)
 	}
 
 	// Check if branch is checked out
 	if checked, err := i.gitWorktree.IsBranchCheckedOut(); err != nil {
-		log.ErrorLog.Print(err)
+		log.ForInstance(i.Title).Error.Print(err)
 		return fmt.Errorf("failed to check if branch is checked out: %w", err)
 	} else if checked {
 		return fmt.Errorf("cannot resume: branch is checked out, please switch to a different branch")
 	}
+	cfg := config.LoadConfig()
+	i.gitWorktree.ApplyConfig(cfg)
+	i.tmuxSession.SetHistoryLimit(cfg.TmuxHistoryLimit)
+	i.tmuxSession.SetCommandRetries(cfg.GetTmuxCommandRetries())
+	i.tmuxSession.SetEnterDelay(cfg.GetSendPromptEnterDelay())
+	i.tmuxSession.SetActivityPattern(compileActivityPattern(cfg))
+	i.tmuxSession.SetProgramWrapper(cfg.ProgramWrapper)
+	i.pastePrompts = cfg.PastePrompts
+	i.configRefreshInterval = cfg.GetDiffRefreshInterval()
+	i.maxWatchDirs = cfg.MaxWatchDirs
+	i.logMaxBytes = cfg.TranscriptMaxBytes
 
 	// Setup git worktree
-	if err := i.gitWorktree.Setup(); err != nil {
-		log.ErrorLog.Print(err)
+	if err := i.gitWorktree.SetupContext(ctx); err != nil {
+		log.ForInstance(i.Title).Error.Print(err)
+		// Nothing has been restored into a partial worktree yet, so it can simply go.
+		if cleanupErr := i.gitWorktree.CleanupWorktree(); cleanupErr != nil {
+			err = fmt.Errorf("%w (cleanup error: %v)", err, cleanupErr)
+		}
 		return fmt.Errorf("failed to setup git worktree: %w", err)
 	}
+	if err := ctx.Err(); err != nil {
+		if cleanupErr := i.gitWorktree.CleanupWorktree(); cleanupErr != nil {
+			err = fmt.Errorf("%w (cleanup error: %v)", err, cleanupErr)
+		}
+		return fmt.Errorf("resume of %s interrupted: %w", i.Title, err)
+	}
+	// Bring back changes stashed by Pause. On failure the stash entry is kept, so nothing is lost.
+	if _, err := i.gitWorktree.PopStash(pauseStashMessage(i.Title)); err != nil {
+		log.ForInstance(i.Title).Warning.Printf("failed to restore stashed changes of %s: %v", i.Title, err)
+	}
 
 	// Check if tmux session still exists from pause, otherwise create new one
+	restored := false
 	if i.tmuxSession.DoesSessionExist() {
 		// Session exists, just restore PTY connection to it
 		if err := i.tmuxSession.Restore(); err != nil {
-			log.ErrorLog.Print(err)
 			// If restore fails, fall back to creating new session
-			if err := i.tmuxSession.Start(i.gitWorktree.GetWorktreePath()); err != nil {
-				log.ErrorLog.Print(err)
-				// Cleanup git worktree if tmux session creation fails
-				if cleanupErr := i.gitWorktree.Cleanup(); cleanupErr != nil {
-					err = fmt.Errorf("%v (cleanup error: %v)", err, cleanupErr)
-					log.ErrorLog.Print(err)
-				}
-				return fmt.Errorf("failed to start new session: %w", err)
-			}
+			log.ForInstance(i.Title).Error.Print(err)
+		} else {
+			restored = true
 		}
-	} else {
+	}
+	if !restored {
 		// Create new tmux session
-		if err := i.tmuxSession.Start(i.gitWorktree.GetWorktreePath()); err != nil {
-			log.ErrorLog.Print(err)
+		workDir, err := i.agentDir()
+		if err == nil {
+			err = i.tmuxSession.StartContext(ctx, workDir)
+		}
+		if err != nil {
+			log.ForInstance(i.Title).Error.Print(err)
 			// Cleanup git worktree if tmux session creation fails
-			if cleanupErr := i.gitWorktree.Cleanup(); cleanupErr != nil {
+			if cleanupErr := i.abandonResume(); cleanupErr != nil {
 				err = fmt.Errorf("%v (cleanup error: %v)", err, cleanupErr)
-				log.ErrorLog.Print(err)
+				log.ForInstance(i.Title).Error.Print(err)
 			}
 			return fmt.Errorf("failed to start new session: %w", err)
 		}
@@ -617,6 +1722,8 @@ func (i *Instance) Resume() error {
 		return fmt.Errorf("failed to initialize diff watcher: %w", err)
 	}
 
+	i.startTranscript(cfg)
+	i.startLogging()
 	i.MarkPreviewDirty()
 	i.MarkDiffDirty()
 	i.lastDiffCheck.Store(0)
@@ -624,6 +1731,7 @@ func (i *Instance) Resume() error {
 
 	// Sync branch from gitWorktree after resume
 	i.GetBranch()
+	i.emit(EventResumed)
 
 	return nil
 }
@@ -631,12 +1739,17 @@ func (i *Instance) Resume() error {
 // UpdateDiffStats updates the git diff statistics for this instance
 func (i *Instance) UpdateDiffStats(now time.Time) error {
 	if !i.started {
-		i.diffStats = nil
+		i.setDiffStats(nil)
+		return nil
+	}
+
+	if i.Paused() || i.GetStatus() == Detached {
+		// Keep the previous diff stats if the instance is paused or its worktree is gone
 		return nil
 	}
 
-	if i.Status == Paused {
-		// Keep the previous diff stats if the instance is paused
+	if _, err := os.Stat(i.gitWorktree.GetWorktreePath()); os.IsNotExist(err) {
+		i.detachMissingWorktree()
 		return nil
 	}
 
@@ -654,7 +1767,7 @@ func (i *Instance) UpdateDiffStats(now time.Time) error {
 			force = true
 		} else {
 			last := time.Unix(0, i.lastDiffCheck.Load())
-			if last.IsZero() || now.Sub(last) >= diffRefreshInterval {
+			if last.IsZero() || now.Sub(last) >= i.RefreshInterval() {
 				refresh = true
 				force = true
 			}
@@ -668,28 +1781,185 @@ func (i *Instance) UpdateDiffStats(now time.Time) error {
 	i.diffMu.Lock()
 	defer i.diffMu.Unlock()
 
+	// Back off while diffs keep failing. A zero now asks for an immediate refresh.
+	if !now.IsZero() && now.Before(i.diffRetryAt) {
+		if dirty {
+			i.diffDirty.Store(true)
+		}
+		return nil
+	}
+
 	stats := i.gitWorktree.Diff(force)
 	if stats.Error != nil {
 		if strings.Contains(stats.Error.Error(), "base commit SHA not set") {
 			// Worktree is not fully set up yet, not an error
-			i.diffStats = nil
+			i.setDiffStats(nil)
 			i.MarkDiffDirty()
 			return nil
 		}
 		i.MarkDiffDirty()
+		i.diffFailures++
+		if now.IsZero() {
+			now = time.Now()
+		}
+		i.diffRetryAt = now.Add(diffBackoff(i.diffFailures))
 		return fmt.Errorf("failed to get diff stats: %w", stats.Error)
 	}
 
-	i.diffStats = stats
+	i.diffFailures = 0
+	i.diffRetryAt = time.Time{}
+	i.setDiffStats(stats)
 	i.lastDiffCheck.Store(now.UnixNano())
+	if count, err := i.gitWorktree.CommitCount(); err == nil {
+		i.commitCount = count
+	}
 	return nil
 }
 
+// diffBackoff returns how long to wait before the next diff after the given number of
+// consecutive failures.
+func diffBackoff(failures int) time.Duration {
+	// Stop doubling well before the shift could overflow.
+	backoff := diffErrorBackoff << min(failures-1, 16)
+	return min(backoff, diffErrorBackoffMax)
+}
+
+// CommitCount returns how many commits the session made on top of its base commit. For a paused
+// instance it returns the count from when it was paused.
+func (i *Instance) CommitCount() (int, error) {
+	if !i.started {
+		return 0, fmt.Errorf("cannot count commits of instance that has not been started")
+	}
+	if i.Paused() || i.GetStatus() == Detached {
+		return i.commitCount, nil
+	}
+	count, err := i.gitWorktree.CommitCount()
+	if err != nil {
+		return 0, err
+	}
+	i.commitCount = count
+	return count, nil
+}
+
+// LastCommit returns the latest commit on the session's branch, including for a paused instance.
+func (i *Instance) LastCommit() (git.CommitInfo, error) {
+	if !i.started || i.gitWorktree == nil {
+		return git.CommitInfo{}, fmt.Errorf("cannot read the last commit of instance that has not been started")
+	}
+	return i.gitWorktree.LastCommit()
+}
+
+// detachMissingWorktree stops watching a worktree that was deleted externally and moves the
+// instance to Detached, so the missing directory is reported once instead of on every refresh.
+func (i *Instance) detachMissingWorktree() {
+	log.ForInstance(i.Title).Warning.Printf("worktree %s of instance %s was deleted; use Recover to recreate it",
+		i.gitWorktree.GetWorktreePath(), i.Title)
+	if err := i.stopDiffWatcher(); err != nil {
+		log.ForInstance(i.Title).Warning.Printf("failed to stop diff watcher for %s: %v", i.Title, err)
+	}
+	i.SetStatus(Detached)
+}
+
+// Recover recreates the worktree of a Detached instance from its branch and resumes watching it.
+// Commits on the branch survive; uncommitted changes that were in the deleted directory do not.
+// The program keeps running in its tmux session, or is restarted if the session is gone.
+func (i *Instance) Recover() error {
+	if !i.started || i.GetStatus() != Detached {
+		return fmt.Errorf("can only recover instances whose worktree is missing")
+	}
+
+	// Forget the deleted worktree so it can be added again at the same path.
+	if err := i.gitWorktree.Prune(); err != nil {
+		return err
+	}
+	i.gitWorktree.ApplyConfig(config.LoadConfig())
+	if err := i.gitWorktree.Setup(); err != nil {
+		log.ForInstance(i.Title).Error.Print(err)
+		return fmt.Errorf("failed to setup git worktree: %w", err)
+	}
+
+	if !i.tmuxSession.DoesSessionExist() {
+		workDir, err := i.agentDir()
+		if err != nil {
+			return err
+		}
+		if err := i.tmuxSession.Start(workDir); err != nil {
+			log.ForInstance(i.Title).Error.Print(err)
+			return fmt.Errorf("failed to start new session: %w", err)
+		}
+		i.startLogging()
+	}
+
+	if err := i.startDiffWatcher(); err != nil {
+		return fmt.Errorf("failed to initialize diff watcher: %w", err)
+	}
+
+	i.MarkPreviewDirty()
+	i.lastDiffCheck.Store(0)
+	i.SetStatus(Running)
+	return nil
+}
+
+// DiffPaths returns the instance's diff limited to changes under paths, e.g. one service of a
+// monorepo. See git.GitWorktree.DiffPaths.
+func (i *Instance) DiffPaths(paths []string) (*git.DiffStats, error) {
+	return i.DiffWithOptions(git.DiffOptions{Paths: paths})
+}
+
+// DiffWithOptions returns the instance's diff configured by opts, e.g. without whitespace-only
+// changes. The stats shown in the UI are unaffected. See git.GitWorktree.DiffWithOptions.
+func (i *Instance) DiffWithOptions(opts git.DiffOptions) (*git.DiffStats, error) {
+	if err := i.checkWorktreeAvailable("diff"); err != nil {
+		return nil, err
+	}
+	stats := i.gitWorktree.DiffWithOptions(opts, false)
+	if stats.Error != nil {
+		return nil, stats.Error
+	}
+	return stats, nil
+}
+
+// DiffWorkDir returns the instance's diff limited to changes under its WorkDir, or the whole
+// worktree if it has none.
+func (i *Instance) DiffWorkDir() (*git.DiffStats, error) {
+	var paths []string
+	if i.WorkDir != "" && i.WorkDir != "." {
+		paths = []string{i.WorkDir}
+	}
+	return i.DiffPaths(paths)
+}
+
 // GetDiffStats returns the current git diff statistics
 func (i *Instance) GetDiffStats() *git.DiffStats {
+	i.stateMu.RLock()
+	defer i.stateMu.RUnlock()
 	return i.diffStats
 }
 
+func (i *Instance) setDiffStats(stats *git.DiffStats) {
+	i.stateMu.Lock()
+	i.diffStats = stats
+	i.stateMu.Unlock()
+}
+
+// CopyDiffToClipboard copies the instance's diff to the clipboard. The cached diff is used unless
+// it is known to be stale.
+func (i *Instance) CopyDiffToClipboard() error {
+	if !i.Paused() && (i.GetDiffStats() == nil || i.diffDirty.Load()) {
+		if err := i.UpdateDiffStats(time.Now()); err != nil {
+			return err
+		}
+	}
+	stats := i.GetDiffStats()
+	if stats == nil || stats.IsEmpty() {
+		return fmt.Errorf("no changes to copy for instance %s", i.Title)
+	}
+	if err := clipboard.WriteAll(stats.Content); err != nil {
+		return fmt.Errorf("failed to copy diff to clipboard: %w", err)
+	}
+	return nil
+}
+
 // SendPrompt sends a prompt to the tmux session
 func (i *Instance) SendPrompt(prompt string) error {
 	if !i.started {
@@ -698,12 +1968,30 @@ func (i *Instance) SendPrompt(prompt string) error {
 	if i.tmuxSession == nil {
 		return fmt.Errorf("tmux session not initialized")
 	}
+	if i.ReadOnly {
+		return fmt.Errorf("cannot send prompt to %s: %w", i.Title, ErrReadOnly)
+	}
+	if i.promptInterceptor != nil {
+		rewritten, err := i.promptInterceptor(prompt)
+		if err != nil {
+			return fmt.Errorf("prompt blocked by interceptor: %w", err)
+		}
+		prompt = rewritten
+	}
+	i.lastActivity.Store(time.Now().UnixNano())
+	// Typed newlines would submit each line separately, so multi-line prompts are always pasted.
+	if i.pastePrompts || strings.Contains(prompt, "\n") {
+		if err := i.tmuxSession.PastePrompt(prompt); err != nil {
+			return err
+		}
+		return nil
+	}
 	if err := i.tmuxSession.SendKeys(prompt); err != nil {
 		return fmt.Errorf("error sending keys to tmux session: %w", err)
 	}
 
 	// Brief pause to prevent carriage return from being interpreted as newline
-	time.Sleep(100 * time.Millisecond)
+	time.Sleep(i.tmuxSession.EnterDelay())
 	if err := i.tmuxSession.TapEnter(); err != nil {
 		return fmt.Errorf("error tapping enter: %w", err)
 	}
@@ -724,14 +2012,14 @@ func (i *Instance) startDiffWatcher() error {
 	// Clean up any existing watcher before starting a new one
 	if i.diffWatcher != nil {
 		if err := i.stopDiffWatcher(); err != nil {
-			log.WarningLog.Printf("failed to stop existing diff watcher for %s: %v", i.Title, err)
+			log.ForInstance(i.Title).Warning.Printf("failed to stop existing diff watcher for %s: %v", i.Title, err)
 		}
 	}
 
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		i.diffWatcherDisabled = true
-		log.WarningLog.Printf("disabling diff watcher for %s: %v", i.Title, err)
+		log.ForInstance(i.Title).Warning.Printf("disabling diff watcher for %s: %v", i.Title, err)
 		i.MarkDiffDirty()
 		return nil
 	}
@@ -739,19 +2027,21 @@ func (i *Instance) startDiffWatcher() error {
 	ctx, cancel := context.WithCancel(context.Background())
 	i.diffWatcher = watcher
 	i.diffWatcherDisabled = false
+	i.diffWatchDirs = 0
+	i.diffWatchPartial = false
 	i.diffWatchCtx = ctx
 	i.diffWatchCancel = cancel
 
 	if err := i.addWatcherRecursive(worktreePath); err != nil {
 		cancel()
 		if closeErr := watcher.Close(); closeErr != nil {
-			log.WarningLog.Printf("failed to close diff watcher for %s: %v", i.Title, closeErr)
+			log.ForInstance(i.Title).Warning.Printf("failed to close diff watcher for %s: %v", i.Title, closeErr)
 		}
 		i.diffWatcher = nil
 		i.diffWatchCtx = nil
 		i.diffWatchCancel = nil
 		i.diffWatcherDisabled = true
-		log.WarningLog.Printf("disabling diff watcher for %s: %v", i.Title, err)
+		log.ForInstance(i.Title).Warning.Printf("disabling diff watcher for %s: %v", i.Title, err)
 		i.MarkDiffDirty()
 		return nil
 	}
@@ -782,7 +2072,7 @@ func (i *Instance) stopDiffWatcher() error {
 	i.diffWatchCtx = nil
 	i.diffWatchCancel = nil
 
-	return i.combineErrors(errs)
+	return combineErrors(errs)
 }
 
 func (i *Instance) runDiffWatcher() {
@@ -803,7 +2093,7 @@ func (i *Instance) runDiffWatcher() {
 			if event.Op&fsnotify.Create != 0 {
 				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
 					if err := i.addWatcherRecursive(event.Name); err != nil {
-						log.WarningLog.Printf("failed to watch new directory %s for %s: %v",
+						log.ForInstance(i.Title).Warning.Printf("failed to watch new directory %s for %s: %v",
 							event.Name, i.Title, err)
 					}
 				}
@@ -812,7 +2102,7 @@ func (i *Instance) runDiffWatcher() {
 			if !ok {
 				return
 			}
-			log.WarningLog.Printf("diff watcher error for %s: %v", i.Title, err)
+			log.ForInstance(i.Title).Warning.Printf("diff watcher error for %s: %v", i.Title, err)
 		}
 	}
 }
@@ -835,13 +2125,35 @@ func (i *Instance) addWatcherRecursive(root string) error {
 			return filepath.SkipDir
 		}
 
+		if i.diffWatchPartial {
+			return filepath.SkipAll
+		}
+		if i.maxWatchDirs > 0 && i.diffWatchDirs >= i.maxWatchDirs {
+			i.startPartialWatch(fmt.Sprintf("max_watch_dirs (%d) reached", i.maxWatchDirs))
+			return filepath.SkipAll
+		}
+
 		if err := i.diffWatcher.Add(path); err != nil {
+			if errors.Is(err, syscall.ENOSPC) {
+				// Out of inotify watches: keep the ones we have rather than giving up on all of them.
+				i.startPartialWatch(err.Error())
+				return filepath.SkipAll
+			}
 			return err
 		}
+		i.diffWatchDirs++
 		return nil
 	})
 }
 
+// startPartialWatch stops the diff watcher from adding more directories, leaving changes in the
+// unwatched ones to the periodic refresh.
+func (i *Instance) startPartialWatch(reason string) {
+	i.diffWatchPartial = true
+	log.ForInstance(i.Title).Warning.Printf("only watching %d directories of %s for changes (%s), "+
+		"the rest is refreshed every %s", i.diffWatchDirs, i.Title, reason, i.RefreshInterval())
+}
+
 func (i *Instance) shouldIgnoreWatch(path string) bool {
 	if i.gitWorktree == nil {
 		return false
@@ -865,23 +2177,231 @@ func (i *Instance) shouldIgnoreWatch(path string) bool {
 	return false
 }
 
+// StreamOutput sends new lines of the instance's pane output as they appear. The channel is
+// closed when ctx is cancelled or the tmux session dies.
+func (i *Instance) StreamOutput(ctx context.Context) (<-chan string, error) {
+	if !i.started || i.Paused() {
+		return nil, fmt.Errorf("cannot stream output of instance that has not been started or is paused")
+	}
+	return i.tmuxSession.StreamOutput(ctx, outputStreamInterval), nil
+}
+
 // PreviewFullHistory captures the entire tmux pane output including full scrollback history
 func (i *Instance) PreviewFullHistory() (string, error) {
-	if !i.started || i.Status == Paused {
+	if !i.started || i.Paused() {
 		return "", nil
 	}
 	return i.tmuxSession.CapturePaneContentWithOptions("-", "-")
 }
 
+// transcriptFileName turns an instance title into a safe transcript file name.
+func transcriptFileName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, title)
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		name = "instance"
+	}
+	return name + ".log"
+}
+
+// startTranscript opens the instance's transcript if cfg enables transcript logging. Failures are
+// logged rather than returned so they never block starting the instance.
+func (i *Instance) startTranscript(cfg *config.Config) {
+	if cfg.TranscriptDir == "" || i.transcript != nil {
+		return
+	}
+	path := filepath.Join(cfg.TranscriptDir, transcriptFileName(i.Title))
+	writer, err := tmux.NewTranscriptWriter(path, cfg.TranscriptMaxBytes)
+	if err != nil {
+		log.ForInstance(i.Title).Warning.Printf("failed to open transcript for %s: %v", i.Title, err)
+		return
+	}
+	i.transcript = writer
+	i.transcriptPath = path
+}
+
+// stopTranscript closes the instance's transcript. The path is kept so Transcript still works.
+func (i *Instance) stopTranscript() error {
+	if i.transcript == nil {
+		return nil
+	}
+	err := i.transcript.Close()
+	i.transcript = nil
+	return err
+}
+
+// SetLogging pipes the instance's pane output to the file at path using tmux pipe-pane, or stops
+// piping if path is empty. The pipe is restarted whenever the instance's tmux session is started
+// or resumed, and stopped while the instance is paused.
+func (i *Instance) SetLogging(path string) error {
+	i.logPath = path
+	if !i.started || i.Paused() {
+		return nil
+	}
+	if path == "" {
+		return i.tmuxSession.StopLogging()
+	}
+	return i.tmuxSession.StartLogging(path, i.logMaxBytes)
+}
+
+// startLogging starts piping the pane to logPath, if set. Failures are logged rather than
+// returned so that logging never prevents a session from starting.
+func (i *Instance) startLogging() {
+	if i.logPath == "" {
+		return
+	}
+	if err := i.tmuxSession.StartLogging(i.logPath, i.logMaxBytes); err != nil {
+		log.ForInstance(i.Title).Warning.Printf("failed to start logging for %s: %v", i.Title, err)
+	}
+}
+
+// appendTranscript captures the pane and appends its new lines to the transcript.
+func (i *Instance) appendTranscript() {
+	content, err := i.tmuxSession.CapturePaneContent()
+	if err != nil {
+		log.ForInstance(i.Title).Warning.Printf("failed to capture pane for transcript of %s: %v", i.Title, err)
+		return
+	}
+	if err := i.transcript.AppendPane(content); err != nil {
+		log.ForInstance(i.Title).Warning.Printf("failed to write transcript for %s: %v", i.Title, err)
+	}
+}
+
+// Transcript returns the instance's on-disk transcript, including rotated files in order.
+func (i *Instance) Transcript() (string, error) {
+	if i.transcriptPath == "" {
+		return "", fmt.Errorf("transcript logging is not enabled for instance %s", i.Title)
+	}
+	return tmux.ReadTranscript(i.transcriptPath)
+}
+
+// SetDiffExcludes sets pathspec patterns (e.g. "*.lock" or "vendor/**") to leave out of this
+// instance's diff. The patterns are persisted with the instance.
+func (i *Instance) SetDiffExcludes(patterns []string) {
+	i.diffExcludes = slices.Clone(patterns)
+	if i.gitWorktree != nil {
+		i.gitWorktree.SetDiffExcludes(i.diffExcludes)
+	}
+	i.MarkDiffDirty()
+}
+
+// DiffExcludes returns the pathspec patterns left out of this instance's diff.
+func (i *Instance) DiffExcludes() []string {
+	return slices.Clone(i.diffExcludes)
+}
+
+// SetRefreshInterval sets how often UpdateDiffStats refreshes the diff when no change has been
+// detected. Zero or a negative value restores the default. The interval is persisted with the
+// instance.
+func (i *Instance) SetRefreshInterval(interval time.Duration) {
+	i.refreshInterval = max(interval, 0)
+}
+
+// RefreshInterval returns how often UpdateDiffStats refreshes the diff when no change has been
+// detected.
+func (i *Instance) RefreshInterval() time.Duration {
+	if i.refreshInterval != 0 {
+		return i.refreshInterval
+	}
+	if i.configRefreshInterval != 0 {
+		return i.configRefreshInterval
+	}
+	return config.DefaultDiffRefreshInterval
+}
+
+// SetPromptInterceptor installs an interceptor consulted by SendPrompt. Pass nil to remove it.
+func (i *Instance) SetPromptInterceptor(interceptor PromptInterceptor) {
+	i.promptInterceptor = interceptor
+}
+
 // SetTmuxSession sets the tmux session for testing purposes
 func (i *Instance) SetTmuxSession(session *tmux.TmuxSession) {
 	i.tmuxSession = session
 }
 
+// shellWindow is the name of the tmux window opened by OpenShell.
+const shellWindow = "shell"
+
+// OpenShell opens a "shell" window running $SHELL in the instance's worktree, next to the agent.
+// It is a no-op if the window already exists. The window goes away with the tmux session.
+func (i *Instance) OpenShell() error {
+	if err := i.checkWorktreeAvailable("open shell for"); err != nil {
+		return err
+	}
+	windows, err := i.tmuxSession.ListWindows()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(windows, shellWindow) {
+		return nil
+	}
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	return i.tmuxSession.NewWindowInDir(shellWindow, i.gitWorktree.GetWorktreePath(), shell)
+}
+
+// HistoryLimit returns the scrollback size tmux keeps for the instance's pane. PreviewFullHistory
+// can't return more lines than this.
+func (i *Instance) HistoryLimit() (int, error) {
+	if !i.started || i.Paused() {
+		return 0, fmt.Errorf("cannot get history limit of instance that has not been started or is paused")
+	}
+	return i.tmuxSession.HistoryLimit()
+}
+
+// Interrupt sends Ctrl-C to the instance to cancel the agent's current action.
+func (i *Instance) Interrupt() error {
+	if !i.started || i.Paused() {
+		return fmt.Errorf("cannot interrupt instance that has not been started or is paused")
+	}
+	if i.ReadOnly {
+		return fmt.Errorf("cannot interrupt %s: %w", i.Title, ErrReadOnly)
+	}
+	defer i.MarkPreviewDirty()
+	return i.tmuxSession.SendInterrupt()
+}
+
+// DoubleInterrupt sends Ctrl-C twice, for programs that need a second press to stop.
+func (i *Instance) DoubleInterrupt() error {
+	if !i.started || i.Paused() {
+		return fmt.Errorf("cannot interrupt instance that has not been started or is paused")
+	}
+	if i.ReadOnly {
+		return fmt.Errorf("cannot interrupt %s: %w", i.Title, ErrReadOnly)
+	}
+	defer i.MarkPreviewDirty()
+	return i.tmuxSession.SendDoubleInterrupt()
+}
+
+// SendKeySequence sends named keys such as "Escape" or "C-c" to the instance. See
+// tmux.TmuxSession.SendSpecialKeys.
+func (i *Instance) SendKeySequence(keys ...string) error {
+	if !i.started || i.Paused() {
+		return fmt.Errorf("cannot send keys to instance that has not been started or is paused")
+	}
+	if i.ReadOnly {
+		return fmt.Errorf("cannot send keys to %s: %w", i.Title, ErrReadOnly)
+	}
+	defer i.MarkPreviewDirty()
+	return i.tmuxSession.SendSpecialKeys(keys...)
+}
+
 // SendKeys sends keys to the tmux session
 func (i *Instance) SendKeys(keys string) error {
-	if !i.started || i.Status == Paused {
+	if !i.started || i.Paused() {
 		return fmt.Errorf("cannot send keys to instance that has not been started or is paused")
 	}
+	if i.ReadOnly {
+		return fmt.Errorf("cannot send keys to %s: %w", i.Title, ErrReadOnly)
+	}
 	return i.tmuxSession.SendKeys(keys)
 }
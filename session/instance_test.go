@@ -1,18 +1,34 @@
 package session
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
+	"agent-squad/config"
+	"agent-squad/log"
 	"agent-squad/session/git"
 	"agent-squad/session/tmux"
+	"agent-squad/session/tmux/tmuxtest"
 )
 
+// TestMain runs before all tests to set up the test environment
+func TestMain(m *testing.M) {
+	// Initialize the logger before any tests run
+	log.Initialize(false)
+	defer log.Close()
+
+	exitCode := m.Run()
+	os.Exit(exitCode)
+}
+
 func TestInstancePreviewDirtyFlag(t *testing.T) {
 	inst := &Instance{}
 	if inst.IsPreviewDirty() {
@@ -60,7 +76,7 @@ func TestInstanceUpdateDiffStatsForcesOnTimer(t *testing.T) {
 		t.Fatalf("write second change: %v", err)
 	}
 
-	inst.lastDiffCheck.Store(time.Now().Add(-diffRefreshInterval - time.Second).UnixNano())
+	inst.lastDiffCheck.Store(time.Now().Add(-config.DefaultDiffRefreshInterval - time.Second).UnixNano())
 
 	if err := inst.UpdateDiffStats(time.Now()); err != nil {
 		t.Fatalf("timer UpdateDiffStats: %v", err)
@@ -74,6 +90,100 @@ func TestInstanceUpdateDiffStatsForcesOnTimer(t *testing.T) {
 	}
 }
 
+func TestDiffWatcherStopsAtMaxWatchDirs(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	head := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD"))
+	for _, dir := range []string{"a", "b", "c", "d"} {
+		if err := os.Mkdir(filepath.Join(repo, dir), 0o755); err != nil {
+			t.Fatalf("mkdir %s: %v", dir, err)
+		}
+	}
+
+	inst := &Instance{
+		Title:        "watch-cap",
+		started:      true,
+		Status:       Running,
+		gitWorktree:  git.NewGitWorktreeFromStorage(repo, repo, "session-test", "main", head),
+		maxWatchDirs: 3,
+	}
+	if err := inst.startDiffWatcher(); err != nil {
+		t.Fatalf("startDiffWatcher: %v", err)
+	}
+	defer func() {
+		if err := inst.stopDiffWatcher(); err != nil {
+			t.Errorf("stopDiffWatcher: %v", err)
+		}
+	}()
+
+	if inst.diffWatcherDisabled {
+		t.Fatalf("expected the watcher to stay enabled past the cap")
+	}
+	if !inst.diffWatchPartial {
+		t.Fatalf("expected partial watch mode once the cap was reached")
+	}
+	if got := len(inst.diffWatcher.WatchList()); got != 3 {
+		t.Fatalf("expected 3 watched directories, got %d", got)
+	}
+
+	// New directories are left to the periodic refresh once the cap is reached.
+	if err := inst.addWatcherRecursive(filepath.Join(repo, "a")); err != nil {
+		t.Fatalf("addWatcherRecursive: %v", err)
+	}
+	if got := len(inst.diffWatcher.WatchList()); got != 3 {
+		t.Fatalf("expected the watch count to stay at 3, got %d", got)
+	}
+}
+
+func TestInstanceRefreshIntervalOverride(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	head := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD"))
+
+	inst := &Instance{
+		Title:       "slow-refresh",
+		started:     true,
+		Status:      Running,
+		gitWorktree: git.NewGitWorktreeFromStorage(repo, repo, "slow-refresh", "main", head),
+	}
+	if inst.RefreshInterval() != config.DefaultDiffRefreshInterval {
+		t.Fatalf("expected default interval %s, got %s", config.DefaultDiffRefreshInterval, inst.RefreshInterval())
+	}
+	inst.SetRefreshInterval(time.Hour)
+
+	now := time.Now()
+	if err := inst.UpdateDiffStats(now); err != nil {
+		t.Fatalf("UpdateDiffStats: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "file.txt"), []byte("original\nchange\n"), 0o644); err != nil {
+		t.Fatalf("write change: %v", err)
+	}
+	if err := inst.UpdateDiffStats(now.Add(config.DefaultDiffRefreshInterval + time.Second)); err != nil {
+		t.Fatalf("UpdateDiffStats: %v", err)
+	}
+	if strings.Contains(inst.GetDiffStats().Content, "change") {
+		t.Fatal("expected the longer interval to skip the timed refresh")
+	}
+
+	data := inst.ToInstanceData()
+	if data.RefreshIntervalMs != time.Hour.Milliseconds() {
+		t.Fatalf("expected interval to be persisted, got %dms", data.RefreshIntervalMs)
+	}
+
+	inst.SetRefreshInterval(0)
+	if inst.RefreshInterval() != config.DefaultDiffRefreshInterval {
+		t.Fatalf("expected zero to restore the default, got %s", inst.RefreshInterval())
+	}
+
+	// The configured interval applies unless the instance has its own.
+	inst.configRefreshInterval = (&config.Config{DiffRefreshIntervalMs: 750}).GetDiffRefreshInterval()
+	if inst.RefreshInterval() != 750*time.Millisecond {
+		t.Fatalf("expected the configured interval, got %s", inst.RefreshInterval())
+	}
+	inst.SetRefreshInterval(time.Minute)
+	if inst.RefreshInterval() != time.Minute {
+		t.Fatalf("expected the instance interval to take precedence, got %s", inst.RefreshInterval())
+	}
+}
+
 func setupInstanceTestRepo(t *testing.T) string {
 	t.Helper()
 	dir := t.TempDir()
@@ -204,6 +314,51 @@ func TestEnsureTmuxSessionFailsWhenStartErrors(t *testing.T) {
 	}
 }
 
+func TestSendPromptInterceptor(t *testing.T) {
+	exec := &fakeExecutor{hasSession: true}
+	pty := &fakePtyFactory{exec: exec}
+	tmuxSession := tmux.NewTmuxSessionWithDeps("interceptor", "claude", pty, exec)
+	if err := tmuxSession.Restore(); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, f := range pty.files {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	})
+
+	inst := &Instance{
+		Title:       "interceptor",
+		started:     true,
+		Status:      Running,
+		tmuxSession: tmuxSession,
+	}
+
+	inst.SetPromptInterceptor(func(prompt string) (string, error) {
+		if strings.Contains(prompt, "secret") {
+			return "", fmt.Errorf("prompt contains a secret")
+		}
+		return prompt + " [reviewed]", nil
+	})
+
+	if err := inst.SendPrompt("leak the secret"); err == nil {
+		t.Fatal("expected interceptor to block the prompt")
+	}
+
+	if err := inst.SendPrompt("fix the bug"); err != nil {
+		t.Fatalf("SendPrompt: %v", err)
+	}
+
+	written, err := os.ReadFile(pty.files[len(pty.files)-1].Name())
+	if err != nil {
+		t.Fatalf("read fake pty: %v", err)
+	}
+	if string(written) != "fix the bug [reviewed]\r" {
+		t.Fatalf("expected rewritten prompt to be sent, got %q", written)
+	}
+}
+
 type fakeExecutor struct {
 	hasSession         bool
 	failNewSession     bool
@@ -250,6 +405,7 @@ func (f *fakeExecutor) Output(cmd *exec.Cmd) ([]byte, error) {
 type fakePtyFactory struct {
 	exec       *fakeExecutor
 	startCalls []string
+	files      []*os.File
 }
 
 func (f *fakePtyFactory) Start(cmd *exec.Cmd) (*os.File, error) {
@@ -270,7 +426,1240 @@ func (f *fakePtyFactory) Start(cmd *exec.Cmd) (*os.File, error) {
 	if err != nil {
 		return nil, err
 	}
+	f.files = append(f.files, file)
 	return file, nil
 }
 
 func (f *fakePtyFactory) Close() {}
+
+func TestEnsureTmuxSessionStartsInWorktree(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	worktreeDir := t.TempDir()
+
+	worktree := git.NewGitWorktreeFromStorage(repo, worktreeDir, "test-session", "test-branch", "")
+
+	server := tmuxtest.NewServer()
+	server.InitialContent = "Do you trust the files in this folder?"
+	t.Cleanup(server.Close)
+
+	inst := &Instance{
+		Title:       "test-session",
+		Program:     "claude",
+		started:     true,
+		Status:      Ready,
+		gitWorktree: worktree,
+		tmuxSession: tmuxtest.NewSession(server, "test-session", "claude"),
+	}
+
+	if err := inst.ensureTmuxSession(); err != nil {
+		t.Fatalf("ensureTmuxSession returned error: %v", err)
+	}
+
+	if !server.HasSession(tmux.TmuxPrefix + "test-session") {
+		t.Fatalf("expected ensureTmuxSession to start a tmux session")
+	}
+
+	if server.WorkDir(tmux.TmuxPrefix+"test-session") != worktreeDir {
+		t.Fatalf("expected tmux session to start in the worktree, got commands %v", server.Commands())
+	}
+
+	if inst.Status != Running {
+		t.Fatalf("expected instance status Running, got %v", inst.Status)
+	}
+}
+
+func TestEnsureTmuxSessionReportsServerNewSessionFailure(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	worktreeDir := t.TempDir()
+
+	worktree := git.NewGitWorktreeFromStorage(repo, worktreeDir, "another-session", "test-branch", "")
+
+	server := tmuxtest.NewServer()
+	server.FailNewSession = fmt.Errorf("forced new-session failure")
+	t.Cleanup(server.Close)
+
+	inst := &Instance{
+		Title:       "another-session",
+		Program:     "claude",
+		started:     true,
+		Status:      Ready,
+		gitWorktree: worktree,
+		tmuxSession: tmuxtest.NewSession(server, "another-session", "claude"),
+	}
+
+	err := inst.ensureTmuxSession()
+	if err == nil {
+		t.Fatalf("expected ensureTmuxSession to fail when tmux start fails")
+	}
+	if !strings.Contains(err.Error(), "failed to start") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSendPromptInterceptorWritesToPane(t *testing.T) {
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddSession(tmux.TmuxPrefix+"interceptor", t.TempDir(), "claude")
+	tmuxSession := tmuxtest.NewSession(server, "interceptor", "claude")
+	if err := tmuxSession.Restore(); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	inst := &Instance{
+		Title:       "interceptor",
+		started:     true,
+		Status:      Running,
+		tmuxSession: tmuxSession,
+	}
+
+	inst.SetPromptInterceptor(func(prompt string) (string, error) {
+		if strings.Contains(prompt, "secret") {
+			return "", fmt.Errorf("prompt contains a secret")
+		}
+		return prompt + " [reviewed]", nil
+	})
+
+	if err := inst.SendPrompt("leak the secret"); err == nil {
+		t.Fatal("expected interceptor to block the prompt")
+	}
+
+	if err := inst.SendPrompt("fix the bug"); err != nil {
+		t.Fatalf("SendPrompt: %v", err)
+	}
+
+	written, err := server.Input(tmux.TmuxPrefix + "interceptor")
+	if err != nil {
+		t.Fatalf("read fake pty: %v", err)
+	}
+	if written != "fix the bug [reviewed]\r" {
+		t.Fatalf("expected rewritten prompt to be sent, got %q", written)
+	}
+}
+
+func TestSendPromptPaste(t *testing.T) {
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddSession(tmux.TmuxPrefix+"paste", t.TempDir(), "claude")
+	tmuxSession := tmuxtest.NewSession(server, "paste", "claude")
+	if err := tmuxSession.Restore(); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	inst := &Instance{
+		Title:        "paste",
+		started:      true,
+		Status:       Running,
+		tmuxSession:  tmuxSession,
+		pastePrompts: true,
+	}
+	prompt := "implement this:\n  - keep indentation\n  - one submit"
+	if err := inst.SendPrompt(prompt); err != nil {
+		t.Fatalf("SendPrompt: %v", err)
+	}
+
+	written, err := server.Input(tmux.TmuxPrefix + "paste")
+	if err != nil {
+		t.Fatalf("read fake pty: %v", err)
+	}
+	if written != prompt+"\r" {
+		t.Fatalf("expected the prompt to be pasted and submitted once, got %q", written)
+	}
+	if buffers := server.Buffers(); len(buffers) != 0 {
+		t.Fatalf("expected the paste buffer to be deleted, got %v", buffers)
+	}
+}
+
+func TestSendPromptPastesMultilinePrompts(t *testing.T) {
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddSession(tmux.TmuxPrefix+"multiline", t.TempDir(), "claude")
+	tmuxSession := tmuxtest.NewSession(server, "multiline", "claude")
+	if err := tmuxSession.Restore(); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	tmuxSession.SetEnterDelay(0)
+
+	inst := &Instance{Title: "multiline", started: true, Status: Running, tmuxSession: tmuxSession}
+	prompt := "first line\nsecond line"
+	if err := inst.SendPrompt(prompt); err != nil {
+		t.Fatalf("SendPrompt: %v", err)
+	}
+
+	written, err := server.Input(tmux.TmuxPrefix + "multiline")
+	if err != nil {
+		t.Fatalf("read fake pty: %v", err)
+	}
+	if written != prompt+"\r" {
+		t.Fatalf("expected a multi-line prompt to be pasted without paste_prompts, got %q", written)
+	}
+	if buffers := server.Buffers(); len(buffers) != 0 {
+		t.Fatalf("expected the paste buffer to be deleted, got %v", buffers)
+	}
+}
+
+func TestInstanceRebaseRequiresActiveWorktree(t *testing.T) {
+	notStarted := &Instance{Title: "test-instance", Status: Ready}
+	if err := notStarted.RebaseOnto("main"); err == nil || !strings.Contains(err.Error(), "not been started") {
+		t.Fatalf("expected not-started error, got %v", err)
+	}
+
+	paused := &Instance{Title: "test-instance", Status: Paused, started: true}
+	if err := paused.RebaseAbort(); err == nil || !strings.Contains(err.Error(), "paused") {
+		t.Fatalf("expected paused error, got %v", err)
+	}
+}
+
+func TestMergeFrom(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	head := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD"))
+	runGitInstanceTest(t, repo, "checkout", "-b", "other-session")
+	if err := os.WriteFile(filepath.Join(repo, "other.txt"), []byte("other\n"), 0o644); err != nil {
+		t.Fatalf("write other.txt: %v", err)
+	}
+	runGitInstanceTest(t, repo, "add", "other.txt")
+	runGitInstanceTest(t, repo, "commit", "-m", "other work")
+	otherHead := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD"))
+	runGitInstanceTest(t, repo, "checkout", "main")
+
+	inst := &Instance{
+		Title:       "target",
+		started:     true,
+		Status:      Running,
+		gitWorktree: git.NewGitWorktreeFromStorage(repo, repo, "target", "main", head),
+	}
+	other := &Instance{
+		Title:       "other",
+		gitWorktree: git.NewGitWorktreeFromStorage(repo, "", "other", "other-session", head),
+	}
+
+	if err := inst.MergeFrom(inst); err == nil {
+		t.Fatal("expected merging an instance into itself to fail")
+	}
+	if err := inst.MergeFrom(other); err != nil {
+		t.Fatalf("MergeFrom: %v", err)
+	}
+	if got := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD")); got != otherHead {
+		t.Fatalf("expected main to fast-forward to %s, got %s", otherHead, got)
+	}
+}
+
+func TestStreamOutputEmitsNewLines(t *testing.T) {
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	name := tmux.TmuxPrefix + "stream"
+	server.AddSession(name, t.TempDir(), "claude")
+	server.SetPaneContent(name, "one\ntwo\n")
+
+	inst := &Instance{
+		Title:       "stream",
+		started:     true,
+		Status:      Running,
+		tmuxSession: tmuxtest.NewSession(server, "stream", "claude"),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lines, err := inst.StreamOutput(ctx)
+	if err != nil {
+		t.Fatalf("StreamOutput: %v", err)
+	}
+
+	next := func() string {
+		select {
+		case line := <-lines:
+			return line
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for output")
+			return ""
+		}
+	}
+	if got := []string{next(), next()}; got[0] != "one" || got[1] != "two" {
+		t.Fatalf("expected initial lines, got %v", got)
+	}
+
+	server.SetPaneContent(name, "two\nthree\n")
+	if got := next(); got != "three" {
+		t.Fatalf("expected only the new line, got %q", got)
+	}
+
+	if err := tmuxtest.NewSession(server, "stream", "claude").Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case _, ok := <-lines:
+		if ok {
+			t.Fatal("expected no more output after the session died")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected channel to close after the session died")
+	}
+}
+
+func TestRunInWorktree(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	head := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD"))
+	inst := &Instance{
+		Title:       "runner",
+		started:     true,
+		Status:      Running,
+		gitWorktree: git.NewGitWorktreeFromStorage(repo, repo, "runner", "main", head),
+	}
+
+	output, err := inst.RunInWorktree(context.Background(), "git", "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatalf("RunInWorktree: %v", err)
+	}
+	if strings.TrimSpace(string(output)) != head {
+		t.Fatalf("expected command to run in the worktree, got %q", output)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := inst.RunInWorktree(ctx, "sleep", "5"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+
+	inst.Status = Paused
+	if _, err := inst.RunInWorktree(context.Background(), "true"); err == nil {
+		t.Fatal("expected paused instance to refuse commands")
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	head := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD"))
+	inst := &Instance{
+		Title:       "runner",
+		started:     true,
+		Status:      Running,
+		gitWorktree: git.NewGitWorktreeFromStorage(repo, repo, "runner", "main", head),
+		env:         []string{"SUITE=unit"},
+	}
+
+	code, output, err := inst.RunCommand(context.Background(), []string{"sh", "-c", `echo "$SUITE in $PWD"; echo oops >&2`})
+	if err != nil || code != 0 {
+		t.Fatalf("RunCommand: code %d, err %v", code, err)
+	}
+	if !strings.Contains(output, "unit in "+repo) || !strings.Contains(output, "oops") {
+		t.Fatalf("expected combined output run with the instance env in the worktree, got %q", output)
+	}
+
+	code, _, err = inst.RunCommand(context.Background(), []string{"sh", "-c", "exit 3"})
+	if err != nil || code != 3 {
+		t.Fatalf("expected exit code 3 without an error, got %d, %v", code, err)
+	}
+
+	if _, _, err := inst.RunCommand(context.Background(), []string{"no-such-command-here"}); err == nil {
+		t.Fatal("expected an error for a command that cannot run")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := inst.RunCommand(ctx, []string{"sleep", "5"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+}
+
+func TestInstanceCommit(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	head := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD"))
+	inst := &Instance{
+		Title:       "checkpoint",
+		started:     true,
+		Status:      Running,
+		gitWorktree: git.NewGitWorktreeFromStorage(repo, repo, "checkpoint", "main", head),
+	}
+
+	if err := inst.Commit("checkpoint"); !errors.Is(err, git.ErrNothingToCommit) {
+		t.Fatalf("expected nothing to commit on a clean worktree, got %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(repo, "file.txt"), []byte("changed\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	inst.diffDirty.Store(false)
+	if err := inst.Commit("checkpoint"); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if got := strings.TrimSpace(runGitInstanceTest(t, repo, "log", "-1", "--format=%s")); got != "checkpoint" {
+		t.Fatalf("expected checkpoint commit, got %q", got)
+	}
+	if !inst.diffDirty.Load() {
+		t.Fatal("expected Commit to mark the diff dirty")
+	}
+
+	inst.Status = Paused
+	if err := inst.Commit("checkpoint"); err == nil {
+		t.Fatal("expected paused instance to refuse to commit")
+	}
+}
+
+func TestTranscriptFileName(t *testing.T) {
+	cases := map[string]string{
+		"fix-bug":        "fix-bug.log",
+		"feature/login":  "feature_login.log",
+		"../../etc/pass": "_.._etc_pass.log",
+		"":               "instance.log",
+	}
+	for title, want := range cases {
+		if got := transcriptFileName(title); got != want {
+			t.Errorf("transcriptFileName(%q) = %q, want %q", title, got, want)
+		}
+	}
+}
+
+func TestHasUpdatedAppendsTranscript(t *testing.T) {
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	name := tmux.TmuxPrefix + "logged"
+	server.AddSession(name, t.TempDir(), "bash")
+	tmuxSession := tmuxtest.NewSession(server, "logged", "bash")
+	if err := tmuxSession.Restore(); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	inst := &Instance{Title: "logged", started: true, Status: Running, tmuxSession: tmuxSession}
+	inst.startTranscript(&config.Config{TranscriptDir: t.TempDir()})
+	t.Cleanup(func() { _ = inst.stopTranscript() })
+
+	server.SetPaneContent(name, "$ make\nbuilding\n")
+	inst.HasUpdated()
+	inst.HasUpdated()
+	server.SetPaneContent(name, "building\ndone\n")
+	inst.HasUpdated()
+
+	content, err := inst.Transcript()
+	if err != nil {
+		t.Fatalf("Transcript: %v", err)
+	}
+	if content != "$ make\nbuilding\ndone\n" {
+		t.Fatalf("unexpected transcript %q", content)
+	}
+
+	if err := inst.stopTranscript(); err != nil {
+		t.Fatalf("stopTranscript: %v", err)
+	}
+	server.SetPaneContent(name, "after pause\n")
+	inst.HasUpdated()
+	if content, _ := inst.Transcript(); strings.Contains(content, "after pause") {
+		t.Fatal("expected logging to stop once the transcript is closed")
+	}
+}
+
+func TestCopyDiffToClipboardRequiresChanges(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	head := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD"))
+	inst := &Instance{
+		Title:       "clean",
+		started:     true,
+		Status:      Running,
+		gitWorktree: git.NewGitWorktreeFromStorage(repo, repo, "clean", "main", head),
+	}
+	inst.diffDirty.Store(true)
+
+	err := inst.CopyDiffToClipboard()
+	if err == nil || !strings.Contains(err.Error(), "no changes") {
+		t.Fatalf("expected no-changes error, got %v", err)
+	}
+	if inst.diffStats == nil {
+		t.Fatal("expected a dirty diff to be recomputed before copying")
+	}
+}
+
+func TestOpenShell(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	head := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD"))
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+
+	tmuxSession := tmuxtest.NewSession(server, "shell-test", "bash")
+	if err := tmuxSession.Start(repo); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	inst := &Instance{
+		Title:       "shell-test",
+		started:     true,
+		Status:      Running,
+		tmuxSession: tmuxSession,
+		gitWorktree: git.NewGitWorktreeFromStorage(repo, repo, "shell-test", "main", head),
+	}
+	t.Setenv("SHELL", "/bin/zsh")
+
+	if err := inst.OpenShell(); err != nil {
+		t.Fatalf("OpenShell: %v", err)
+	}
+	if err := inst.OpenShell(); err != nil {
+		t.Fatalf("OpenShell again: %v", err)
+	}
+	windows, err := tmuxSession.ListWindows()
+	if err != nil {
+		t.Fatalf("ListWindows: %v", err)
+	}
+	if !slices.Equal(windows, []string{tmux.AgentWindow, "shell"}) {
+		t.Fatalf("expected agent and shell windows, got %v", windows)
+	}
+	name := tmux.TmuxPrefix + "shell-test"
+	if server.ActiveWindow(name) != tmux.AgentWindow {
+		t.Fatal("expected the agent window to stay active")
+	}
+
+	// Captures target the agent window even when the shell window is active.
+	if err := tmuxSession.SelectWindow("shell"); err != nil {
+		t.Fatalf("SelectWindow: %v", err)
+	}
+	if _, err := tmuxSession.CapturePaneContent(); err != nil {
+		t.Fatalf("CapturePaneContent: %v", err)
+	}
+	commands := server.Commands()
+	if last := commands[len(commands)-1]; !strings.HasSuffix(last, name+":"+tmux.AgentWindow) {
+		t.Fatalf("expected capture to target the agent window, got %q", last)
+	}
+
+	if err := tmuxSession.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if server.HasSession(name) {
+		t.Fatal("expected Close to remove the session and its windows")
+	}
+}
+
+func TestHasUpdatedDetectsStartup(t *testing.T) {
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	name := tmux.TmuxPrefix + "startup"
+	server.AddSession(name, t.TempDir(), "claude")
+	tmuxSession := tmuxtest.NewSession(server, "startup", "claude")
+	if err := tmuxSession.Restore(); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	inst := &Instance{
+		Title:        "startup",
+		started:      true,
+		Status:       Loading,
+		tmuxSession:  tmuxSession,
+		readyPattern: compileReadyPattern(&config.Config{}),
+		readyTimeout: time.Minute,
+		loadingSince: time.Now(),
+	}
+
+	server.SetPaneContent(name, "Welcome to Claude Code\nloading...\n")
+	inst.HasUpdated()
+	if inst.Status != Loading {
+		t.Fatalf("expected instance to stay Loading, got %v", inst.Status)
+	}
+
+	server.SetPaneContent(name, "│ >                    │\n  ? for shortcuts\n")
+	inst.HasUpdated()
+	if inst.Status != Ready {
+		t.Fatalf("expected instance to become Ready, got %v", inst.Status)
+	}
+
+	inst.Status = Loading
+	inst.loadingSince = time.Now().Add(-inst.readyTimeout - time.Second)
+	server.SetPaneContent(name, "Enter your API key:\n")
+	inst.HasUpdated()
+	if inst.Status != Crashed {
+		t.Fatalf("expected instance stuck loading to be Crashed, got %v", inst.Status)
+	}
+
+	inst.Status = Loading
+	inst.loadingSince = time.Now()
+	if err := tmuxSession.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	inst.HasUpdated()
+	if inst.Status != Crashed {
+		t.Fatalf("expected instance whose program exited to be Crashed, got %v", inst.Status)
+	}
+}
+
+func TestTouchAndActivityPatternCountAsUpdates(t *testing.T) {
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	name := tmux.TmuxPrefix + "touch"
+	server.AddSession(name, t.TempDir(), "claude")
+	tmuxSession := tmuxtest.NewSession(server, "touch", "claude")
+	if err := tmuxSession.Restore(); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	inst := &Instance{
+		Title:       "touch",
+		started:     true,
+		Status:      Running,
+		tmuxSession: tmuxSession,
+		CreatedAt:   time.Now().Add(-time.Hour),
+	}
+
+	server.SetPaneContent(name, "running a long tool call\n")
+	if updated, _ := inst.HasUpdated(); !updated {
+		t.Fatal("expected the first capture to count as an update")
+	}
+	if updated, _ := inst.HasUpdated(); updated {
+		t.Fatal("expected an unchanged pane not to count as an update")
+	}
+
+	inst.lastActivity.Store(time.Now().Add(-time.Hour).UnixNano())
+	inst.Touch()
+	if idle := time.Since(inst.LastActivity()); idle > time.Minute {
+		t.Fatalf("expected Touch to reset the idle time, got %s", idle)
+	}
+	if time.Since(inst.UpdatedAt) > time.Minute {
+		t.Fatalf("expected Touch to update UpdatedAt, got %s", inst.UpdatedAt)
+	}
+	if updated, _ := inst.HasUpdated(); !updated {
+		t.Fatal("expected HasUpdated to report an update after Touch")
+	}
+	if updated, _ := inst.HasUpdated(); updated {
+		t.Fatal("expected Touch to count as a single update")
+	}
+
+	tmuxSession.SetActivityPattern(compileActivityPattern(&config.Config{ActivityPattern: `Thinking\.\.\.`}))
+	server.SetPaneContent(name, "✻ Thinking...\n")
+	inst.HasUpdated()
+	if updated, _ := inst.HasUpdated(); !updated {
+		t.Fatal("expected an unchanged pane matching activity_pattern to count as an update")
+	}
+
+	if compileActivityPattern(&config.Config{ActivityPattern: "("}) != nil {
+		t.Fatal("expected an invalid activity_pattern to be ignored")
+	}
+}
+
+func TestWaitUntilReady(t *testing.T) {
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	name := tmux.TmuxPrefix + "waiter"
+	server.AddSession(name, t.TempDir(), "bash")
+	tmuxSession := tmuxtest.NewSession(server, "waiter", "bash")
+	if err := tmuxSession.Restore(); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	inst := &Instance{Title: "waiter", started: true, Status: Running, tmuxSession: tmuxSession}
+
+	server.SetPaneContent(name, "$ make\nbuilding\n")
+	if err := inst.WaitUntilReady(context.Background()); err != nil {
+		t.Fatalf("WaitUntilReady: %v", err)
+	}
+	if inst.Status != Ready {
+		t.Fatalf("expected instance to be Ready, got %v", inst.Status)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := inst.WaitUntilReady(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context error, got %v", err)
+	}
+
+	inst.Status = Loading
+	inst.loadingSince = time.Now()
+	if err := tmuxSession.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := inst.WaitUntilReady(context.Background()); !errors.Is(err, ErrInstanceCrashed) {
+		t.Fatalf("expected ErrInstanceCrashed, got %v", err)
+	}
+}
+
+func TestRecoverDeletedWorktree(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	t.Setenv("HOME", t.TempDir())
+	worktree, _, err := git.NewGitWorktree(repo, "recover")
+	if err != nil {
+		t.Fatalf("NewGitWorktree: %v", err)
+	}
+	if err := worktree.Setup(); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	tmuxSession := tmuxtest.NewSession(server, "recover", "bash")
+	if err := tmuxSession.Start(worktree.GetWorktreePath()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	inst := &Instance{
+		Title:       "recover",
+		started:     true,
+		Status:      Running,
+		tmuxSession: tmuxSession,
+		gitWorktree: worktree,
+	}
+	t.Cleanup(func() { _ = inst.Kill() })
+
+	if err := inst.Recover(); err == nil {
+		t.Fatal("expected Recover to refuse an instance whose worktree exists")
+	}
+	if err := os.RemoveAll(worktree.GetWorktreePath()); err != nil {
+		t.Fatalf("remove worktree: %v", err)
+	}
+	if err := inst.UpdateDiffStats(time.Time{}); err != nil {
+		t.Fatalf("UpdateDiffStats: %v", err)
+	}
+	if inst.Status != Detached {
+		t.Fatalf("expected instance with deleted worktree to be Detached, got %v", inst.Status)
+	}
+	if err := inst.UpdateDiffStats(time.Time{}); err != nil {
+		t.Fatalf("UpdateDiffStats while detached: %v", err)
+	}
+
+	if err := inst.Recover(); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if inst.Status != Running {
+		t.Fatalf("expected recovered instance to be Running, got %v", inst.Status)
+	}
+	if _, err := os.Stat(filepath.Join(worktree.GetWorktreePath(), "file.txt")); err != nil {
+		t.Fatalf("expected worktree to be recreated: %v", err)
+	}
+	if err := inst.UpdateDiffStats(time.Time{}); err != nil {
+		t.Fatalf("UpdateDiffStats after Recover: %v", err)
+	}
+}
+
+func TestReadOnlyInstanceRefusesInput(t *testing.T) {
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	tmuxSession := tmuxtest.NewSession(server, "read-only", "bash")
+	if err := tmuxSession.Start(t.TempDir()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	inst := &Instance{
+		Title:       "read-only",
+		started:     true,
+		Status:      Running,
+		AutoYes:     true,
+		tmuxSession: tmuxSession,
+	}
+	inst.SetReadOnly(true)
+
+	if err := inst.SendKeys("ls"); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected SendKeys to fail with ErrReadOnly, got %v", err)
+	}
+	if err := inst.SendPrompt("hello"); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected SendPrompt to fail with ErrReadOnly, got %v", err)
+	}
+	if err := inst.TapEnter(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected TapEnter to fail with ErrReadOnly, got %v", err)
+	}
+	if input, err := server.Input(tmux.TmuxPrefix + "read-only"); err != nil || input != "" {
+		t.Fatalf("expected no input to reach the session, got %q, %v", input, err)
+	}
+	if !inst.ToInstanceData().ReadOnly {
+		t.Fatal("expected ReadOnly to survive serialization")
+	}
+
+	inst.SetReadOnly(false)
+	if err := inst.SendKeys("ls"); err != nil {
+		t.Fatalf("SendKeys after SetReadOnly(false): %v", err)
+	}
+}
+
+func TestPauseCommitMessage(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"", "[agentsquad] update from 'fix-login' on 01 May 24 12:30 UTC (paused)"},
+		{"chore({branch}): wip {title} at {time}", "chore(tester/fix-login): wip fix-login at 01 May 24 12:30 UTC"},
+		{"{title}", "fix-login"},
+		{"  ", "[agentsquad] update from 'fix-login' on 01 May 24 12:30 UTC (paused)"},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{PauseCommitTemplate: tt.template}
+		if got := pauseCommitMessage(cfg, "fix-login", "tester/fix-login", now); got != tt.want {
+			t.Errorf("template %q: expected %q, got %q", tt.template, tt.want, got)
+		}
+	}
+}
+
+func TestPauseClipboardText(t *testing.T) {
+	off := false
+	tests := []struct {
+		cfg    config.Config
+		want   string
+		copies bool
+	}{
+		{config.Config{}, "tester/fix-login", true},
+		{config.Config{PauseCopyCheckoutCommand: true}, "git checkout tester/fix-login", true},
+		{config.Config{PauseCopyBranch: &off, PauseCopyCheckoutCommand: true}, "", false},
+	}
+	for _, tt := range tests {
+		got, copies := pauseClipboardText(&tt.cfg, "tester/fix-login")
+		if got != tt.want || copies != tt.copies {
+			t.Errorf("%+v: expected %q, %v, got %q, %v", tt.cfg, tt.want, tt.copies, got, copies)
+		}
+	}
+}
+
+func TestPauseStrategies(t *testing.T) {
+	setup := func(t *testing.T, strategy string) (*Instance, string) {
+		repo := setupInstanceTestRepo(t)
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		if err := os.MkdirAll(filepath.Join(home, ".agent-squad"), 0o755); err != nil {
+			t.Fatalf("create config dir: %v", err)
+		}
+		cfg := `{"branch_prefix": "tester/", "pause_strategy": "` + strategy + `"}`
+		if err := os.WriteFile(filepath.Join(home, ".agent-squad", config.ConfigFileName), []byte(cfg), 0o644); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+
+		worktree, _, err := git.NewGitWorktree(repo, "pauser")
+		if err != nil {
+			t.Fatalf("NewGitWorktree: %v", err)
+		}
+		if err := worktree.Setup(); err != nil {
+			t.Fatalf("Setup: %v", err)
+		}
+		server := tmuxtest.NewServer()
+		t.Cleanup(server.Close)
+		tmuxSession := tmuxtest.NewSession(server, "pauser", "bash")
+		if err := tmuxSession.Start(worktree.GetWorktreePath()); err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		inst := &Instance{
+			Title:       "pauser",
+			started:     true,
+			Status:      Running,
+			tmuxSession: tmuxSession,
+			gitWorktree: worktree,
+		}
+		t.Cleanup(func() { _ = inst.Kill() })
+
+		wip := filepath.Join(worktree.GetWorktreePath(), "wip.txt")
+		if err := os.WriteFile(wip, []byte("wip\n"), 0o644); err != nil {
+			t.Fatalf("write wip: %v", err)
+		}
+		return inst, wip
+	}
+
+	t.Run("leave-dirty refuses a dirty worktree", func(t *testing.T) {
+		inst, wip := setup(t, config.PauseStrategyLeaveDirty)
+		if err := inst.Pause(); err == nil || !strings.Contains(err.Error(), "uncommitted changes") {
+			t.Fatalf("expected Pause to refuse, got %v", err)
+		}
+		if inst.Status != Running {
+			t.Fatalf("expected instance to keep running, got %v", inst.Status)
+		}
+		if _, err := os.Stat(wip); err != nil {
+			t.Fatalf("expected worktree to be kept: %v", err)
+		}
+	})
+
+	t.Run("stash restores changes on resume", func(t *testing.T) {
+		inst, wip := setup(t, config.PauseStrategyStash)
+		head := runGitInstanceTest(t, inst.gitWorktree.GetRepoPath(), "rev-parse", inst.gitWorktree.GetBranchName())
+		if err := inst.Pause(); err != nil {
+			t.Fatalf("Pause: %v", err)
+		}
+		if after := runGitInstanceTest(t, inst.gitWorktree.GetRepoPath(), "rev-parse", inst.gitWorktree.GetBranchName()); after != head {
+			t.Fatal("expected stash strategy not to commit to the branch")
+		}
+		if err := inst.Resume(); err != nil {
+			t.Fatalf("Resume: %v", err)
+		}
+		if _, err := os.Stat(wip); err != nil {
+			t.Fatalf("expected stashed change to be restored: %v", err)
+		}
+	})
+}
+
+func TestResumeAppliesPromptConfig(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".agent-squad"), 0o755); err != nil {
+		t.Fatalf("create config dir: %v", err)
+	}
+	cfg := `{"branch_prefix": "tester/", "send_prompt_enter_delay_ms": 250, "paste_prompts": true}`
+	if err := os.WriteFile(filepath.Join(home, ".agent-squad", config.ConfigFileName), []byte(cfg), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	worktree, _, err := git.NewGitWorktree(repo, "resumer")
+	if err != nil {
+		t.Fatalf("NewGitWorktree: %v", err)
+	}
+	if err := worktree.Setup(); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	tmuxSession := tmuxtest.NewSession(server, "resumer", "bash")
+	tmuxSession.SetEnterDelay(0)
+	if err := tmuxSession.Start(worktree.GetWorktreePath()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	// Built like FromInstanceData builds a running instance, without going through Start.
+	inst := &Instance{
+		Title:       "resumer",
+		started:     true,
+		Status:      Running,
+		tmuxSession: tmuxSession,
+		gitWorktree: worktree,
+	}
+	t.Cleanup(func() { _ = inst.Kill() })
+
+	if err := inst.Pause(); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	if err := inst.Resume(); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if got := inst.tmuxSession.EnterDelay(); got != 250*time.Millisecond {
+		t.Fatalf("expected the configured enter delay after resume, got %s", got)
+	}
+	if !inst.pastePrompts {
+		t.Fatal("expected paste_prompts to apply after resume")
+	}
+}
+
+func TestSetLogging(t *testing.T) {
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	tmuxSession := tmuxtest.NewSession(server, "logger", "bash")
+	inst := &Instance{Title: "logger", tmuxSession: tmuxSession}
+	path := filepath.Join(t.TempDir(), "logger.log")
+
+	// Before the instance starts, the path is only remembered.
+	if err := inst.SetLogging(path); err != nil {
+		t.Fatalf("SetLogging before start: %v", err)
+	}
+	if len(server.Commands()) != 0 {
+		t.Fatalf("expected no tmux commands before start, got %v", server.Commands())
+	}
+
+	if err := tmuxSession.Start(t.TempDir()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	inst.started = true
+	inst.Status = Running
+	inst.startLogging()
+	target := tmux.TmuxPrefix + "logger:" + tmux.AgentWindow
+	commands := server.Commands()
+	if last := commands[len(commands)-1]; last != "tmux pipe-pane -t "+target+" cat >> '"+path+"'" {
+		t.Fatalf("expected pane to be piped to the log, got %q", last)
+	}
+
+	// transcript_max_bytes caps the piped log too.
+	inst.logMaxBytes = 1024
+	inst.startLogging()
+	commands = server.Commands()
+	if last := commands[len(commands)-1]; !strings.Contains(last, `if [ "$s" -ge 1024 ]`) {
+		t.Fatalf("expected the piped log to rotate at 1024 bytes, got %q", last)
+	}
+
+	if err := inst.SetLogging(""); err != nil {
+		t.Fatalf("SetLogging off: %v", err)
+	}
+	commands = server.Commands()
+	if last := commands[len(commands)-1]; last != "tmux pipe-pane -t "+target {
+		t.Fatalf("expected pipe to be stopped, got %q", last)
+	}
+}
+
+func TestTitleValidation(t *testing.T) {
+	for _, title := range []string{"v1.2", "fix:login", "tab\there", "---", "🔥"} {
+		if _, err := NewInstance(InstanceOptions{Title: title, Path: t.TempDir(), Program: "bash"}); err == nil {
+			t.Errorf("expected NewInstance to reject title %q", title)
+		}
+	}
+
+	inst, err := NewInstance(InstanceOptions{Title: "", Path: t.TempDir(), Program: "bash"})
+	if err != nil {
+		t.Fatalf("expected an empty title to be accepted while it is entered: %v", err)
+	}
+	if err := inst.SetTitle("Fix login flow_2"); err != nil {
+		t.Fatalf("SetTitle: %v", err)
+	}
+	if err := inst.SetTitle("Fix login flow.2"); err == nil || !strings.Contains(err.Error(), `'.'`) {
+		t.Fatalf("expected SetTitle to reject a dot, got %v", err)
+	}
+	if inst.Title != "Fix login flow_2" {
+		t.Fatalf("expected a rejected title to leave the title unchanged, got %q", inst.Title)
+	}
+}
+
+func TestAutoYesSurvivesReload(t *testing.T) {
+	inst, err := NewInstance(InstanceOptions{Title: "auto-yes", Path: t.TempDir(), Program: "bash", AutoYes: true})
+	if err != nil {
+		t.Fatalf("NewInstance: %v", err)
+	}
+	if !inst.AutoYes {
+		t.Fatal("expected NewInstance to honor the AutoYes option")
+	}
+
+	repo := setupInstanceTestRepo(t)
+	head := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD"))
+	paused := &Instance{
+		Title:       "auto-yes",
+		Status:      Paused,
+		started:     true,
+		gitWorktree: git.NewGitWorktreeFromStorage(repo, repo, "auto-yes", "main", head),
+	}
+	for _, autoYes := range []bool{true, false} {
+		paused.SetAutoYes(autoYes)
+		restored, err := FromInstanceData(paused.ToInstanceData())
+		if err != nil {
+			t.Fatalf("FromInstanceData: %v", err)
+		}
+		if restored.AutoYes != autoYes {
+			t.Fatalf("expected AutoYes %v after reload, got %v", autoYes, restored.AutoYes)
+		}
+	}
+}
+
+func TestCleanSessionReloadsWithoutDiffStats(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	head := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD"))
+	inst := &Instance{
+		Title:       "clean",
+		Status:      Paused,
+		started:     true,
+		gitWorktree: git.NewGitWorktreeFromStorage(repo, repo, "clean", "main", head),
+	}
+
+	restored, err := FromInstanceData(inst.ToInstanceData())
+	if err != nil {
+		t.Fatalf("FromInstanceData: %v", err)
+	}
+	if restored.GetDiffStats() != nil {
+		t.Fatalf("expected a clean session to reload with nil stats, got %+v", restored.GetDiffStats())
+	}
+
+	inst.diffStats = &git.DiffStats{Added: 2, Removed: 1, Content: "diff", FilesChanged: 1}
+	restored, err = FromInstanceData(inst.ToInstanceData())
+	if err != nil {
+		t.Fatalf("FromInstanceData: %v", err)
+	}
+	if got := restored.GetDiffStats(); got == nil || got.Added != 2 || got.Removed != 1 || got.FilesChanged != 1 {
+		t.Fatalf("expected stored stats to be restored, got %+v", got)
+	}
+}
+
+func TestUpdateDiffStatsBacksOffOnErrors(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	head := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD"))
+
+	inst := &Instance{
+		Title:   "backoff",
+		started: true,
+		Status:  Running,
+		// A base commit that does not exist makes every diff fail.
+		gitWorktree: git.NewGitWorktreeFromStorage(repo, repo, "backoff", "main", strings.Repeat("0", 40)),
+	}
+	now := time.Now()
+	if err := inst.UpdateDiffStats(now); err == nil {
+		t.Fatal("expected the diff to fail")
+	}
+	if err := inst.UpdateDiffStats(now.Add(diffErrorBackoff / 2)); err != nil {
+		t.Fatalf("expected the retry to be skipped during the backoff, got %v", err)
+	}
+	if err := inst.UpdateDiffStats(now.Add(diffErrorBackoff)); err == nil {
+		t.Fatal("expected the diff to be retried after the backoff")
+	}
+	if inst.diffFailures != 2 || inst.diffRetryAt.Sub(now) != diffErrorBackoff+2*diffErrorBackoff {
+		t.Fatalf("expected the backoff to double, got %d failures, retry at +%s", inst.diffFailures, inst.diffRetryAt.Sub(now))
+	}
+	if diffBackoff(100) != diffErrorBackoffMax {
+		t.Fatalf("expected the backoff to be capped at %s, got %s", diffErrorBackoffMax, diffBackoff(100))
+	}
+
+	inst.gitWorktree = git.NewGitWorktreeFromStorage(repo, repo, "backoff", "main", head)
+	if err := inst.UpdateDiffStats(time.Time{}); err != nil {
+		t.Fatalf("UpdateDiffStats: %v", err)
+	}
+	if inst.diffFailures != 0 || !inst.diffRetryAt.IsZero() {
+		t.Fatalf("expected a successful diff to reset the backoff, got %d failures", inst.diffFailures)
+	}
+}
+
+func TestInstanceTemplateOptions(t *testing.T) {
+	cfg := &config.Config{DefaultProgram: "claude"}
+	opts := templateOptions(cfg, config.InstanceTemplate{
+		AutoYes:        true,
+		Env:            map[string]string{"ROLE": "reviewer", "NOTE": "it's late"},
+		Labels:         []string{"review"},
+		BranchTemplate: "review/{title}",
+	}, "nightly")
+	if opts.Title != "nightly" || opts.Program != "claude" || !opts.AutoYes || opts.BranchTemplate != "review/{title}" {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+	if !slices.Equal(opts.Env, []string{"NOTE=it's late", "ROLE=reviewer"}) {
+		t.Fatalf("expected sorted env, got %v", opts.Env)
+	}
+
+	opts.Path = t.TempDir()
+	inst, err := NewInstance(opts)
+	if err != nil {
+		t.Fatalf("NewInstance: %v", err)
+	}
+	if !inst.AutoYes || !slices.Equal(inst.Labels, []string{"review"}) {
+		t.Fatalf("expected auto-yes and labels from the template, got %v %v", inst.AutoYes, inst.Labels)
+	}
+	if got, want := inst.programCommand(), `env 'NOTE=it'\''s late' 'ROLE=reviewer' claude`; got != want {
+		t.Fatalf("expected program command %q, got %q", want, got)
+	}
+
+	data := inst.ToInstanceData()
+	if !slices.Equal(data.Env, opts.Env) || !slices.Equal(data.Labels, inst.Labels) {
+		t.Fatalf("expected env and labels to be persisted, got %v %v", data.Env, data.Labels)
+	}
+
+	if _, err := cfg.GetTemplate("missing"); err == nil {
+		t.Fatal("expected an error for an unknown template")
+	}
+}
+
+func TestStartContextCancelledCleansUp(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	t.Setenv("HOME", t.TempDir())
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+
+	// The fake pane never shows claude's trust prompt, so Start waits for it until cancelled.
+	inst := &Instance{
+		Title:       "cancelled",
+		Path:        repo,
+		Program:     "claude",
+		tmuxSession: tmuxtest.NewSession(server, "cancelled", "claude"),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	err := inst.StartContext(ctx, true)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the start to be interrupted, got %v", err)
+	}
+	if inst.Started() {
+		t.Fatal("expected a cancelled start to leave the instance not started")
+	}
+	if server.HasSession(tmux.TmuxPrefix + "cancelled") {
+		t.Fatal("expected the tmux session to be killed")
+	}
+	if _, err := os.Stat(inst.gitWorktree.GetWorktreePath()); !os.IsNotExist(err) {
+		t.Fatalf("expected the worktree to be removed, got %v", err)
+	}
+	branch := inst.gitWorktree.GetBranchName()
+	if out := runGitInstanceTest(t, repo, "branch", "--list", branch); strings.TrimSpace(out) != "" {
+		t.Fatalf("expected branch %s to be deleted, got %q", branch, out)
+	}
+}
+
+func TestStartRunsProgramInWorkDir(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	t.Setenv("HOME", t.TempDir())
+	if err := os.MkdirAll(filepath.Join(repo, "services", "api"), 0o755); err != nil {
+		t.Fatalf("create dirs: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "services", "api", "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	runGitInstanceTest(t, repo, "add", ".")
+	runGitInstanceTest(t, repo, "commit", "-m", "add api")
+
+	if _, err := NewInstance(InstanceOptions{Title: "escape", Path: repo, Program: "bash", WorkDir: "../elsewhere"}); err == nil {
+		t.Fatal("expected a work dir outside the worktree to be rejected")
+	}
+
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	inst, err := NewInstance(InstanceOptions{Title: "workdir", Path: repo, Program: "bash", WorkDir: "services/api"})
+	if err != nil {
+		t.Fatalf("NewInstance: %v", err)
+	}
+	inst.tmuxSession = tmuxtest.NewSession(server, "workdir", "bash")
+	if err := inst.Start(true); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { _ = inst.KillWithOptions(KillOptions{DeleteBranch: true}) })
+
+	want := "-c " + filepath.Join(inst.gitWorktree.GetWorktreePath(), "services", "api")
+	started := false
+	for _, command := range server.Commands() {
+		if strings.Contains(command, "new-session") && strings.Contains(command, want) {
+			started = true
+		}
+	}
+	if !started {
+		t.Fatalf("expected the session to start in the work dir, got %v", server.Commands())
+	}
+	if got := inst.ToInstanceData().WorkDir; got != "services/api" {
+		t.Fatalf("expected the work dir to be serialized, got %q", got)
+	}
+
+	missing, err := NewInstance(InstanceOptions{Title: "missing", Path: repo, Program: "bash", WorkDir: "services/web"})
+	if err != nil {
+		t.Fatalf("NewInstance: %v", err)
+	}
+	missing.tmuxSession = tmuxtest.NewSession(server, "missing", "bash")
+	if err := missing.Start(true); err == nil || !strings.Contains(err.Error(), "services/web") {
+		t.Fatalf("expected a missing work dir to fail the start, got %v", err)
+	}
+	if server.HasSession(tmux.TmuxPrefix + "missing") {
+		t.Fatal("expected no session for a missing work dir")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	head := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD"))
+	inst := &Instance{
+		Title:       "snapshot",
+		Status:      Running,
+		started:     true,
+		CreatedAt:   time.Now().Add(-time.Minute),
+		gitWorktree: git.NewGitWorktreeFromStorage(repo, repo, "snapshot", "main", head),
+	}
+	inst.setDiffStats(&git.DiffStats{Added: 3, Files: []git.FileStat{{NewPath: "a.txt", Added: 3}}})
+
+	snap := inst.Snapshot()
+	if snap.Title != "snapshot" || snap.Status != Running || snap.Branch != "main" || snap.Paused {
+		t.Fatalf("unexpected snapshot %+v", snap)
+	}
+	if snap.Idle < time.Minute {
+		t.Fatalf("expected the idle time to count from creation, got %s", snap.Idle)
+	}
+	snap.DiffStats.Added = 100
+	snap.DiffStats.Files[0].Added = 100
+	if stats := inst.GetDiffStats(); stats.Added != 3 || stats.Files[0].Added != 3 {
+		t.Fatalf("expected the snapshot's diff stats to be a copy, got %+v", stats)
+	}
+
+	// Snapshots taken while the instance is updated see each update whole.
+	runGitInstanceTest(t, repo, "branch", "other")
+	done := make(chan struct{})
+	checkedOut := make(chan error, 1)
+	go func() {
+		defer close(done)
+		for n := range 200 {
+			inst.SetStatus(Status(n % 2))
+			inst.setDiffStats(&git.DiffStats{Added: n})
+		}
+	}()
+	go func() {
+		var err error
+		for _, branch := range []string{"other", "main", "other", "main"} {
+			if err = inst.CheckoutBranch(branch, git.CheckoutOptions{}); err != nil {
+				break
+			}
+		}
+		checkedOut <- err
+	}()
+	for range 200 {
+		_ = inst.Snapshot()
+		_ = inst.ToInstanceData()
+		_ = inst.Paused()
+	}
+	<-done
+	if err := <-checkedOut; err != nil {
+		t.Fatalf("CheckoutBranch: %v", err)
+	}
+
+	inst.SetStatus(Paused)
+	if !inst.Snapshot().Paused {
+		t.Fatal("expected a paused instance to be reported paused")
+	}
+}
@@ -0,0 +1,76 @@
+package session
+
+import (
+	"fmt"
+	"os"
+)
+
+// KillImpact describes what killing an instance would throw away.
+type KillImpact struct {
+	// Branch is the instance's branch.
+	Branch string
+	// DeletesBranch is true if Kill deletes the branch. Branches that existed before the
+	// instance are kept.
+	DeletesBranch bool
+	// Dirty is true if the worktree has uncommitted changes, which Kill discards.
+	Dirty bool
+	// UnpushedCommits is the number of commits on the branch that are on no remote and no other
+	// branch.
+	UnpushedCommits int
+}
+
+// LosesWork reports whether killing the instance would lose changes that exist nowhere else.
+func (k KillImpact) LosesWork() bool {
+	return k.Dirty || (k.DeletesBranch && k.UnpushedCommits > 0)
+}
+
+// String summarizes the impact for a confirmation prompt, e.g. "uncommitted changes and 2
+// unpushed commits on tester/feature will be lost". It is empty if nothing would be lost.
+func (k KillImpact) String() string {
+	var lost string
+	switch {
+	case k.Dirty && k.DeletesBranch && k.UnpushedCommits > 0:
+		lost = fmt.Sprintf("uncommitted changes and %d unpushed commit(s) on %s", k.UnpushedCommits, k.Branch)
+	case k.Dirty:
+		lost = "uncommitted changes"
+	case k.DeletesBranch && k.UnpushedCommits > 0:
+		lost = fmt.Sprintf("%d unpushed commit(s) on %s", k.UnpushedCommits, k.Branch)
+	default:
+		return ""
+	}
+	return lost + " will be lost"
+}
+
+// KillPlan reports what Kill would throw away, without killing the instance.
+func (i *Instance) KillPlan() (KillImpact, error) {
+	return i.KillPlanWithOptions(KillOptions{})
+}
+
+// KillPlanWithOptions reports what KillWithOptions would throw away with the same options.
+func (i *Instance) KillPlanWithOptions(opts KillOptions) (KillImpact, error) {
+	if !i.started {
+		return KillImpact{}, fmt.Errorf("cannot plan kill of instance that has not been started")
+	}
+	if i.gitWorktree == nil {
+		return KillImpact{}, fmt.Errorf("git worktree not initialized")
+	}
+
+	impact := KillImpact{
+		Branch:        i.gitWorktree.GetBranchName(),
+		DeletesBranch: opts.DeleteBranch && !i.gitWorktree.IsExternalBranch(),
+	}
+	// Paused and detached instances have no worktree left to be dirty.
+	if _, err := os.Stat(i.gitWorktree.GetWorktreePath()); err == nil {
+		dirty, err := i.gitWorktree.IsDirty()
+		if err != nil {
+			return KillImpact{}, err
+		}
+		impact.Dirty = dirty
+	}
+	unpushed, err := i.gitWorktree.UnpushedCommits()
+	if err != nil {
+		return KillImpact{}, err
+	}
+	impact.UnpushedCommits = unpushed
+	return impact, nil
+}
@@ -0,0 +1,98 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"agent-squad/session/git"
+)
+
+func TestKillPlan(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	t.Setenv("HOME", t.TempDir())
+	worktree, _, err := git.NewGitWorktree(repo, "doomed")
+	if err != nil {
+		t.Fatalf("NewGitWorktree: %v", err)
+	}
+	if err := worktree.Setup(); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	t.Cleanup(func() { _ = worktree.Cleanup() })
+	inst := &Instance{Title: "doomed", started: true, Status: Running, gitWorktree: worktree}
+
+	deleteBranch := KillOptions{DeleteBranch: true}
+	impact, err := inst.KillPlanWithOptions(deleteBranch)
+	if err != nil {
+		t.Fatalf("KillPlan: %v", err)
+	}
+	if impact.LosesWork() || impact.String() != "" || !impact.DeletesBranch || impact.Branch != worktree.GetBranchName() {
+		t.Fatalf("expected a fresh instance to lose nothing, got %+v", impact)
+	}
+
+	file := filepath.Join(worktree.GetWorktreePath(), "file.txt")
+	if err := os.WriteFile(file, []byte("changed\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if impact, err = inst.KillPlanWithOptions(deleteBranch); err != nil || !impact.Dirty || impact.UnpushedCommits != 0 {
+		t.Fatalf("expected uncommitted changes only, got %+v, %v", impact, err)
+	}
+
+	runGitInstanceTest(t, worktree.GetWorktreePath(), "commit", "-qam", "work")
+	impact, err = inst.KillPlanWithOptions(deleteBranch)
+	if err != nil || impact.Dirty || impact.UnpushedCommits != 1 {
+		t.Fatalf("expected one unpushed commit, got %+v, %v", impact, err)
+	}
+	if !strings.Contains(impact.String(), "1 unpushed commit(s) on "+impact.Branch) {
+		t.Fatalf("unexpected summary %q", impact.String())
+	}
+
+	// The commits survive when the branch is kept, which is the default.
+	if impact, err = inst.KillPlan(); err != nil || impact.LosesWork() || impact.DeletesBranch {
+		t.Fatalf("expected nothing lost by a default kill, got %+v, %v", impact, err)
+	}
+	worktree.SetExternalBranch(true)
+	if impact, err = inst.KillPlanWithOptions(deleteBranch); err != nil || impact.LosesWork() {
+		t.Fatalf("expected nothing lost when the branch is kept, got %+v, %v", impact, err)
+	}
+}
+
+func TestKillKeepsBranchByDefault(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	t.Setenv("HOME", t.TempDir())
+	newInstance := func(title string) (*Instance, *git.GitWorktree) {
+		t.Helper()
+		worktree, _, err := git.NewGitWorktree(repo, title)
+		if err != nil {
+			t.Fatalf("NewGitWorktree: %v", err)
+		}
+		if err := worktree.Setup(); err != nil {
+			t.Fatalf("Setup: %v", err)
+		}
+		return &Instance{Title: title, started: true, Status: Running, gitWorktree: worktree}, worktree
+	}
+	branchExists := func(branch string) bool {
+		return strings.TrimSpace(runGitInstanceTest(t, repo, "branch", "--list", branch)) != ""
+	}
+
+	kept, keptWorktree := newInstance("kept")
+	if err := kept.Kill(); err != nil {
+		t.Fatalf("Kill: %v", err)
+	}
+	if _, err := os.Stat(keptWorktree.GetWorktreePath()); !os.IsNotExist(err) {
+		t.Fatalf("expected the worktree to be removed, got %v", err)
+	}
+	if !branchExists(keptWorktree.GetBranchName()) {
+		t.Fatal("expected Kill to keep the branch")
+	}
+	runGitInstanceTest(t, repo, "branch", "-D", keptWorktree.GetBranchName())
+
+	deleted, deletedWorktree := newInstance("deleted")
+	if err := deleted.KillWithOptions(KillOptions{DeleteBranch: true}); err != nil {
+		t.Fatalf("KillWithOptions: %v", err)
+	}
+	if branchExists(deletedWorktree.GetBranchName()) {
+		t.Fatal("expected the branch to be deleted")
+	}
+}
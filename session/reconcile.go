@@ -0,0 +1,36 @@
+package session
+
+import (
+	"agent-squad/cmd"
+	"agent-squad/session/tmux"
+)
+
+// ReconcileTmuxSessions matches the tmux sessions created by this tool against instances, e.g.
+// after a crash left sessions running. adopted lists the sessions that belong to a running
+// instance; orphaned lists the ones no running instance owns, including sessions of paused
+// instances, which should have none, so the caller can offer to adopt or kill them.
+func ReconcileTmuxSessions(instances []*Instance) (adopted, orphaned []string, err error) {
+	return reconcileTmuxSessions(cmd.MakeExecutor(), instances)
+}
+
+func reconcileTmuxSessions(cmdExec cmd.Executor, instances []*Instance) (adopted, orphaned []string, err error) {
+	sessions, err := tmux.ListSessions(cmdExec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owned := make(map[string]bool, len(instances))
+	for _, instance := range instances {
+		if instance.Started() && !instance.Paused() {
+			owned[tmux.SessionName(instance.Title)] = true
+		}
+	}
+	for _, name := range sessions {
+		if owned[name] {
+			adopted = append(adopted, name)
+		} else {
+			orphaned = append(orphaned, name)
+		}
+	}
+	return adopted, orphaned, nil
+}
@@ -0,0 +1,34 @@
+package session
+
+import (
+	"agent-squad/session/tmux"
+	"agent-squad/session/tmux/tmuxtest"
+	"slices"
+	"testing"
+)
+
+func TestReconcileTmuxSessions(t *testing.T) {
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	for _, name := range []string{"running", "paused", "zombie"} {
+		server.AddSession(tmux.SessionName(name), t.TempDir(), "bash")
+	}
+	// Sessions the user created themselves are never reported.
+	server.AddSession("mine", t.TempDir(), "bash")
+
+	instances := []*Instance{
+		{Title: "running", started: true, Status: Running},
+		{Title: "paused", started: true, Status: Paused},
+		{Title: "gone", started: true, Status: Running},
+	}
+	adopted, orphaned, err := reconcileTmuxSessions(server, instances)
+	if err != nil {
+		t.Fatalf("reconcileTmuxSessions: %v", err)
+	}
+	if !slices.Equal(adopted, []string{tmux.SessionName("running")}) {
+		t.Fatalf("unexpected adopted sessions %v", adopted)
+	}
+	if !slices.Equal(orphaned, []string{tmux.SessionName("paused"), tmux.SessionName("zombie")}) {
+		t.Fatalf("unexpected orphaned sessions %v", orphaned)
+	}
+}
@@ -0,0 +1,80 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Scheduler limits how many instances run at the same time. Instances that don't fit wait in the
+// Queued status until PromoteQueued starts them.
+type Scheduler struct {
+	maxRunning int
+	// promoting is held while PromoteQueued resumes instances, so overlapping calls don't
+	// promote the same instances twice.
+	promoting sync.Mutex
+}
+
+// NewScheduler returns a scheduler that allows maxRunning instances to run at once. Zero or a
+// negative value means no limit.
+func NewScheduler(maxRunning int) *Scheduler {
+	return &Scheduler{maxRunning: maxRunning}
+}
+
+// running returns how many of instances are started and neither paused nor queued.
+func running(instances []*Instance) int {
+	count := 0
+	for _, instance := range instances {
+		if instance.Started() && !instance.Paused() {
+			count++
+		}
+	}
+	return count
+}
+
+// HasSlot reports whether another instance can start without exceeding the limit.
+func (s *Scheduler) HasSlot(instances []*Instance) bool {
+	return s.maxRunning <= 0 || running(instances) < s.maxRunning
+}
+
+// PromoteQueued starts queued instances, oldest first by CreatedAt, until the limit is reached.
+// It returns the instances it started. Instances queued before they ever ran get their first
+// Start, with the prompt they were queued with; paused instances that were queued are resumed.
+// Starting sets up worktrees and programs, so call it off the UI goroutine; a call made while
+// another is still promoting returns immediately. An instance that fails to start stays queued
+// and its error is returned.
+func (s *Scheduler) PromoteQueued(instances []*Instance) ([]*Instance, error) {
+	if !s.promoting.TryLock() {
+		return nil, nil
+	}
+	defer s.promoting.Unlock()
+
+	var queued []*Instance
+	for _, instance := range instances {
+		if instance.Started() && instance.GetStatus() == Queued {
+			queued = append(queued, instance)
+		}
+	}
+	slices.SortStableFunc(queued, func(a, b *Instance) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	var promoted []*Instance
+	var errs []error
+	for _, instance := range queued {
+		if !s.HasSlot(instances) {
+			break
+		}
+		start := instance.Resume
+		if instance.PendingStart() {
+			start = func() error { return instance.startQueued(context.Background()) }
+		}
+		if err := start(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to start queued instance %s: %w", instance.Title, err))
+			continue
+		}
+		promoted = append(promoted, instance)
+	}
+	return promoted, combineErrors(errs)
+}
@@ -0,0 +1,150 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"agent-squad/config"
+	"agent-squad/session/tmux"
+	"agent-squad/session/tmux/tmuxtest"
+)
+
+func TestSchedulerPromotesOldestQueued(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".agent-squad"), 0o755); err != nil {
+		t.Fatalf("create config dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".agent-squad", config.ConfigFileName), []byte(`{"branch_prefix": "tester/"}`), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+
+	newQueued := func(title string, createdAt time.Time) *Instance {
+		inst, err := NewInstance(InstanceOptions{Title: title, Path: repo, Program: "bash"})
+		if err != nil {
+			t.Fatalf("NewInstance: %v", err)
+		}
+		inst.CreatedAt = createdAt
+		inst.SetTmuxSession(tmuxtest.NewSession(server, title, "bash"))
+		if err := inst.Queue(); err != nil {
+			t.Fatalf("Queue %s: %v", title, err)
+		}
+		t.Cleanup(func() { _ = inst.Kill() })
+		return inst
+	}
+	now := time.Now()
+	running := &Instance{Title: "running", started: true, Status: Running}
+	newer := newQueued("newer", now)
+	older := newQueued("older", now.Add(-time.Minute))
+	instances := []*Instance{running, newer, older}
+
+	scheduler := NewScheduler(2)
+	if scheduler.HasSlot([]*Instance{running, running}) {
+		t.Fatalf("expected no slot with two running instances")
+	}
+	if _, err := os.Stat(older.gitWorktree.GetWorktreePath()); !os.IsNotExist(err) {
+		t.Fatalf("expected queued instance to have no worktree yet, got %v", err)
+	}
+
+	promoted, err := scheduler.PromoteQueued(instances)
+	if err != nil {
+		t.Fatalf("PromoteQueued: %v", err)
+	}
+	if len(promoted) != 1 || promoted[0] != older {
+		t.Fatalf("expected only the oldest queued instance to be promoted, got %v", promoted)
+	}
+	if older.Status != Loading || newer.Status != Queued {
+		t.Fatalf("expected older loading and newer queued, got %v and %v", older.Status, newer.Status)
+	}
+	if _, err := os.Stat(older.gitWorktree.GetWorktreePath()); err != nil {
+		t.Fatalf("expected promoted instance to have a worktree: %v", err)
+	}
+
+	if promoted, err := scheduler.PromoteQueued(instances); err != nil || len(promoted) != 0 {
+		t.Fatalf("expected nothing to be promoted while full, got %v, %v", promoted, err)
+	}
+
+	running.Status = Paused
+	if promoted, err := scheduler.PromoteQueued(instances); err != nil || len(promoted) != 1 || promoted[0] != newer {
+		t.Fatalf("expected the freed slot to go to the remaining queued instance, got %v, %v", promoted, err)
+	}
+}
+
+func TestQueueRefusesRunningInstance(t *testing.T) {
+	inst := &Instance{Title: "busy", started: true, Status: Running}
+	if err := inst.Queue(); err == nil {
+		t.Fatalf("expected Queue to refuse a running instance")
+	}
+	inst.Status = Paused
+	if err := inst.Queue(); err != nil || inst.Status != Queued || !inst.Paused() {
+		t.Fatalf("expected paused instance to be queued, got %v, %v", inst.Status, err)
+	}
+}
+
+func TestPromoteQueuedStartsNewInstance(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".agent-squad"), 0o755); err != nil {
+		t.Fatalf("create config dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".agent-squad", config.ConfigFileName), []byte(`{"branch_prefix": "tester/"}`), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+
+	inst, err := NewInstance(InstanceOptions{Title: "later", Path: repo, Program: "bash"})
+	if err != nil {
+		t.Fatalf("NewInstance: %v", err)
+	}
+	session := tmuxtest.NewSession(server, "later", "bash")
+	session.SetEnterDelay(0)
+	inst.SetTmuxSession(session)
+	if err := inst.Queue(); err != nil {
+		t.Fatalf("Queue: %v", err)
+	}
+	t.Cleanup(func() { _ = inst.Kill() })
+	inst.Prompt = "fix the tests"
+
+	// The pending start and prompt survive a reload.
+	data := inst.ToInstanceData()
+	if !data.PendingStart || data.Prompt != "fix the tests" {
+		t.Fatalf("expected the pending start and prompt to be saved, got %v %q", data.PendingStart, data.Prompt)
+	}
+
+	bus := NewEventBus()
+	events, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+	inst.SetEventBus(bus)
+
+	promoted, err := NewScheduler(1).PromoteQueued([]*Instance{inst})
+	if err != nil || len(promoted) != 1 {
+		t.Fatalf("PromoteQueued: %v, %v", promoted, err)
+	}
+	if inst.Status != Loading || inst.readyPattern == nil || inst.PendingStart() {
+		t.Fatalf("expected a first start, got status %v, ready pattern %v, pending %v",
+			inst.Status, inst.readyPattern, inst.PendingStart())
+	}
+	var types []EventType
+	for len(events) > 0 {
+		types = append(types, (<-events).Type)
+	}
+	if !slices.Contains(types, EventStarted) || slices.Contains(types, EventResumed) {
+		t.Fatalf("expected a started event and no resumed event, got %v", types)
+	}
+	written, err := server.Input(tmux.TmuxPrefix + "later")
+	if err != nil {
+		t.Fatalf("read fake pty: %v", err)
+	}
+	if !strings.Contains(written, "fix the tests") || inst.Prompt != "" {
+		t.Fatalf("expected the queued prompt to be sent once, got %q (left %q)", written, inst.Prompt)
+	}
+}
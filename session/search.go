@@ -0,0 +1,87 @@
+package session
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// defaultSearchContextLines is the number of lines of context SearchHistory returns around a match.
+const defaultSearchContextLines = 2
+
+// ansiEscapeRegex matches the terminal escape sequences kept in captured pane content.
+var ansiEscapeRegex = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)`)
+
+// Match is a line of pane history that matched a search.
+type Match struct {
+	// Line is the 1-based line number in the captured history.
+	Line int
+	// Text is the matching line with terminal escape sequences removed.
+	Text string
+	// Before and After hold the surrounding lines, nearest last and first respectively.
+	Before []string
+	After  []string
+}
+
+// SearchOptions configures SearchHistoryWithOptions.
+type SearchOptions struct {
+	// Literal treats the pattern as a plain substring rather than a regular expression.
+	Literal bool
+	// IgnoreCase matches regardless of case.
+	IgnoreCase bool
+	// ContextLines is the number of lines to include before and after each match.
+	ContextLines int
+}
+
+// SearchHistory returns the lines of the instance's full pane history that match the regular
+// expression pattern, with a little surrounding context.
+func (i *Instance) SearchHistory(pattern string) ([]Match, error) {
+	return i.SearchHistoryWithOptions(pattern, SearchOptions{ContextLines: defaultSearchContextLines})
+}
+
+// SearchHistoryWithOptions is like SearchHistory but configurable.
+func (i *Instance) SearchHistoryWithOptions(pattern string, opts SearchOptions) ([]Match, error) {
+	if !i.started || i.Paused() {
+		return nil, fmt.Errorf("cannot search history of instance that has not been started or is paused")
+	}
+	history, err := i.PreviewFullHistory()
+	if err != nil {
+		return nil, err
+	}
+	return searchLines(history, pattern, opts)
+}
+
+// searchLines finds the lines of content matching pattern.
+func searchLines(content, pattern string, opts SearchOptions) ([]Match, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("search pattern cannot be empty")
+	}
+	expr := pattern
+	if opts.Literal {
+		expr = regexp.QuoteMeta(pattern)
+	}
+	if opts.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search pattern: %w", err)
+	}
+
+	lines := strings.Split(ansiEscapeRegex.ReplaceAllString(content, ""), "\n")
+	var matches []Match
+	for n, line := range lines {
+		if !re.MatchString(line) {
+			continue
+		}
+		start := max(0, n-opts.ContextLines)
+		end := min(len(lines), n+1+opts.ContextLines)
+		matches = append(matches, Match{
+			Line:   n + 1,
+			Text:   line,
+			Before: lines[start:n],
+			After:  lines[n+1 : end],
+		})
+	}
+	return matches, nil
+}
@@ -0,0 +1,41 @@
+package session
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSearchLines(t *testing.T) {
+	content := "one\n\x1b[32mTwo\x1b[0m\nthree\nfour (2)\nfive\n"
+
+	matches, err := searchLines(content, "t[wh]", SearchOptions{ContextLines: 1})
+	if err != nil {
+		t.Fatalf("searchLines: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Line != 3 || matches[0].Text != "three" {
+		t.Fatalf("unexpected case-sensitive matches %+v", matches)
+	}
+	if !slices.Equal(matches[0].Before, []string{"Two"}) || !slices.Equal(matches[0].After, []string{"four (2)"}) {
+		t.Fatalf("unexpected context %+v", matches[0])
+	}
+
+	matches, err = searchLines(content, "t[wh]", SearchOptions{IgnoreCase: true})
+	if err != nil {
+		t.Fatalf("searchLines: %v", err)
+	}
+	if len(matches) != 2 || matches[0].Text != "Two" || matches[1].Text != "three" {
+		t.Fatalf("unexpected case-insensitive matches %+v", matches)
+	}
+
+	matches, err = searchLines(content, "(2)", SearchOptions{Literal: true})
+	if err != nil {
+		t.Fatalf("searchLines: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Line != 4 {
+		t.Fatalf("unexpected literal matches %+v", matches)
+	}
+
+	if _, err := searchLines(content, "(", SearchOptions{}); err == nil {
+		t.Fatal("expected invalid regex to fail")
+	}
+}
@@ -0,0 +1,42 @@
+package session
+
+import "fmt"
+
+// Shutdown prepares for the process to exit. It detaches from every running instance, leaving
+// its tmux session alive so it can be restored later, then saves and flushes the final state so
+// no debounced update is lost. Errors are collected and returned together.
+func Shutdown(instances []*Instance, storage *Storage) error {
+	var errs []error
+	for _, instance := range instances {
+		if !instance.Started() || instance.Paused() {
+			continue
+		}
+		if err := instance.detach(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to detach instance %s: %w", instance.Title, err))
+		}
+	}
+
+	if err := storage.SaveInstances(instances); err != nil {
+		errs = append(errs, fmt.Errorf("failed to save instances: %w", err))
+	}
+	if err := storage.Flush(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to flush instances: %w", err))
+	}
+	return combineErrors(errs)
+}
+
+// detach stops the instance's background work and disconnects from its tmux session without
+// killing it.
+func (i *Instance) detach() error {
+	var errs []error
+	if err := i.stopDiffWatcher(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to stop diff watcher: %w", err))
+	}
+	if err := i.stopTranscript(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close transcript: %w", err))
+	}
+	if err := i.tmuxSession.DetachSafely(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to detach tmux session: %w", err))
+	}
+	return combineErrors(errs)
+}
@@ -0,0 +1,62 @@
+package session
+
+import (
+	"slices"
+)
+
+// SortKey selects the order SortInstances puts instances in.
+type SortKey int
+
+const (
+	// SortByCreated orders instances oldest first. It is the default and matches the order
+	// instances are created and stored in.
+	SortByCreated SortKey = iota
+	// SortByPriority orders instances by descending Priority.
+	SortByPriority
+	// SortByUpdated orders the most recently updated instances first.
+	SortByUpdated
+	// SortByStatus orders instances waiting for input first, then running ones, with paused and
+	// queued instances last.
+	SortByStatus
+	// SortByDiffSize orders instances with the most changed lines first.
+	SortByDiffSize
+)
+
+// statusSortRank is the position of each status under SortByStatus.
+var statusSortRank = map[Status]int{
+	Ready:    0,
+	Running:  1,
+	Loading:  2,
+	Crashed:  3,
+	Detached: 4,
+	Queued:   5,
+	Paused:   6,
+}
+
+// SortInstances sorts instances in place by the given key. The sort is stable, so instances that
+// compare equal keep their relative order and re-sorting a reloaded list does not shuffle them.
+func SortInstances(instances []*Instance, by SortKey) {
+	slices.SortStableFunc(instances, func(a, b *Instance) int {
+		switch by {
+		case SortByPriority:
+			return b.Priority - a.Priority
+		case SortByUpdated:
+			return b.UpdatedAt.Compare(a.UpdatedAt)
+		case SortByStatus:
+			return statusSortRank[a.Status] - statusSortRank[b.Status]
+		case SortByDiffSize:
+			return diffSize(b) - diffSize(a)
+		default:
+			return a.CreatedAt.Compare(b.CreatedAt)
+		}
+	})
+}
+
+// diffSize is the number of lines added and removed in the instance's last known diff.
+func diffSize(instance *Instance) int {
+	stats := instance.GetDiffStats()
+	if stats == nil {
+		return 0
+	}
+	return stats.Added + stats.Removed
+}
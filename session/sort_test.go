@@ -0,0 +1,85 @@
+package session
+
+import (
+	"agent-squad/session/git"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+func instanceTitles(instances []*Instance) []string {
+	titles := make([]string, len(instances))
+	for i, instance := range instances {
+		titles[i] = instance.Title
+	}
+	return titles
+}
+
+func TestSortInstances(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newInstances := func() []*Instance {
+		return []*Instance{
+			{Title: "a", CreatedAt: base, UpdatedAt: base.Add(time.Hour), Status: Paused, Priority: 1,
+				diffStats: &git.DiffStats{Added: 1}},
+			{Title: "b", CreatedAt: base.Add(2 * time.Minute), UpdatedAt: base.Add(3 * time.Hour), Status: Running,
+				diffStats: &git.DiffStats{Added: 10, Removed: 5}},
+			{Title: "c", CreatedAt: base.Add(time.Minute), UpdatedAt: base.Add(2 * time.Hour), Status: Ready, Priority: 5},
+			{Title: "d", CreatedAt: base.Add(time.Minute), UpdatedAt: base, Status: Running, Priority: 1,
+				diffStats: &git.DiffStats{Removed: 3}},
+		}
+	}
+
+	tests := []struct {
+		by   SortKey
+		want []string
+	}{
+		{SortByCreated, []string{"a", "c", "d", "b"}},
+		{SortByPriority, []string{"c", "a", "d", "b"}},
+		{SortByUpdated, []string{"b", "c", "a", "d"}},
+		{SortByStatus, []string{"c", "b", "d", "a"}},
+		{SortByDiffSize, []string{"b", "d", "a", "c"}},
+	}
+	for _, tt := range tests {
+		instances := newInstances()
+		SortInstances(instances, tt.by)
+		if got := instanceTitles(instances); !slices.Equal(got, tt.want) {
+			t.Errorf("sort key %d: expected %v, got %v", tt.by, tt.want, got)
+		}
+	}
+
+	var zero SortKey
+	if zero != SortByCreated {
+		t.Fatalf("expected the zero sort key to sort by creation time")
+	}
+}
+
+func TestPrioritySurvivesReload(t *testing.T) {
+	inst := &Instance{Title: "prio", Status: Paused, started: true, Priority: 3}
+
+	before := inst.ToInstanceData()
+	encoded, err := json.Marshal(before)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var decoded InstanceData
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded.Priority != 3 {
+		t.Fatalf("expected priority 3 after round trip, got %d", decoded.Priority)
+	}
+
+	restored, err := FromInstanceData(decoded)
+	if err != nil {
+		t.Fatalf("FromInstanceData: %v", err)
+	}
+	if restored.Priority != 3 {
+		t.Fatalf("expected restored priority 3, got %d", restored.Priority)
+	}
+
+	decoded.Priority = 0
+	if before.Equal(decoded) {
+		t.Fatal("expected a priority change to be meaningful")
+	}
+}
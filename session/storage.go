@@ -3,9 +3,12 @@ package session
 import (
 	"agent-squad/config"
 	"agent-squad/log"
+	"agent-squad/session/git"
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"os"
+	"slices"
 	"sync"
 	"time"
 )
@@ -21,10 +24,32 @@ type InstanceData struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	AutoYes   bool      `json:"auto_yes"`
+	// ReadOnly is true if the instance refuses input.
+	ReadOnly bool `json:"read_only,omitempty"`
 
 	Program   string          `json:"program"`
 	Worktree  GitWorktreeData `json:"worktree"`
 	DiffStats DiffStatsData   `json:"diff_stats"`
+	// DiffExcludePatterns are pathspec patterns left out of this instance's diff.
+	DiffExcludePatterns []string `json:"diff_exclude_patterns,omitempty"`
+	// RefreshIntervalMs overrides the diff refresh interval for this instance. Zero uses the default.
+	RefreshIntervalMs int64 `json:"refresh_interval_ms,omitempty"`
+	// CommitCount is the number of commits the session made on top of its base commit.
+	CommitCount int `json:"commit_count,omitempty"`
+	// Env holds extra environment variables for the program, as KEY=value.
+	Env []string `json:"env,omitempty"`
+	// Labels tag the instance.
+	Labels []string `json:"labels,omitempty"`
+	// Priority orders the instance when sorting by priority.
+	Priority int `json:"priority,omitempty"`
+	// WorkDir is the directory, relative to the worktree, the program runs in. Empty means the
+	// worktree root.
+	WorkDir string `json:"work_dir,omitempty"`
+	// PendingStart is true for an instance queued before it was ever started. Its worktree and
+	// program are only set up once it is promoted.
+	PendingStart bool `json:"pending_start,omitempty"`
+	// Prompt is sent to a queued instance once it starts.
+	Prompt string `json:"prompt,omitempty"`
 }
 
 // GitWorktreeData represents the serializable data of a GitWorktree
@@ -34,6 +59,10 @@ type GitWorktreeData struct {
 	SessionName   string `json:"session_name"`
 	BranchName    string `json:"branch_name"`
 	BaseCommitSHA string `json:"base_commit_sha"`
+	// BaseBranch is the branch the session forked from. Empty for sessions saved by older versions.
+	BaseBranch string `json:"base_branch,omitempty"`
+	// ExternalBranch is true if the branch existed before the session and must survive Kill.
+	ExternalBranch bool `json:"external_branch,omitempty"`
 }
 
 // DiffStatsData represents the serializable data of a DiffStats
@@ -41,8 +70,115 @@ type DiffStatsData struct {
 	Added   int    `json:"added"`
 	Removed int    `json:"removed"`
 	Content string `json:"content"`
+	// FilesChanged is the number of files with changes.
+	FilesChanged int `json:"files_changed,omitempty"`
 }
 
+// FieldChange describes a single field that differs between two InstanceData values.
+type FieldChange struct {
+	Field string
+	Old   any
+	New   any
+}
+
+// Equal reports whether two InstanceData values are meaningfully the same. Volatile fields such as
+// UpdatedAt and the diff content are ignored.
+func (d InstanceData) Equal(other InstanceData) bool {
+	return len(d.Diff(other)) == 0
+}
+
+// Diff returns the meaningful fields that changed from d to other, in declaration order.
+// UpdatedAt and the diff content are ignored.
+func (d InstanceData) Diff(other InstanceData) []FieldChange {
+	var changes []FieldChange
+	add := func(field string, old, new any) {
+		if old != new {
+			changes = append(changes, FieldChange{Field: field, Old: old, New: new})
+		}
+	}
+
+	add("Title", d.Title, other.Title)
+	add("Path", d.Path, other.Path)
+	add("Branch", d.Branch, other.Branch)
+	add("Status", d.Status, other.Status)
+	add("Height", d.Height, other.Height)
+	add("Width", d.Width, other.Width)
+	if !d.CreatedAt.Equal(other.CreatedAt) {
+		changes = append(changes, FieldChange{Field: "CreatedAt", Old: d.CreatedAt, New: other.CreatedAt})
+	}
+	add("AutoYes", d.AutoYes, other.AutoYes)
+	add("ReadOnly", d.ReadOnly, other.ReadOnly)
+	add("Program", d.Program, other.Program)
+	add("Worktree.RepoPath", d.Worktree.RepoPath, other.Worktree.RepoPath)
+	add("Worktree.WorktreePath", d.Worktree.WorktreePath, other.Worktree.WorktreePath)
+	add("Worktree.SessionName", d.Worktree.SessionName, other.Worktree.SessionName)
+	add("Worktree.BranchName", d.Worktree.BranchName, other.Worktree.BranchName)
+	add("Worktree.BaseCommitSHA", d.Worktree.BaseCommitSHA, other.Worktree.BaseCommitSHA)
+	add("Worktree.BaseBranch", d.Worktree.BaseBranch, other.Worktree.BaseBranch)
+	add("Worktree.ExternalBranch", d.Worktree.ExternalBranch, other.Worktree.ExternalBranch)
+	add("DiffStats.Added", d.DiffStats.Added, other.DiffStats.Added)
+	add("DiffStats.Removed", d.DiffStats.Removed, other.DiffStats.Removed)
+	if !slices.Equal(d.DiffExcludePatterns, other.DiffExcludePatterns) {
+		changes = append(changes, FieldChange{Field: "DiffExcludePatterns", Old: d.DiffExcludePatterns, New: other.DiffExcludePatterns})
+	}
+	add("RefreshIntervalMs", d.RefreshIntervalMs, other.RefreshIntervalMs)
+	add("CommitCount", d.CommitCount, other.CommitCount)
+	if !slices.Equal(d.Env, other.Env) {
+		changes = append(changes, FieldChange{Field: "Env", Old: d.Env, New: other.Env})
+	}
+	if !slices.Equal(d.Labels, other.Labels) {
+		changes = append(changes, FieldChange{Field: "Labels", Old: d.Labels, New: other.Labels})
+	}
+	add("Priority", d.Priority, other.Priority)
+	add("WorkDir", d.WorkDir, other.WorkDir)
+	add("PendingStart", d.PendingStart, other.PendingStart)
+	add("Prompt", d.Prompt, other.Prompt)
+
+	return changes
+}
+
+// instancesDataEqual reports whether two instance sets are meaningfully the same, in order.
+// Diff stat counts that moved by at most diffTolerance lines in total are treated as unchanged.
+func instancesDataEqual(a, b []InstanceData, diffTolerance int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		for _, change := range a[i].Diff(b[i]) {
+			if change.Field == "DiffStats.Added" || change.Field == "DiffStats.Removed" {
+				continue
+			}
+			return false
+		}
+		drift := abs(a[i].DiffStats.Added-b[i].DiffStats.Added) + abs(a[i].DiffStats.Removed-b[i].DiffStats.Removed)
+		if drift > diffTolerance {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+const (
+	// defaultDebounceInterval is the minimum time between two writes of the instance state.
+	defaultDebounceInterval = 5 * time.Second
+	// minDebounceInterval is the smallest interval SetDebounceInterval accepts.
+	minDebounceInterval = 10 * time.Millisecond
+	// maxPendingDelayFloor is the shortest delay before a deferred write, unless the debounce
+	// interval itself is shorter.
+	maxPendingDelayFloor = time.Second
+)
+
+// largePayloadDebounceMultiplier stretches the debounce interval for payloads above the
+// large payload threshold.
+const largePayloadDebounceMultiplier = 4
+
 // Storage handles saving and loading instances using the state interface
 type Storage struct {
 	state            config.InstanceStorage
@@ -52,13 +188,29 @@ type Storage struct {
 	debounceInterval time.Duration
 	pendingData      []byte
 	debounceTimer    *time.Timer
+	// largePayloadBytes is the serialized size above which writes are debounced for longer.
+	// Zero disables the size-aware policy.
+	largePayloadBytes int
+	// diffStatsTolerance is how many lines the diff stat counts of the saved instances may drift
+	// before the change is worth a write.
+	diffStatsTolerance int
+	// onSave, if set, is called with every payload written to the state.
+	onSave func(data []byte)
+	// killed is the data of the last killed instance, recorded at killedAt.
+	killed   *InstanceData
+	killedAt time.Time
+
+	// lastSavedInstances and pendingInstances mirror lastSavedData and pendingData so that
+	// saves can be skipped when nothing meaningful changed.
+	lastSavedInstances []InstanceData
+	pendingInstances   []InstanceData
 }
 
 // NewStorage creates a new storage instance
 func NewStorage(state config.InstanceStorage) (*Storage, error) {
 	return &Storage{
 		state:            state,
-		debounceInterval: 5 * time.Second,
+		debounceInterval: defaultDebounceInterval,
 	}, nil
 }
 
@@ -72,40 +224,132 @@ func (s *Storage) SaveInstances(instances []*Instance) error {
 		}
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.saveDataLocked(data)
+}
+
+// saveDataLocked marshals and persists the instance data, honoring the debounce interval.
+// s.mu must be held.
+func (s *Storage) saveDataLocked(data []InstanceData) error {
+	if !s.lastSaveTime.IsZero() && instancesDataEqual(data, s.lastSavedInstances, s.diffStatsTolerance) {
+		// Whatever was pending has been undone, so there is nothing left to write.
+		s.dropPendingLocked()
+		return nil
+	}
+
 	// Marshal to JSON
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal instances: %w", err)
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	if bytes.Equal(jsonData, s.lastSavedData) && s.pendingData == nil {
 		return nil
 	}
 
+	interval := s.debounceIntervalFor(len(jsonData))
 	now := time.Now()
-	if s.lastSaveTime.IsZero() || now.Sub(s.lastSaveTime) >= s.debounceInterval {
+	if s.lastSaveTime.IsZero() || now.Sub(s.lastSaveTime) >= interval {
 		if err := s.writeLocked(jsonData); err != nil {
 			return err
 		}
-		s.trackImmediateSave(jsonData, now)
+		s.trackImmediateSave(jsonData, data, now)
 		return nil
 	}
 
 	s.pendingData = cloneBytes(jsonData)
+	s.pendingInstances = data
 	if s.debounceTimer == nil {
-		delay := s.debounceInterval - now.Sub(s.lastSaveTime)
-		if delay < time.Second {
-			delay = time.Second
-		}
-		s.debounceTimer = time.AfterFunc(delay, s.flushPending)
+		s.debounceTimer = time.AfterFunc(s.pendingDelayLocked(now), s.flushPending)
 	}
 
 	return nil
 }
 
+// pendingDelayLocked returns how long to wait from now before writing the pending data. s.mu
+// must be held.
+func (s *Storage) pendingDelayLocked(now time.Time) time.Duration {
+	interval := s.debounceIntervalFor(len(s.pendingData))
+	delay := interval - now.Sub(s.lastSaveTime)
+	return max(delay, min(interval, maxPendingDelayFloor))
+}
+
+// SetDebounceInterval sets the minimum time between two writes of the instance state. Intervals
+// below 10ms are raised to 10ms. A pending write is rescheduled for the new interval.
+func (s *Storage) SetDebounceInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("debounce interval must be positive, got %s", interval)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.debounceInterval = max(interval, minDebounceInterval)
+	if s.debounceTimer != nil {
+		s.debounceTimer.Stop()
+		s.debounceTimer = time.AfterFunc(s.pendingDelayLocked(time.Now()), s.flushPending)
+	}
+	return nil
+}
+
+// debounceIntervalFor returns the debounce interval for a payload of the given size. Large
+// payloads are written less often to limit I/O churn from big diff contents.
+func (s *Storage) debounceIntervalFor(size int) time.Duration {
+	if s.largePayloadBytes > 0 && size > s.largePayloadBytes {
+		return s.debounceInterval * largePayloadDebounceMultiplier
+	}
+	return s.debounceInterval
+}
+
+// SetLargePayloadThreshold sets the serialized size in bytes above which saves are debounced
+// for longer. Zero or a negative value disables the size-aware policy.
+func (s *Storage) SetLargePayloadThreshold(bytes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bytes < 0 {
+		bytes = 0
+	}
+	s.largePayloadBytes = bytes
+}
+
+// SetDiffStatsTolerance sets how many lines the added and removed counts of an instance's diff
+// stats may move in total, relative to what was last written, before the change is persisted.
+// Other meaningful changes are always persisted along with the current counts. Zero or a
+// negative value persists every count change.
+func (s *Storage) SetDiffStatsTolerance(lines int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lines < 0 {
+		lines = 0
+	}
+	s.diffStatsTolerance = lines
+}
+
+// SetOnSave sets a function that is called with the serialized instances after every successful
+// write to the state, whether immediate or debounced. It runs in its own goroutine so it never
+// holds up a save; calls for consecutive writes may therefore overlap or run out of order. Pass
+// nil to remove it.
+func (s *Storage) SetOnSave(onSave func(data []byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onSave = onSave
+}
+
+// loadDataLocked returns the most recent instance data, preferring a pending debounced write
+// over what has already been persisted. s.mu must be held.
+func (s *Storage) loadDataLocked() ([]InstanceData, error) {
+	jsonData := s.pendingData
+	if jsonData == nil {
+		jsonData = s.state.GetInstances()
+	}
+
+	var instancesData []InstanceData
+	if err := json.Unmarshal(jsonData, &instancesData); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal instances: %w", err)
+	}
+	return instancesData, nil
+}
+
 // LoadInstances loads the list of instances from disk
 func (s *Storage) LoadInstances() ([]*Instance, error) {
 	jsonData := s.state.GetInstances()
@@ -129,17 +373,19 @@ func (s *Storage) LoadInstances() ([]*Instance, error) {
 
 // DeleteInstance removes an instance from storage
 func (s *Storage) DeleteInstance(title string) error {
-	instances, err := s.LoadInstances()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instancesData, err := s.loadDataLocked()
 	if err != nil {
 		return fmt.Errorf("failed to load instances: %w", err)
 	}
 
 	found := false
-	newInstances := make([]*Instance, 0)
-	for _, instance := range instances {
-		data := instance.ToInstanceData()
+	remaining := make([]InstanceData, 0, len(instancesData))
+	for _, data := range instancesData {
 		if data.Title != title {
-			newInstances = append(newInstances, instance)
+			remaining = append(remaining, data)
 		} else {
 			found = true
 		}
@@ -149,22 +395,82 @@ func (s *Storage) DeleteInstance(title string) error {
 		return fmt.Errorf("instance not found: %s", title)
 	}
 
-	return s.SaveInstances(newInstances)
+	return s.saveDataLocked(remaining)
+}
+
+// Compact drops the stored instances for which validate returns false, e.g. LiveInstanceData,
+// and returns how many were dropped. The survivors are rewritten as they were.
+func (s *Storage) Compact(validate func(InstanceData) bool) (removed int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instancesData, err := s.loadDataLocked()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load instances: %w", err)
+	}
+	survivors := make([]InstanceData, 0, len(instancesData))
+	for _, data := range instancesData {
+		if validate(data) {
+			survivors = append(survivors, data)
+		}
+	}
+	removed = len(instancesData) - len(survivors)
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := s.saveDataLocked(survivors); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// LiveInstanceData reports whether the stored instance can still be loaded: its repository and
+// branch exist and, unless it is paused, so does its worktree. Paused instances have no worktree,
+// so a missing one does not count against them, and a queued instance may not have its branch
+// yet either. Instances that cannot be checked are kept.
+func LiveInstanceData(data InstanceData) bool {
+	if _, err := os.Stat(data.Worktree.RepoPath); os.IsNotExist(err) {
+		return false
+	}
+	if data.Status == Queued {
+		return true
+	}
+	exists, err := git.BranchExists(data.Worktree.RepoPath, data.Worktree.BranchName)
+	if err != nil {
+		log.WarningLog.Printf("could not check the branch of instance %s: %v", data.Title, err)
+		return true
+	}
+	if !exists {
+		return false
+	}
+	if data.Status == Paused {
+		return true
+	}
+	_, err = os.Stat(data.Worktree.WorktreePath)
+	return !os.IsNotExist(err)
 }
 
 // UpdateInstance updates an existing instance in storage
 func (s *Storage) UpdateInstance(instance *Instance) error {
-	instances, err := s.LoadInstances()
+	return s.UpdateInstanceData(instance.ToInstanceData())
+}
+
+// UpdateInstanceData merges a single instance's data into the persisted set, matching by title.
+// The load and save happen under the storage lock so concurrent updates to different instances
+// cannot clobber each other.
+func (s *Storage) UpdateInstanceData(data InstanceData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instancesData, err := s.loadDataLocked()
 	if err != nil {
 		return fmt.Errorf("failed to load instances: %w", err)
 	}
 
-	data := instance.ToInstanceData()
 	found := false
-	for i, existing := range instances {
-		existingData := existing.ToInstanceData()
-		if existingData.Title == data.Title {
-			instances[i] = instance
+	for i, existing := range instancesData {
+		if existing.Title == data.Title {
+			instancesData[i] = data
 			found = true
 			break
 		}
@@ -174,7 +480,44 @@ func (s *Storage) UpdateInstance(instance *Instance) error {
 		return fmt.Errorf("instance not found: %s", data.Title)
 	}
 
-	return s.SaveInstances(instances)
+	return s.saveDataLocked(instancesData)
+}
+
+// trackedBranches returns the branch of every stored instance, paused ones included.
+func (s *Storage) trackedBranches() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instancesData, err := s.loadDataLocked()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load instances: %w", err)
+	}
+	branches := make([]string, 0, len(instancesData))
+	for _, data := range instancesData {
+		if data.Worktree.BranchName != "" {
+			branches = append(branches, data.Worktree.BranchName)
+		}
+	}
+	return branches, nil
+}
+
+// ListOrphans returns the session worktrees and branches in the repository at repoPath that no
+// stored instance owns. Save the current instances first so new ones are not reported.
+func (s *Storage) ListOrphans(repoPath string) ([]git.OrphanInfo, error) {
+	branches, err := s.trackedBranches()
+	if err != nil {
+		return nil, err
+	}
+	return git.ListOrphans(repoPath, branches)
+}
+
+// CleanupOrphans removes the orphans ListOrphans reports; see git.CleanupOrphans.
+func (s *Storage) CleanupOrphans(repoPath string, opts git.CleanupOrphansOptions) (removed, skipped []git.OrphanInfo, err error) {
+	branches, err := s.trackedBranches()
+	if err != nil {
+		return nil, nil, err
+	}
+	return git.CleanupOrphans(repoPath, branches, opts)
 }
 
 // DeleteAllInstances removes all stored instances
@@ -196,8 +539,10 @@ func (s *Storage) Flush() error {
 		return err
 	}
 	s.lastSavedData = data
+	s.lastSavedInstances = s.pendingInstances
 	s.lastSaveTime = time.Now()
 	s.pendingData = nil
+	s.pendingInstances = nil
 	if s.debounceTimer != nil {
 		s.debounceTimer.Stop()
 		s.debounceTimer = nil
@@ -205,10 +550,22 @@ func (s *Storage) Flush() error {
 	return nil
 }
 
-func (s *Storage) trackImmediateSave(data []byte, now time.Time) {
+func (s *Storage) trackImmediateSave(data []byte, instances []InstanceData, now time.Time) {
 	s.lastSavedData = cloneBytes(data)
+	s.lastSavedInstances = instances
 	s.lastSaveTime = now
 	s.pendingData = nil
+	s.pendingInstances = nil
+	if s.debounceTimer != nil {
+		s.debounceTimer.Stop()
+		s.debounceTimer = nil
+	}
+}
+
+// dropPendingLocked discards the pending debounced write, if any. s.mu must be held.
+func (s *Storage) dropPendingLocked() {
+	s.pendingData = nil
+	s.pendingInstances = nil
 	if s.debounceTimer != nil {
 		s.debounceTimer.Stop()
 		s.debounceTimer = nil
@@ -234,14 +591,22 @@ func (s *Storage) flushPending() {
 	}
 
 	s.lastSavedData = data
+	s.lastSavedInstances = s.pendingInstances
 	s.lastSaveTime = time.Now()
 	s.pendingData = nil
+	s.pendingInstances = nil
 	s.debounceTimer = nil
 }
 
 func (s *Storage) writeLocked(data []byte) error {
 	clone := cloneBytes(data)
-	return s.state.SaveInstances(json.RawMessage(clone))
+	if err := s.state.SaveInstances(json.RawMessage(clone)); err != nil {
+		return err
+	}
+	if s.onSave != nil {
+		go s.onSave(cloneBytes(data))
+	}
+	return nil
 }
 
 func cloneBytes(src []byte) []byte {
@@ -2,9 +2,17 @@ package session
 
 import (
 	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"agent-squad/session/git"
+	"agent-squad/session/tmux"
+	"agent-squad/session/tmux/tmuxtest"
 )
 
 type fakeInstanceStorage struct {
@@ -22,7 +30,12 @@ func (f *fakeInstanceStorage) SaveInstances(data json.RawMessage) error {
 }
 
 func (f *fakeInstanceStorage) GetInstances() json.RawMessage {
-	return json.RawMessage("[]")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.writes) == 0 {
+		return json.RawMessage("[]")
+	}
+	return json.RawMessage(f.writes[len(f.writes)-1])
 }
 
 func (f *fakeInstanceStorage) DeleteAllInstances() error {
@@ -83,3 +96,453 @@ func TestStorageDebounceAndFlush(t *testing.T) {
 		t.Fatal("expected debounce timer to be cleared after flush")
 	}
 }
+
+func TestStorageConcurrentUpdateInstanceData(t *testing.T) {
+	store := &fakeInstanceStorage{}
+	s, err := NewStorage(store)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+
+	const count = 8
+	initial := make([]InstanceData, count)
+	for i := range initial {
+		initial[i] = InstanceData{Title: fmt.Sprintf("instance-%d", i)}
+	}
+	s.mu.Lock()
+	if err := s.saveDataLocked(initial); err != nil {
+		s.mu.Unlock()
+		t.Fatalf("seed instances: %v", err)
+	}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := InstanceData{Title: fmt.Sprintf("instance-%d", i), Program: fmt.Sprintf("program-%d", i)}
+			if err := s.UpdateInstanceData(data); err != nil {
+				t.Errorf("UpdateInstanceData %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	var persisted []InstanceData
+	if err := json.Unmarshal(store.GetInstances(), &persisted); err != nil {
+		t.Fatalf("unmarshal persisted: %v", err)
+	}
+	if len(persisted) != count {
+		t.Fatalf("expected %d instances, got %d", count, len(persisted))
+	}
+	for i, data := range persisted {
+		if want := fmt.Sprintf("program-%d", i); data.Program != want {
+			t.Fatalf("instance %s lost its update: got program %q, want %q", data.Title, data.Program, want)
+		}
+	}
+
+	if err := s.DeleteInstance("instance-0"); err != nil {
+		t.Fatalf("DeleteInstance: %v", err)
+	}
+	if err := s.UpdateInstanceData(InstanceData{Title: "instance-0"}); err == nil {
+		t.Fatal("expected update of deleted instance to fail")
+	}
+}
+
+func TestInstanceDataEqualIgnoresVolatileFields(t *testing.T) {
+	created := time.Now()
+	a := InstanceData{
+		Title:     "example",
+		CreatedAt: created,
+		UpdatedAt: created,
+		DiffStats: DiffStatsData{Added: 1, Removed: 2, Content: "+a\n-b\n-c\n"},
+	}
+	b := a
+	b.UpdatedAt = created.Add(time.Minute)
+	b.DiffStats.Content = "reordered"
+
+	if !a.Equal(b) {
+		t.Fatalf("expected data to be equal, got changes %+v", a.Diff(b))
+	}
+
+	b.Status = Paused
+	b.DiffStats.Added = 3
+	changes := a.Diff(b)
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", changes)
+	}
+	if changes[0].Field != "Status" || changes[0].Old != Running || changes[0].New != Paused {
+		t.Fatalf("unexpected status change %+v", changes[0])
+	}
+	if changes[1].Field != "DiffStats.Added" || changes[1].Old != 1 || changes[1].New != 3 {
+		t.Fatalf("unexpected diff stats change %+v", changes[1])
+	}
+}
+
+func TestStorageSkipsSaveWhenOnlyTimestampsChange(t *testing.T) {
+	store := &fakeInstanceStorage{}
+	s, err := NewStorage(store)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	s.debounceInterval = 0
+
+	instance := &Instance{Title: "example"}
+	instance.started = true
+	if err := s.SaveInstances([]*Instance{instance}); err != nil {
+		t.Fatalf("SaveInstances first: %v", err)
+	}
+	// ToInstanceData stamps UpdatedAt with the current time on every call.
+	time.Sleep(time.Millisecond)
+	if err := s.SaveInstances([]*Instance{instance}); err != nil {
+		t.Fatalf("SaveInstances second: %v", err)
+	}
+	if got := store.writeCount(); got != 1 {
+		t.Fatalf("expected timestamp-only change to be skipped, got %d writes", got)
+	}
+
+	instance.Program = "aider"
+	if err := s.SaveInstances([]*Instance{instance}); err != nil {
+		t.Fatalf("SaveInstances third: %v", err)
+	}
+	if got := store.writeCount(); got != 2 {
+		t.Fatalf("expected meaningful change to be written, got %d writes", got)
+	}
+}
+
+func TestStorageDiffStatsTolerance(t *testing.T) {
+	store := &fakeInstanceStorage{}
+	s, err := NewStorage(store)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	s.debounceInterval = 0
+	s.SetDiffStatsTolerance(5)
+
+	instance := &Instance{Title: "example", diffStats: &git.DiffStats{Added: 10, Content: "a"}}
+	instance.started = true
+	if err := s.SaveInstances([]*Instance{instance}); err != nil {
+		t.Fatalf("SaveInstances first: %v", err)
+	}
+
+	instance.diffStats = &git.DiffStats{Added: 13, Removed: 1, Content: "b"}
+	if err := s.SaveInstances([]*Instance{instance}); err != nil {
+		t.Fatalf("SaveInstances within tolerance: %v", err)
+	}
+	if got := store.writeCount(); got != 1 {
+		t.Fatalf("expected diff stat drift within tolerance to be skipped, got %d writes", got)
+	}
+
+	// Drift is measured against the last write, so small steps add up.
+	instance.diffStats = &git.DiffStats{Added: 15, Removed: 1, Content: "c"}
+	if err := s.SaveInstances([]*Instance{instance}); err != nil {
+		t.Fatalf("SaveInstances past tolerance: %v", err)
+	}
+	if got := store.writeCount(); got != 2 {
+		t.Fatalf("expected diff stat drift past tolerance to be written, got %d writes", got)
+	}
+}
+
+func TestStorageDropsPendingWriteWhenUndone(t *testing.T) {
+	store := &fakeInstanceStorage{}
+	s, err := NewStorage(store)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	s.debounceInterval = time.Hour
+
+	instance := &Instance{Title: "example", diffStats: &git.DiffStats{Added: 1}}
+	instance.started = true
+	if err := s.SaveInstances([]*Instance{instance}); err != nil {
+		t.Fatalf("SaveInstances first: %v", err)
+	}
+	instance.diffStats = &git.DiffStats{Added: 2}
+	if err := s.SaveInstances([]*Instance{instance}); err != nil {
+		t.Fatalf("SaveInstances second: %v", err)
+	}
+	instance.diffStats = &git.DiffStats{Added: 1, Content: "reordered"}
+	if err := s.SaveInstances([]*Instance{instance}); err != nil {
+		t.Fatalf("SaveInstances third: %v", err)
+	}
+
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := store.writeCount(); got != 1 {
+		t.Fatalf("expected the undone change not to be written, got %d writes", got)
+	}
+}
+
+func TestStorageDefersLargePayloads(t *testing.T) {
+	store := &fakeInstanceStorage{}
+	s, err := NewStorage(store)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	s.debounceInterval = 20 * time.Millisecond
+	s.SetLargePayloadThreshold(1)
+
+	instance := &Instance{Title: "example"}
+	instance.started = true
+	if err := s.SaveInstances([]*Instance{instance}); err != nil {
+		t.Fatalf("SaveInstances first: %v", err)
+	}
+
+	// Past the normal interval, but within the extended one for large payloads.
+	time.Sleep(30 * time.Millisecond)
+	instance.Program = "aider"
+	if err := s.SaveInstances([]*Instance{instance}); err != nil {
+		t.Fatalf("SaveInstances second: %v", err)
+	}
+	if got := store.writeCount(); got != 1 {
+		t.Fatalf("expected large payload write to be deferred, got %d writes", got)
+	}
+
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := store.writeCount(); got != 2 {
+		t.Fatalf("expected deferred write to flush, got %d", got)
+	}
+}
+
+func TestDiffExcludePatternsSurviveReload(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	head := strings.TrimSpace(runGitInstanceTest(t, repo, "rev-parse", "HEAD"))
+
+	inst := &Instance{
+		Title:       "excludes",
+		Status:      Paused,
+		started:     true,
+		gitWorktree: git.NewGitWorktreeFromStorage(repo, repo, "excludes", "main", head),
+	}
+	inst.SetDiffExcludes([]string{"*.lock", "vendor/**"})
+
+	before := inst.ToInstanceData()
+	encoded, err := json.Marshal(before)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var decoded InstanceData
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !before.Equal(decoded) {
+		t.Fatalf("expected round trip to be equal, got changes %+v", before.Diff(decoded))
+	}
+
+	restored, err := FromInstanceData(decoded)
+	if err != nil {
+		t.Fatalf("FromInstanceData: %v", err)
+	}
+	want := []string{"*.lock", "vendor/**"}
+	if got := restored.DiffExcludes(); !slices.Equal(got, want) {
+		t.Fatalf("expected excludes %v, got %v", want, got)
+	}
+	if got := restored.gitWorktree.GetDiffExcludes(); !slices.Equal(got, want) {
+		t.Fatalf("expected worktree excludes %v, got %v", want, got)
+	}
+
+	decoded.DiffExcludePatterns = nil
+	if before.Equal(decoded) {
+		t.Fatal("expected cleared excludes to be a meaningful change")
+	}
+}
+
+func TestShutdownFlushesAndKeepsSessionsAlive(t *testing.T) {
+	store := &fakeInstanceStorage{}
+	s, err := NewStorage(store)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	s.debounceInterval = time.Hour
+
+	server := tmuxtest.NewServer()
+	t.Cleanup(server.Close)
+	name := tmux.TmuxPrefix + "shutdown"
+	server.AddSession(name, t.TempDir(), "bash")
+	tmuxSession := tmuxtest.NewSession(server, "shutdown", "bash")
+	if err := tmuxSession.Restore(); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	instance := &Instance{Title: "shutdown", started: true, Status: Running, tmuxSession: tmuxSession}
+	if err := s.SaveInstances(nil); err != nil {
+		t.Fatalf("SaveInstances initial: %v", err)
+	}
+	if err := s.SaveInstances([]*Instance{instance}); err != nil {
+		t.Fatalf("SaveInstances pending write: %v", err)
+	}
+	if s.pendingData == nil {
+		t.Fatal("expected pendingData to be queued")
+	}
+
+	if err := Shutdown([]*Instance{instance}, s); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if s.pendingData != nil {
+		t.Fatal("expected Shutdown to flush pending data")
+	}
+	if !strings.Contains(string(store.GetInstances()), `"title":"shutdown"`) {
+		t.Fatalf("expected final state to include the instance, got %s", store.GetInstances())
+	}
+	if !server.HasSession(name) {
+		t.Fatal("expected Shutdown to leave the tmux session running")
+	}
+}
+
+func TestStorageSetDebounceInterval(t *testing.T) {
+	store := &fakeInstanceStorage{}
+	s, err := NewStorage(store)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if err := s.SetDebounceInterval(interval); err == nil {
+			t.Fatalf("expected interval %s to be rejected", interval)
+		}
+	}
+	if err := s.SetDebounceInterval(time.Nanosecond); err != nil {
+		t.Fatalf("SetDebounceInterval: %v", err)
+	}
+	if s.debounceInterval != minDebounceInterval {
+		t.Fatalf("expected interval to be raised to %s, got %s", minDebounceInterval, s.debounceInterval)
+	}
+
+	if err := s.SetDebounceInterval(time.Hour); err != nil {
+		t.Fatalf("SetDebounceInterval: %v", err)
+	}
+	instance := &Instance{Title: "example"}
+	instance.started = true
+	if err := s.SaveInstances(nil); err != nil {
+		t.Fatalf("SaveInstances initial: %v", err)
+	}
+	if err := s.SaveInstances([]*Instance{instance}); err != nil {
+		t.Fatalf("SaveInstances pending write: %v", err)
+	}
+
+	// Shortening the interval reschedules the pending write.
+	if err := s.SetDebounceInterval(20 * time.Millisecond); err != nil {
+		t.Fatalf("SetDebounceInterval: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for store.writeCount() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the pending write to be flushed under the new interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStorageOnSave(t *testing.T) {
+	store := &fakeInstanceStorage{}
+	s, err := NewStorage(store)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	if err := s.SetDebounceInterval(20 * time.Millisecond); err != nil {
+		t.Fatalf("SetDebounceInterval: %v", err)
+	}
+	saved := make(chan []byte, 4)
+	s.SetOnSave(func(data []byte) { saved <- data })
+
+	instance := &Instance{Title: "example"}
+	instance.started = true
+	if err := s.SaveInstances(nil); err != nil {
+		t.Fatalf("SaveInstances initial: %v", err)
+	}
+	// Deferred by the debounce interval and written by the flush timer.
+	if err := s.SaveInstances([]*Instance{instance}); err != nil {
+		t.Fatalf("SaveInstances pending write: %v", err)
+	}
+
+	for _, want := range []string{"[]", `"title":"example"`} {
+		select {
+		case data := <-saved:
+			if !strings.Contains(string(data), want) {
+				t.Fatalf("expected saved payload to contain %s, got %s", want, data)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected OnSave to be called for the payload containing %s", want)
+		}
+	}
+}
+
+func TestStorageLastKilled(t *testing.T) {
+	s, err := NewStorage(&fakeInstanceStorage{})
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	if _, ok := s.LastKilled(); ok {
+		t.Fatal("expected no killed instance before a kill")
+	}
+
+	s.RecordKilled(InstanceData{Title: "first"})
+	s.RecordKilled(InstanceData{Title: "second"})
+	data, ok := s.LastKilled()
+	if !ok || data.Title != "second" {
+		t.Fatalf("expected the last killed instance, got %+v, %v", data, ok)
+	}
+
+	s.killedAt = time.Now().Add(-KilledUndoWindow - time.Second)
+	if _, ok := s.LastKilled(); ok {
+		t.Fatal("expected the record to expire after the undo window")
+	}
+	if _, err := s.RestoreLastKilled(); err == nil {
+		t.Fatal("expected restoring an expired kill to fail")
+	}
+}
+
+func TestStorageCompact(t *testing.T) {
+	repo := setupInstanceTestRepo(t)
+	runGitInstanceTest(t, repo, "branch", "paused-branch")
+	runGitInstanceTest(t, repo, "branch", "running-branch")
+	missingDir := filepath.Join(t.TempDir(), "gone")
+	data := []InstanceData{
+		// Paused instances have no worktree.
+		{Title: "paused", Status: Paused, Worktree: GitWorktreeData{RepoPath: repo, WorktreePath: missingDir, BranchName: "paused-branch"}},
+		{Title: "running", Status: Running, Worktree: GitWorktreeData{RepoPath: repo, WorktreePath: repo, BranchName: "running-branch"}},
+		// A queued new instance gets its branch and worktree once it is promoted.
+		{Title: "queued", Status: Queued, PendingStart: true, Worktree: GitWorktreeData{RepoPath: repo, WorktreePath: missingDir, BranchName: "tester/queued"}},
+		{Title: "no-worktree", Status: Running, Worktree: GitWorktreeData{RepoPath: repo, WorktreePath: missingDir, BranchName: "running-branch"}},
+		{Title: "no-branch", Status: Paused, Worktree: GitWorktreeData{RepoPath: repo, WorktreePath: missingDir, BranchName: "deleted-branch"}},
+		{Title: "no-repo", Status: Paused, Worktree: GitWorktreeData{RepoPath: missingDir, WorktreePath: missingDir, BranchName: "paused-branch"}},
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	store := &fakeInstanceStorage{writes: [][]byte{encoded}}
+	s, err := NewStorage(store)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+
+	removed, err := s.Compact(LiveInstanceData)
+	if err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if removed != 3 {
+		t.Fatalf("expected 3 instances to be removed, got %d", removed)
+	}
+	var survivors []InstanceData
+	if err := json.Unmarshal(store.GetInstances(), &survivors); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(survivors) != 3 || survivors[0].Title != "paused" || survivors[1].Title != "running" || survivors[2].Title != "queued" {
+		t.Fatalf("expected paused, running and queued to survive, got %+v", survivors)
+	}
+
+	writes := store.writeCount()
+	if removed, err := s.Compact(LiveInstanceData); err != nil || removed != 0 {
+		t.Fatalf("expected nothing more to remove, got %d, %v", removed, err)
+	}
+	if store.writeCount() != writes {
+		t.Fatal("expected no write when nothing was removed")
+	}
+}
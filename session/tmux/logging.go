@@ -0,0 +1,66 @@
+package tmux
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// logChunkBytes is the most the rotating log pipe reads from the pane in one go.
+const logChunkBytes = 65536
+
+// StartLogging streams everything the agent's pane prints to the file at path with tmux
+// pipe-pane, appending to it. Once the file reaches maxBytes it is rotated like a transcript,
+// keeping TranscriptBackups older files; zero disables rotation. The file's directory is created
+// if needed. Starting again replaces the previous pipe.
+func (t *TmuxSession) StartLogging(path string, maxBytes int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	cmd := exec.Command("tmux", "pipe-pane", "-t", t.paneTarget(), logPipeCommand(path, maxBytes))
+	if err := t.cmdExec.Run(cmd); err != nil {
+		return fmt.Errorf("error starting pipe-pane for tmux session %s: %w", t.sanitizedName, err)
+	}
+	return nil
+}
+
+// StopLogging stops the pipe started by StartLogging. It is a no-op if the pane is not piped.
+func (t *TmuxSession) StopLogging() error {
+	cmd := exec.Command("tmux", "pipe-pane", "-t", t.paneTarget())
+	if err := t.cmdExec.Run(cmd); err != nil {
+		return fmt.Errorf("error stopping pipe-pane for tmux session %s: %w", t.sanitizedName, err)
+	}
+	return nil
+}
+
+// logPipeCommand returns the shell command pipe-pane feeds the pane output to. Without a size cap
+// it just appends to path. With one it copies the output a chunk at a time, never more than is
+// left before maxBytes, and shifts path to path.1, path.1 to path.2 and so on when it is full.
+func logPipeCommand(path string, maxBytes int64) string {
+	if maxBytes <= 0 {
+		return "cat >> " + shellQuote(path)
+	}
+
+	var rotate strings.Builder
+	for n := TranscriptBackups - 1; n >= 1; n-- {
+		fmt.Fprintf(&rotate, `mv -f "$f.%d" "$f.%d" 2>/dev/null; `, n, n+1)
+	}
+	rotate.WriteString(`mv -f "$f" "$f.1"; s=0`)
+
+	return fmt.Sprintf(`f=%s; s=$(wc -c < "$f" 2>/dev/null) || s=0; s=$((s + 0)); `+
+		`while :; do `+
+		`if [ "$s" -ge %d ]; then %s; fi; `+
+		`r=$((%d - s)); if [ "$r" -gt %d ]; then r=%d; fi; `+
+		`n=$(dd bs="$r" count=1 2>/dev/null | tee -a "$f" | wc -c); n=$((n + 0)); `+
+		`if [ "$n" -eq 0 ]; then break; fi; `+
+		`s=$((s + n)); `+
+		`done`,
+		shellQuote(path), maxBytes, rotate.String(), maxBytes, logChunkBytes, logChunkBytes)
+}
+
+// shellQuote quotes s as a single word for the shell tmux runs pipe commands with.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,64 @@
+package tmux
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cmd2 "agent-squad/cmd"
+	"agent-squad/cmd/cmd_test"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartAndStopLogging(t *testing.T) {
+	var ran []string
+	cmdExec := cmd_test.MockCmdExec{
+		RunFunc: func(cmd *exec.Cmd) error {
+			ran = append(ran, cmd2.ToString(cmd))
+			return nil
+		},
+		OutputFunc: func(cmd *exec.Cmd) ([]byte, error) {
+			return nil, nil
+		},
+	}
+	session := newTmuxSession("test-session", "claude", NewMockPtyFactory(t), cmdExec)
+	path := filepath.Join(t.TempDir(), "logs", "it's.log")
+
+	require.NoError(t, session.StartLogging(path, 0))
+	require.NoError(t, session.StopLogging())
+	require.Equal(t, []string{
+		`tmux pipe-pane -t agentsquad_test-session cat >> '` + filepath.Dir(path) + `/it'\''s.log'`,
+		"tmux pipe-pane -t agentsquad_test-session",
+	}, ran)
+
+	info, err := os.Stat(filepath.Dir(path))
+	require.NoError(t, err)
+	require.True(t, info.IsDir())
+}
+
+func TestLogPipeCommandRotatesAtMaxBytes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "it's.log")
+	require.NoError(t, os.WriteFile(path, []byte("0123"), 0644))
+
+	input := strings.Repeat("abcdefghij", 4) + "xyz"
+	shell := exec.Command("sh", "-c", logPipeCommand(path, 10))
+	shell.Stdin = strings.NewReader(input)
+	require.NoError(t, shell.Run())
+
+	var kept string
+	for n := TranscriptBackups; n >= 1; n-- {
+		data, err := os.ReadFile(rotatedTranscriptPath(path, n))
+		require.NoError(t, err)
+		require.Len(t, data, 10)
+		kept += string(data)
+	}
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	kept += string(data)
+
+	// The oldest output is dropped once every backup is in use.
+	require.Equal(t, ("0123" + input)[10:], kept)
+}
@@ -0,0 +1,122 @@
+package tmux
+
+import (
+	"agent-squad/cmd"
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultCommandRetries is how many times an idempotent tmux command is retried when none is
+// configured.
+const DefaultCommandRetries = 2
+
+// retryBaseDelay is the wait before the first retry. It doubles for each further retry.
+const retryBaseDelay = 50 * time.Millisecond
+
+// idempotentCommands are the tmux commands that are safe to run again after a failure. Commands
+// such as new-session or send-keys are not, since a failed attempt may still have taken effect.
+var idempotentCommands = map[string]bool{
+	"has-session":     true,
+	"capture-pane":    true,
+	"display-message": true,
+	"list-windows":    true,
+	"list-sessions":   true,
+	"ls":              true,
+	"set-option":      true,
+}
+
+// transientErrors are messages tmux prints when its server is briefly unavailable, e.g. while it
+// restarts, as opposed to failures such as a missing session that retrying can't fix.
+var transientErrors = []string{
+	"error connecting to",
+	"server exited unexpectedly",
+	"lost server",
+	"Resource temporarily unavailable",
+}
+
+// retryingExecutor retries idempotent tmux commands that fail because the tmux server was
+// briefly unavailable, waiting a little longer before each attempt.
+type retryingExecutor struct {
+	exec cmd.Executor
+	// retries is how many times a command is run again after a transient failure.
+	retries int
+	// sleep waits between attempts. Tests replace it.
+	sleep func(time.Duration)
+}
+
+func newRetryingExecutor(exec cmd.Executor) *retryingExecutor {
+	return &retryingExecutor{exec: exec, retries: DefaultCommandRetries, sleep: time.Sleep}
+}
+
+// Run implements cmd.Executor.
+func (r *retryingExecutor) Run(c *exec.Cmd) error {
+	_, err := r.do(c, func(c *exec.Cmd) ([]byte, error) {
+		var stderr bytes.Buffer
+		if c.Stderr == nil {
+			c.Stderr = &stderr
+		}
+		err := r.exec.Run(c)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) == 0 {
+			exitErr.Stderr = stderr.Bytes()
+		}
+		return nil, err
+	})
+	return err
+}
+
+// Output implements cmd.Executor.
+func (r *retryingExecutor) Output(c *exec.Cmd) ([]byte, error) {
+	return r.do(c, r.exec.Output)
+}
+
+func (r *retryingExecutor) do(c *exec.Cmd, run func(*exec.Cmd) ([]byte, error)) ([]byte, error) {
+	retries := 0
+	if len(c.Args) > 1 && idempotentCommands[c.Args[1]] {
+		retries = r.retries
+	}
+
+	output, err := run(c)
+	delay := retryBaseDelay
+	for attempt := 0; attempt < retries && err != nil && isTransient(err); attempt++ {
+		r.sleep(delay)
+		delay *= 2
+		// An exec.Cmd can only be run once.
+		c = copyCmd(c)
+		output, err = run(c)
+	}
+	return output, err
+}
+
+// isTransient reports whether err looks like the tmux server was briefly unavailable.
+func isTransient(err error) bool {
+	msg := err.Error()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		msg += " " + string(exitErr.Stderr)
+	}
+	for _, transient := range transientErrors {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyCmd returns an unstarted copy of c.
+func copyCmd(c *exec.Cmd) *exec.Cmd {
+	copied := exec.Command(c.Args[0], c.Args[1:]...)
+	copied.Path = c.Path
+	copied.Dir = c.Dir
+	copied.Env = c.Env
+	return copied
+}
+
+// SetCommandRetries sets how many times idempotent tmux commands such as has-session and
+// capture-pane are retried when the tmux server is briefly unavailable. Zero disables retries.
+func (t *TmuxSession) SetCommandRetries(retries int) {
+	t.retrier.retries = max(retries, 0)
+}
@@ -0,0 +1,74 @@
+package tmux
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"agent-squad/cmd/cmd_test"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryingExecutor(t *testing.T) {
+	var calls map[string]int
+	var failures int
+	failWith := errors.New("error connecting to /tmp/tmux-1000/default (Resource temporarily unavailable)")
+	mock := cmd_test.MockCmdExec{
+		RunFunc: func(cmd *exec.Cmd) error {
+			calls[cmd.Args[1]]++
+			if calls[cmd.Args[1]] <= failures {
+				return failWith
+			}
+			return nil
+		},
+		OutputFunc: func(cmd *exec.Cmd) ([]byte, error) {
+			calls[cmd.Args[1]]++
+			if calls[cmd.Args[1]] <= failures {
+				return nil, failWith
+			}
+			return []byte("content"), nil
+		},
+	}
+	var slept []time.Duration
+	session := newTmuxSession("test-session", "claude", NewMockPtyFactory(t), mock)
+	session.retrier.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	reset := func(n int) {
+		calls = make(map[string]int)
+		failures = n
+		slept = nil
+	}
+
+	// Idempotent commands are retried with growing delays.
+	reset(2)
+	require.True(t, session.DoesSessionExist())
+	require.Equal(t, 3, calls["has-session"])
+	require.Equal(t, []time.Duration{retryBaseDelay, 2 * retryBaseDelay}, slept)
+
+	reset(1)
+	content, err := session.CapturePaneContent()
+	require.NoError(t, err)
+	require.Equal(t, "content", content)
+	require.Equal(t, 2, calls["capture-pane"])
+
+	// They give up after the configured number of retries.
+	reset(5)
+	session.SetCommandRetries(1)
+	require.False(t, session.DoesSessionExist())
+	require.Equal(t, 2, calls["has-session"])
+
+	// Commands that may have taken effect are never retried.
+	reset(1)
+	session.SetCommandRetries(3)
+	require.Error(t, session.retrier.Run(exec.Command("tmux", "new-session", "-d", "-s", "x")))
+	require.Equal(t, 1, calls["new-session"])
+
+	// Neither are failures retrying can't fix.
+	reset(1)
+	failWith = errors.New("can't find session: test-session")
+	require.False(t, session.DoesSessionExist())
+	require.Equal(t, 1, calls["has-session"])
+	require.Empty(t, slept)
+}
@@ -0,0 +1,156 @@
+package tmux
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sinceAnchorLines is how many of the last returned lines CapturePaneContentSince looks for
+	// to find where new output starts once the scrollback is full.
+	sinceAnchorLines = 3
+	// sinceWindowLines is how far above the cursor CapturePaneContentSince looks for new output
+	// once the scrollback is full.
+	sinceWindowLines = 500
+)
+
+// StreamOutput polls the pane every interval and sends lines that were not in the previous
+// capture. The channel is closed when ctx is cancelled or the session goes away.
+func (t *TmuxSession) StreamOutput(ctx context.Context, interval time.Duration) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var prev []string
+		for {
+			if !t.DoesSessionExist() {
+				return
+			}
+			content, err := t.CapturePaneContent()
+			if err != nil {
+				return
+			}
+			curr := paneLines(content)
+			for _, line := range newLines(prev, curr) {
+				select {
+				case out <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+			prev = curr
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// paneLines splits captured pane content into lines, dropping the blank padding tmux adds below
+// the last line of output.
+func paneLines(content string) []string {
+	lines := strings.Split(content, "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// newLines returns the lines of curr that follow its longest overlap with the end of prev. As
+// output scrolls, the top of the new capture repeats the bottom of the previous one.
+func newLines(prev, curr []string) []string {
+	maxOverlap := min(len(prev), len(curr))
+	for k := maxOverlap; k > 0; k-- {
+		if equalLines(prev[len(prev)-k:], curr[:k]) {
+			return curr[k:]
+		}
+	}
+	return curr
+}
+
+// linesAfter returns the lines of window that follow the last place the end of tail appears in
+// it, or all of window if it does not appear.
+func linesAfter(tail, window []string) []string {
+	anchor := tail[max(0, len(tail)-sinceAnchorLines):]
+	if len(anchor) == 0 {
+		return window
+	}
+	for end := len(window); end >= len(anchor); end-- {
+		if equalLines(anchor, window[end-len(anchor):end]) {
+			return window[end:]
+		}
+	}
+	return window
+}
+
+func equalLines(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// CapturePaneContentSince returns the complete lines the pane printed since the previous call,
+// or since the session started for the first call, each followed by a newline. The line the
+// cursor is on is left for a later call, since the program may still be writing it.
+//
+// Lines are tracked by their position in the scrollback. If the pane was cleared, so there are
+// fewer lines than before, the cursor resets to the top of the visible pane and its content is
+// returned. Once the scrollback is full, old lines drop off and positions shift, so new lines
+// are found after the last lines returned before instead, looking at most sinceWindowLines
+// above the cursor.
+func (t *TmuxSession) CapturePaneContentSince() (string, error) {
+	cmd := exec.Command("tmux", "display-message", "-p", "-t", t.paneTarget(), "#{history_size} #{history_limit} #{cursor_y}")
+	output, err := t.cmdExec.Output(cmd)
+	if err != nil {
+		return "", fmt.Errorf("error reading pane position of tmux session %s: %w", t.sanitizedName, err)
+	}
+	var historySize, historyLimit, cursorY int
+	if _, err := fmt.Sscan(string(output), &historySize, &historyLimit, &cursorY); err != nil {
+		return "", fmt.Errorf("unexpected pane position %q for tmux session %s", output, t.sanitizedName)
+	}
+	end := historySize + cursorY
+
+	var lines []string
+	if historyLimit > 0 && historySize >= historyLimit {
+		start := max(-historySize, cursorY-sinceWindowLines)
+		content, err := t.CapturePaneContentWithOptions(strconv.Itoa(start), strconv.Itoa(cursorY-1))
+		if err != nil {
+			return "", err
+		}
+		lines = linesAfter(t.sinceTail, strings.Split(strings.TrimSuffix(content, "\n"), "\n"))
+	} else {
+		if t.sinceCursor > end {
+			t.sinceCursor = historySize
+		}
+		if t.sinceCursor < end {
+			content, err := t.CapturePaneContentWithOptions(strconv.Itoa(t.sinceCursor-historySize), strconv.Itoa(cursorY-1))
+			if err != nil {
+				return "", err
+			}
+			lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+		}
+	}
+	t.sinceCursor = end
+	if len(lines) == 0 {
+		return "", nil
+	}
+
+	t.sinceTail = append(t.sinceTail, lines...)
+	if extra := len(t.sinceTail) - sinceAnchorLines; extra > 0 {
+		t.sinceTail = append([]string(nil), t.sinceTail[extra:]...)
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
@@ -0,0 +1,119 @@
+package tmux
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"agent-squad/cmd/cmd_test"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLines(t *testing.T) {
+	require.Equal(t, []string{"a", "b"}, newLines(nil, []string{"a", "b"}))
+	require.Empty(t, newLines([]string{"a", "b"}, []string{"a", "b"}))
+	// Output scrolled by one line.
+	require.Equal(t, []string{"d"}, newLines([]string{"a", "b", "c"}, []string{"b", "c", "d"}))
+	// Output appended below existing lines.
+	require.Equal(t, []string{"c"}, newLines([]string{"a", "b"}, []string{"a", "b", "c"}))
+	// Screen was redrawn with nothing in common.
+	require.Equal(t, []string{"x", "y"}, newLines([]string{"a", "b"}, []string{"x", "y"}))
+}
+
+func TestPaneLinesTrimsBlankPadding(t *testing.T) {
+	require.Equal(t, []string{"$ ls", "file"}, paneLines("$ ls\nfile\n\n  \n"))
+}
+
+// fakePane models a tmux pane of the given height. The last line of lines is the one the cursor
+// is on; everything above the visible part is scrollback, capped at limit lines.
+type fakePane struct {
+	lines  []string
+	height int
+	limit  int
+}
+
+func (p *fakePane) print(lines ...string) {
+	p.lines = append(p.lines[:len(p.lines)-1], lines...)
+	p.lines = append(p.lines, "")
+	if extra := len(p.lines) - p.height - p.limit; extra > 0 {
+		p.lines = p.lines[extra:]
+	}
+}
+
+func (p *fakePane) historySize() int {
+	return max(0, len(p.lines)-p.height)
+}
+
+func (p *fakePane) exec() cmd_test.MockCmdExec {
+	return cmd_test.MockCmdExec{
+		RunFunc: func(cmd *exec.Cmd) error { return nil },
+		OutputFunc: func(cmd *exec.Cmd) ([]byte, error) {
+			args := cmd.Args
+			switch args[1] {
+			case "display-message":
+				return []byte(fmt.Sprintf("%d %d %d\n", p.historySize(), p.limit, len(p.lines)-p.historySize()-1)), nil
+			case "capture-pane":
+				var start, end int
+				for i, arg := range args {
+					if arg == "-S" {
+						start, _ = strconv.Atoi(args[i+1])
+					} else if arg == "-E" {
+						end, _ = strconv.Atoi(args[i+1])
+					}
+				}
+				var b strings.Builder
+				for _, line := range p.lines[p.historySize()+start : p.historySize()+end+1] {
+					b.WriteString(line + "\n")
+				}
+				return []byte(b.String()), nil
+			}
+			return nil, fmt.Errorf("unexpected command %v", args)
+		},
+	}
+}
+
+func TestCapturePaneContentSince(t *testing.T) {
+	pane := &fakePane{lines: []string{""}, height: 3, limit: 100}
+	session := newTmuxSession("test-session", "claude", NewMockPtyFactory(t), pane.exec())
+
+	since := func() string {
+		t.Helper()
+		out, err := session.CapturePaneContentSince()
+		require.NoError(t, err)
+		return out
+	}
+
+	require.Empty(t, since())
+	pane.print("one", "two")
+	require.Equal(t, "one\ntwo\n", since())
+	require.Empty(t, since())
+
+	// Lines that scrolled into the scrollback between calls are not missed.
+	pane.print("three", "four", "five", "six")
+	require.Equal(t, "three\nfour\nfive\nsix\n", since())
+
+	// After a clear, the cursor starts over at the top of the visible pane.
+	pane.lines = []string{"$ clear", ""}
+	require.Equal(t, "$ clear\n", since())
+	pane.print("seven")
+	require.Equal(t, "seven\n", since())
+}
+
+func TestCapturePaneContentSinceFullScrollback(t *testing.T) {
+	pane := &fakePane{lines: []string{""}, height: 4, limit: 2}
+	session := newTmuxSession("test-session", "claude", NewMockPtyFactory(t), pane.exec())
+
+	pane.print("a", "b", "c", "d", "e")
+	out, err := session.CapturePaneContentSince()
+	require.NoError(t, err)
+	require.Equal(t, "a\nb\nc\nd\ne\n", out)
+
+	// The scrollback is full now, so lines drop off the top as new ones arrive.
+	pane.print("f", "g")
+	out, err = session.CapturePaneContentSince()
+	require.NoError(t, err)
+	require.Equal(t, "f\ng\n", out)
+}
@@ -11,11 +11,14 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/creack/pty"
+	"golang.org/x/term"
 )
 
 const ProgramClaude = "claude"
@@ -32,8 +35,21 @@ type TmuxSession struct {
 	program       string
 	// ptyFactory is used to create a PTY for the tmux session.
 	ptyFactory PtyFactory
-	// cmdExec is used to execute commands in the tmux session.
+	// cmdExec is used to execute commands in the tmux session. It is retrier.
 	cmdExec cmd.Executor
+	// retrier retries idempotent commands when the tmux server is briefly unavailable.
+	retrier *retryingExecutor
+	// historyLimit is the scrollback size set on the session in Start. Zero means DefaultHistoryLimit.
+	historyLimit int
+	// readOnly attaches the PTY with attach-session -r, so tmux ignores input written to it.
+	readOnly bool
+	// enterDelay is how long PastePrompt waits between the paste and the enter key.
+	enterDelay time.Duration
+	// programWrapper, if set, is a command such as "nice -n 10" that Start runs the program under.
+	programWrapper string
+	// activityPattern, if set, makes HasUpdated report an update while the pane matches it, even
+	// if the content did not change.
+	activityPattern *regexp.Regexp
 
 	// Initialized by Start or Restore
 	//
@@ -43,6 +59,15 @@ type TmuxSession struct {
 	ptmx *os.File
 	// monitor monitors the tmux pane content and sends signals to the UI when it's status changes
 	monitor *statusMonitor
+	// hasAgentWindow is true if the program runs in a window named AgentWindow. Sessions created
+	// before windows were named only have their default window.
+	hasAgentWindow bool
+
+	// sinceCursor is the index, counted from the oldest scrollback line, of the first line
+	// CapturePaneContentSince has not returned yet.
+	sinceCursor int
+	// sinceTail holds the last lines CapturePaneContentSince returned.
+	sinceTail []string
 
 	// Initialized by Attach
 	// Deinitilaized by Detach
@@ -58,6 +83,12 @@ type TmuxSession struct {
 
 const TmuxPrefix = "agentsquad_"
 
+// DefaultHistoryLimit is the scrollback size, in lines, used when none is configured.
+const DefaultHistoryLimit = 50000
+
+// AgentWindow is the name of the tmux window running the instance's program.
+const AgentWindow = "agent"
+
 var whiteSpaceRegex = regexp.MustCompile(`\s+`)
 
 func toAgentSquadTmuxName(str string) string {
@@ -77,24 +108,44 @@ func NewTmuxSessionWithDeps(name string, program string, ptyFactory PtyFactory,
 }
 
 func newTmuxSession(name string, program string, ptyFactory PtyFactory, cmdExec cmd.Executor) *TmuxSession {
+	retrier := newRetryingExecutor(cmdExec)
 	return &TmuxSession{
 		sanitizedName: toAgentSquadTmuxName(name),
 		program:       program,
 		ptyFactory:    ptyFactory,
-		cmdExec:       cmdExec,
+		cmdExec:       retrier,
+		retrier:       retrier,
+		enterDelay:    DefaultEnterDelay,
 	}
 }
 
 // Start creates and starts a new tmux session, then attaches to it. Program is the command to run in
 // the session (ex. claude). workdir is the git worktree directory.
 func (t *TmuxSession) Start(workDir string) error {
+	return t.StartContext(context.Background(), workDir)
+}
+
+// StartContext is like Start but gives up once ctx is done, killing the session if it was
+// already created.
+func (t *TmuxSession) StartContext(ctx context.Context, workDir string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	// Check if the session already exists
 	if t.DoesSessionExist() {
 		return fmt.Errorf("tmux session already exists: %s", t.sanitizedName)
 	}
 
+	command, err := t.wrappedProgram()
+	if err != nil {
+		return err
+	}
+
+	// A new session has no output yet.
+	t.sinceCursor, t.sinceTail = 0, nil
+
 	// Create a new detached tmux session and start claude in it
-	cmd := exec.Command("tmux", "new-session", "-d", "-s", t.sanitizedName, "-c", workDir, t.program)
+	cmd := exec.Command("tmux", "new-session", "-d", "-s", t.sanitizedName, "-n", AgentWindow, "-c", workDir, command)
 
 	ptmx, err := t.ptyFactory.Start(cmd)
 	if err != nil {
@@ -118,6 +169,12 @@ func (t *TmuxSession) Start(workDir string) error {
 				err = fmt.Errorf("%v (cleanup error: %v)", err, cleanupErr)
 			}
 			return fmt.Errorf("timed out waiting for tmux session %s: %v", t.sanitizedName, err)
+		case <-ctx.Done():
+			err := ctx.Err()
+			if cleanupErr := t.Close(); cleanupErr != nil {
+				err = fmt.Errorf("%w (cleanup error: %v)", err, cleanupErr)
+			}
+			return fmt.Errorf("interrupted waiting for tmux session %s: %w", t.sanitizedName, err)
 		default:
 			time.Sleep(sleepDuration)
 			// Exponential backoff up to 50ms max
@@ -128,8 +185,12 @@ func (t *TmuxSession) Start(workDir string) error {
 	}
 	ptmx.Close()
 
-	// Set history limit to enable scrollback (default is 2000, we'll use 10000 for more history)
-	historyCmd := exec.Command("tmux", "set-option", "-t", t.sanitizedName, "history-limit", "10000")
+	// Raise the history limit from tmux's default of 2000 lines to keep more scrollback.
+	historyLimit := t.historyLimit
+	if historyLimit <= 0 {
+		historyLimit = DefaultHistoryLimit
+	}
+	historyCmd := exec.Command("tmux", "set-option", "-t", t.sanitizedName, "history-limit", strconv.Itoa(historyLimit))
 	if err := t.cmdExec.Run(historyCmd); err != nil {
 		log.InfoLog.Printf("Warning: failed to set history-limit for session %s: %v", t.sanitizedName, err)
 	}
@@ -166,7 +227,15 @@ func (t *TmuxSession) Start(workDir string) error {
 
 		for time.Since(startTime) < maxWaitTime {
 			attempt++
-			time.Sleep(sleepDuration)
+			select {
+			case <-ctx.Done():
+				err := ctx.Err()
+				if cleanupErr := t.Close(); cleanupErr != nil {
+					err = fmt.Errorf("%w (cleanup error: %v)", err, cleanupErr)
+				}
+				return fmt.Errorf("interrupted starting tmux session %s: %w", t.sanitizedName, err)
+			case <-time.After(sleepDuration):
+			}
 			content, err := t.CapturePaneContent()
 			if err != nil {
 				// Session might not be ready yet, continue waiting
@@ -189,17 +258,70 @@ func (t *TmuxSession) Start(workDir string) error {
 	return nil
 }
 
+// SetReadOnly sets whether the session's PTY is attached read-only. If the session is running
+// and the mode changes, the PTY is reattached in the new mode.
+func (t *TmuxSession) SetReadOnly(readOnly bool) error {
+	if t.readOnly == readOnly {
+		return nil
+	}
+	t.readOnly = readOnly
+	if t.ptmx == nil {
+		return nil
+	}
+	if err := t.ptmx.Close(); err != nil {
+		return fmt.Errorf("error closing attach pty session: %w", err)
+	}
+	return t.Restore()
+}
+
+// SetHistoryLimit sets the scrollback size, in lines, applied when the session is started.
+func (t *TmuxSession) SetHistoryLimit(lines int) {
+	t.historyLimit = lines
+}
+
+// HistoryLimit returns the scrollback size tmux actually uses for the agent's pane. tmux fixes a
+// pane's limit when the pane is created, so this can differ from the configured value, e.g. for
+// sessions started by an older version.
+func (t *TmuxSession) HistoryLimit() (int, error) {
+	cmd := exec.Command("tmux", "display-message", "-p", "-t", t.paneTarget(), "#{history_limit}")
+	output, err := t.cmdExec.Output(cmd)
+	if err != nil {
+		return 0, fmt.Errorf("error reading history limit of tmux session %s: %w", t.sanitizedName, err)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected history limit %q for tmux session %s", output, t.sanitizedName)
+	}
+	return limit, nil
+}
+
 // Restore attaches to an existing session and restores the window size
 func (t *TmuxSession) Restore() error {
-	ptmx, err := t.ptyFactory.Start(exec.Command("tmux", "attach-session", "-t", t.sanitizedName))
+	args := []string{"attach-session"}
+	if t.readOnly {
+		args = append(args, "-r")
+	}
+	ptmx, err := t.ptyFactory.Start(exec.Command("tmux", append(args, "-t", t.sanitizedName)...))
 	if err != nil {
 		return fmt.Errorf("error opening PTY: %w", err)
 	}
 	t.ptmx = ptmx
 	t.monitor = newStatusMonitor()
+	if windows, err := t.ListWindows(); err == nil {
+		t.hasAgentWindow = slices.Contains(windows, AgentWindow)
+	}
 	return nil
 }
 
+// paneTarget returns the tmux target for the agent's pane, so captures and key presses are not
+// sent to a side window that happens to be active.
+func (t *TmuxSession) paneTarget() string {
+	if t.hasAgentWindow {
+		return t.sanitizedName + ":" + AgentWindow
+	}
+	return t.sanitizedName
+}
+
 // statusMonitor tracks changes in tmux pane output using fast, non-cryptographic
 // hashing to minimize overhead during frequent polling (every 100-500ms).
 //
@@ -260,6 +382,147 @@ func (t *TmuxSession) SendKeys(keys string) error {
 	return err
 }
 
+// PasteText pastes text into the pane through a tmux paste buffer, so it arrives as a single
+// bracketed paste instead of typed keys: newlines do not submit it and indentation survives.
+func (t *TmuxSession) PasteText(text string) error {
+	buffer := t.promptBuffer()
+	load := exec.Command("tmux", "load-buffer", "-b", buffer, "-")
+	load.Stdin = strings.NewReader(text)
+	if err := t.cmdExec.Run(load); err != nil {
+		return fmt.Errorf("error loading paste buffer for tmux session %s: %w", t.sanitizedName, err)
+	}
+	// -d deletes the buffer once it is pasted; -p pastes it as a bracketed paste.
+	paste := exec.Command("tmux", "paste-buffer", "-d", "-p", "-b", buffer, "-t", t.paneTarget())
+	if err := t.cmdExec.Run(paste); err != nil {
+		// The buffer is only deleted by a successful paste, so drop it here to avoid leaking it
+		// into the server's buffer list. Failing to do so is not worth masking the paste error.
+		_ = t.cmdExec.Run(exec.Command("tmux", "delete-buffer", "-b", buffer))
+		return fmt.Errorf("error pasting into tmux session %s: %w", t.sanitizedName, err)
+	}
+	return nil
+}
+
+// promptBuffer is the name of the tmux paste buffer PasteText uses for this session.
+func (t *TmuxSession) promptBuffer() string {
+	return TmuxPrefix + "prompt_" + strings.TrimPrefix(t.sanitizedName, TmuxPrefix)
+}
+
+// DefaultEnterDelay is the pause PastePrompt leaves between the paste and the enter key unless
+// SetEnterDelay changes it.
+const DefaultEnterDelay = 100 * time.Millisecond
+
+// SetEnterDelay sets how long PastePrompt waits after pasting before it submits the prompt.
+func (t *TmuxSession) SetEnterDelay(d time.Duration) {
+	t.enterDelay = max(d, 0)
+}
+
+// SetProgramWrapper makes Start run the program under wrapper, e.g. "nice -n 10" or "firejail",
+// by prefixing it to the program's command line. An empty wrapper runs the program directly.
+func (t *TmuxSession) SetProgramWrapper(wrapper string) {
+	t.programWrapper = strings.TrimSpace(wrapper)
+}
+
+// wrappedProgram returns the command line Start runs: the program, prefixed with the program
+// wrapper if one is set. The wrapper's command must be on PATH.
+func (t *TmuxSession) wrappedProgram() (string, error) {
+	if t.programWrapper == "" {
+		return t.program, nil
+	}
+	name := strings.Fields(t.programWrapper)[0]
+	if _, err := exec.LookPath(name); err != nil {
+		return "", fmt.Errorf("program wrapper %q not found: %w", name, err)
+	}
+	return t.programWrapper + " " + t.program, nil
+}
+
+// SetActivityPattern makes HasUpdated treat a pane matching pattern, e.g. a "Thinking..." line
+// the program shows while it works, as updated. A nil pattern only counts changed content.
+func (t *TmuxSession) SetActivityPattern(pattern *regexp.Regexp) {
+	t.activityPattern = pattern
+}
+
+// EnterDelay returns how long to wait between sending a prompt and the enter key that submits it.
+func (t *TmuxSession) EnterDelay() time.Duration {
+	return t.enterDelay
+}
+
+// PastePrompt pastes a possibly multi-line prompt with PasteText and submits it with a single
+// enter, so embedded newlines do not submit partial prompts.
+func (t *TmuxSession) PastePrompt(text string) error {
+	if err := t.PasteText(text); err != nil {
+		return err
+	}
+	// Give the program a moment to finish processing the paste before the enter arrives.
+	time.Sleep(t.enterDelay)
+	return t.TapEnter()
+}
+
+// interruptRepeatDelay is the pause between the two Ctrl-C presses of SendDoubleInterrupt.
+const interruptRepeatDelay = 200 * time.Millisecond
+
+// SendInterrupt sends Ctrl-C to the pane.
+func (t *TmuxSession) SendInterrupt() error {
+	cmd := exec.Command("tmux", "send-keys", "-t", t.paneTarget(), "C-c")
+	if err := t.cmdExec.Run(cmd); err != nil {
+		return fmt.Errorf("error sending interrupt to tmux session %s: %w", t.sanitizedName, err)
+	}
+	return nil
+}
+
+// specialKeyNames are the tmux key names accepted by SendSpecialKeys, with tmux's aliases.
+var specialKeyNames = map[string]bool{
+	"Enter": true, "Escape": true, "Tab": true, "BTab": true, "Space": true, "BSpace": true,
+	"Up": true, "Down": true, "Left": true, "Right": true, "Home": true, "End": true,
+	"PageUp": true, "PgUp": true, "PPage": true, "PageDown": true, "PgDn": true, "NPage": true,
+	"Insert": true, "IC": true, "Delete": true, "DC": true,
+	"F1": true, "F2": true, "F3": true, "F4": true, "F5": true, "F6": true,
+	"F7": true, "F8": true, "F9": true, "F10": true, "F11": true, "F12": true,
+}
+
+// validateKeyName checks that key is a tmux key name: one of specialKeyNames, or a key with any
+// of the modifier prefixes C- (Ctrl), M- (Meta/Alt) and S- (Shift), e.g. "C-c" or "M-Enter".
+func validateKeyName(key string) error {
+	name := key
+	modified := false
+	for len(name) > 2 && (strings.HasPrefix(name, "C-") || strings.HasPrefix(name, "M-") || strings.HasPrefix(name, "S-")) {
+		name = name[2:]
+		modified = true
+	}
+	if specialKeyNames[name] || (modified && len(name) == 1 && name[0] > ' ' && name[0] < 0x7f) {
+		return nil
+	}
+	return fmt.Errorf("unknown tmux key name %q", key)
+}
+
+// SendSpecialKeys sends named keys such as "Escape", "C-c" or "PageUp" to the pane, in order.
+// Unlike SendKeys the names are interpreted by tmux rather than typed literally. Names are
+// validated before anything is sent; see validateKeyName for the accepted vocabulary.
+func (t *TmuxSession) SendSpecialKeys(keys ...string) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys to send")
+	}
+	for _, key := range keys {
+		if err := validateKeyName(key); err != nil {
+			return err
+		}
+	}
+	cmd := exec.Command("tmux", append([]string{"send-keys", "-t", t.paneTarget()}, keys...)...)
+	if err := t.cmdExec.Run(cmd); err != nil {
+		return fmt.Errorf("error sending keys to tmux session %s: %w", t.sanitizedName, err)
+	}
+	return nil
+}
+
+// SendDoubleInterrupt sends Ctrl-C twice with a short delay, for REPLs that only stop on the
+// second press.
+func (t *TmuxSession) SendDoubleInterrupt() error {
+	if err := t.SendInterrupt(); err != nil {
+		return err
+	}
+	time.Sleep(interruptRepeatDelay)
+	return t.SendInterrupt()
+}
+
 // HasUpdated checks if the tmux pane content has changed since the last tick. It also returns true if
 // the tmux pane has a prompt for aider or claude code.
 func (t *TmuxSession) HasUpdated() (updated bool, hasPrompt bool) {
@@ -284,10 +547,27 @@ func (t *TmuxSession) HasUpdated() (updated bool, hasPrompt bool) {
 		t.monitor.prevOutputSeen = true
 		return true, hasPrompt
 	}
+	if t.activityPattern != nil && t.activityPattern.MatchString(content) {
+		return true, hasPrompt
+	}
 	return false, hasPrompt
 }
 
+// Attach attaches the current terminal to the session, sized to the terminal.
 func (t *TmuxSession) Attach() (chan struct{}, error) {
+	return t.AttachWithSize(0, 0)
+}
+
+// AttachWithSize attaches the current terminal to the session. The PTY is resized to width x
+// height before any output is shown, so the program redraws for the right dimensions straight
+// away instead of keeping the size it had while detached. Zero dimensions use the terminal's size.
+func (t *TmuxSession) AttachWithSize(width, height int) (chan struct{}, error) {
+	t.resizeForAttach(width, height)
+
+	if err := t.selectAgentWindow(); err != nil {
+		log.WarningLog.Printf("could not select agent window for %s: %v", t.sanitizedName, err)
+	}
+
 	t.attachCh = make(chan struct{})
 
 	t.wg = &sync.WaitGroup{}
@@ -364,12 +644,85 @@ func (t *TmuxSession) Attach() (chan struct{}, error) {
 	return t.attachCh, nil
 }
 
+// NewWindow opens a named window in the session running command, in the session's directory.
+// The window is created in the background; use SelectWindow to switch to it.
+func (t *TmuxSession) NewWindow(name, command string) error {
+	return t.NewWindowInDir(name, "", command)
+}
+
+// NewWindowInDir is like NewWindow but starts the window in workDir.
+func (t *TmuxSession) NewWindowInDir(name, workDir, command string) error {
+	if err := validateWindowName(name); err != nil {
+		return err
+	}
+	args := []string{"new-window", "-d", "-t", t.sanitizedName + ":", "-n", name}
+	if workDir != "" {
+		args = append(args, "-c", workDir)
+	}
+	if command != "" {
+		args = append(args, command)
+	}
+	if err := t.cmdExec.Run(exec.Command("tmux", args...)); err != nil {
+		return fmt.Errorf("error creating window %s in tmux session %s: %w", name, t.sanitizedName, err)
+	}
+	return nil
+}
+
+// ListWindows returns the names of the session's windows in index order.
+func (t *TmuxSession) ListWindows() ([]string, error) {
+	cmd := exec.Command("tmux", "list-windows", "-t", t.sanitizedName, "-F", "#{window_name}")
+	output, err := t.cmdExec.Output(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("error listing windows of tmux session %s: %w", t.sanitizedName, err)
+	}
+	var windows []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			windows = append(windows, line)
+		}
+	}
+	return windows, nil
+}
+
+// SelectWindow makes the named window the active one, so it is shown on attach.
+func (t *TmuxSession) SelectWindow(name string) error {
+	if err := validateWindowName(name); err != nil {
+		return err
+	}
+	cmd := exec.Command("tmux", "select-window", "-t", t.sanitizedName+":"+name)
+	if err := t.cmdExec.Run(cmd); err != nil {
+		return fmt.Errorf("error selecting window %s in tmux session %s: %w", name, t.sanitizedName, err)
+	}
+	return nil
+}
+
+// selectAgentWindow switches to the agent window. Sessions created before windows were named
+// fall back to their first window.
+func (t *TmuxSession) selectAgentWindow() error {
+	if err := t.SelectWindow(AgentWindow); err == nil {
+		return nil
+	}
+	cmd := exec.Command("tmux", "select-window", "-t", t.sanitizedName+":^")
+	return t.cmdExec.Run(cmd)
+}
+
+func validateWindowName(name string) error {
+	if name == "" || strings.ContainsAny(name, ":. \t") {
+		return fmt.Errorf("invalid tmux window name %q", name)
+	}
+	return nil
+}
+
 // DetachSafely disconnects from the current tmux session without panicking
 func (t *TmuxSession) DetachSafely() error {
 	// Only detach if we're actually attached
 	if t.attachCh == nil {
 		return nil // Already detached
 	}
+	// Leave the agent window active so keys sent while detached reach the program.
+	if err := t.selectAgentWindow(); err != nil {
+		log.WarningLog.Printf("could not select agent window for %s: %v", t.sanitizedName, err)
+	}
 
 	var errs []error
 
@@ -410,6 +763,9 @@ func (t *TmuxSession) DetachSafely() error {
 func (t *TmuxSession) Detach() {
 	// TODO: control flow is a bit messy here. If there's an error,
 	// I'm not sure if we get into a bad state. Needs testing.
+	if err := t.selectAgentWindow(); err != nil {
+		log.WarningLog.Printf("could not select agent window for %s: %v", t.sanitizedName, err)
+	}
 	defer func() {
 		close(t.attachCh)
 		t.attachCh = nil
@@ -477,7 +833,47 @@ func (t *TmuxSession) SetDetachedSize(width, height int) error {
 	return t.updateWindowSize(width, height)
 }
 
+// Resize sets the width and height of the session like SetDetachedSize, then redraws every
+// client attached to the session, so the new size takes effect immediately instead of on the
+// next attach.
+func (t *TmuxSession) Resize(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid size %dx%d", width, height)
+	}
+	if t.ptmx == nil {
+		return fmt.Errorf("session %s is not attached", t.sanitizedName)
+	}
+	if err := t.updateWindowSize(width, height); err != nil {
+		return fmt.Errorf("error resizing session %s: %w", t.sanitizedName, err)
+	}
+
+	listCmd := exec.Command("tmux", "list-clients", "-t", t.sanitizedName, "-F", "#{client_name}")
+	output, err := t.cmdExec.Output(listCmd)
+	if err != nil {
+		return fmt.Errorf("error listing clients of session %s: %w", t.sanitizedName, err)
+	}
+	for _, client := range strings.Fields(string(output)) {
+		if err := t.cmdExec.Run(exec.Command("tmux", "refresh-client", "-t", client)); err != nil {
+			return fmt.Errorf("error refreshing client %s: %w", client, err)
+		}
+	}
+	return nil
+}
+
 // updateWindowSize updates the window size of the PTY.
+// resizeForAttach resizes the PTY to width x height, or to the terminal's size if either is zero,
+// ahead of attaching. Failures are only logged since the program still works at the old size.
+func (t *TmuxSession) resizeForAttach(width, height int) {
+	if width <= 0 || height <= 0 {
+		width, height, _ = term.GetSize(int(os.Stdin.Fd()))
+	}
+	if width > 0 && height > 0 {
+		if err := t.updateWindowSize(width, height); err != nil {
+			log.WarningLog.Printf("could not resize %s before attaching: %v", t.sanitizedName, err)
+		}
+	}
+}
+
 func (t *TmuxSession) updateWindowSize(cols, rows int) error {
 	return pty.Setsize(t.ptmx, &pty.Winsize{
 		Rows: uint16(rows),
@@ -496,7 +892,7 @@ func (t *TmuxSession) DoesSessionExist() bool {
 // CapturePaneContent captures the content of the tmux pane
 func (t *TmuxSession) CapturePaneContent() (string, error) {
 	// Add -e flag to preserve escape sequences (ANSI color codes)
-	cmd := exec.Command("tmux", "capture-pane", "-p", "-e", "-J", "-t", t.sanitizedName)
+	cmd := exec.Command("tmux", "capture-pane", "-p", "-e", "-J", "-t", t.paneTarget())
 	output, err := t.cmdExec.Output(cmd)
 	if err != nil {
 		return "", fmt.Errorf("error capturing pane content: %v", err)
@@ -508,7 +904,7 @@ func (t *TmuxSession) CapturePaneContent() (string, error) {
 // start and end specify the starting and ending line numbers (use "-" for the start/end of history)
 func (t *TmuxSession) CapturePaneContentWithOptions(start, end string) (string, error) {
 	// Add -e flag to preserve escape sequences (ANSI color codes)
-	cmd := exec.Command("tmux", "capture-pane", "-p", "-e", "-J", "-S", start, "-E", end, "-t", t.sanitizedName)
+	cmd := exec.Command("tmux", "capture-pane", "-p", "-e", "-J", "-S", start, "-E", end, "-t", t.paneTarget())
 	output, err := t.cmdExec.Output(cmd)
 	if err != nil {
 		return "", fmt.Errorf("failed to capture tmux pane content with options: %v", err)
@@ -516,31 +912,47 @@ func (t *TmuxSession) CapturePaneContentWithOptions(start, end string) (string,
 	return string(output), nil
 }
 
-// CleanupSessions kills all tmux sessions that start with "session-"
-func CleanupSessions(cmdExec cmd.Executor) error {
-	// First try to list sessions
-	cmd := exec.Command("tmux", "ls")
+// SessionName returns the tmux session name used for an instance titled title.
+func SessionName(title string) string {
+	return toAgentSquadTmuxName(title)
+}
+
+// ListSessions returns the names of the tmux sessions created by this tool, i.e. those carrying
+// TmuxPrefix. It returns no sessions if no tmux server is running.
+func ListSessions(cmdExec cmd.Executor) ([]string, error) {
+	cmd := exec.Command("tmux", "ls", "-F", "#{session_name}")
 	output, err := cmdExec.Output(cmd)
 
 	// If there's an error and it's because no server is running, that's fine
 	// Exit code 1 typically means no sessions exist
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
-			return nil // No sessions to clean up
+			return nil, nil
 		}
-		return fmt.Errorf("failed to list tmux sessions: %v", err)
+		return nil, fmt.Errorf("failed to list tmux sessions: %v", err)
 	}
 
-	re := regexp.MustCompile(fmt.Sprintf(`%s.*:`, TmuxPrefix))
-	matches := re.FindAllString(string(output), -1)
-	for i, match := range matches {
-		matches[i] = match[:strings.Index(match, ":")]
+	var names []string
+	for _, line := range strings.Split(string(output), "\n") {
+		// Session names cannot contain ':', so this also handles the default "name: ..." format.
+		name, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+		if strings.HasPrefix(name, TmuxPrefix) {
+			names = append(names, name)
+		}
 	}
+	return names, nil
+}
 
-	for _, match := range matches {
-		log.InfoLog.Printf("cleaning up session: %s", match)
-		if err := cmdExec.Run(exec.Command("tmux", "kill-session", "-t", match)); err != nil {
-			return fmt.Errorf("failed to kill tmux session %s: %v", match, err)
+// CleanupSessions kills all tmux sessions created by this tool.
+func CleanupSessions(cmdExec cmd.Executor) error {
+	names, err := ListSessions(cmdExec)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		log.InfoLog.Printf("cleaning up session: %s", name)
+		if err := cmdExec.Run(exec.Command("tmux", "kill-session", "-t", name)); err != nil {
+			return fmt.Errorf("failed to kill tmux session %s: %v", name, err)
 		}
 	}
 	return nil
@@ -2,19 +2,28 @@ package tmux
 
 import (
 	cmd2 "agent-squad/cmd"
+	"agent-squad/log"
 	"fmt"
 	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
 	"agent-squad/cmd/cmd_test"
 
+	"github.com/creack/pty"
 	"github.com/stretchr/testify/require"
 )
 
+func TestMain(m *testing.M) {
+	log.Initialize(false)
+	defer log.Close()
+	os.Exit(m.Run())
+}
+
 type MockPtyFactory struct {
 	t *testing.T
 
@@ -72,7 +81,7 @@ func TestStartTmuxSession(t *testing.T) {
 	err := session.Start(workdir)
 	require.NoError(t, err)
 	require.Equal(t, 2, len(ptyFactory.cmds))
-	require.Equal(t, fmt.Sprintf("tmux new-session -d -s agentsquad_test-session -c %s claude", workdir),
+	require.Equal(t, fmt.Sprintf("tmux new-session -d -s agentsquad_test-session -n agent -c %s claude", workdir),
 		cmd2.ToString(ptyFactory.cmds[0]))
 	require.Equal(t, "tmux attach-session -t agentsquad_test-session",
 		cmd2.ToString(ptyFactory.cmds[1]))
@@ -86,3 +95,233 @@ func TestStartTmuxSession(t *testing.T) {
 	_, err = ptyFactory.files[1].Stat()
 	require.NoError(t, err)
 }
+
+func TestStartWithProgramWrapper(t *testing.T) {
+	ptyFactory := NewMockPtyFactory(t)
+	created := false
+	cmdExec := cmd_test.MockCmdExec{
+		RunFunc: func(cmd *exec.Cmd) error {
+			if strings.Contains(cmd.String(), "has-session") && !created {
+				created = true
+				return fmt.Errorf("no session")
+			}
+			return nil
+		},
+		OutputFunc: func(cmd *exec.Cmd) ([]byte, error) {
+			return []byte(""), nil
+		},
+	}
+
+	workdir := t.TempDir()
+	session := newTmuxSession("test-session", "aider --model gpt-4o", ptyFactory, cmdExec)
+	session.SetProgramWrapper(" nice -n 10 ")
+	require.NoError(t, session.Start(workdir))
+	require.Equal(t, []string{"tmux", "new-session", "-d", "-s", "agentsquad_test-session", "-n", "agent", "-c", workdir,
+		"nice -n 10 aider --model gpt-4o"}, ptyFactory.cmds[0].Args)
+
+	missing := newTmuxSession("missing-wrapper", "claude", NewMockPtyFactory(t), cmd_test.MockCmdExec{
+		RunFunc: func(cmd *exec.Cmd) error {
+			return fmt.Errorf("no session")
+		},
+		OutputFunc: func(cmd *exec.Cmd) ([]byte, error) {
+			return []byte(""), nil
+		},
+	})
+	missing.SetProgramWrapper("agentsquad-no-such-wrapper --flag")
+	err := missing.Start(workdir)
+	require.ErrorContains(t, err, `program wrapper "agentsquad-no-such-wrapper" not found`)
+}
+
+func TestSendInterrupt(t *testing.T) {
+	var ran []string
+	cmdExec := cmd_test.MockCmdExec{
+		RunFunc: func(cmd *exec.Cmd) error {
+			ran = append(ran, cmd2.ToString(cmd))
+			return nil
+		},
+		OutputFunc: func(cmd *exec.Cmd) ([]byte, error) {
+			return nil, nil
+		},
+	}
+	session := newTmuxSession("test-session", "claude", NewMockPtyFactory(t), cmdExec)
+
+	require.NoError(t, session.SendInterrupt())
+	require.Equal(t, []string{"tmux send-keys -t agentsquad_test-session C-c"}, ran)
+
+	ran = nil
+	require.NoError(t, session.SendDoubleInterrupt())
+	require.Len(t, ran, 2)
+}
+
+func TestStartSetsDefaultHistoryLimit(t *testing.T) {
+	var ran []string
+	created := false
+	cmdExec := cmd_test.MockCmdExec{
+		RunFunc: func(cmd *exec.Cmd) error {
+			ran = append(ran, cmd2.ToString(cmd))
+			if strings.Contains(cmd.String(), "has-session") && !created {
+				created = true
+				return fmt.Errorf("no session")
+			}
+			return nil
+		},
+		OutputFunc: func(cmd *exec.Cmd) ([]byte, error) {
+			return []byte(""), nil
+		},
+	}
+	session := newTmuxSession("test-session", "bash", NewMockPtyFactory(t), cmdExec)
+
+	require.NoError(t, session.Start(t.TempDir()))
+	require.Contains(t, ran, fmt.Sprintf("tmux set-option -t agentsquad_test-session history-limit %d", DefaultHistoryLimit))
+}
+
+func TestSetReadOnlyReattaches(t *testing.T) {
+	ptyFactory := NewMockPtyFactory(t)
+	cmdExec := cmd_test.MockCmdExec{
+		RunFunc: func(cmd *exec.Cmd) error {
+			return nil
+		},
+		OutputFunc: func(cmd *exec.Cmd) ([]byte, error) {
+			return []byte(""), nil
+		},
+	}
+	session := newTmuxSession("test-session", "bash", ptyFactory, cmdExec)
+	require.NoError(t, session.Restore())
+
+	require.NoError(t, session.SetReadOnly(true))
+	require.NoError(t, session.SetReadOnly(true))
+	require.NoError(t, session.SetReadOnly(false))
+
+	var attaches []string
+	for _, cmd := range ptyFactory.cmds {
+		attaches = append(attaches, cmd2.ToString(cmd))
+	}
+	require.Equal(t, []string{
+		"tmux attach-session -t agentsquad_test-session",
+		"tmux attach-session -r -t agentsquad_test-session",
+		"tmux attach-session -t agentsquad_test-session",
+	}, attaches)
+
+	// The PTYs of the earlier attaches are closed.
+	_, err := ptyFactory.files[1].Stat()
+	require.Error(t, err)
+}
+
+// realPtyFactory hands out real PTYs, so their size can be read back, without running anything.
+type realPtyFactory struct {
+	t     *testing.T
+	ptmxs []*os.File
+}
+
+func (f *realPtyFactory) Start(cmd *exec.Cmd) (*os.File, error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	f.t.Cleanup(func() { _ = tty.Close() })
+	f.ptmxs = append(f.ptmxs, ptmx)
+	return ptmx, nil
+}
+
+func (f *realPtyFactory) Close() {}
+
+func TestResizeRefreshesAttachedClients(t *testing.T) {
+	factory := &realPtyFactory{t: t}
+	var refreshed []string
+	cmdExec := cmd_test.MockCmdExec{
+		RunFunc: func(cmd *exec.Cmd) error {
+			if slices.Contains(cmd.Args, "refresh-client") {
+				refreshed = append(refreshed, cmd.Args[len(cmd.Args)-1])
+			}
+			return nil
+		},
+		OutputFunc: func(cmd *exec.Cmd) ([]byte, error) {
+			if slices.Contains(cmd.Args, "list-clients") {
+				return []byte("/dev/pts/3\n/dev/pts/7\n"), nil
+			}
+			return []byte(""), nil
+		},
+	}
+	session := newTmuxSession("test-session", "bash", factory, cmdExec)
+	require.Error(t, session.Resize(80, 24), "an unattached session cannot be resized")
+	require.NoError(t, session.Restore())
+
+	require.NoError(t, session.Resize(100, 30))
+	rows, cols, err := pty.Getsize(factory.ptmxs[0])
+	require.NoError(t, err)
+	require.Equal(t, 100, cols)
+	require.Equal(t, 30, rows)
+	require.Equal(t, []string{"/dev/pts/3", "/dev/pts/7"}, refreshed)
+
+	require.Error(t, session.Resize(0, 30))
+}
+
+func TestAttachWithSizeResizesBeforeAttaching(t *testing.T) {
+	factory := &realPtyFactory{t: t}
+	cmdExec := cmd_test.MockCmdExec{
+		RunFunc: func(cmd *exec.Cmd) error {
+			return nil
+		},
+		OutputFunc: func(cmd *exec.Cmd) ([]byte, error) {
+			return []byte(""), nil
+		},
+	}
+	session := newTmuxSession("test-session", "bash", factory, cmdExec)
+	require.NoError(t, session.Restore())
+	require.NoError(t, session.SetDetachedSize(40, 10))
+
+	// AttachWithSize resizes with resizeForAttach before it starts copying to and from the pane.
+	session.resizeForAttach(120, 50)
+	rows, cols, err := pty.Getsize(factory.ptmxs[0])
+	require.NoError(t, err)
+	require.Equal(t, 120, cols)
+	require.Equal(t, 50, rows)
+}
+
+func TestSendSpecialKeys(t *testing.T) {
+	var ran []string
+	cmdExec := cmd_test.MockCmdExec{
+		RunFunc: func(cmd *exec.Cmd) error {
+			ran = append(ran, cmd2.ToString(cmd))
+			return nil
+		},
+		OutputFunc: func(cmd *exec.Cmd) ([]byte, error) {
+			return nil, nil
+		},
+	}
+	session := newTmuxSession("test-session", "claude", NewMockPtyFactory(t), cmdExec)
+
+	require.NoError(t, session.SendSpecialKeys("Escape", "C-c", "M-S-Up", "PageUp", "Enter"))
+	require.Equal(t, []string{"tmux send-keys -t agentsquad_test-session Escape C-c M-S-Up PageUp Enter"}, ran)
+
+	ran = nil
+	for _, keys := range [][]string{{}, {"Esc"}, {"Enter", "hello"}, {"C-"}, {"x"}} {
+		require.Error(t, session.SendSpecialKeys(keys...), "keys %q", keys)
+	}
+	require.Empty(t, ran)
+}
+
+func TestPastePromptDeletesBufferOnFailure(t *testing.T) {
+	var ran []string
+	cmdExec := cmd_test.MockCmdExec{
+		RunFunc: func(cmd *exec.Cmd) error {
+			ran = append(ran, cmd2.ToString(cmd))
+			if cmd.Args[1] == "paste-buffer" {
+				return fmt.Errorf("pane gone")
+			}
+			return nil
+		},
+		OutputFunc: func(cmd *exec.Cmd) ([]byte, error) {
+			return nil, nil
+		},
+	}
+	session := newTmuxSession("test-session", "claude", NewMockPtyFactory(t), cmdExec)
+	session.SetEnterDelay(0)
+
+	require.Error(t, session.PastePrompt("line one\nline two"))
+	require.Equal(t, []string{
+		"tmux load-buffer -b agentsquad_prompt_test-session -",
+		"tmux paste-buffer -d -p -b agentsquad_prompt_test-session -t agentsquad_test-session",
+		"tmux delete-buffer -b agentsquad_prompt_test-session",
+	}, ran)
+}
@@ -0,0 +1,403 @@
+// Package tmuxtest provides an in-memory tmux server for testing code built on the tmux package
+// without a real tmux binary.
+package tmuxtest
+
+import (
+	"agent-squad/session/tmux"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Server fakes a tmux server. It implements both cmd.Executor and tmux.PtyFactory, so it can be
+// passed to tmux.NewTmuxSessionWithDeps for both dependencies.
+//
+// PTYs handed out by the server are temporary files, so anything written to a session (keys,
+// prompts, enter taps) can be read back with Input. Resizing these PTYs is not supported.
+type Server struct {
+	mu       sync.Mutex
+	sessions map[string]*fakeSession
+	commands []string
+	files    []*os.File
+	buffers  map[string]string
+
+	// FailNewSession, if set, is returned when a new session is created.
+	FailNewSession error
+	// InitialContent is the pane content of newly created sessions.
+	InitialContent string
+}
+
+type fakeSession struct {
+	workDir string
+	program string
+	content string
+	history string
+	options map[string]string
+	ptys    []*os.File
+	windows []string
+	active  string
+}
+
+func newFakeSession(workDir, program, window string) *fakeSession {
+	return &fakeSession{
+		workDir: workDir,
+		program: program,
+		options: make(map[string]string),
+		windows: []string{window},
+		active:  window,
+	}
+}
+
+// NewServer returns an empty fake tmux server.
+func NewServer() *Server {
+	return &Server{sessions: make(map[string]*fakeSession), buffers: make(map[string]string)}
+}
+
+// NewSession returns a TmuxSession backed by server.
+func NewSession(server *Server, name string, program string) *tmux.TmuxSession {
+	return tmux.NewTmuxSessionWithDeps(name, program, server, server)
+}
+
+// Run implements cmd.Executor.
+func (s *Server) Run(cmd *exec.Cmd) error {
+	_, err := s.handle(cmd)
+	return err
+}
+
+// Output implements cmd.Executor.
+func (s *Server) Output(cmd *exec.Cmd) ([]byte, error) {
+	out, err := s.handle(cmd)
+	return []byte(out), err
+}
+
+// Start implements tmux.PtyFactory.
+func (s *Server) Start(cmd *exec.Cmd) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, strings.Join(cmd.Args, " "))
+
+	var name string
+	switch subcommand(cmd) {
+	case "new-session":
+		if s.FailNewSession != nil {
+			return nil, s.FailNewSession
+		}
+		name = flagValue(cmd.Args, "-s")
+		if _, ok := s.sessions[name]; ok {
+			return nil, fmt.Errorf("duplicate session: %s", name)
+		}
+		window := flagValue(cmd.Args, "-n")
+		if window == "" {
+			window = "0"
+		}
+		session := newFakeSession(flagValue(cmd.Args, "-c"), cmd.Args[len(cmd.Args)-1], window)
+		session.content = s.InitialContent
+		s.sessions[name] = session
+	case "attach-session":
+		name, _ = splitTarget(flagValue(cmd.Args, "-t"))
+		if _, ok := s.sessions[name]; !ok {
+			return nil, fmt.Errorf("can't find session: %s", name)
+		}
+	default:
+		return nil, fmt.Errorf("tmuxtest: unsupported pty command %q", strings.Join(cmd.Args, " "))
+	}
+
+	f, err := os.CreateTemp("", "tmuxtest-pty")
+	if err != nil {
+		return nil, err
+	}
+	s.files = append(s.files, f)
+	session := s.sessions[name]
+	session.ptys = append(session.ptys, f)
+	return f, nil
+}
+
+// Close implements tmux.PtyFactory. It closes and removes every PTY file the server handed out.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.files {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+	s.files = nil
+}
+
+func (s *Server) handle(cmd *exec.Cmd) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, strings.Join(cmd.Args, " "))
+
+	name, window := splitTarget(targetName(cmd.Args))
+	switch subcommand(cmd) {
+	case "has-session":
+		if _, ok := s.sessions[name]; !ok {
+			return "", fmt.Errorf("can't find session: %s", name)
+		}
+		return "", nil
+	case "kill-session":
+		if _, ok := s.sessions[name]; !ok {
+			return "", fmt.Errorf("can't find session: %s", name)
+		}
+		delete(s.sessions, name)
+		return "", nil
+	case "set-option":
+		session, ok := s.sessions[name]
+		if !ok {
+			return "", fmt.Errorf("can't find session: %s", name)
+		}
+		if n := len(cmd.Args); n >= 2 {
+			session.options[cmd.Args[n-2]] = cmd.Args[n-1]
+		}
+		return "", nil
+	case "capture-pane":
+		session, ok := s.sessions[name]
+		if !ok || (window != "" && !slices.Contains(session.windows, window)) {
+			return "", fmt.Errorf("can't find pane: %s", targetName(cmd.Args))
+		}
+		if hasFlag(cmd.Args, "-S") {
+			return session.history + session.content, nil
+		}
+		return session.content, nil
+	case "new-window":
+		session, ok := s.sessions[name]
+		if !ok {
+			return "", fmt.Errorf("can't find session: %s", name)
+		}
+		newName := flagValue(cmd.Args, "-n")
+		session.windows = append(session.windows, newName)
+		if !hasFlag(cmd.Args, "-d") {
+			session.active = newName
+		}
+		return "", nil
+	case "list-windows":
+		session, ok := s.sessions[name]
+		if !ok {
+			return "", fmt.Errorf("can't find session: %s", name)
+		}
+		return strings.Join(session.windows, "\n") + "\n", nil
+	case "select-window":
+		session, ok := s.sessions[name]
+		if !ok {
+			return "", fmt.Errorf("can't find session: %s", name)
+		}
+		if window == "^" {
+			window = session.windows[0]
+		}
+		if !slices.Contains(session.windows, window) {
+			return "", fmt.Errorf("can't find window: %s", window)
+		}
+		session.active = window
+		return "", nil
+	case "display-message":
+		session, ok := s.sessions[name]
+		if !ok {
+			return "", fmt.Errorf("can't find session: %s", name)
+		}
+		if format := cmd.Args[len(cmd.Args)-1]; format == "#{history_limit}" {
+			// Like tmux, report the limit in effect rather than tracking it per pane.
+			if limit := session.options["history-limit"]; limit != "" {
+				return limit + "\n", nil
+			}
+			return "2000\n", nil
+		}
+		return "\n", nil
+	case "load-buffer":
+		if cmd.Stdin == nil {
+			return "", fmt.Errorf("tmuxtest: load-buffer supports stdin only")
+		}
+		data, err := io.ReadAll(cmd.Stdin)
+		if err != nil {
+			return "", err
+		}
+		s.buffers[flagValue(cmd.Args, "-b")] = string(data)
+		return "", nil
+	case "paste-buffer":
+		buffer := flagValue(cmd.Args, "-b")
+		data, ok := s.buffers[buffer]
+		if !ok {
+			return "", fmt.Errorf("no buffer %s", buffer)
+		}
+		session, ok := s.sessions[name]
+		if !ok || len(session.ptys) == 0 {
+			return "", fmt.Errorf("can't find pane: %s", targetName(cmd.Args))
+		}
+		if hasFlag(cmd.Args, "-d") {
+			delete(s.buffers, buffer)
+		}
+		_, err := session.ptys[len(session.ptys)-1].WriteString(data)
+		return "", err
+	case "delete-buffer":
+		delete(s.buffers, flagValue(cmd.Args, "-b"))
+		return "", nil
+	case "ls", "list-sessions":
+		names := make([]string, 0, len(s.sessions))
+		for name := range s.sessions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s: 1 windows\n", name)
+		}
+		return b.String(), nil
+	default:
+		return "", nil
+	}
+}
+
+// SetPaneContent sets what capture-pane returns for the named tmux session. name is the
+// sanitized tmux name, e.g. tmux.TmuxPrefix + "my-session".
+func (s *Server) SetPaneContent(name, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[name]; ok {
+		session.content = content
+	}
+}
+
+// SetHistory sets the scrollback returned ahead of the pane content when history is captured.
+func (s *Server) SetHistory(name, history string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[name]; ok {
+		session.history = history
+	}
+}
+
+// Buffers returns the names of the paste buffers currently held by the server.
+func (s *Server) Buffers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.buffers))
+	for name := range s.buffers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasSession reports whether the named tmux session exists.
+func (s *Server) HasSession(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.sessions[name]
+	return ok
+}
+
+// AddSession creates a session as if it had been left running by an earlier process.
+func (s *Server) AddSession(name, workDir, program string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[name] = newFakeSession(workDir, program, tmux.AgentWindow)
+}
+
+// ActiveWindow returns the name of the named session's active window.
+func (s *Server) ActiveWindow(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[name]; ok {
+		return session.active
+	}
+	return ""
+}
+
+// Option returns a session option set with set-option, e.g. "history-limit".
+func (s *Server) Option(name, option string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[name]; ok {
+		return session.options[option]
+	}
+	return ""
+}
+
+// WorkDir returns the working directory the named session was created in.
+func (s *Server) WorkDir(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[name]; ok {
+		return session.workDir
+	}
+	return ""
+}
+
+// Input returns everything written to the named session's PTYs, in order.
+func (s *Server) Input(name string) (string, error) {
+	s.mu.Lock()
+	session, ok := s.sessions[name]
+	var ptys []*os.File
+	if ok {
+		ptys = append(ptys, session.ptys...)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("can't find session: %s", name)
+	}
+
+	var b strings.Builder
+	for _, f := range ptys {
+		data, err := os.ReadFile(f.Name())
+		if err != nil {
+			return "", err
+		}
+		b.Write(data)
+	}
+	return b.String(), nil
+}
+
+// Commands returns every tmux command the server received, in order.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func subcommand(cmd *exec.Cmd) string {
+	if len(cmd.Args) < 2 {
+		return ""
+	}
+	return cmd.Args[1]
+}
+
+// targetName returns the session named by -t, accepting both "-t name" and "-t=name".
+func targetName(args []string) string {
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "-t=") {
+			return strings.TrimPrefix(arg, "-t=")
+		}
+		if arg == "-t" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// splitTarget splits a "session:window" target into its parts.
+func splitTarget(target string) (string, string) {
+	session, window, _ := strings.Cut(target, ":")
+	return session, window
+}
+
+func flagValue(args []string, flag string) string {
+	for i, arg := range args {
+		if arg == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func hasFlag(args []string, flag string) bool {
+	for _, arg := range args {
+		if arg == flag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package tmuxtest
+
+import (
+	"agent-squad/session/tmux"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerDrivesTmuxSession(t *testing.T) {
+	server := NewServer()
+	t.Cleanup(server.Close)
+
+	name := tmux.TmuxPrefix + "fake"
+	workDir := t.TempDir()
+	session := NewSession(server, "fake", "bash")
+	session.SetHistoryLimit(123)
+
+	require.NoError(t, session.Start(workDir))
+	require.True(t, session.DoesSessionExist())
+	require.Equal(t, workDir, server.WorkDir(name))
+	require.Equal(t, "123", server.Option(name, "history-limit"))
+	limit, err := session.HistoryLimit()
+	require.NoError(t, err)
+	require.Equal(t, 123, limit)
+
+	server.SetHistory(name, "earlier\n")
+	server.SetPaneContent(name, "$ ready\n")
+	content, err := session.CapturePaneContent()
+	require.NoError(t, err)
+	require.Equal(t, "$ ready\n", content)
+	full, err := session.CapturePaneContentWithOptions("-", "-")
+	require.NoError(t, err)
+	require.Equal(t, "earlier\n$ ready\n", full)
+
+	require.NoError(t, session.SendKeys("echo hi"))
+	require.NoError(t, session.TapEnter())
+	input, err := server.Input(name)
+	require.NoError(t, err)
+	require.Equal(t, "echo hi\r", input)
+
+	require.NoError(t, session.Close())
+	require.False(t, server.HasSession(name))
+}
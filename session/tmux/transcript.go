@@ -0,0 +1,154 @@
+package tmux
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TranscriptBackups is the number of rotated transcript files kept next to the active one.
+const TranscriptBackups = 3
+
+// TranscriptWriter appends pane output to a transcript file, rotating it once it grows past
+// maxBytes. Rotated files get numeric suffixes: path.1 is the most recent, path.N the oldest.
+type TranscriptWriter struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	file     *os.File
+	size     int64
+	// lastPane holds the lines of the last pane capture passed to AppendPane.
+	lastPane []string
+}
+
+// NewTranscriptWriter opens (or creates) the transcript at path for appending. A maxBytes of zero
+// or less disables rotation.
+func NewTranscriptWriter(path string, maxBytes int64) (*TranscriptWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create transcript directory: %w", err)
+	}
+	w := &TranscriptWriter{path: path, maxBytes: maxBytes}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *TranscriptWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open transcript %s: %w", w.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to stat transcript %s: %w", w.path, err)
+	}
+	w.file = f
+	w.size = info.Size()
+	return nil
+}
+
+// Write appends p to the transcript, rotating beforehand if p would push the file past the limit.
+func (w *TranscriptWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, fmt.Errorf("transcript %s is closed", w.path)
+	}
+
+	if w.maxBytes > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// AppendPane writes the lines of a pane capture that were not already in the previous capture.
+func (w *TranscriptWriter) AppendPane(content string) error {
+	curr := paneLines(content)
+	w.mu.Lock()
+	fresh := newLines(w.lastPane, curr)
+	w.lastPane = curr
+	w.mu.Unlock()
+
+	if len(fresh) == 0 {
+		return nil
+	}
+	_, err := w.Write([]byte(strings.Join(fresh, "\n") + "\n"))
+	return err
+}
+
+// rotate shifts path.N-1 -> path.N, ..., path -> path.1 and reopens an empty active file.
+func (w *TranscriptWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close transcript before rotation: %w", err)
+	}
+	w.file = nil
+
+	_ = os.Remove(rotatedTranscriptPath(w.path, TranscriptBackups))
+	for n := TranscriptBackups - 1; n >= 1; n-- {
+		src := rotatedTranscriptPath(w.path, n)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		if err := os.Rename(src, rotatedTranscriptPath(w.path, n+1)); err != nil {
+			return fmt.Errorf("failed to rotate transcript %s: %w", src, err)
+		}
+	}
+	if err := os.Rename(w.path, rotatedTranscriptPath(w.path, 1)); err != nil {
+		return fmt.Errorf("failed to rotate transcript %s: %w", w.path, err)
+	}
+
+	return w.open()
+}
+
+// Close closes the active transcript file.
+func (w *TranscriptWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// ReadTranscript returns the full transcript at path, reading rotated files from oldest to newest
+// before the active file. Missing rotated files are skipped.
+func ReadTranscript(path string) (string, error) {
+	var b strings.Builder
+	found := false
+	for n := TranscriptBackups; n >= 0; n-- {
+		name := path
+		if n > 0 {
+			name = rotatedTranscriptPath(path, n)
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", fmt.Errorf("failed to read transcript %s: %w", name, err)
+		}
+		found = true
+		b.Write(data)
+	}
+	if !found {
+		return "", fmt.Errorf("transcript not found: %s", path)
+	}
+	return b.String(), nil
+}
+
+func rotatedTranscriptPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
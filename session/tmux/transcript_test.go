@@ -0,0 +1,68 @@
+package tmux
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscriptWriterRotatesAndReadsInOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transcripts", "session.log")
+
+	w, err := NewTranscriptWriter(path, 10)
+	require.NoError(t, err)
+
+	for _, chunk := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n"} {
+		_, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	_, err = os.Stat(path + ".1")
+	require.NoError(t, err)
+	_, err = os.Stat(path + ".2")
+	require.NoError(t, err)
+
+	content, err := ReadTranscript(path)
+	require.NoError(t, err)
+	require.Equal(t, "aaaaaa\nbbbbbb\ncccccc\n", content)
+}
+
+func TestTranscriptWriterDropsOldestBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.log")
+
+	w, err := NewTranscriptWriter(path, 2)
+	require.NoError(t, err)
+	for _, chunk := range []string{"1\n", "2\n", "3\n", "4\n", "5\n"} {
+		_, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	content, err := ReadTranscript(path)
+	require.NoError(t, err)
+	require.Equal(t, "2\n3\n4\n5\n", content)
+}
+
+func TestTranscriptWriterAppendPaneSkipsRepeatedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.log")
+
+	w, err := NewTranscriptWriter(path, 0)
+	require.NoError(t, err)
+	require.NoError(t, w.AppendPane("one\ntwo\n\n"))
+	require.NoError(t, w.AppendPane("one\ntwo\n\n"))
+	require.NoError(t, w.AppendPane("two\nthree\n"))
+	require.NoError(t, w.Close())
+
+	// Reopening appends to the existing transcript.
+	w, err = NewTranscriptWriter(path, 0)
+	require.NoError(t, err)
+	require.NoError(t, w.AppendPane("four\n"))
+	require.NoError(t, w.Close())
+
+	content, err := ReadTranscript(path)
+	require.NoError(t, err)
+	require.Equal(t, "one\ntwo\nthree\nfour\n", content)
+}
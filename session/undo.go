@@ -0,0 +1,78 @@
+package session
+
+import (
+	"agent-squad/session/git"
+	"fmt"
+	"time"
+)
+
+// KilledUndoWindow is how long after a kill the killed instance can still be restored.
+const KilledUndoWindow = 10 * time.Minute
+
+// RecordKilled remembers the data of an instance that is being killed so RestoreLastKilled can
+// bring it back. Only the last killed instance is kept, and only in memory.
+func (s *Storage) RecordKilled(data InstanceData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.killed = &data
+	s.killedAt = time.Now()
+}
+
+// LastKilled returns the data of the last killed instance, if it was killed within
+// KilledUndoWindow.
+func (s *Storage) LastKilled() (*InstanceData, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastKilledLocked()
+}
+
+func (s *Storage) lastKilledLocked() (*InstanceData, bool) {
+	if s.killed == nil || time.Since(s.killedAt) > KilledUndoWindow {
+		return nil, false
+	}
+	data := *s.killed
+	return &data, true
+}
+
+// RestoreLastKilled recreates the last killed instance from its branch and resumes it. Work that
+// was not committed when the instance was killed is lost. The caller adds the returned instance
+// to its list and saves it.
+func (s *Storage) RestoreLastKilled() (*Instance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.lastKilledLocked()
+	if !ok {
+		return nil, fmt.Errorf("no recently killed instance to restore")
+	}
+	instance, err := restoreKilled(*data)
+	if err != nil {
+		return nil, err
+	}
+	s.killed = nil
+	return instance, nil
+}
+
+// restoreKilled brings back the branch of a killed instance and resumes the instance on it.
+func restoreKilled(data InstanceData) (*Instance, error) {
+	worktree := data.Worktree
+	if worktree.RepoPath == "" || worktree.BranchName == "" {
+		return nil, fmt.Errorf("cannot restore %s: it has no branch", data.Title)
+	}
+	// The branch only needs to come back from its backup if the kill deleted it.
+	if err := git.RestoreKilledBranch(worktree.RepoPath, worktree.BranchName); err != nil {
+		return nil, fmt.Errorf("cannot restore %s: %w", data.Title, err)
+	}
+
+	// A paused instance is loaded without starting anything, and resuming it recreates the
+	// worktree from the branch.
+	data.Status = Paused
+	instance, err := FromInstanceData(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := instance.Resume(); err != nil {
+		return nil, fmt.Errorf("failed to resume restored instance %s: %w", data.Title, err)
+	}
+	return instance, nil
+}
@@ -2,6 +2,7 @@ package ui
 
 import (
 	"agent-squad/session"
+	"agent-squad/session/git"
 	"fmt"
 	"strings"
 
@@ -21,6 +22,8 @@ type DiffPane struct {
 	stats    string
 	width    int
 	height   int
+	// rendered is a copy of the stats the viewport shows, or nil if it shows a message.
+	rendered *git.DiffStats
 }
 
 func NewDiffPane() *DiffPane {
@@ -50,12 +53,14 @@ func (d *DiffPane) SetDiff(instance *session.Instance) {
 	)
 
 	if instance == nil || !instance.Started() {
+		d.rendered = nil
 		d.viewport.SetContent(centeredFallbackMessage)
 		return
 	}
 
 	stats := instance.GetDiffStats()
 	if stats == nil {
+		d.rendered = nil
 		// Show loading message if worktree is not ready
 		centeredMessage := lipgloss.Place(
 			d.width,
@@ -69,6 +74,7 @@ func (d *DiffPane) SetDiff(instance *session.Instance) {
 	}
 
 	if stats.Error != nil {
+		d.rendered = nil
 		// Show error message
 		centeredMessage := lipgloss.Place(
 			d.width,
@@ -82,13 +88,23 @@ func (d *DiffPane) SetDiff(instance *session.Instance) {
 	}
 
 	if stats.IsEmpty() {
+		d.rendered = nil
 		d.stats = ""
 		d.diff = ""
 		d.viewport.SetContent(centeredFallbackMessage)
-	} else {
+	} else if stats.ChangedSince(d.rendered) {
+		// Colorizing large diffs is expensive, so only redraw when the diff changed.
+		rendered := *stats
+		d.rendered = &rendered
 		additions := AdditionStyle.Render(fmt.Sprintf("%d additions(+)", stats.Added))
 		deletions := DeletionStyle.Render(fmt.Sprintf("%d deletions(-)", stats.Removed))
 		d.stats = lipgloss.JoinHorizontal(lipgloss.Center, additions, " ", deletions)
+		if stats.BinaryFilesChanged > 0 {
+			d.stats = lipgloss.JoinHorizontal(lipgloss.Center, d.stats, fmt.Sprintf(" %d binary files", stats.BinaryFilesChanged))
+		}
+		if base := instance.GetBaseBranch(); base != "" {
+			d.stats = lipgloss.JoinHorizontal(lipgloss.Center, d.stats, fmt.Sprintf(" vs %s", base))
+		}
 		d.diff = colorizeDiff(stats.Content)
 		d.viewport.SetContent(lipgloss.JoinVertical(lipgloss.Left, d.stats, d.diff))
 	}
@@ -13,6 +13,7 @@ import (
 
 const readyIcon = "● "
 const pausedIcon = "⏸ "
+const crashedIcon = "✗ "
 
 var readyStyle = lipgloss.NewStyle().
 	Foreground(lipgloss.AdaptiveColor{Light: "#51bd73", Dark: "#51bd73"})
@@ -81,14 +82,15 @@ func (l *List) SetSize(width, height int) {
 }
 
 // SetSessionPreviewSize sets the height and width for the tmux sessions. This makes the stdout line have the correct
-// width and height.
+// width and height. The sessions are redrawn at the new size right away.
 func (l *List) SetSessionPreviewSize(width, height int) (err error) {
 	for i, item := range l.items {
 		if !item.Started() || item.Paused() {
 			continue
 		}
 
-		if innerErr := item.SetPreviewSize(width, height); innerErr != nil {
+		opts := session.PreviewSizeOptions{Reflow: true}
+		if innerErr := item.SetPreviewSizeWithOptions(width, height, opts); innerErr != nil {
 			err = errors.Join(
 				err, fmt.Errorf("could not set preview size for instance %d: %v", i, innerErr))
 		}
@@ -125,20 +127,25 @@ func (r *InstanceRenderer) Render(i *session.Instance, idx int, selected bool, h
 		descS = listDescStyle
 	}
 
+	// Read the state once so the status, branch and diff stats shown agree with each other.
+	snap := i.Snapshot()
+
 	// add spinner next to title if it's running
 	var join string
-	switch i.Status {
-	case session.Running:
+	switch snap.Status {
+	case session.Running, session.Loading:
 		join = fmt.Sprintf("%s ", r.spinner.View())
 	case session.Ready:
 		join = readyStyle.Render(readyIcon)
-	case session.Paused:
+	case session.Paused, session.Queued:
 		join = pausedStyle.Render(pausedIcon)
+	case session.Crashed, session.Detached:
+		join = removedLinesStyle.Render(crashedIcon)
 	default:
 	}
 
 	// Cut the title if it's too long
-	titleText := i.Title
+	titleText := snap.Title
 	widthAvail := r.width - 3 - len(prefix) - 1
 	if widthAvail > 0 && widthAvail < len(titleText) && len(titleText) >= widthAvail-3 {
 		titleText = titleText[:widthAvail-3] + "..."
@@ -150,7 +157,7 @@ func (r *InstanceRenderer) Render(i *session.Instance, idx int, selected bool, h
 		join,
 	))
 
-	stat := i.GetDiffStats()
+	stat := snap.DiffStats
 
 	var diff string
 	var addedDiff, removedDiff string
@@ -182,7 +189,7 @@ func (r *InstanceRenderer) Render(i *session.Instance, idx int, selected bool, h
 	// Use fixed width for diff stats to avoid layout issues
 	remainingWidth -= diffWidth
 
-	branch := i.GetBranch()
+	branch := snap.Branch
 	if i.Started() && hasMultipleRepos {
 		repoName, err := i.RepoName()
 		if err != nil {
@@ -271,13 +278,18 @@ func (l *List) Down() {
 
 // Kill selects the next item in the list.
 func (l *List) Kill() {
+	l.KillWithOptions(session.KillOptions{})
+}
+
+// KillWithOptions kills the selected instance with the given options and removes it from the list.
+func (l *List) KillWithOptions(opts session.KillOptions) {
 	if len(l.items) == 0 {
 		return
 	}
 	targetInstance := l.items[l.selectedIdx]
 
 	// Kill the tmux session
-	if err := targetInstance.Kill(); err != nil {
+	if err := targetInstance.KillWithOptions(opts); err != nil {
 		log.ErrorLog.Printf("could not kill instance: %v", err)
 	}
 